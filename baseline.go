@@ -0,0 +1,132 @@
+package trc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// baseline is a rolling summary of the durations and outcomes of finished
+// traces in a single category. Observations are kept in an exponentially
+// decayed histogram, so recent traces dominate, and older traces fade away
+// with the configured half-life.
+type baseline struct {
+	halfLife time.Duration
+
+	mtx     sync.Mutex
+	buckets [baselineBucketCount]float64
+	total   float64
+	errored float64
+	decayed time.Time // when the weights were last decayed
+}
+
+const (
+	// baselineBucketCount buckets, with bounds growing by baselineBucketGrowth
+	// from baselineBucketMin, cover durations up to about 28 hours.
+	baselineBucketCount  = 64
+	baselineBucketMin    = time.Microsecond
+	baselineBucketGrowth = 1.5
+
+	// baselineMinWeight is the minimum decayed number of observations before a
+	// baseline is considered meaningful.
+	baselineMinWeight = 50
+
+	// baselineDecaysPerHalfLife is how often weights are decayed. Between
+	// decays, observations accumulate at full weight.
+	baselineDecaysPerHalfLife = 16
+
+	// defaultBaselineHalfLife is used when the collector config doesn't
+	// specify a half-life.
+	defaultBaselineHalfLife = 10 * time.Minute
+)
+
+func newBaseline(halfLife time.Duration) *baseline {
+	return &baseline{halfLife: halfLife}
+}
+
+// observe a trace which finished at the given time.
+func (b *baseline) observe(finished time.Time, duration time.Duration, errored bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.decay(finished)
+
+	b.buckets[baselineBucket(duration)]++
+	b.total++
+	if errored {
+		b.errored++
+	}
+}
+
+// decay scales down every weight according to the time since the last decay.
+// It must be called with the mutex held.
+func (b *baseline) decay(now time.Time) {
+	if b.decayed.IsZero() {
+		b.decayed = now
+		return
+	}
+
+	elapsed := now.Sub(b.decayed)
+	if elapsed < b.halfLife/baselineDecaysPerHalfLife {
+		return
+	}
+
+	factor := math.Exp2(-float64(elapsed) / float64(b.halfLife))
+	for i := range b.buckets {
+		b.buckets[i] *= factor
+	}
+	b.total *= factor
+	b.errored *= factor
+	b.decayed = now
+}
+
+// p99 returns the upper bound of the bucket containing the 99th percentile of
+// observed durations, and false if there aren't enough observations.
+func (b *baseline) p99() (time.Duration, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.total < baselineMinWeight {
+		return 0, false
+	}
+
+	var (
+		target = 0.99 * b.total
+		sum    float64
+	)
+	for i, w := range b.buckets {
+		sum += w
+		if sum >= target {
+			return baselineBucketBound(i), true
+		}
+	}
+	return baselineBucketBound(baselineBucketCount - 1), true
+}
+
+// errorRate returns the decayed fraction of observed traces which errored, and
+// false if there aren't enough observations.
+func (b *baseline) errorRate() (float64, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.total < baselineMinWeight {
+		return 0, false
+	}
+	return b.errored / b.total, true
+}
+
+func baselineBucket(d time.Duration) int {
+	if d <= baselineBucketMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(baselineBucketMin)) / math.Log(baselineBucketGrowth)))
+	if i >= baselineBucketCount {
+		i = baselineBucketCount - 1
+	}
+	return i
+}
+
+// baselineBucketBound is the inclusive upper bound of the bucket.
+func baselineBucketBound(i int) time.Duration {
+	return time.Duration(float64(baselineBucketMin) * math.Pow(baselineBucketGrowth, float64(i)))
+}
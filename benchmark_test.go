@@ -2,6 +2,7 @@ package trc_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/peterbourgon/trc"
@@ -133,4 +134,21 @@ func BenchmarkCollector(b *testing.B) {
 			tr.Finish()
 		}
 	})
+
+	for _, batchSize := range []int{0, 64} {
+		b.Run(fmt.Sprintf("parallel insert batch size %d", batchSize), func(b *testing.B) {
+			collector := trc.NewCollector(trc.CollectorConfig{InsertBatchSize: batchSize})
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, tr := collector.NewTrace(ctx, category)
+					tr.Tracef("trace event")
+					tr.Finish()
+				}
+			})
+		})
+	}
 }
@@ -2,31 +2,89 @@ package trc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/peterbourgon/trc/internal/trcutil"
 )
 
 // Broker allows traces to be published to a set of subscribers.
 type Broker struct {
-	mtx  sync.Mutex
-	subs map[chan<- Trace]*subscriber
+	mtx       sync.Mutex
+	subs      map[chan<- Trace]*subscriber
+	evictions int
+	closed    bool
+	closing   chan struct{} // closed by Shutdown
+	idle      chan struct{} // closed when closed and there are no subscribers
+	idleOnce  sync.Once
 }
 
+// ErrBrokerClosed is returned by stream methods when the broker is shut down.
+var ErrBrokerClosed = errors.New("broker closed")
+
+// ErrSlowSubscriber is returned by stream methods when the subscription is
+// evicted, because it dropped more consecutive traces than allowed by the
+// MaxDrops stream option.
+var ErrSlowSubscriber = errors.New("slow subscriber evicted")
+
 // NewBroker returns a new, empty broker.
 func NewBroker() *Broker {
 	return &Broker{
-		subs: map[chan<- Trace]*subscriber{},
+		subs:    map[chan<- Trace]*subscriber{},
+		closing: make(chan struct{}),
+		idle:    make(chan struct{}),
+	}
+}
+
+// Shutdown stops the broker from accepting new subscriptions, and ends all
+// active subscriptions, whose stream methods return ErrBrokerClosed. It waits
+// until every subscription has been removed, or until the context is canceled,
+// in which case it returns the context error. Published traces are dropped
+// once the broker is shut down.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	func() {
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+
+		if !b.closed {
+			b.closed = true
+			close(b.closing)
+		}
+
+		b.maybeIdle()
+	}()
+
+	select {
+	case <-b.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// maybeIdle signals that the broker is idle, if it's closed and has no more
+// subscribers. It's called with the broker mutex held.
+func (b *Broker) maybeIdle() {
+	if b.closed && len(b.subs) <= 0 {
+		b.idleOnce.Do(func() { close(b.idle) })
 	}
 }
 
 // Publish the trace, transformed via [NewStreamTrace], to any active and
-// matching subscribers. Sends to subscribers don't block and will drop.
+// matching subscribers. What happens when a subscriber's channel is full is
+// determined by the send policy of the subscription. By default, sends to
+// subscribers don't block, and will drop the published trace.
 func (b *Broker) Publish(ctx context.Context, tr Trace) {
 	b.mtx.Lock()
 	defer b.mtx.Unlock()
 
 	// Fast path exit if there are no subscribers.
-	if len(b.subs) <= 0 {
+	if len(b.subs) <= 0 || b.closed {
 		return
 	}
 
@@ -34,16 +92,22 @@ func (b *Broker) Publish(ctx context.Context, tr Trace) {
 	str := NewStreamTrace(tr)
 
 	for _, sub := range b.subs {
-		if !sub.filter.Allow(str) {
+		if sub.isEvicted {
+			continue
+		}
+
+		tr, ok := sub.filter.allowEvents(str)
+		if !ok {
 			sub.stats.Skips++
 			continue
 		}
 
-		select {
-		case sub.traces <- str:
-			sub.stats.Sends++
-		default:
-			sub.stats.Drops++
+		sub.send(tr)
+
+		if max := sub.opts.MaxDrops; max > 0 && sub.stats.ConsecutiveDrops >= max {
+			sub.isEvicted = true
+			close(sub.evicted)
+			b.evictions++
 		}
 	}
 }
@@ -51,48 +115,113 @@ func (b *Broker) Publish(ctx context.Context, tr Trace) {
 // Stream will forward a copy of every trace created in the collector matching
 // the filter to the provided channel. If the channel is full, traces will be
 // dropped. For reasons of efficiency, streamed trace events don't have stacks.
-// Stream blocks until the context is canceled.
+// Stream blocks until the context is canceled. It's equivalent to
+// [Broker.StreamWithOptions] with default options.
 //
 // Note that if the filter has IsActive true, the caller will receive not only
 // complete matching traces as they are finished, but also a single-event trace
 // for each individual matching event as they are created. This can be an
-// enormous volume of data, please be careful.
+// enormous volume of data, please be careful. Setting MatchEvents in the filter
+// applies IsErrored and Query to individual events rather than whole traces,
+// which can reduce that volume significantly.
 func (b *Broker) Stream(ctx context.Context, f Filter, ch chan<- Trace) (StreamStats, error) {
+	return b.StreamWithOptions(ctx, f, ch, StreamOptions{})
+}
+
+// StreamWithOptions is like [Broker.Stream], but allows the caller to specify
+// how traces are sent to the channel, in particular when it's full.
+//
+// If the broker is shut down, StreamWithOptions returns ErrBrokerClosed. If the
+// subscription is evicted, due to the MaxDrops option, it returns
+// ErrSlowSubscriber.
+func (b *Broker) StreamWithOptions(ctx context.Context, f Filter, ch chan<- Trace, opts StreamOptions) (StreamStats, error) {
+	if errs := opts.Normalize(); len(errs) > 0 {
+		return StreamStats{}, errs[0]
+	}
+
+	if errs := f.Normalize(); len(errs) > 0 {
+		return StreamStats{}, fmt.Errorf("filter: %s", strings.Join(trcutil.FlattenErrors(errs...), "; "))
+	}
+
+	// The drop-oldest policy needs to receive from the queue, which isn't
+	// possible with the caller's send-only channel, so traces are published
+	// to an intermediate queue, and forwarded to the caller's channel below.
+	queue := ch
+	var forward chan Trace
+	if opts.SendPolicy == SendPolicyDropOldest {
+		forward = make(chan Trace, max(1, cap(ch)))
+		queue = forward
+	}
+
+	now := getClock().Now()
+	sub := &subscriber{
+		id:      ulid.MustNew(ulid.Timestamp(now), traceIDEntropy).String(),
+		name:    SubscriberFromContext(ctx),
+		started: now,
+		filter:  f,
+		traces:  queue,
+		queue:   forward,
+		opts:    opts,
+		evicted: make(chan struct{}),
+	}
+
+	// The stream ends when the caller's context is canceled, when the broker
+	// is shut down, or when the subscriber is evicted, whichever happens first.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-b.closing:
+			cancel()
+		case <-sub.evicted:
+			cancel()
+		case <-streamCtx.Done():
+		}
+	}()
+
 	if err := func() error {
 		b.mtx.Lock()
 		defer b.mtx.Unlock()
 
+		if b.closed {
+			return ErrBrokerClosed
+		}
+
 		if _, ok := b.subs[ch]; ok {
 			return fmt.Errorf("already subscribed")
 		}
 
-		b.subs[ch] = &subscriber{
-			filter: f,
-			traces: ch,
-		}
+		b.subs[ch] = sub
 
 		return nil
 	}(); err != nil {
 		return StreamStats{}, err
 	}
 
-	<-ctx.Done()
+	if forward != nil {
+		forwardTraces(streamCtx, forward, ch)
+	}
+
+	<-streamCtx.Done()
 
-	sub := func() *subscriber {
+	stats, isEvicted := func() (StreamStats, bool) {
 		b.mtx.Lock()
 		defer b.mtx.Unlock()
 
-		sub := b.subs[ch]
 		delete(b.subs, ch)
+		b.maybeIdle()
 
-		return sub
+		return sub.currentStats(), sub.isEvicted
 	}()
 
-	if sub == nil {
-		return StreamStats{}, fmt.Errorf("not subscribed (programmer error)")
+	switch {
+	case isEvicted:
+		return stats, ErrSlowSubscriber
+	case ctx.Err() == nil:
+		return stats, ErrBrokerClosed
+	default:
+		return stats, ctx.Err()
 	}
-
-	return sub.stats, ctx.Err()
 }
 
 // StreamStats returns statistics about a currently active subscription.
@@ -105,7 +234,129 @@ func (b *Broker) StreamStats(ctx context.Context, ch chan<- Trace) (StreamStats,
 		return StreamStats{}, fmt.Errorf("not subscribed")
 	}
 
-	return sub.stats, nil
+	return sub.currentStats(), nil
+}
+
+// Stats returns a summary of the current subscribers of the broker.
+func (b *Broker) Stats() BrokerStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	stats := BrokerStats{Subscribers: len(b.subs), Evictions: b.evictions}
+	for _, sub := range b.subs {
+		if cap(sub.traces) <= 0 {
+			continue // unbuffered, so saturation is meaningless
+		}
+		saturation := float64(len(sub.traces)) / float64(cap(sub.traces))
+		if saturation >= 1 {
+			stats.Saturated++
+		}
+		if saturation > stats.MaxSaturation {
+			stats.MaxSaturation = saturation
+		}
+	}
+	return stats
+}
+
+// Subscriptions returns a description of every active subscription to the
+// broker, ordered from oldest to newest.
+func (b *Broker) Subscriptions() []SubscriptionInfo {
+	now := getClock().Now()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	infos := make([]SubscriptionInfo, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if sub.isEvicted {
+			continue // about to be removed
+		}
+		var (
+			stats = sub.currentStats()
+			age   = now.Sub(sub.started).Seconds()
+		)
+		infos = append(infos, SubscriptionInfo{
+			ID:         sub.id,
+			Subscriber: sub.name,
+			Filter:     sub.filter,
+			Options:    sub.opts,
+			Started:    sub.started,
+			Stats:      stats,
+			SendRate:   iff(age > 0, float64(stats.Sends)/age, 0),
+			DropRate:   iff(age > 0, float64(stats.Drops)/age, 0),
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Started.Equal(infos[j].Started) {
+			return infos[i].Started.Before(infos[j].Started)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+
+	return infos
+}
+
+// SubscriptionInfo describes an active subscription to a broker, see
+// [Broker.Subscriptions].
+type SubscriptionInfo struct {
+	// ID uniquely identifies the subscription.
+	ID string `json:"id"`
+
+	// Subscriber describes who subscribed, if known, see [WithSubscriber].
+	Subscriber string `json:"subscriber,omitempty"`
+
+	// Filter and Options are the parameters of the subscription, normalized.
+	Filter  Filter        `json:"filter"`
+	Options StreamOptions `json:"options"`
+
+	// Started is when the subscription began.
+	Started time.Time `json:"started"`
+
+	// Stats are the current stats of the subscription.
+	Stats StreamStats `json:"stats"`
+
+	// SendRate and DropRate are the average number of traces sent and dropped
+	// per second, respectively, since the subscription began.
+	SendRate float64 `json:"send_rate"`
+	DropRate float64 `json:"drop_rate"`
+}
+
+type subscriberContextKey struct{}
+
+// WithSubscriber returns a context containing a description of a subscriber,
+// e.g. the remote address of an HTTP client. Subscriptions made with the
+// returned context, e.g. via [Broker.Stream], include the description in
+// [Broker.Subscriptions].
+func WithSubscriber(ctx context.Context, subscriber string) context.Context {
+	return context.WithValue(ctx, subscriberContextKey{}, subscriber)
+}
+
+// SubscriberFromContext returns the description of the subscriber in the
+// context, set via [WithSubscriber], if any.
+func SubscriberFromContext(ctx context.Context) string {
+	subscriber, _ := ctx.Value(subscriberContextKey{}).(string)
+	return subscriber
+}
+
+// BrokerStats is a summary of the current subscribers of a broker, see
+// [Broker.Stats].
+type BrokerStats struct {
+	// Subscribers is the number of active subscriptions.
+	Subscribers int `json:"subscribers"`
+
+	// Saturated is the number of subscribers whose channels are full, and
+	// which are therefore dropping traces, or blocking publishers, depending
+	// on their send policy.
+	Saturated int `json:"saturated"`
+
+	// MaxSaturation is the greatest fraction of capacity used by the channel
+	// of any subscriber, from 0 to 1. Unbuffered channels aren't considered.
+	MaxSaturation float64 `json:"max_saturation"`
+
+	// Evictions is the total number of subscribers which have been evicted
+	// for dropping too many consecutive traces, see [StreamOptions.MaxDrops].
+	Evictions int `json:"evictions"`
 }
 
 // StreamStats is metadata about a currently active subscription.
@@ -118,15 +369,249 @@ type StreamStats struct {
 
 	// Drops is how many traces were dropped due to lack of capacity.
 	Drops int `json:"drops"`
+
+	// Summaries is how many synthetic traces reporting drops were sent, when
+	// the send policy is SendPolicySummarize.
+	Summaries int `json:"summaries,omitempty"`
+
+	// ConsecutiveDrops is how many traces were dropped since the subscriber
+	// last kept up, i.e. since a trace was sent without dropping any traces.
+	// It's compared to [StreamOptions.MaxDrops].
+	ConsecutiveDrops int `json:"consecutive_drops"`
+
+	// QueueDepth is the number of traces waiting in the subscriber's channel,
+	// and QueueCapacity is its capacity. A queue that's persistently full
+	// indicates a subscriber that can't keep up.
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+
+	// LastSend is when a trace was last sent to the subscriber.
+	LastSend time.Time `json:"last_send,omitempty"`
 }
 
 // String implements fmt.Stringer.
 func (s StreamStats) String() string {
-	return fmt.Sprintf("skips=%d sends=%d drops=%d", s.Skips, s.Sends, s.Drops)
+	return fmt.Sprintf("skips=%d sends=%d drops=%d queue=%d/%d", s.Skips, s.Sends, s.Drops, s.QueueDepth, s.QueueCapacity)
+}
+
+//
+//
+//
+
+// SendPolicy determines how a broker sends traces to a subscriber, in
+// particular when the subscriber's channel is full.
+type SendPolicy string
+
+const (
+	// SendPolicyDropNewest drops the trace being published if the channel is
+	// full. This is the default.
+	SendPolicyDropNewest SendPolicy = "drop-newest"
+
+	// SendPolicyDropOldest drops the oldest trace in the channel, to make room
+	// for the trace being published, if the channel is full.
+	SendPolicyDropOldest SendPolicy = "drop-oldest"
+
+	// SendPolicyBlock blocks for up to the send timeout if the channel is full,
+	// and then drops the trace being published. Note that publishing happens
+	// synchronously as traces are modified, so this policy can slow down the
+	// traced code itself, as well as other subscribers.
+	SendPolicyBlock SendPolicy = "block"
+
+	// SendPolicySummarize drops the trace being published if the channel is
+	// full, like SendPolicyDropNewest, and then, as soon as there is capacity,
+	// sends a synthetic errored trace in the DroppedCategory, with a single
+	// event reporting the number of dropped traces. This makes drops visible
+	// to consumers of the stream, who might otherwise think that traces, e.g.
+	// errors, have stopped occurring.
+	SendPolicySummarize SendPolicy = "summarize"
+)
+
+// DroppedCategory is the category of synthetic traces reporting drops, which
+// are sent to subscribers with the SendPolicySummarize send policy.
+const DroppedCategory = "(dropped)"
+
+// StreamOptions are optional parameters for a stream subscription.
+type StreamOptions struct {
+	// SendPolicy determines what happens when the subscriber's channel is
+	// full. The default is SendPolicyDropNewest.
+	SendPolicy SendPolicy `json:"send_policy,omitempty"`
+
+	// SendTimeout is how long to block when the send policy is
+	// SendPolicyBlock. The default is 10ms, and the maximum is 1s.
+	SendTimeout time.Duration `json:"send_timeout,omitempty"`
+
+	// MaxDrops is the number of consecutive drops, see
+	// [StreamStats.ConsecutiveDrops], after which the subscriber is evicted,
+	// and its stream method returns ErrSlowSubscriber. This prevents a stuck
+	// subscriber, e.g. an abandoned dashboard, from silently dropping traces
+	// forever, or, with SendPolicyBlock, from slowing down publishers. The
+	// default is zero, which means subscribers are never evicted.
+	MaxDrops int `json:"max_drops,omitempty"`
+}
+
+const (
+	streamSendTimeoutDefault = 10 * time.Millisecond
+	streamSendTimeoutMax     = 1 * time.Second
+)
+
+// Normalize ensures the options are valid, modifying them if necessary. It
+// returns any errors encountered in the process.
+func (opts *StreamOptions) Normalize() []error {
+	var errs []error
+
+	switch opts.SendPolicy {
+	case "":
+		opts.SendPolicy = SendPolicyDropNewest
+	case SendPolicyDropNewest, SendPolicyDropOldest, SendPolicyBlock, SendPolicySummarize:
+		//
+	default:
+		errs = append(errs, fmt.Errorf("invalid send policy %q", opts.SendPolicy))
+	}
+
+	switch {
+	case opts.SendTimeout <= 0:
+		opts.SendTimeout = streamSendTimeoutDefault
+	case opts.SendTimeout > streamSendTimeoutMax:
+		opts.SendTimeout = streamSendTimeoutMax
+	}
+
+	if opts.MaxDrops < 0 {
+		errs = append(errs, fmt.Errorf("invalid max drops %d", opts.MaxDrops))
+		opts.MaxDrops = 0
+	}
+
+	return errs
 }
 
 type subscriber struct {
-	traces chan<- Trace
-	filter Filter
-	stats  StreamStats
+	id        string
+	name      string // see WithSubscriber
+	started   time.Time
+	traces    chan<- Trace
+	queue     chan Trace // only for SendPolicyDropOldest, same as traces
+	filter    Filter
+	opts      StreamOptions
+	stats     StreamStats
+	dropped   int           // only for SendPolicySummarize, not yet reported
+	evicted   chan struct{} // closed when evicted
+	isEvicted bool
+}
+
+// currentStats returns the stats of the subscriber, including its current
+// queue depth. It's called with the broker mutex held.
+func (sub *subscriber) currentStats() StreamStats {
+	stats := sub.stats
+	stats.QueueDepth = len(sub.traces)
+	stats.QueueCapacity = cap(sub.traces)
+	return stats
+}
+
+// send the trace to the subscriber according to its send policy, and update
+// its lag stats. It's called with the broker mutex held.
+func (sub *subscriber) send(tr Trace) {
+	sends, drops := sub.stats.Sends, sub.stats.Drops
+
+	sub.sendPolicy(tr)
+
+	if sub.stats.Sends > sends {
+		sub.stats.LastSend = getClock().Now()
+	}
+	if n := sub.stats.Drops - drops; n > 0 {
+		sub.stats.ConsecutiveDrops += n
+	} else {
+		sub.stats.ConsecutiveDrops = 0
+	}
+}
+
+func (sub *subscriber) sendPolicy(tr Trace) {
+	switch sub.opts.SendPolicy {
+	case SendPolicyDropOldest:
+		for {
+			select {
+			case sub.queue <- tr:
+				sub.stats.Sends++
+				return
+			default:
+			}
+			select {
+			case <-sub.queue:
+				sub.stats.Drops++
+			default:
+			}
+		}
+
+	case SendPolicyBlock:
+		select {
+		case sub.traces <- tr:
+			sub.stats.Sends++
+			return
+		default:
+		}
+		timer := time.NewTimer(sub.opts.SendTimeout)
+		defer timer.Stop()
+		select {
+		case sub.traces <- tr:
+			sub.stats.Sends++
+		case <-timer.C:
+			sub.stats.Drops++
+		}
+
+	case SendPolicySummarize:
+		if sub.dropped > 0 {
+			select {
+			case sub.traces <- newDroppedTrace(tr.Source(), sub.dropped):
+				sub.stats.Summaries++
+				sub.dropped = 0
+			default:
+			}
+		}
+		select {
+		case sub.traces <- tr:
+			sub.stats.Sends++
+		default:
+			sub.stats.Drops++
+			sub.dropped++
+		}
+
+	default:
+		select {
+		case sub.traces <- tr:
+			sub.stats.Sends++
+		default:
+			sub.stats.Drops++
+		}
+	}
+}
+
+// forwardTraces from the queue to the channel until the context is canceled.
+func forwardTraces(ctx context.Context, queue <-chan Trace, ch chan<- Trace) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tr := <-queue:
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- tr:
+			}
+		}
+	}
+}
+
+func newDroppedTrace(source string, n int) *StaticTrace {
+	now := getClock().Now()
+	return &StaticTrace{
+		TraceSource:   source,
+		TraceID:       ulid.MustNew(ulid.Timestamp(now), traceIDEntropy).String(),
+		TraceCategory: DroppedCategory,
+		TraceStarted:  now,
+		TraceFinished: true,
+		TraceErrored:  true,
+		TraceEvents: []Event{{
+			When:    now,
+			What:    fmt.Sprintf("%d traces dropped", n),
+			IsError: true,
+		}},
+	}
 }
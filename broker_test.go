@@ -3,6 +3,7 @@ package trc_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/peterbourgon/trc"
 )
@@ -51,3 +52,401 @@ func BenchmarkBrokerPublish(b *testing.B) {
 	fn("9 skip, 1 send", isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isActive)
 	fn("1 skip, 9 send", isActive, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored, isErrored)
 }
+
+func TestBrokerMatchEvents(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		broker      = trc.NewBroker()
+		tracec      = make(chan trc.Trace, 100)
+		donec       = make(chan struct{})
+	)
+	defer func() { cancel(); <-donec }()
+
+	go func() {
+		defer close(donec)
+		broker.Stream(ctx, trc.Filter{IsErrored: true, Query: "b", MatchEvents: true}, tracec)
+	}()
+	for {
+		if _, err := broker.StreamStats(ctx, tracec); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, tr := trc.New(ctx, "src", "cat")
+	for _, f := range []func(string, ...any){tr.Tracef, tr.Errorf, tr.Errorf, tr.Tracef} {
+		f("a")
+		broker.Publish(ctx, tr)
+		f("b")
+		broker.Publish(ctx, tr)
+	}
+	tr.Finish()
+	broker.Publish(ctx, tr)
+
+	stats, err := broker.StreamStats(ctx, tracec)
+	AssertNoError(t, err)
+	AssertEqual(t, 2+1, stats.Sends) // 2 "b" error events, 1 finished trace
+	AssertEqual(t, 8-2, stats.Skips)
+
+	for i := 0; i < 2; i++ {
+		events := (<-tracec).Events()
+		AssertEqual(t, 1, len(events))
+		AssertEqual(t, "b", events[0].What)
+		AssertEqual(t, true, events[0].IsError)
+	}
+
+	final := <-tracec
+	AssertEqual(t, true, final.Finished())
+	AssertEqual(t, 2, len(final.Events()))
+}
+
+func TestBrokerSources(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		broker      = trc.NewBroker()
+		tracec      = make(chan trc.Trace, 100)
+		donec       = make(chan struct{})
+	)
+	defer func() { cancel(); <-donec }()
+
+	go func() {
+		defer close(donec)
+		broker.Stream(ctx, trc.Filter{Sources: []string{"", "b"}}, tracec)
+	}()
+	for {
+		if _, err := broker.StreamStats(ctx, tracec); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, source := range []string{"a", "b", "c", "b"} {
+		_, tr := trc.New(ctx, source, "cat")
+		tr.Finish()
+		broker.Publish(ctx, tr)
+	}
+
+	stats, err := broker.StreamStats(ctx, tracec)
+	AssertNoError(t, err)
+	AssertEqual(t, 2, stats.Sends)
+	AssertEqual(t, 2, stats.Skips)
+	AssertEqual(t, "b", (<-tracec).Source())
+}
+
+func TestBrokerSendPolicy(t *testing.T) {
+	t.Parallel()
+
+	subscribe := func(t *testing.T, broker *trc.Broker, ch chan trc.Trace, opts trc.StreamOptions) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		donec := make(chan struct{})
+		t.Cleanup(func() { cancel(); <-donec })
+		go func() {
+			defer close(donec)
+			broker.StreamWithOptions(ctx, trc.Filter{}, ch, opts)
+		}()
+		for {
+			if _, err := broker.StreamStats(ctx, ch); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	publish := func(broker *trc.Broker, categories ...string) {
+		for _, category := range categories {
+			_, tr := trc.New(context.Background(), "src", category)
+			tr.Finish()
+			broker.Publish(context.Background(), tr)
+		}
+	}
+
+	t.Run("drop-newest", func(t *testing.T) {
+		broker, ch := trc.NewBroker(), make(chan trc.Trace, 2)
+		subscribe(t, broker, ch, trc.StreamOptions{})
+		publish(broker, "a", "b", "c", "d")
+
+		stats, err := broker.StreamStats(context.Background(), ch)
+		AssertNoError(t, err)
+		AssertEqual(t, 2, stats.Sends)
+		AssertEqual(t, 2, stats.Drops)
+		AssertEqual(t, "a", (<-ch).Category())
+		AssertEqual(t, "b", (<-ch).Category())
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		broker, ch := trc.NewBroker(), make(chan trc.Trace)
+		subscribe(t, broker, ch, trc.StreamOptions{SendPolicy: trc.SendPolicyDropOldest})
+		publish(broker, "a", "b", "c", "d", "e")
+
+		var received int
+		for done := false; !done; {
+			select {
+			case tr := <-ch:
+				received++
+				done = tr.Category() == "e"
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for newest trace")
+			}
+		}
+
+		stats, err := broker.StreamStats(context.Background(), ch)
+		AssertNoError(t, err)
+		AssertEqual(t, 5, stats.Sends)
+		AssertEqual(t, 5, received+stats.Drops)
+		ExpectNotEqual(t, 0, stats.Drops)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		broker, ch := trc.NewBroker(), make(chan trc.Trace, 1)
+		subscribe(t, broker, ch, trc.StreamOptions{SendPolicy: trc.SendPolicyBlock, SendTimeout: time.Second})
+		go func() { time.Sleep(10 * time.Millisecond); <-ch }()
+		publish(broker, "a", "b")
+
+		stats, err := broker.StreamStats(context.Background(), ch)
+		AssertNoError(t, err)
+		AssertEqual(t, 2, stats.Sends)
+		AssertEqual(t, 0, stats.Drops)
+		AssertEqual(t, "b", (<-ch).Category())
+	})
+
+	t.Run("summarize", func(t *testing.T) {
+		broker, ch := trc.NewBroker(), make(chan trc.Trace, 2)
+		subscribe(t, broker, ch, trc.StreamOptions{SendPolicy: trc.SendPolicySummarize})
+		publish(broker, "a", "b", "c", "d")
+		AssertEqual(t, "a", (<-ch).Category())
+		AssertEqual(t, "b", (<-ch).Category())
+		publish(broker, "e")
+
+		summary := <-ch
+		AssertEqual(t, trc.DroppedCategory, summary.Category())
+		AssertEqual(t, true, summary.Errored())
+		AssertEqual(t, "2 traces dropped", summary.Events()[0].What)
+		AssertEqual(t, "e", (<-ch).Category())
+
+		stats, err := broker.StreamStats(context.Background(), ch)
+		AssertNoError(t, err)
+		AssertEqual(t, 3, stats.Sends)
+		AssertEqual(t, 2, stats.Drops)
+		AssertEqual(t, 1, stats.Summaries)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := trc.NewBroker().StreamWithOptions(context.Background(), trc.Filter{}, make(chan trc.Trace), trc.StreamOptions{SendPolicy: "bogus"})
+		ExpectNotEqual(t, nil, err)
+	})
+}
+
+func TestBrokerShutdown(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		broker = trc.NewBroker()
+		ch     = make(chan trc.Trace, 1)
+		errc   = make(chan error, 1)
+	)
+
+	go func() {
+		_, err := broker.Stream(ctx, trc.Filter{}, ch)
+		errc <- err
+	}()
+	for {
+		if _, err := broker.StreamStats(ctx, ch); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	AssertNoError(t, broker.Shutdown(shutdownCtx))
+	ExpectEqual(t, trc.ErrBrokerClosed, <-errc)
+
+	// New subscriptions are rejected immediately.
+	_, err := broker.Stream(ctx, trc.Filter{}, make(chan trc.Trace))
+	ExpectEqual(t, trc.ErrBrokerClosed, err)
+
+	// Shutdown is idempotent.
+	AssertNoError(t, broker.Shutdown(shutdownCtx))
+}
+
+func TestBrokerStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		broker      = trc.NewBroker()
+		full        = make(chan trc.Trace, 2)
+		half        = make(chan trc.Trace, 4)
+		done        = make(chan struct{}, 2)
+	)
+	defer cancel()
+
+	for _, ch := range []chan trc.Trace{full, half} {
+		go func(ch chan trc.Trace) {
+			broker.Stream(ctx, trc.Filter{}, ch)
+			done <- struct{}{}
+		}(ch)
+		for {
+			if _, err := broker.StreamStats(ctx, ch); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	ExpectEqual(t, trc.BrokerStats{Subscribers: 2}, broker.Stats())
+
+	_, tr := trc.New(ctx, "src", "cat")
+	broker.Publish(ctx, tr)
+	broker.Publish(ctx, tr)
+
+	ExpectEqual(t, trc.BrokerStats{Subscribers: 2, Saturated: 1, MaxSaturation: 1}, broker.Stats())
+
+	cancel()
+	<-done
+	<-done
+	ExpectEqual(t, trc.BrokerStats{}, broker.Stats())
+}
+
+func TestBrokerSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		broker      = trc.NewBroker()
+		first       = make(chan trc.Trace, 1)
+		second      = make(chan trc.Trace, 1)
+		done        = make(chan struct{}, 2)
+	)
+	defer cancel()
+
+	subscribe := func(ch chan trc.Trace, subscriber string, f trc.Filter, opts trc.StreamOptions) {
+		go func() {
+			broker.StreamWithOptions(trc.WithSubscriber(ctx, subscriber), f, ch, opts)
+			done <- struct{}{}
+		}()
+		for {
+			if _, err := broker.StreamStats(ctx, ch); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	subscribe(first, "alpha", trc.Filter{Category: "foo"}, trc.StreamOptions{})
+	time.Sleep(time.Millisecond) // distinct start times
+	subscribe(second, "beta", trc.Filter{}, trc.StreamOptions{SendPolicy: trc.SendPolicyDropOldest})
+
+	_, tr := trc.New(ctx, "src", "bar")
+	broker.Publish(ctx, tr)
+	broker.Publish(ctx, tr)
+
+	subs := broker.Subscriptions()
+	AssertEqual(t, 2, len(subs))
+
+	ExpectEqual(t, "alpha", subs[0].Subscriber)
+	ExpectEqual(t, "foo", subs[0].Filter.Category)
+	ExpectEqual(t, trc.SendPolicyDropNewest, subs[0].Options.SendPolicy)
+	ExpectEqual(t, 2, subs[0].Stats.Skips)
+	ExpectEqual(t, 0, subs[0].Stats.Sends)
+
+	ExpectEqual(t, "beta", subs[1].Subscriber)
+	ExpectEqual(t, trc.SendPolicyDropOldest, subs[1].Options.SendPolicy)
+	ExpectEqual(t, 2, subs[1].Stats.Sends+subs[1].Stats.Drops)
+	ExpectEqual(t, true, subs[1].SendRate > 0)
+
+	ExpectEqual(t, true, subs[0].ID != subs[1].ID)
+	ExpectEqual(t, true, !subs[0].Started.After(subs[1].Started))
+
+	cancel()
+	<-done
+	<-done
+	ExpectEqual(t, 0, len(broker.Subscriptions()))
+}
+
+func TestBrokerEviction(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		broker = trc.NewBroker()
+		slow   = make(chan trc.Trace, 2)
+		fast   = make(chan trc.Trace, 100)
+		errc   = make(chan error, 1)
+	)
+
+	go func() {
+		_, err := broker.StreamWithOptions(ctx, trc.Filter{}, slow, trc.StreamOptions{MaxDrops: 3})
+		errc <- err
+	}()
+	fastCtx, fastCancel := context.WithCancel(ctx)
+	defer fastCancel()
+	go broker.Stream(fastCtx, trc.Filter{}, fast)
+	for _, ch := range []chan trc.Trace{slow, fast} {
+		for {
+			if _, err := broker.StreamStats(ctx, ch); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	publish := func() {
+		_, tr := trc.New(ctx, "src", "cat")
+		tr.Finish()
+		broker.Publish(ctx, tr)
+	}
+
+	// Fill the slow subscriber's queue, and drop fewer than the threshold.
+	publish()
+	publish()
+	publish()
+	publish()
+
+	stats, err := broker.StreamStats(ctx, slow)
+	AssertNoError(t, err)
+	ExpectEqual(t, 2, stats.Sends)
+	ExpectEqual(t, 2, stats.Drops)
+	ExpectEqual(t, 2, stats.ConsecutiveDrops)
+	ExpectEqual(t, 2, stats.QueueDepth)
+	ExpectEqual(t, 2, stats.QueueCapacity)
+	ExpectEqual(t, false, stats.LastSend.IsZero())
+
+	// Catching up resets the consecutive drops.
+	<-slow
+	publish()
+	stats, err = broker.StreamStats(ctx, slow)
+	AssertNoError(t, err)
+	ExpectEqual(t, 0, stats.ConsecutiveDrops)
+
+	// Reaching the threshold evicts the subscriber.
+	publish()
+	publish()
+	publish()
+
+	select {
+	case err := <-errc:
+		ExpectEqual(t, trc.ErrSlowSubscriber, err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for eviction")
+	}
+
+	// Other subscribers are unaffected.
+	stats, err = broker.StreamStats(ctx, fast)
+	AssertNoError(t, err)
+	ExpectEqual(t, 8, stats.Sends)
+	ExpectEqual(t, 0, stats.Drops)
+
+	bstats := broker.Stats()
+	ExpectEqual(t, 1, bstats.Subscribers)
+	ExpectEqual(t, 1, bstats.Evictions)
+
+	_, err = trc.NewBroker().StreamWithOptions(ctx, trc.Filter{}, make(chan trc.Trace), trc.StreamOptions{MaxDrops: -1})
+	ExpectNotEqual(t, nil, err)
+}
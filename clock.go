@@ -0,0 +1,51 @@
+package trc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock is a source of the current time. Traces, collectors, and search stats
+// use a clock, rather than calling [time.Now] directly, so that tests can
+// control the passage of time.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to a [Clock].
+type ClockFunc func() time.Time
+
+// Now implements [Clock].
+func (f ClockFunc) Now() time.Time { return f() }
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+type clockHolder struct{ Clock }
+
+var globalClock = func() *atomic.Value {
+	var v atomic.Value
+	v.Store(clockHolder{systemClock{}})
+	return &v
+}()
+
+// SetClock sets the clock used by default throughout the package, including by
+// [New] when creating traces. Passing a nil clock restores the system clock,
+// which is the default.
+//
+// Changing the clock does not affect traces that have already been created.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	globalClock.Store(clockHolder{c})
+}
+
+func getClock() Clock {
+	return globalClock.Load().(clockHolder).Clock
+}
+
+func clockSince(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
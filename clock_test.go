@@ -0,0 +1,125 @@
+package trc_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+type manualClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCollectorClock(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+	)
+
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		2 * time.Millisecond,
+		2 * time.Millisecond,
+		20 * time.Millisecond,
+	} {
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Tracef("event")
+		clock.Advance(d)
+		tr.Finish()
+		AssertEqual(t, d, tr.Duration())
+		AssertEqual(t, clock.Now().Add(-d), tr.Started())
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{
+		Bucketing: []time.Duration{0, time.Millisecond, 10 * time.Millisecond},
+	})
+	AssertNoError(t, err)
+
+	cs := res.Stats.Categories["category"]
+	AssertEqual(t, 4, cs.BucketCounts[0])
+	AssertEqual(t, 3, cs.BucketCounts[1])
+	AssertEqual(t, 1, cs.BucketCounts[2])
+	AssertEqual(t, time.Duration(0), res.Duration)
+
+	// Rates are measured as of the search, per the clock of the collector.
+	elapsed := clock.Now().Sub(cs.Oldest)
+	AssertEqual(t, 4/elapsed.Seconds(), cs.TraceRate())
+	AssertEqual(t, 4/elapsed.Seconds(), cs.EventRate())
+}
+
+func TestChildClock(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+	)
+
+	ctx, tr := collector.NewTrace(context.Background(), "category")
+	defer tr.Finish()
+
+	_, child := trc.Child(ctx, "child")
+	clock.Advance(5 * time.Millisecond)
+	child.Finish()
+
+	AssertEqual(t, tr.Started(), child.Started())
+	AssertEqual(t, 5*time.Millisecond, child.Duration())
+}
+
+func TestEventOffsets(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+	)
+
+	_, tr := collector.NewTrace(ctx, "category")
+	clock.Advance(time.Millisecond)
+	tr.Tracef("one")
+	clock.Advance(2 * time.Millisecond)
+	tr.Tracef("two")
+	tr.Finish()
+
+	events := tr.Events()
+	AssertEqual(t, 2, len(events))
+	AssertEqual(t, time.Millisecond, events[0].Offset)
+	AssertEqual(t, 3*time.Millisecond, events[1].Offset)
+	AssertEqual(t, time.UTC, events[1].When.Location())
+
+	// Traces using the system clock measure offsets with the monotonic clock.
+	_, tr = trc.New(ctx, "source", "category")
+	tr.Tracef("event")
+	tr.Finish()
+	ev := tr.Events()[0]
+	if ev.Offset < 0 || ev.Offset > tr.Duration() {
+		t.Errorf("offset %s: want within [0, %s]", ev.Offset, tr.Duration())
+	}
+
+	// Events without offsets, e.g. from older versions, fall back to When.
+	start := clock.Now()
+	legacy := trc.Event{When: start.Add(5 * time.Millisecond)}
+	AssertEqual(t, 5*time.Millisecond, legacy.OffsetFrom(start))
+	legacy.Offset = 7 * time.Millisecond
+	AssertEqual(t, 7*time.Millisecond, legacy.OffsetFrom(start))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+type completionConfig struct {
+	*rootConfig
+
+	root *ff.Command // set after every command is registered
+}
+
+// completeCommand is the hidden argument to `trc completion` which is invoked
+// by the generated shell scripts, with the words of the command line, up to and
+// including the word being completed, and which prints one candidate per line.
+const completeCommand = "__complete"
+
+func (cfg *completionConfig) Exec(ctx context.Context, args []string) error {
+	if len(args) <= 0 {
+		return fmt.Errorf("shell required: bash, zsh, or fish")
+	}
+
+	switch shell, rest := args[0], args[1:]; shell {
+	case "bash":
+		io.WriteString(cfg.stdout, bashCompletion)
+	case "zsh":
+		io.WriteString(cfg.stdout, zshCompletion)
+	case "fish":
+		io.WriteString(cfg.stdout, fishCompletion)
+	case completeCommand:
+		if len(rest) > 0 && rest[0] == "--" {
+			rest = rest[1:]
+		}
+		for _, candidate := range cfg.complete(ctx, rest) {
+			fmt.Fprintln(cfg.stdout, candidate)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported shell, must be bash, zsh, or fish", shell)
+	}
+
+	return nil
+}
+
+// complete returns the completion candidates for the last of the words, which
+// are a trc command line, starting with the program name. Candidates are
+// subcommands, flags, or values for the flag in the previous word, where
+// categories are fetched from the URIs in the command line, or the
+// environment.
+func (cfg *completionConfig) complete(ctx context.Context, words []string) []string {
+	if len(words) <= 1 {
+		return nil
+	}
+
+	var (
+		cmd  = cfg.root
+		cur  = words[len(words)-1]
+		prev = words[len(words)-2]
+	)
+	for _, word := range words[1 : len(words)-1] {
+		for _, sub := range cmd.Subcommands {
+			if sub.Name == word {
+				cmd = sub
+				break
+			}
+		}
+	}
+
+	var candidates []string
+	switch name := completionFlagName(prev); {
+	case name != "" && completionValues[name] != nil:
+		candidates = completionValues[name]
+	case name == "category":
+		candidates = cfg.completeCategories(ctx, words)
+	case strings.HasPrefix(cur, "-"):
+		if cmd.Flags != nil {
+			cmd.Flags.WalkFlags(func(f ff.Flag) error {
+				if long, ok := f.GetLongName(); ok {
+					candidates = append(candidates, "--"+long)
+				}
+				return nil
+			})
+		}
+	case cmd.Name == "completion":
+		candidates = []string{"bash", "zsh", "fish"}
+	default:
+		for _, sub := range cmd.Subcommands {
+			candidates = append(candidates, sub.Name)
+		}
+	}
+
+	var matching []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, cur) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
+
+// completionFlagName returns the long name of the flag in the word, if it's a
+// flag which takes a value which can be completed, or an empty string.
+func completionFlagName(word string) string {
+	switch word {
+	case "-c", "--category":
+		return "category"
+	case "-o", "--output":
+		return "output"
+	case "-l", "--log":
+		return "log"
+	case "--sort":
+		return "sort"
+	case "--min-level":
+		return "min-level"
+	}
+	return ""
+}
+
+// completionValues are the static values of flags which can be completed.
+var completionValues = map[string][]string{
+	"output":    {"ndjson", "prettyjson", "table", "csv", "html"},
+	"log":       {"info", "debug", "trace", "none"},
+	"sort":      stringsOf(trc.SearchSorts),
+	"min-level": stringsOf(trc.Levels),
+}
+
+func stringsOf[T ~string](a []T) []string {
+	s := make([]string, len(a))
+	for i := range a {
+		s[i] = string(a[i])
+	}
+	return s
+}
+
+// completeCategories fetches the category names from every URI, which are taken
+// from the environment, and from any --uri flags in the words. Completion should
+// be fast, so errors are ignored, and slow URIs are skipped.
+func (cfg *completionConfig) completeCategories(ctx context.Context, words []string) []string {
+	uris := cfg.uris
+	for i, word := range words[:len(words)-1] {
+		switch {
+		case (word == "-u" || word == "--uri") && i+1 < len(words)-1:
+			uris = append(uris, words[i+1])
+		case strings.HasPrefix(word, "--uri="):
+			uris = append(uris, strings.TrimPrefix(word, "--uri="))
+		case word == "--uri-path" && i+1 < len(words)-1:
+			cfg.uriPath = words[i+1]
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+
+	index := map[string]bool{}
+	for _, uri := range uris {
+		normalized, err := cfg.normalizeURI(strings.TrimSpace(uri))
+		if err != nil {
+			cfg.debug.Printf("%s: %v", uri, err)
+			continue
+		}
+		categories, err := fetchCategories(ctx, normalized)
+		if err != nil {
+			cfg.debug.Printf("%s: %v", normalized, err)
+			continue
+		}
+		for _, category := range categories {
+			index[category] = true
+		}
+	}
+
+	categories := make([]string, 0, len(index))
+	for category := range index {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+const completionTimeout = 2 * time.Second
+
+// fetchCategories returns the names of the categories served by the trace
+// server at the URI, via its categories endpoint.
+func fetchCategories(ctx context.Context, uri string) ([]string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse URI: %w", err)
+	}
+	q := u.Query()
+	q.Set("categories", "")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response code %d", resp.StatusCode)
+	}
+
+	var data trcweb.CategoriesData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+
+	categories := make([]string, 0, len(data.Categories))
+	for _, info := range data.Categories {
+		categories = append(categories, info.Category)
+	}
+	return categories, nil
+}
+
+const bashCompletion = `# bash completion for trc, e.g. source <(trc completion bash)
+_trc() {
+	local line
+	COMPREPLY=()
+	while IFS= read -r line; do
+		COMPREPLY+=("$(printf '%q' "$line")")
+	done < <("${COMP_WORDS[0]}" completion __complete -- "${COMP_WORDS[@]:0:COMP_CWORD+1}" 2>/dev/null)
+}
+complete -o default -F _trc trc
+`
+
+const zshCompletion = `#compdef trc
+# zsh completion for trc, e.g. source <(trc completion zsh)
+_trc() {
+	local -a candidates
+	candidates=(${(f)"$("${words[1]}" completion __complete -- "${(@)words[1,CURRENT]}" 2>/dev/null)"})
+	compadd -a candidates
+}
+compdef _trc trc
+`
+
+const fishCompletion = `# fish completion for trc, e.g. trc completion fish | source
+complete -c trc -f -a '(trc completion __complete -- (commandline -opc) (commandline -ct) 2>/dev/null)'
+`
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -78,12 +77,66 @@ func exec(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args [
 	streamCommand := &ff.Command{
 		Name:      "stream",
 		ShortHelp: "stream trace data to the terminal",
-		LongHelp:  "Stream traces, or trace events, that match the provided query flags.",
+		LongHelp:  "Stream traces, or trace events, that match the provided query flags. With -listen, the merged stream is served as a single event stream endpoint, rather than written to stdout.",
 		Flags:     streamFlags,
 		Exec:      streamConfig.Exec,
 	}
 	trcCommand.Subcommands = append(trcCommand.Subcommands, streamCommand)
 
+	// Config for `trc top`.
+	topConfig := &topConfig{streamConfig: streamConfig}
+	topFlags := ff.NewFlagSet("top").SetParent(streamFlags)
+	topConfig.register(topFlags)
+	topCommand := &ff.Command{
+		Name:      "top",
+		ShortHelp: "live aggregate view of trace data in the terminal",
+		LongHelp:  "Stream complete traces that match the provided query flags, and continuously display rate, error percentage, and latency quantiles for each category.",
+		Flags:     topFlags,
+		Exec:      topConfig.Exec,
+	}
+	trcCommand.Subcommands = append(trcCommand.Subcommands, topCommand)
+
+	// Config for `trc stats`.
+	statsConfig := &statsConfig{rootConfig: rootConfig}
+	statsFlags := ff.NewFlagSet("stats").SetParent(trcFlags)
+	statsConfig.register(statsFlags)
+	statsCommand := &ff.Command{
+		Name:      "stats",
+		ShortHelp: "compare category stats across instances",
+		LongHelp:  "Fetch search stats from each of two or more URIs separately, and print a table comparing the trace counts, error percentage, and latency distribution of each category, with percentages that differ significantly from the median marked with a *.",
+		Flags:     statsFlags,
+		Exec:      statsConfig.Exec,
+	}
+	trcCommand.Subcommands = append(trcCommand.Subcommands, statsCommand)
+
+	// Config for `trc replay`.
+	replayConfig := &replayConfig{rootConfig: rootConfig}
+	replayFlags := ff.NewFlagSet("replay").SetParent(trcFlags)
+	replayConfig.register(replayFlags)
+	replayCommand := &ff.Command{
+		Name:      "replay",
+		Usage:     "trc replay [FLAGS] [FILE ...]",
+		ShortHelp: "serve archived trace data in the web UI",
+		LongHelp:  "Read ndjson traces, as produced by `trc stream -o ndjson` or the export endpoint, from each file, or from stdin if no files are given, and serve them in the standard web UI. Traces which don't match the provided query flags are skipped.",
+		Flags:     replayFlags,
+		Exec:      replayConfig.Exec,
+	}
+	trcCommand.Subcommands = append(trcCommand.Subcommands, replayCommand)
+
+	// Config for `trc completion`.
+	completionConfig := &completionConfig{rootConfig: rootConfig}
+	completionFlags := ff.NewFlagSet("completion").SetParent(baseFlags)
+	completionCommand := &ff.Command{
+		Name:      "completion",
+		Usage:     "trc completion bash|zsh|fish",
+		ShortHelp: "generate a shell completion script",
+		LongHelp:  "Print a completion script for the given shell, which completes subcommands, flags, and flag values, including category names, which are fetched from the trace servers in the command line, or in TRC_URI. For example, `source <(trc completion bash)`.",
+		Flags:     completionFlags,
+		Exec:      completionConfig.Exec,
+	}
+	trcCommand.Subcommands = append(trcCommand.Subcommands, completionCommand)
+	completionConfig.root = trcCommand
+
 	// Print help when appropriate.
 	showHelp := true
 	defer func() {
@@ -121,8 +174,15 @@ func exec(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args [
 		rootConfig.trace = log.New(tracedst, "[TRACE] ", log.Lmsgprefix)
 	}
 
-	if len(rootConfig.uris) <= 0 {
-		return fmt.Errorf("at least one URI is required")
+	// Replay reads traces from files, rather than from trace servers, and
+	// completion uses trace servers only if they're available.
+	var (
+		isReplay     = trcCommand.GetSelected() == replayCommand
+		isCompletion = trcCommand.GetSelected() == completionCommand
+	)
+
+	if len(rootConfig.uris) <= 0 && rootConfig.discover == "" && !isReplay && !isCompletion {
+		return fmt.Errorf("at least one URI, or a discovery spec, is required")
 	}
 
 	for i, uri := range rootConfig.uris {
@@ -131,23 +191,29 @@ func exec(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args [
 			continue
 		}
 
-		if !strings.HasPrefix(uri, "http") {
-			uri = "http://" + uri
+		normalized, err := rootConfig.normalizeURI(uri)
+		if err != nil {
+			return err
 		}
 
-		u, err := url.ParseRequestURI(uri)
+		rootConfig.uris[i] = normalized
+
+		rootConfig.debug.Printf("URI: %s", normalized)
+	}
+
+	rootConfig.staticURIs = append([]string(nil), rootConfig.uris...)
+
+	if rootConfig.discover != "" {
+		uris, err := rootConfig.refreshURIs(ctx)
 		if err != nil {
-			return fmt.Errorf("%s: invalid: %w", uri, err)
+			return err
 		}
 
-		if rootConfig.uriPath != "" {
-			u.Path = rootConfig.uriPath
+		for _, uri := range uris[len(rootConfig.staticURIs):] {
+			rootConfig.debug.Printf("URI: %s (discovered)", uri)
 		}
 
-		uri = u.String()
-		rootConfig.uris[i] = uri
-
-		rootConfig.debug.Printf("URI: %s", uri)
+		rootConfig.uris = uris
 	}
 
 	{
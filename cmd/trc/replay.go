@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffval"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+type replayConfig struct {
+	*rootConfig
+
+	listen       string
+	categorySize int
+}
+
+func (cfg *replayConfig) register(fs *ff.FlagSet) {
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "listen" /*        */, Value: ffval.NewValueDefault(&cfg.listen, "localhost:8080") /* */, Usage: "serve the web UI on this address" /*     */, Placeholder: "ADDR"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "category-size" /* */, Value: ffval.NewValueDefault(&cfg.categorySize, 10000) /*      */, Usage: "max number of traces retained per category", Placeholder: "N"})
+}
+
+func (cfg *replayConfig) Exec(ctx context.Context, args []string) error {
+	ctx, tr := cfg.newTrace(ctx, "replay")
+	defer tr.Finish()
+
+	if errs := cfg.filter.Normalize(); len(errs) > 0 {
+		return fmt.Errorf("invalid filter: %s", strings.Join(trcutil.FlattenErrors(errs...), "; "))
+	}
+
+	if len(args) <= 0 {
+		args = []string{"-"}
+	}
+
+	// Streams include an update for every event of active traces, so a trace
+	// ID can occur more than once. The last occurrence is the most complete.
+	var (
+		traces []trc.Trace
+		index  = map[string]int{}
+	)
+	for _, filename := range args {
+		n, err := cfg.readFile(filename, func(st *trc.StaticTrace) {
+			if !cfg.filter.Allow(st) {
+				return
+			}
+			if i, ok := index[st.ID()]; ok {
+				traces[i] = st
+				return
+			}
+			index[st.ID()] = len(traces)
+			traces = append(traces, st)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		cfg.debug.Printf("%s: read trace count %d", filename, n)
+	}
+
+	collector := trc.NewCollector(trc.CollectorConfig{Source: "replay"}).SetCategorySize(cfg.categorySize)
+	collector.Add(traces...)
+
+	cfg.info.Printf("loaded trace count %d", len(traces))
+
+	ln, err := trcweb.Listen(cfg.listen)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	var (
+		server = &http.Server{Handler: trcweb.NewTraceServer(collector)}
+		errc   = make(chan error, 1)
+	)
+	go func() { errc <- server.Serve(ln) }()
+
+	cfg.info.Printf("serving on %s", ln.Addr())
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serve: %w", err)
+
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
+
+// readFile decodes ndjson static traces from the file, or from stdin if the
+// filename is "-", and calls fn for each of them. It returns the number of
+// decoded traces.
+func (cfg *replayConfig) readFile(filename string, fn func(*trc.StaticTrace)) (int, error) {
+	var r io.Reader
+	if filename == "-" {
+		r = cfg.stdin
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var (
+		dec   = json.NewDecoder(r)
+		count int
+	)
+	for {
+		var st trc.StaticTrace
+		err := dec.Decode(&st)
+		switch {
+		case errors.Is(err, io.EOF):
+			return count, nil
+		case err != nil:
+			return count, fmt.Errorf("decode trace %d: %w", count+1, err)
+		case st.TraceID == "":
+			cfg.debug.Printf("%s: skipping trace %d without ID", filename, count+1)
+		default:
+			fn(&st)
+		}
+		count++
+	}
+}
@@ -2,14 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffval"
 	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
 	"github.com/peterbourgon/trc/trcweb"
 )
 
@@ -20,16 +28,42 @@ type searchConfig struct {
 	stackDepth     int
 	includeRequest bool
 	includeStats   bool
+	statsOnly      bool
+	maxScan        int
+	maxDuration    time.Duration
+	sort           string
+	minLevel       string
+	interactive    bool
 }
 
 func (cfg *searchConfig) register(fs *ff.FlagSet) {
-	fs.AddFlag(ff.FlagConfig{ShortName: 'n', LongName: "limit" /*            */, Value: ffval.NewValueDefault(&cfg.limit, 10) /*  */, Usage: "maximum number of traces to return"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stack-depth" /*      */, Value: ffval.NewValue(&cfg.stackDepth) /*        */, Usage: "number of stack frames to include with each event"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "include-request" /*  */, Value: ffval.NewValue(&cfg.includeRequest) /*    */, Usage: "include search request in output", NoDefault: true})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "include-stats" /*    */, Value: ffval.NewValue(&cfg.includeStats) /*      */, Usage: "include search statistics in output", NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'n', LongName: "limit" /*           */, Value: ffval.NewValueDefault(&cfg.limit, 10) /*      */, Usage: "maximum number of traces to return"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stack-depth" /*     */, Value: ffval.NewValue(&cfg.stackDepth) /*            */, Usage: "number of stack frames to include with each event"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "include-request" /* */, Value: ffval.NewValue(&cfg.includeRequest) /*        */, Usage: "include search request in output", NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "include-stats" /*   */, Value: ffval.NewValue(&cfg.includeStats) /*          */, Usage: "include search statistics in output", NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stats-only" /*      */, Value: ffval.NewValue(&cfg.statsOnly) /*             */, Usage: "return only search statistics, no traces (implies -include-stats)", NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "max-scan" /*        */, Value: ffval.NewValue(&cfg.maxScan) /*               */, Usage: "max traces to evaluate per source, 0 for no limit"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "max-duration" /*    */, Value: ffval.NewValue(&cfg.maxDuration) /*           */, Usage: "max time to spend evaluating traces per source, 0 for no limit"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "sort" /*            */, Value: ffval.NewValueDefault(&cfg.sort, "newest") /* */, Usage: "order of traces: newest, oldest, slowest, errored"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "min-level" /*       */, Value: ffval.NewValue(&cfg.minLevel) /*              */, Usage: "drop events below this level: debug, info, warn, error"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "interactive" /*     */, Value: ffval.NewValue(&cfg.interactive) /*           */, Usage: "prompt for filter fields on stdin before searching", NoDefault: true})
+	cfg.registerFormatFlag(fs)
 }
 
-func (cfg *searchConfig) writeResult(ctx context.Context, res *trc.SearchResponse) error {
+func (cfg *searchConfig) writeResult(ctx context.Context, req *trc.SearchRequest, res *trc.SearchResponse) error {
+	if cfg.format != "" {
+		return cfg.writeFormat(res)
+	}
+
+	switch cfg.output {
+	case "table":
+		return cfg.writeTable(res)
+	case "csv":
+		return cfg.writeCSV(res)
+	case "html":
+		return cfg.writeHTML(ctx, req, res)
+	}
+
 	enc := json.NewEncoder(cfg.stdout)
 	switch cfg.output {
 	case "prettyjson":
@@ -45,6 +79,98 @@ func (cfg *searchConfig) writeResult(ctx context.Context, res *trc.SearchRespons
 	return nil
 }
 
+func (cfg *searchConfig) writeFormat(res *trc.SearchResponse) error {
+	f, err := newTraceFormatter(cfg.stdout, cfg.format)
+	if err != nil {
+		return err
+	}
+	for _, tr := range res.Traces {
+		if err := f.format(tr); err != nil {
+			return fmt.Errorf("%s: %w", tr.ID(), err)
+		}
+	}
+	return nil
+}
+
+func (cfg *searchConfig) writeTable(res *trc.SearchResponse) error {
+	tw := tabwriter.NewWriter(cfg.stdout, 0, 2, 2, ' ', 0)
+
+	if res.Stats != nil {
+		fmt.Fprintf(tw, "CATEGORY\tACTIVE\tSUCCESS\tERRORED\tTOTAL\tRATE\n")
+		for _, cs := range res.Stats.AllCategories() {
+			var success int
+			if len(cs.BucketCounts) > 0 {
+				success = cs.BucketCounts[0]
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s/s\n", cs.Category, cs.ActiveCount, success, cs.ErroredCount, cs.TotalCount(), trcutil.HumanizeFloat(cs.TraceRate()))
+		}
+		fmt.Fprintf(tw, "\t\t\t\t\t\n")
+	}
+
+	fmt.Fprintf(tw, "ID\tSOURCE\tCATEGORY\tSTARTED\tDURATION\tSTATUS\tEVENTS\n")
+	for _, tr := range res.Traces {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", trc.DisplayID(tr.ID()), tr.Source(), tr.Category(), tr.Started().Format(time.RFC3339), trcutil.HumanizeDuration(tr.Duration()), traceStatus(tr), len(tr.Events()))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write table: %w", err)
+	}
+	return nil
+}
+
+func (cfg *searchConfig) writeCSV(res *trc.SearchResponse) error {
+	w := csv.NewWriter(cfg.stdout)
+	w.Write([]string{"id", "source", "category", "started", "duration_sec", "status", "events"})
+	for _, tr := range res.Traces {
+		w.Write([]string{
+			tr.ID(),
+			tr.Source(),
+			tr.Category(),
+			tr.Started().Format(time.RFC3339Nano),
+			strconv.FormatFloat(tr.Duration().Seconds(), 'f', -1, 64),
+			traceStatus(tr),
+			strconv.Itoa(len(tr.Events())),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write CSV: %w", err)
+	}
+	return nil
+}
+
+func (cfg *searchConfig) writeHTML(ctx context.Context, req *trc.SearchRequest, res *trc.SearchResponse) error {
+	if res.Stats == nil {
+		res.Stats = trc.NewSearchStats(req.Bucketing) // template requires stats
+	}
+	data := trcweb.SearchData{
+		Request:  *req,
+		Response: *res,
+	}
+	for _, problem := range res.Problems {
+		data.Problems = append(data.Problems, errors.New(problem))
+	}
+	body, err := trcweb.RenderSearchHTML(ctx, data)
+	if err != nil {
+		return fmt.Errorf("render HTML: %w", err)
+	}
+	if _, err := cfg.stdout.Write(body); err != nil {
+		return fmt.Errorf("write HTML: %w", err)
+	}
+	return nil
+}
+
+func traceStatus(tr trc.Trace) string {
+	switch {
+	case !tr.Finished():
+		return "active"
+	case tr.Errored():
+		return "errored"
+	default:
+		return "success"
+	}
+}
+
 func (cfg *searchConfig) Exec(ctx context.Context, args []string) error {
 	ctx, tr := cfg.newTrace(ctx, "search")
 	defer tr.Finish()
@@ -58,10 +184,21 @@ func (cfg *searchConfig) Exec(ctx context.Context, args []string) error {
 		cfg.stackDepth = -1 // 0 means all available stacks, -1 means no stacks
 	}
 
+	if cfg.interactive {
+		if err := cfg.promptFilter(ctx); err != nil {
+			return fmt.Errorf("interactive: %w", err)
+		}
+	}
+
 	req := &trc.SearchRequest{
-		Filter:     cfg.filter,
-		Limit:      cfg.limit,
-		StackDepth: cfg.stackDepth,
+		Filter:        cfg.filter,
+		Limit:         cfg.limit,
+		StackDepth:    cfg.stackDepth,
+		StatsOnly:     cfg.statsOnly,
+		MaxScan:       cfg.maxScan,
+		MaxDuration:   cfg.maxDuration,
+		Sort:          trc.SearchSort(cfg.sort),
+		MinEventLevel: trc.Level(cfg.minLevel),
 	}
 
 	cfg.debug.Printf("request: filter: %s", cfg.filter)
@@ -84,14 +221,79 @@ func (cfg *searchConfig) Exec(ctx context.Context, args []string) error {
 		res.Request = nil
 	}
 
-	if !cfg.includeStats {
+	if !cfg.includeStats && !cfg.statsOnly && cfg.output != "html" && cfg.format == "" {
 		cfg.debug.Printf("removing stats from response")
 		res.Stats = nil
 	}
 
-	if err := cfg.writeResult(ctx, res); err != nil {
+	if err := cfg.writeResult(ctx, req, res); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// promptFilter asks for the most common filter fields, and the limit, one at a
+// time, with the values from the flags as defaults. The known categories are
+// fetched from the URIs and listed first. An answer of - clears a field, and if
+// the input ends, the remaining fields keep their defaults.
+func (cfg *searchConfig) promptFilter(ctx context.Context) error {
+	p := newPrompter(cfg.stdin, cfg.stderr)
+
+	categoriesCtx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	var categories []string
+	for _, uri := range cfg.uris {
+		if c, err := fetchCategories(categoriesCtx, uri); err == nil {
+			categories = append(categories, c...)
+		}
+	}
+	if len(categories) > 0 {
+		sort.Strings(categories)
+		fmt.Fprintf(cfg.stderr, "categories: %s\n", strings.Join(slices.Compact(categories), ", "))
+	}
+
+	cfg.filter.Category = p.ask("category (* matches anything)", cfg.filter.Category)
+	cfg.filter.Query = p.ask("query, e.g. 'err:true timeout'", cfg.filter.Query)
+
+	for {
+		current := "n"
+		if cfg.filter.IsErrored {
+			current = "y"
+		}
+		answer := p.ask("errored traces only (y/n)", current)
+		if errored, ok := parseYesNo(answer); ok {
+			cfg.filter.IsErrored = errored
+			break
+		}
+		fmt.Fprintf(cfg.stderr, "invalid answer %q\n", answer)
+	}
+
+	for {
+		var current string
+		if cfg.filter.MinDuration != nil {
+			current = cfg.filter.MinDuration.String()
+		}
+		answer := p.ask("min duration, e.g. 250ms", current)
+		if answer == "" {
+			cfg.filter.MinDuration = nil
+			break
+		}
+		if d, err := time.ParseDuration(answer); err == nil {
+			cfg.filter.MinDuration = &d
+			break
+		}
+		fmt.Fprintf(cfg.stderr, "invalid duration %q\n", answer)
+	}
+
+	for {
+		answer := p.ask("max number of traces", strconv.Itoa(cfg.limit))
+		if n, err := strconv.Atoi(answer); err == nil && n > 0 {
+			cfg.limit = n
+			break
+		}
+		fmt.Fprintf(cfg.stderr, "invalid number %q\n", answer)
+	}
+
+	return p.err()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffval"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+type statsConfig struct {
+	*rootConfig
+
+	buckets   []time.Duration
+	threshold float64
+}
+
+func (cfg *statsConfig) register(fs *ff.FlagSet) {
+	fs.AddFlag(ff.FlagConfig{ShortName: 'b', LongName: "bucket" /*    */, Value: ffval.NewUniqueList(&cfg.buckets) /*           */, Usage: "compare the percentage of traces at least this slow (repeatable)", Placeholder: "DURATION"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 't', LongName: "threshold" /* */, Value: ffval.NewValueDefault(&cfg.threshold, 10.0) /* */, Usage: "highlight percentages that differ from the median by this many points"})
+}
+
+func (cfg *statsConfig) Exec(ctx context.Context, args []string) error {
+	ctx, tr := cfg.newTrace(ctx, "stats")
+	defer tr.Finish()
+
+	if len(cfg.uris) < 2 {
+		return fmt.Errorf("at least two URIs are required")
+	}
+
+	if len(cfg.buckets) <= 0 {
+		cfg.buckets = []time.Duration{10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+	}
+	sort.Slice(cfg.buckets, func(i, j int) bool { return cfg.buckets[i] < cfg.buckets[j] })
+
+	req := &trc.SearchRequest{
+		Filter:    cfg.filter,
+		Bucketing: append([]time.Duration{0}, cfg.buckets...),
+		StatsOnly: true,
+	}
+
+	cfg.debug.Printf("request: filter: %s", cfg.filter)
+	cfg.debug.Printf("request: bucketing: %v", req.Bucketing)
+
+	// Each URI is searched separately, rather than with a MultiSearcher, so
+	// that the stats of each instance can be compared.
+	var (
+		wg      sync.WaitGroup
+		results = make([]*trc.SearchStats, len(cfg.uris))
+		errs    = make([]error, len(cfg.uris))
+	)
+	for i, uri := range cfg.uris {
+		wg.Add(1)
+		go func(i int, uri string) {
+			defer wg.Done()
+			res, err := trcweb.NewSearchClient(http.DefaultClient, uri).Search(ctx, req)
+			switch {
+			case err != nil:
+				errs[i] = err
+			case res.Stats.IsZero():
+				errs[i] = fmt.Errorf("no stats in response")
+			default:
+				results[i] = res.Stats
+			}
+		}(i, uri)
+	}
+	wg.Wait()
+
+	var instances []statsInstance
+	for i, uri := range cfg.uris {
+		if err := errs[i]; err != nil {
+			cfg.info.Printf("%s: %v", uri, err)
+			continue
+		}
+		instances = append(instances, statsInstance{uri: uri, stats: results[i]})
+	}
+	if len(instances) < 2 {
+		return fmt.Errorf("need stats from at least two URIs to compare, got %d", len(instances))
+	}
+
+	return writeStatsTable(cfg.stdout, instances, cfg.buckets, cfg.threshold)
+}
+
+//
+//
+//
+
+type statsInstance struct {
+	uri   string
+	stats *trc.SearchStats
+}
+
+// statsRow is the stats for a single category from a single instance. Errored
+// and slow are percentages of finished traces.
+type statsRow struct {
+	uri     string
+	total   int
+	active  int
+	rate    float64
+	errored float64
+	slow    []float64
+}
+
+func newStatsRow(uri string, cs *trc.CategoryStats, buckets int) statsRow {
+	row := statsRow{
+		uri:    uri,
+		total:  cs.TotalCount(),
+		active: cs.ActiveCount,
+		rate:   cs.TraceRate(),
+		slow:   make([]float64, buckets),
+	}
+
+	var success int
+	if len(cs.BucketCounts) > 0 {
+		success = cs.BucketCounts[0]
+	}
+	if finished := success + cs.ErroredCount; finished > 0 {
+		row.errored = 100 * float64(cs.ErroredCount) / float64(finished)
+	}
+	if success > 0 {
+		for i := range row.slow {
+			if i+1 < len(cs.BucketCounts) {
+				row.slow[i] = 100 * float64(cs.BucketCounts[i+1]) / float64(success)
+			}
+		}
+	}
+	return row
+}
+
+// writeStatsTable writes a table comparing the stats of each category across
+// every instance. Percentages which differ from the median of all instances by
+// at least threshold points are marked with a *.
+func writeStatsTable(w io.Writer, instances []statsInstance, buckets []time.Duration, threshold float64) error {
+	var categories []string
+	{
+		index := map[string]bool{}
+		for _, inst := range instances {
+			for category := range inst.stats.Categories {
+				index[category] = true
+			}
+		}
+		for category := range index {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		categories = append(categories, "overall")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+
+	header := []string{"CATEGORY", "URI", "TOTAL", "ACTIVE", "RATE", "ERRORS"}
+	for _, b := range buckets {
+		header = append(header, "≥"+trcutil.HumanizeDuration(b))
+	}
+	fmt.Fprintf(tw, "%s\n", strings.Join(header, "\t"))
+
+	var divergent int
+	for _, category := range categories {
+		rows := make([]statsRow, len(instances))
+		for i, inst := range instances {
+			var cs *trc.CategoryStats
+			switch category {
+			case "overall":
+				cs = inst.stats.Overall()
+			default:
+				cs = inst.stats.Categories[category]
+			}
+			if cs == nil {
+				cs = trc.NewCategoryStats(category, inst.stats.Bucketing)
+			}
+			rows[i] = newStatsRow(inst.uri, cs, len(buckets))
+		}
+
+		erroredMedian := statsMedian(rows, func(r statsRow) float64 { return r.errored })
+		slowMedians := make([]float64, len(buckets))
+		for i := range slowMedians {
+			slowMedians[i] = statsMedian(rows, func(r statsRow) float64 { return r.slow[i] })
+		}
+
+		mark := func(v, median float64) string {
+			s := fmt.Sprintf("%.1f%%", v)
+			if diff := v - median; diff >= threshold || -diff >= threshold {
+				divergent++
+				s += " *"
+			}
+			return s
+		}
+
+		for i, row := range rows {
+			label := category
+			if i > 0 {
+				label = ""
+			}
+			fields := []string{
+				label,
+				row.uri,
+				fmt.Sprint(row.total),
+				fmt.Sprint(row.active),
+				trcutil.HumanizeFloat(row.rate) + "/s",
+				mark(row.errored, erroredMedian),
+			}
+			for j, slow := range row.slow {
+				fields = append(fields, mark(slow, slowMedians[j]))
+			}
+			fmt.Fprintf(tw, "%s\n", strings.Join(fields, "\t"))
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write table: %w", err)
+	}
+
+	if divergent > 0 {
+		fmt.Fprintf(w, "\n* differs from the median by at least %s points\n", trcutil.HumanizeFloat(threshold))
+	}
+
+	return nil
+}
+
+// statsMedian returns the median of the values selected from the rows.
+func statsMedian(rows []statsRow, value func(statsRow) float64) float64 {
+	if len(rows) <= 0 {
+		return 0
+	}
+	vs := make([]float64, len(rows))
+	for i, row := range rows {
+		vs[i] = value(row)
+	}
+	sort.Float64s(vs)
+	if n := len(vs); n%2 == 0 {
+		return (vs[n/2-1] + vs[n/2]) / 2
+	}
+	return vs[len(vs)/2]
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"sync"
@@ -19,24 +20,48 @@ import (
 type streamConfig struct {
 	*rootConfig
 
-	streamEvents  bool
-	sendBuf       int
-	recvBuf       int
-	statsInterval time.Duration
-	retryInterval time.Duration
+	streamEvents     bool
+	sendBuf          int
+	sendPolicy       string
+	sendTimeout      time.Duration
+	maxDrops         int
+	recvBuf          int
+	statsInterval    time.Duration
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	listen           string
 
 	traces chan trc.Trace
 }
 
 func (cfg *streamConfig) register(fs *ff.FlagSet) {
-	fs.AddFlag(ff.FlagConfig{ShortName: 'e', LongName: "events" /*         */, Value: ffval.NewValue(&cfg.streamEvents) /*                         */, Usage: "stream individual events rather than complete traces", NoDefault: true})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "send-buffer" /*    */, Value: ffval.NewValueDefault(&cfg.sendBuf, 100) /*                  */, Usage: "remote send buffer size"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "recv-buffer" /*    */, Value: ffval.NewValueDefault(&cfg.recvBuf, 100) /*                  */, Usage: "local receive buffer size"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stats-interval" /* */, Value: ffval.NewValueDefault(&cfg.statsInterval, 10*time.Second) /* */, Usage: "stats reporting interval"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "retry-interval" /* */, Value: ffval.NewValueDefault(&cfg.retryInterval, 1*time.Second) /*  */, Usage: "connection retry interval"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'e', LongName: "events" /*             */, Value: ffval.NewValue(&cfg.streamEvents) /*                            */, Usage: "stream individual events rather than complete traces", NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "send-buffer" /*        */, Value: ffval.NewValueDefault(&cfg.sendBuf, 100) /*                     */, Usage: "remote send buffer size"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "send-policy" /*        */, Value: ffval.NewValue(&cfg.sendPolicy) /*                              */, Usage: "remote send policy when the send buffer is full: drop-newest, drop-oldest, block, summarize"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "send-timeout" /*       */, Value: ffval.NewValue(&cfg.sendTimeout) /*                             */, Usage: "remote send timeout for the block send policy"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "max-drops" /*          */, Value: ffval.NewValue(&cfg.maxDrops) /*                                */, Usage: "remote evicts the stream after this many consecutive drops, 0 for never"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "recv-buffer" /*        */, Value: ffval.NewValueDefault(&cfg.recvBuf, 100) /*                     */, Usage: "local receive buffer size"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stats-interval" /*     */, Value: ffval.NewValueDefault(&cfg.statsInterval, 10*time.Second) /*    */, Usage: "stats reporting interval"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "retry-interval" /*     */, Value: ffval.NewValueDefault(&cfg.retryInterval, 1*time.Second) /*     */, Usage: "initial connection retry interval, doubled after each failed attempt"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "max-retry-interval" /* */, Value: ffval.NewValueDefault(&cfg.maxRetryInterval, 30*time.Second) /* */, Usage: "max connection retry interval"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "listen" /*             */, Value: ffval.NewValue(&cfg.listen) /*                                  */, Usage: "serve the merged stream on this address, rather than writing to stdout", Placeholder: "ADDR"})
+	cfg.registerFormatFlag(fs)
 }
 
 func (cfg *streamConfig) Exec(ctx context.Context, args []string) error {
+	switch cfg.output {
+	case "table", "csv", "html":
+		if cfg.listen == "" && cfg.format == "" {
+			return fmt.Errorf("output format %q not supported for stream", cfg.output)
+		}
+	}
+
+	if cfg.format != "" {
+		if _, err := newTraceFormatter(cfg.stdout, cfg.format); err != nil {
+			return err
+		}
+	}
+
 	ctx, tr := cfg.newTrace(ctx, "stream")
 	defer tr.Finish()
 
@@ -46,11 +71,13 @@ func (cfg *streamConfig) Exec(ctx context.Context, args []string) error {
 	{
 		// IsActive rejects the final trace, which we always want. IsFinished
 		// rejects every trace except the last one, which is what we want to
-		// control by the streamEvents flag.
+		// control by the streamEvents flag. When streaming events, the errored
+		// and query filters should apply to each event, not the whole trace.
 		cfg.filter.IsActive = false
 		if cfg.streamEvents {
 			streaming = "events"
 			cfg.filter.IsFinished = false
+			cfg.filter.MatchEvents = true
 		} else {
 			streaming = "traces"
 			cfg.filter.IsFinished = true
@@ -63,6 +90,9 @@ func (cfg *streamConfig) Exec(ctx context.Context, args []string) error {
 		cfg.debug.Printf("recv buffer: %d", cfg.recvBuf)
 		cfg.debug.Printf("stats interval: %s", cfg.statsInterval)
 		cfg.debug.Printf("retry interval: %s", cfg.retryInterval)
+		if cfg.listen != "" {
+			cfg.info.Printf("listen: %s", cfg.listen)
+		}
 	}
 
 	cfg.debug.Printf("starting streams")
@@ -79,6 +109,9 @@ func (cfg *streamConfig) Exec(ctx context.Context, args []string) error {
 	{
 		ctx, cancel := context.WithCancel(ctx)
 		g.Add(func() error {
+			if cfg.listen != "" {
+				return cfg.serveTraces(ctx)
+			}
 			return cfg.writeTraces(ctx)
 		}, func(error) {
 			cancel()
@@ -92,23 +125,70 @@ func (cfg *streamConfig) Exec(ctx context.Context, args []string) error {
 
 func (cfg *streamConfig) runStreams(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		streams = map[string]context.CancelFunc{}
+	)
 
-	var wg sync.WaitGroup
-	for _, uri := range cfg.uris {
-		wg.Add(1)
-		go func(uri string) {
-			defer wg.Done()
-			cfg.runStream(ctx, uri)
-		}(uri)
+	// update starts streams for new URIs, and stops streams for URIs which
+	// are no longer discovered.
+	update := func(uris []string) {
+		want := make(map[string]bool, len(uris))
+		for _, uri := range uris {
+			want[uri] = true
+			if _, ok := streams[uri]; ok {
+				continue
+			}
+			if len(streams) > 0 {
+				cfg.info.Printf("%s: discovered, starting stream", uri)
+			}
+			streamCtx, streamCancel := context.WithCancel(ctx)
+			streams[uri] = streamCancel
+			wg.Add(1)
+			go func(uri string) {
+				defer wg.Done()
+				cfg.runStream(streamCtx, uri)
+			}(uri)
+		}
+		for uri, streamCancel := range streams {
+			if !want[uri] {
+				cfg.info.Printf("%s: no longer discovered, stopping stream", uri)
+				streamCancel()
+				delete(streams, uri)
+			}
+		}
 	}
 
+	update(cfg.uris)
 	cfg.debug.Printf("started streams")
-	<-ctx.Done()
-	cfg.debug.Printf("stopping streams...")
-	cancel()
-	wg.Wait()
-	cfg.debug.Printf("streams finished")
-	return nil
+
+	var refresh <-chan time.Time
+	if cfg.discover != "" {
+		ticker := time.NewTicker(cfg.discoverInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	for {
+		select {
+		case <-refresh:
+			uris, err := cfg.refreshURIs(ctx)
+			if err != nil {
+				cfg.info.Printf("%v (keeping %d stream(s))", err, len(streams))
+				continue
+			}
+			update(uris)
+
+		case <-ctx.Done():
+			cfg.debug.Printf("stopping streams...")
+			cancel()
+			wg.Wait()
+			cfg.debug.Printf("streams finished")
+			return nil
+		}
+	}
 }
 
 func (cfg *streamConfig) runStream(ctx context.Context, uri string) {
@@ -117,9 +197,15 @@ func (cfg *streamConfig) runStream(ctx context.Context, uri string) {
 	var lastData atomic.Value
 	onRead := func(ctx context.Context, eventType string, eventData []byte) {
 		lastData.Store(time.Now())
-		if eventType == "init" {
-			cfg.debug.Printf("%s: stream re/connected", uri)
-		}
+	}
+	onConnect := func(ctx context.Context) {
+		cfg.debug.Printf("%s: connected", uri)
+	}
+	onDisconnect := func(ctx context.Context, err error) {
+		cfg.info.Printf("%s: disconnected: %v", uri, err)
+	}
+	onRetry := func(ctx context.Context, attempt int, delay time.Duration) {
+		cfg.debug.Printf("%s: reconnect attempt %d in %s", uri, attempt, delay.Truncate(time.Millisecond))
 	}
 
 	reporterDone := make(chan struct{})
@@ -151,12 +237,17 @@ func (cfg *streamConfig) runStream(ctx context.Context, uri string) {
 	defer cfg.debug.Printf("%s: stopped", uri)
 
 	sc := &trcweb.StreamClient{
-		HTTPClient:    http.DefaultClient,
-		URI:           uri,
-		SendBuffer:    cfg.sendBuf,
-		OnRead:        onRead,
-		RetryInterval: cfg.retryInterval,
-		StatsInterval: cfg.statsInterval,
+		HTTPClient:       http.DefaultClient,
+		URI:              uri,
+		SendBuffer:       cfg.sendBuf,
+		SendOptions:      trc.StreamOptions{SendPolicy: trc.SendPolicy(cfg.sendPolicy), SendTimeout: cfg.sendTimeout, MaxDrops: cfg.maxDrops},
+		OnRead:           onRead,
+		OnConnect:        onConnect,
+		OnDisconnect:     onDisconnect,
+		OnRetry:          onRetry,
+		RetryInterval:    cfg.retryInterval,
+		MaxRetryInterval: cfg.maxRetryInterval,
+		StatsInterval:    cfg.statsInterval,
 	}
 
 	for ctx.Err() == nil {
@@ -182,11 +273,21 @@ func (cfg *streamConfig) runStream(ctx context.Context, uri string) {
 
 func (cfg *streamConfig) writeTraces(ctx context.Context) error {
 	var encode func(tr trc.Trace)
-	switch cfg.output {
-	case "ndjson":
+	switch {
+	case cfg.format != "":
+		f, err := newTraceFormatter(cfg.stdout, cfg.format)
+		if err != nil {
+			return err
+		}
+		encode = func(tr trc.Trace) {
+			if err := f.format(tr); err != nil {
+				cfg.info.Printf("%s: %v", tr.ID(), err)
+			}
+		}
+	case cfg.output == "ndjson":
 		enc := json.NewEncoder(cfg.stdout)
 		encode = func(tr trc.Trace) { enc.Encode(tr) }
-	case "prettyjson":
+	case cfg.output == "prettyjson":
 		enc := json.NewEncoder(cfg.stdout)
 		enc.SetIndent("", "    ")
 		encode = func(tr trc.Trace) { enc.Encode(tr) }
@@ -206,3 +307,58 @@ func (cfg *streamConfig) writeTraces(ctx context.Context) error {
 		}
 	}
 }
+
+// serveTraces re-publishes the merged stream of traces from every URI to
+// subscribers of a trace server listening on the listen address, so that e.g.
+// dashboards can subscribe to a single stream, rather than one per instance.
+// Subscribers provide their own filters, which are applied in addition to the
+// filter of the stream command. Search requests are served by searching every
+// URI, including discovered URIs.
+func (cfg *streamConfig) serveTraces(ctx context.Context) error {
+	ln, err := trcweb.Listen(cfg.listen)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	var searcher trc.MultiSearcher
+	for _, uri := range cfg.staticURIs {
+		searcher = append(searcher, trcweb.NewSearchClient(http.DefaultClient, uri))
+	}
+	if cfg.discover != "" {
+		searcher = append(searcher, &trcweb.DiscoverySearcher{
+			Spec:            cfg.discover,
+			HTTPClient:      http.DefaultClient,
+			Path:            cfg.uriPath,
+			RefreshInterval: cfg.discoverInterval,
+		})
+	}
+
+	var (
+		broker = trc.NewBroker()
+		server = &http.Server{Handler: &trcweb.TraceServer{Searcher: searcher, Streamer: broker}}
+		errc   = make(chan error, 1)
+	)
+	go func() { errc <- server.Serve(ln) }()
+
+	cfg.info.Printf("serving on %s", ln.Addr())
+
+	var count uint64
+	for {
+		select {
+		case tr := <-cfg.traces:
+			count++
+			broker.Publish(ctx, tr)
+
+		case err := <-errc:
+			return fmt.Errorf("serve: %w", err)
+
+		case <-ctx.Done():
+			cfg.debug.Printf("published trace count %d", count)
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			broker.Shutdown(shutdownCtx)
+			server.Shutdown(shutdownCtx)
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffval"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+type topConfig struct {
+	*streamConfig
+
+	window  time.Duration
+	refresh time.Duration
+	plain   bool
+}
+
+func (cfg *topConfig) register(fs *ff.FlagSet) {
+	fs.AddFlag(ff.FlagConfig{ShortName: 'w', LongName: "window" /*  */, Value: ffval.NewValueDefault(&cfg.window, time.Minute) /*     */, Usage: "compute stats over traces finished in this window"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'r', LongName: "refresh" /* */, Value: ffval.NewValueDefault(&cfg.refresh, time.Second) /*    */, Usage: "refresh interval"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "plain" /*   */, Value: ffval.NewValue(&cfg.plain) /*                          */, Usage: "don't clear the screen between refreshes", NoDefault: true})
+}
+
+func (cfg *topConfig) Exec(ctx context.Context, args []string) error {
+	ctx, tr := cfg.newTrace(ctx, "top")
+	defer tr.Finish()
+
+	// Stats are computed over complete traces.
+	cfg.filter.IsActive = false
+	cfg.filter.IsFinished = true
+	cfg.traces = make(chan trc.Trace, cfg.recvBuf)
+
+	cfg.info.Printf("filter: %s", cfg.filter)
+	cfg.debug.Printf("window: %s", cfg.window)
+	cfg.debug.Printf("refresh: %s", cfg.refresh)
+
+	var g run.Group
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return cfg.runStreams(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		ctx, cancel := context.WithCancel(ctx)
+		g.Add(func() error {
+			return cfg.renderTop(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		g.Add(run.SignalHandler(ctx, os.Interrupt, os.Kill))
+	}
+	return g.Run()
+}
+
+func (cfg *topConfig) renderTop(ctx context.Context) error {
+	var (
+		stats  = newTopStats(cfg.window, time.Now())
+		ticker = time.NewTicker(cfg.refresh)
+		buf    = &bytes.Buffer{}
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case tr := <-cfg.traces:
+			stats.observe(tr)
+
+		case now := <-ticker.C:
+			buf.Reset()
+			if !cfg.plain {
+				buf.WriteString("\033[H\033[2J") // move cursor home, clear screen
+			}
+			stats.write(buf, now, cfg.uris)
+			if _, err := buf.WriteTo(cfg.stdout); err != nil {
+				return fmt.Errorf("write: %w", err)
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//
+//
+//
+
+// topStats aggregates recently finished traces by category, over a sliding
+// window of time.
+type topStats struct {
+	window     time.Duration
+	begin      time.Time
+	categories map[string][]topSample
+}
+
+type topSample struct {
+	finished time.Time
+	duration time.Duration
+	errored  bool
+}
+
+func newTopStats(window time.Duration, begin time.Time) *topStats {
+	return &topStats{
+		window:     window,
+		begin:      begin,
+		categories: map[string][]topSample{},
+	}
+}
+
+func (s *topStats) observe(tr trc.Trace) {
+	category := tr.Category()
+	s.categories[category] = append(s.categories[category], topSample{
+		finished: tr.Started().Add(tr.Duration()),
+		duration: tr.Duration(),
+		errored:  tr.Errored(),
+	})
+}
+
+type topRow struct {
+	category string
+	count    int
+	rate     float64
+	errored  float64
+	p50, p99 time.Duration
+}
+
+// rows drops samples which have fallen out of the window, and returns a row of
+// stats for each category, ordered by rate, highest first.
+func (s *topStats) rows(now time.Time) []topRow {
+	// Rates are computed over the time we've actually been observing, until
+	// that's a full window.
+	cutoff := now.Add(-s.window)
+	elapsed := s.window
+	if observed := now.Sub(s.begin); observed < elapsed {
+		elapsed = observed
+	}
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+
+	var rows []topRow
+	for category, samples := range s.categories {
+		var i int
+		for i < len(samples) && samples[i].finished.Before(cutoff) {
+			i++
+		}
+		samples = samples[i:]
+		s.categories[category] = samples
+
+		if len(samples) <= 0 {
+			delete(s.categories, category)
+			continue
+		}
+
+		var (
+			durations = make([]time.Duration, len(samples))
+			errored   int
+		)
+		for i, sample := range samples {
+			durations[i] = sample.duration
+			if sample.errored {
+				errored++
+			}
+		}
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+		rows = append(rows, topRow{
+			category: category,
+			count:    len(samples),
+			rate:     float64(len(samples)) / elapsed.Seconds(),
+			errored:  100 * float64(errored) / float64(len(samples)),
+			p50:      topQuantile(durations, 0.50),
+			p99:      topQuantile(durations, 0.99),
+		})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].rate != rows[j].rate {
+			return rows[i].rate > rows[j].rate
+		}
+		return rows[i].category < rows[j].category
+	})
+
+	return rows
+}
+
+func (s *topStats) write(buf *bytes.Buffer, now time.Time, uris []string) {
+	rows := s.rows(now)
+
+	fmt.Fprintf(buf, "trc top - %s - %d source(s) - window %s\n\n", now.Format(time.TimeOnly), len(uris), s.window)
+
+	tw := tabwriter.NewWriter(buf, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(tw, "CATEGORY\tCOUNT\tRATE\tERRORS\tP50\tP99\n")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t%s/s\t%.1f%%\t%s\t%s\n",
+			row.category,
+			row.count,
+			trcutil.HumanizeFloat(row.rate),
+			row.errored,
+			trcutil.HumanizeDuration(row.p50),
+			trcutil.HumanizeDuration(row.p99),
+		)
+	}
+	tw.Flush()
+
+	if len(rows) <= 0 {
+		fmt.Fprintf(buf, "(no traces in window)\n")
+	}
+}
+
+// topQuantile returns the nearest-rank quantile of the sorted durations.
+func topQuantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) <= 0 {
+		return 0
+	}
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
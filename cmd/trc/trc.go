@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffval"
 	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
 )
 
 type rootConfig struct {
@@ -16,10 +20,14 @@ type rootConfig struct {
 	stdout io.Writer
 	stderr io.Writer
 
-	uris     []string
-	uriPath  string
-	logLevel string
-	output   string
+	uris             []string
+	staticURIs       []string // provided via --uri, without discovered URIs
+	uriPath          string
+	discover         string
+	discoverInterval time.Duration
+	logLevel         string
+	output           string
+	format           string
 
 	info, debug, trace *log.Logger
 
@@ -37,17 +45,25 @@ type rootConfig struct {
 }
 
 func (cfg *rootConfig) registerBaseFlags(fs *ff.FlagSet) {
-	fs.AddFlag(ff.FlagConfig{ShortName: 'u', LongName: "uri" /*      */, Value: ffval.NewUniqueList(&cfg.uris) /*                                                     */, Usage: "trace server URI (repeatable, required)" /*     */, Placeholder: "URI"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "uri-path" /* */, Value: ffval.NewValue(&cfg.uriPath) /*                                                       */, Usage: "path that will be applied to every URI" /*      */, Placeholder: "PATH"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 'l', LongName: "log" /*      */, Value: ffval.NewEnum(&cfg.logLevel, "info", "i", "debug", "d", "trace", "t", "none", "n") /* */, Usage: "log level: i/info, d/debug, t/trace, n/none" /* */, Placeholder: "LEVEL"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 'o', LongName: "output" /*   */, Value: ffval.NewEnum(&cfg.output, "ndjson", "prettyjson") /*                                 */, Usage: "output format: ndjson, prettyjson" /*           */, Placeholder: "FORMAT"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'u', LongName: "uri" /*               */, Value: ffval.NewUniqueList(&cfg.uris) /*                                                     */, Usage: "trace server URI (repeatable)" /*                       */, Placeholder: "URI"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "uri-path" /*          */, Value: ffval.NewValue(&cfg.uriPath) /*                                                       */, Usage: "path that will be applied to every URI" /*              */, Placeholder: "PATH"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "discover" /*          */, Value: ffval.NewValue(&cfg.discover) /*                                                      */, Usage: "discover URIs via dns:name (SRV) or file:path" /*       */, Placeholder: "SPEC"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "discover-interval" /* */, Value: ffval.NewValueDefault(&cfg.discoverInterval, 30*time.Second) /*                       */, Usage: "how often to refresh discovered URIs when streaming" /* */, Placeholder: "DURATION"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'l', LongName: "log" /*               */, Value: ffval.NewEnum(&cfg.logLevel, "info", "i", "debug", "d", "trace", "t", "none", "n") /* */, Usage: "log level: i/info, d/debug, t/trace, n/none" /*         */, Placeholder: "LEVEL"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'o', LongName: "output" /*            */, Value: ffval.NewEnum(&cfg.output, "ndjson", "prettyjson", "table", "csv", "html") /*         */, Usage: "output format: ndjson, prettyjson, table, csv, html" /* */, Placeholder: "FORMAT"})
+}
+
+// registerFormatFlag registers the --format flag, which is shared by commands
+// that write traces to stdout.
+func (cfg *rootConfig) registerFormatFlag(fs *ff.FlagSet) {
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "format", Value: ffval.NewValue(&cfg.format), Usage: "Go template evaluated per trace, e.g. '{{.ID}} {{.Category}} {{.Duration}}', overrides -output", Placeholder: "TEMPLATE"})
 }
 
 func (cfg *rootConfig) registerFilterFlags(fs *ff.FlagSet) {
 	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "source" /*   */, Value: ffval.NewUniqueList(&cfg.sources) /* */, NoDefault: true, Usage: "trace source (repeatable)"})
 	fs.AddFlag(ff.FlagConfig{ShortName: 'i', LongName: "id" /*       */, Value: ffval.NewUniqueList(&cfg.ids) /*     */, NoDefault: true, Usage: "trace ID (repeatable)"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 'c', LongName: "category" /* */, Value: ffval.NewValue(&cfg.category) /*     */, NoDefault: true, Usage: "trace category"})
-	fs.AddFlag(ff.FlagConfig{ShortName: 'q', LongName: "query" /*    */, Value: ffval.NewValue(&cfg.query) /*        */, NoDefault: true, Usage: "query expression", Placeholder: "REGEX"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'c', LongName: "category" /* */, Value: ffval.NewValue(&cfg.category) /*     */, NoDefault: true, Usage: "trace category, where * matches any characters"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'q', LongName: "query" /*    */, Value: ffval.NewValue(&cfg.query) /*        */, NoDefault: true, Usage: "query expression, e.g. 'cat:api err:true dur>250ms timeout'", Placeholder: "QUERY"})
 	fs.AddFlag(ff.FlagConfig{ShortName: 'a', LongName: "active" /*   */, Value: ffval.NewValue(&cfg.isActive) /*     */, NoDefault: true, Usage: "only active traces"})
 	fs.AddFlag(ff.FlagConfig{ShortName: 'f', LongName: "finished" /* */, Value: ffval.NewValue(&cfg.isFinished) /*   */, NoDefault: true, Usage: "only finished traces"})
 	fs.AddFlag(ff.FlagConfig{ShortName: 'd', LongName: "duration" /* */, Value: ffval.NewValue(&cfg.minDuration) /*  */, NoDefault: true, Usage: "only finished traces of at least this duration"})
@@ -55,6 +71,58 @@ func (cfg *rootConfig) registerFilterFlags(fs *ff.FlagSet) {
 	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "errored" /*  */, Value: ffval.NewValue(&cfg.isErrored) /*    */, NoDefault: true, Usage: "only errored traces"})
 }
 
+// normalizeURI returns the URI with a scheme, if it didn't have one, and with
+// the --uri-path applied.
+func (cfg *rootConfig) normalizeURI(uri string) (string, error) {
+	if !strings.HasPrefix(uri, "http") {
+		uri = "http://" + uri
+	}
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid: %w", uri, err)
+	}
+
+	if cfg.uriPath != "" {
+		u.Path = cfg.uriPath
+	}
+
+	return u.String(), nil
+}
+
+// refreshURIs returns the static URIs, followed by any URIs discovered via the
+// --discover spec which weren't already provided.
+func (cfg *rootConfig) refreshURIs(ctx context.Context) ([]string, error) {
+	uris := append([]string(nil), cfg.staticURIs...)
+	if cfg.discover == "" {
+		return uris, nil
+	}
+
+	discovered, err := trcweb.Discover(ctx, cfg.discover)
+	if err != nil {
+		return nil, fmt.Errorf("discover: %w", err)
+	}
+
+	index := make(map[string]bool, len(uris))
+	for _, uri := range uris {
+		index[uri] = true
+	}
+	for _, uri := range discovered {
+		normalized, err := cfg.normalizeURI(uri)
+		if err != nil {
+			cfg.info.Printf("discover: %v", err)
+			continue
+		}
+		if index[normalized] {
+			continue
+		}
+		index[normalized] = true
+		uris = append(uris, normalized)
+	}
+
+	return uris, nil
+}
+
 func (cfg *rootConfig) newTrace(ctx context.Context, category string) (context.Context, trc.Trace) {
 	ctx, tr := trc.New(ctx, "trc", category)
 	tr = trc.LogDecorator(&logWriter{Logger: cfg.trace})(tr)
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"strings"
+	"text/template"
 	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
 )
 
 func contextSleep(ctx context.Context, d time.Duration) {
@@ -23,3 +33,120 @@ func (w *logWriter) Write(p []byte) (int, error) {
 	w.Logger.Print(string(p))
 	return len(p), nil
 }
+
+//
+//
+//
+
+// formatFuncs are available to --format templates, in addition to the methods
+// and fields of the trace, which is a [trc.StaticTrace].
+var formatFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+	"join":     strings.Join,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"humanize": trcutil.HumanizeDuration,
+	"rfc3339":  func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+}
+
+// traceFormatter evaluates a --format template for each trace, and writes the
+// result to w, followed by a newline if the template doesn't end with one.
+type traceFormatter struct {
+	w       io.Writer
+	tmpl    *template.Template
+	newline bool
+}
+
+func newTraceFormatter(w io.Writer, format string) (*traceFormatter, error) {
+	tmpl, err := template.New("format").Funcs(formatFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("parse format: %w", err)
+	}
+	return &traceFormatter{
+		w:       w,
+		tmpl:    tmpl,
+		newline: !strings.HasSuffix(format, "\n"),
+	}, nil
+}
+
+func (f *traceFormatter) format(tr trc.Trace) error {
+	st, ok := tr.(*trc.StaticTrace)
+	if !ok {
+		st = trc.NewSearchTrace(tr)
+	}
+
+	var buf bytes.Buffer
+	if err := f.tmpl.Execute(&buf, st); err != nil {
+		return fmt.Errorf("execute format: %w", err)
+	}
+	if f.newline {
+		buf.WriteByte('\n')
+	}
+	if _, err := f.w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+//
+//
+//
+
+// prompter asks questions on w, and reads the answers, one per line, from r.
+type prompter struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+	done    bool
+}
+
+func newPrompter(r io.Reader, w io.Writer) *prompter {
+	return &prompter{
+		scanner: bufio.NewScanner(r),
+		w:       w,
+	}
+}
+
+// ask writes the question, with the current value, if any, and returns the
+// answer. An empty answer, or the end of the input, keeps the current value,
+// and an answer of - returns an empty string.
+func (p *prompter) ask(question, current string) string {
+	if current != "" {
+		fmt.Fprintf(p.w, "%s [%s]: ", question, current)
+	} else {
+		fmt.Fprintf(p.w, "%s: ", question)
+	}
+
+	if p.done || !p.scanner.Scan() {
+		p.done = true
+		fmt.Fprintln(p.w)
+		return current
+	}
+
+	switch answer := strings.TrimSpace(p.scanner.Text()); answer {
+	case "":
+		return current
+	case "-":
+		return ""
+	default:
+		return answer
+	}
+}
+
+// err returns the error, if any, from reading the answers.
+func (p *prompter) err() error {
+	return p.scanner.Err()
+}
+
+func parseYesNo(s string) (yes bool, ok bool) {
+	switch strings.ToLower(s) {
+	case "y", "yes", "true":
+		return true, true
+	case "n", "no", "false", "":
+		return false, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,116 @@
+// trcbench generates load against a trc collector, and reports throughput,
+// allocations, and search latency. See package trcbench for details.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffhelp"
+	"github.com/peterbourgon/ff/v4/ffval"
+	"github.com/peterbourgon/trc/trcbench"
+)
+
+func main() {
+	var (
+		ctx    = context.Background()
+		stdout = os.Stdout
+		stderr = os.Stderr
+		args   = os.Args[1:]
+	)
+	err := exec(ctx, stdout, stderr, args)
+	switch {
+	case err == nil:
+		os.Exit(0)
+	case err != nil:
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type benchConfig struct {
+	trcbench.Config
+
+	stdout io.Writer
+	name   string
+	output string
+}
+
+func (cfg *benchConfig) register(fs *ff.FlagSet) {
+	fs.AddFlag(ff.FlagConfig{ShortName: 'n', LongName: "traces" /*        */, Value: ffval.NewValue(&cfg.Traces) /*                                    */, Usage: "total number of traces, reproducible across runs" /*     */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'd', LongName: "duration" /*      */, Value: ffval.NewValue(&cfg.Duration) /*                                  */, Usage: "max duration of the run (default 10s without --traces)", Placeholder: "DURATION"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'r', LongName: "rate" /*          */, Value: ffval.NewValue(&cfg.Rate) /*                                      */, Usage: "target traces per second, or 0 for unbounded" /*         */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'c', LongName: "concurrency" /*   */, Value: ffval.NewValue(&cfg.Concurrency) /*                               */, Usage: "goroutines creating traces, or 0 for GOMAXPROCS" /*      */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "categories" /*    */, Value: ffval.NewValueDefault(&cfg.Categories, 1) /*                      */, Usage: "number of distinct categories" /*                        */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'e', LongName: "events" /*        */, Value: ffval.NewValueDefault(&cfg.EventsPerTrace, 10) /*                 */, Usage: "events per trace" /*                                     */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "error-rate" /*    */, Value: ffval.NewValue(&cfg.ErrorRate) /*                                 */, Usage: "fraction of errored traces, between 0 and 1" /*          */, Placeholder: "F"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "stacks" /*        */, Value: ffval.NewValue(&cfg.Stacks) /*                                    */, Usage: "include stack traces in events" /*                       */, NoDefault: true})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "category-size" /* */, Value: ffval.NewValue(&cfg.CategorySize) /*                              */, Usage: "max traces per category, or 0 for the default" /*        */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "search-interval", Value: ffval.NewValueDefault(&cfg.SearchInterval, 100*time.Millisecond), Usage: "interval between searches, or 0 to disable searches" /*  */, Placeholder: "DURATION"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "search-limit" /*  */, Value: ffval.NewValueDefault(&cfg.SearchLimit, 10) /*                    */, Usage: "limit of each search request" /*                         */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "insert-batch" /*  */, Value: ffval.NewValue(&cfg.InsertBatchSize) /*                           */, Usage: "insert batch size of the collector, or 0 to disable" /*  */, Placeholder: "N"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 0x0, LongName: "name" /*          */, Value: ffval.NewValueDefault(&cfg.name, "default") /*                    */, Usage: "name of the configuration in benchmark output" /*        */, Placeholder: "NAME"})
+	fs.AddFlag(ff.FlagConfig{ShortName: 'o', LongName: "output" /*        */, Value: ffval.NewEnum(&cfg.output, "text", "json", "benchmark") /*        */, Usage: "output format: text, json, benchmark (for benchstat)" /* */, Placeholder: "FORMAT"})
+}
+
+func exec(ctx context.Context, stdout, stderr io.Writer, args []string) (err error) {
+	cfg := &benchConfig{stdout: stdout}
+	fs := ff.NewFlagSet("trcbench")
+	cfg.register(fs)
+
+	cmd := &ff.Command{
+		Name:      "trcbench",
+		ShortHelp: "generate load against a trc collector",
+		LongHelp:  "Create traces in an in-process collector, as described by the flags, and report throughput, allocations, and search latency. With --traces, the load is identical across runs, so results, e.g. from repeated runs with -o benchmark, can be compared with benchstat to detect regressions.",
+		Flags:     fs,
+		Exec:      cfg.Exec,
+	}
+
+	defer func() {
+		if errors.Is(err, ff.ErrHelp) {
+			fmt.Fprintf(stderr, "\n%s\n", ffhelp.Command(cmd))
+			err = nil
+		}
+	}()
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	return cmd.ParseAndRun(ctx, args, ff.WithEnvVarPrefix("TRCBENCH"))
+}
+
+// Exec runs the benchmark. An interrupt stops the run early, and the partial
+// result is still reported.
+func (cfg *benchConfig) Exec(ctx context.Context, args []string) error {
+	res, err := trcbench.Run(ctx, cfg.Config)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	switch cfg.output {
+	case "json":
+		enc := json.NewEncoder(cfg.stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(res)
+
+	case "benchmark":
+		return res.WriteBenchmark(cfg.stdout, cfg.name)
+
+	default:
+		fmt.Fprintf(cfg.stdout, "traces       %d (%d errored) in %s\n", res.Traces, res.Errored, res.Elapsed.Round(time.Millisecond))
+		fmt.Fprintf(cfg.stdout, "throughput   %.1f traces/s, %.1f events/s\n", res.TracesPerSecond, res.EventsPerSecond)
+		fmt.Fprintf(cfg.stdout, "per trace    %.1f ns, %.1f B, %.1f allocs\n", res.NsPerTrace, res.BytesPerTrace, res.AllocsPerTrace)
+		if res.Searches > 0 {
+			l := res.SearchLatency
+			fmt.Fprintf(cfg.stdout, "searches     %d, mean %s, p50 %s, p90 %s, p99 %s, max %s\n", res.Searches, l.Mean, l.P50, l.P90, l.P99, l.Max)
+		}
+		return nil
+	}
+}
@@ -2,7 +2,13 @@ package trc
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/peterbourgon/trc/internal/trcringbuf"
@@ -11,11 +17,32 @@ import (
 
 // Collector maintains a set of traces in memory, grouped by category.
 type Collector struct {
-	source     string
-	newTrace   NewTraceFunc
-	broker     *Broker
-	decorators []DecoratorFunc
-	categories *trcringbuf.RingBuffers[Trace]
+	metadata     Metadata
+	clock        Clock
+	source       string
+	newTrace     NewTraceFunc
+	broker       *Broker
+	decorators   []DecoratorFunc
+	publish      func(category string) bool
+	retainMin    map[string]time.Duration
+	sampleRate   map[string]float64
+	categories   *trcringbuf.RingBuffers[Trace]
+	batches      *insertBatches // nil if inserts aren't batched
+	onEvict      func(Trace)
+	onFinish     func(Trace)
+	finishQueue  chan Trace // nil if OnFinish is synchronous
+	maxAge       time.Duration
+	nextPrune    atomic.Int64 // unix nanos, when maxAge > 0
+	readers      atomic.Int64 // searches and exports using ring buffer snapshots
+	lastSearch   atomic.Int64 // duration of the most recent search, in nanoseconds
+	counters     *collectorCounters
+	stackFilters []StackFilter
+	builtin      []DecoratorFunc // applied to every new trace
+	builtinPub   []DecoratorFunc // builtin, plus publishing, see Publish
+	watchContext bool
+	pinsMtx      sync.Mutex
+	pins         map[string]*collectorPin // see Pin
+	pinsLen      atomic.Int64             // len(pins), plus any Pin in progress
 }
 
 var _ Searcher = (*Collector)(nil)
@@ -29,8 +56,7 @@ type NewTraceFunc func(ctx context.Context, source string, category string, deco
 // using [New] to produce new traces.
 func NewDefaultCollector() *Collector {
 	return NewCollector(CollectorConfig{
-		Source:   "default",
-		NewTrace: New,
+		Source: "default",
 	})
 }
 
@@ -41,15 +67,179 @@ type CollectorConfig struct {
 	Source string
 
 	// NewTrace is used to construct the traces in the collector. If not
-	// provided, the [New] function is used.
+	// provided, the [New] function is used, or, if Clock is provided, the
+	// function returned by [NewWithClock].
 	NewTrace NewTraceFunc
 
-	// Decorators are applied to every new trace created in the collector.
+	// Clock is used by the collector for timing searches, and, if NewTrace
+	// is not provided, by the traces created in the collector. If not
+	// provided, the package clock set via [SetClock] is used.
+	Clock Clock
+
+	// Decorators are applied to every new trace created in the collector, in
+	// order, after the built-in decorators of the collector. See
+	// [DecoratorFunc] for details on ordering, and [ConditionalDecorator] to
+	// apply a decorator only to certain categories.
 	Decorators []DecoratorFunc
 
+	// Publish determines whether traces in a category are published to the
+	// broker, and are therefore visible to streams. For example, to stream
+	// only API traces, use [MatchCategories]("api"). If not provided, every
+	// trace is published.
+	Publish func(category string) bool
+
 	// Broker is used for streaming traces and events. If not provided, a new
 	// broker will be constructed and used.
 	Broker *Broker
+
+	// RetainMinDuration maps categories to minimum durations. Traces in those
+	// categories are evaluated when they finish, and are only retained in the
+	// collector if they errored, or if their duration is at least the minimum
+	// duration. Consequently, active traces in those categories aren't
+	// visible to search. Traces in other categories are retained as normal.
+	//
+	// This is useful for hot paths, where only slow or failing traces are
+	// interesting, and fast successful traces would otherwise evict them.
+	RetainMinDuration map[string]time.Duration
+
+	// SampleRates maps categories to the fraction of traces in those
+	// categories, greater than 0 and less than 1, which are retained in the
+	// collector. The decision is made randomly when each trace is created, and
+	// traces which aren't retained are still returned to the caller, and
+	// still streamed, but aren't visible to search. Stats for sampled
+	// categories report both observed and extrapolated totals, see
+	// [CategoryStats.ExtrapolatedTotalCount]. Rates outside of the valid range
+	// are ignored.
+	//
+	// Sampling is applied before RetainMinDuration.
+	SampleRates map[string]float64
+
+	// OnEvict is called for every trace which is dropped from the collector,
+	// because it's been overwritten by a newer trace in the same category,
+	// because the category size was reduced, or because it exceeded MaxAge.
+	// It can be used to archive traces which would otherwise be lost. OnEvict
+	// is called synchronously, and the trace may be reused after it returns,
+	// so implementations should be fast, and should copy the trace, e.g. via
+	// [NewSearchTrace], to retain it. Optional.
+	OnEvict func(Trace)
+
+	// OnFinish is called once for every trace created in the collector, when
+	// it's finished, including traces which aren't retained due to SampleRates
+	// or RetainMinDuration. It's useful to derive metrics from traces, e.g. a
+	// histogram of durations labeled by category and errored. Optional.
+	//
+	// By default, OnFinish is called synchronously, by the goroutine which
+	// finished the trace, before Finish returns. The trace is finished, so its
+	// category, duration, errored state, and events won't change, but it may be
+	// evicted and reused after OnFinish returns, so implementations should be
+	// fast, and should copy the trace, e.g. via [NewSearchTrace], to retain it.
+	OnFinish func(Trace)
+
+	// OnFinishWorkers, if greater than zero, makes OnFinish asynchronous. Each
+	// finished trace is copied to a static trace, which is safe to retain, and
+	// queued, and OnFinish is called from a pool of this many goroutines, which
+	// run for the lifetime of the collector. If the queue is full, because
+	// OnFinish is slower than the rate at which traces finish, traces are
+	// dropped rather than blocking Finish, and counted in the FinishDropped
+	// field of the category's [CollectorCategoryStats].
+	OnFinishWorkers int
+
+	// MaxAge, if greater than zero, is the maximum age of finished traces in
+	// the collector, measured from when they finished. Older traces are never
+	// returned by searches or exports, and are pruned from the collector
+	// lazily, as new traces are created and searches are performed, regardless
+	// of how full their category is. Active traces are never pruned.
+	//
+	// This is useful for low-traffic categories, where traces would otherwise
+	// be retained, and shown as if they were recent, for a very long time.
+	MaxAge time.Duration
+
+	// StackFilters are applied to the stacks of trace events in the results
+	// of searches and exports, in addition to the global stack filters
+	// registered via [RegisterStackFilter]. They're useful to hide frames
+	// which clutter every stack, e.g. from internal middleware. Optional.
+	StackFilters []StackFilter
+
+	// BaselineHalfLife is the half-life of the rolling baselines, computed from
+	// the durations and outcomes of finished traces in each category. Traces
+	// which are slower than the p99 duration of their category's baseline are
+	// marked as outliers in search results, see [StaticTrace.IsOutlier]. If
+	// zero, a default of 10 minutes is used. If negative, baselines are
+	// disabled.
+	BaselineHalfLife time.Duration
+
+	// MaxTraceBytes, if greater than zero, limits the approximate size of the
+	// event text of every trace created in the collector, in addition to the
+	// max number of events, see [SetMaxBytes]. It's useful to prevent a single
+	// trace with huge events from using more memory than many normal traces.
+	// Like FoldEvents, it's applied before any decorators.
+	MaxTraceBytes int
+
+	// FoldEvents, if true, enables event folding for every trace created in
+	// the collector, see [SetFoldEvents]. It's applied to the trace produced by
+	// NewTrace, before any decorators, so it has no effect if that trace
+	// doesn't support folding.
+	FoldEvents bool
+
+	// EventRewriter, if provided, is applied to the text of every event in
+	// every trace created in the collector, when the event is recorded, and
+	// before it's stored. It's typically used to redact secrets, e.g. tokens,
+	// which then never enter the collector at all, unlike redaction applied to
+	// search results. Lazy events are formatted immediately, rather than when
+	// they're read, so that their args aren't retained. See
+	// [SetEventRewriter]. Optional.
+	//
+	// The rewriter is applied to the trace produced by NewTrace, before any
+	// decorators, so decorators which observe events as they're recorded,
+	// e.g. [LogDecorator], see them before they're rewritten. It's called
+	// while the trace is locked, so it should be fast, and must not use the
+	// trace.
+	EventRewriter func(string) string
+
+	// MinEventLevel, if provided, is the minimum level of the events stored in
+	// every trace created in the collector, see [SetMinLevel]. For example,
+	// LevelInfo drops debug events, which are useful in development, but
+	// noisy in production. Events below the minimum level are dropped before
+	// they're formatted. Like FoldEvents, it's applied before any decorators.
+	// Optional.
+	MinEventLevel Level
+
+	// WatchContext, if true, means the context used to create each trace is
+	// watched while the trace is active. If the context is canceled, or its
+	// deadline is exceeded, before the trace is finished, an event like
+	// "context canceled after 12ms" is added to the trace, and its status is
+	// set accordingly, see [ContextStatus], unless it already has a status.
+	// This explains why e.g. abandoned requests stopped. Contexts which can
+	// never be done, e.g. context.Background, aren't watched.
+	WatchContext bool
+
+	// InsertBatchSize, if greater than one, means new traces are added to
+	// their categories in batches, rather than individually. Each new trace is
+	// buffered in one of several shards, approximately one per P, and a shard
+	// is added to the categories when it has this many traces, or when its
+	// oldest trace has been buffered for longer than InsertBatchInterval. This
+	// reduces lock contention between goroutines which create traces
+	// concurrently, which otherwise limits the peak throughput of the
+	// collector.
+	//
+	// Buffered traces are added before every search, export, or other read of
+	// the collector, so they're always visible. The cost is that evictions,
+	// and so OnEvict, are delayed until a batch is added, and that traces
+	// created concurrently may be ordered slightly differently within their
+	// category. Traces added via [Collector.Add] aren't batched. Optional.
+	InsertBatchSize int
+
+	// InsertBatchInterval is the max time a new trace is buffered, if
+	// InsertBatchSize is set. It's checked as new traces are created, so a
+	// shard which receives no new traces is only added by the next read. If
+	// zero, a default of 100ms is used.
+	InsertBatchInterval time.Duration
+
+	// Metadata is static information, e.g. hostname, region, or build version,
+	// which is attached to every trace in the collector. It's included in
+	// search results, streamed traces, and exports, and is shown in the UI.
+	// Optional.
+	Metadata Metadata
 }
 
 // NewCollector returns a new collector with the provided config.
@@ -59,20 +249,89 @@ func NewCollector(cfg CollectorConfig) *Collector {
 	}
 
 	if cfg.NewTrace == nil {
-		cfg.NewTrace = New
+		cfg.NewTrace = NewWithClock(cfg.Clock)
 	}
 
 	if cfg.Broker == nil {
 		cfg.Broker = NewBroker()
 	}
 
-	return &Collector{
-		source:     cfg.Source,
-		newTrace:   cfg.NewTrace,
-		broker:     cfg.Broker,
-		decorators: cfg.Decorators,
-		categories: trcringbuf.NewRingBuffers[Trace](1000),
+	retainMin := make(map[string]time.Duration, len(cfg.RetainMinDuration))
+	for category, d := range cfg.RetainMinDuration {
+		retainMin[category] = d
+	}
+
+	sampleRate := make(map[string]float64, len(cfg.SampleRates))
+	for category, rate := range cfg.SampleRates {
+		if rate > 0 && rate < 1 {
+			sampleRate[category] = rate
+		}
+	}
+
+	var metadata Metadata
+	if len(cfg.Metadata) > 0 {
+		metadata = make(Metadata, len(cfg.Metadata))
+		for k, v := range cfg.Metadata {
+			metadata[k] = v
+		}
+	}
+
+	c := &Collector{
+		metadata:     metadata,
+		clock:        cfg.Clock,
+		source:       cfg.Source,
+		newTrace:     cfg.NewTrace,
+		broker:       cfg.Broker,
+		decorators:   cfg.Decorators,
+		publish:      cfg.Publish,
+		retainMin:    retainMin,
+		sampleRate:   sampleRate,
+		onEvict:      cfg.OnEvict,
+		onFinish:     cfg.OnFinish,
+		maxAge:       cfg.MaxAge,
+		stackFilters: append([]StackFilter(nil), cfg.StackFilters...),
+		watchContext: cfg.WatchContext,
+		categories:   trcringbuf.NewRingBuffers[Trace](1000),
+	}
+	c.builtin, c.builtinPub = builtinDecorators(cfg, metadata)
+	if cfg.InsertBatchSize > 1 {
+		c.batches = newInsertBatches(cfg.InsertBatchSize, cfg.InsertBatchInterval)
+	}
+	c.counters = newCollectorCounters(c.getClock().Now(), iff(cfg.BaselineHalfLife == 0, defaultBaselineHalfLife, cfg.BaselineHalfLife), c.finish)
+	if cfg.OnFinish != nil && cfg.OnFinishWorkers > 0 {
+		c.finishQueue = make(chan Trace, onFinishQueueSize)
+		for i := 0; i < cfg.OnFinishWorkers; i++ {
+			go func() {
+				for tr := range c.finishQueue {
+					c.onFinish(tr)
+				}
+			}()
+		}
+	}
+	return c
+}
+
+// builtinDecorators returns the decorators which implement the config of the
+// collector, without and with publishing. They're built once, rather than for
+// every new trace, and decorators which would have no effect are omitted.
+func builtinDecorators(cfg CollectorConfig, metadata Metadata) (builtin, builtinPub []DecoratorFunc) {
+	if cfg.MaxTraceBytes > 0 {
+		builtin = append(builtin, maxBytesDecorator(cfg.MaxTraceBytes))
 	}
+	if cfg.FoldEvents {
+		builtin = append(builtin, foldEventsDecorator(true))
+	}
+	if cfg.MinEventLevel != "" {
+		builtin = append(builtin, minLevelDecorator(cfg.MinEventLevel))
+	}
+	if cfg.EventRewriter != nil {
+		builtin = append(builtin, eventRewriterDecorator(cfg.EventRewriter))
+	}
+	if len(metadata) > 0 {
+		builtin = append(builtin, metadataDecorator(metadata))
+	}
+	builtinPub = append(builtin[:len(builtin):len(builtin)], publishDecorator(cfg.Broker))
+	return builtin, builtinPub
 }
 
 // SetSourceName sets the source used by the collector.
@@ -91,7 +350,8 @@ func (c *Collector) SetNewTrace(newTrace NewTraceFunc) *Collector {
 	return c
 }
 
-// SetDecorators completely resets the decorators used by the collector.
+// SetDecorators completely resets the decorators used by the collector. See
+// [CollectorConfig.Decorators].
 //
 // The method returns its receiver to allow for builder-style construction.
 func (c *Collector) SetDecorators(decorators ...DecoratorFunc) *Collector {
@@ -101,35 +361,159 @@ func (c *Collector) SetDecorators(decorators ...DecoratorFunc) *Collector {
 
 // SetCategorySize resets the max size of each category in the collector. If any
 // categories are currently larger than the given capacity, they will be reduced
-// by dropping old traces. The default capacity is 1000.
+// by dropping old traces. The default capacity is 1000. See [Collector.Resize].
 //
 // The method returns its receiver to allow for builder-style construction.
 func (c *Collector) SetCategorySize(cap int) *Collector {
-	for _, droppedTrace := range c.categories.Resize(cap) {
-		maybeFree(droppedTrace)
-	}
+	c.Resize(cap)
 	return c
 }
 
+// Resize sets the default max number of traces in each category, and resizes
+// every category which doesn't have a specific size, see
+// [Collector.ResizeCategory]. Categories which shrink keep their newest traces,
+// and evict the rest. Categories which grow keep all of their traces. It's safe
+// to call at any time, e.g. to tune the collector without a restart, which
+// would lose the collected traces. It returns the number of evicted traces. A
+// capacity of zero or less is ignored.
+func (c *Collector) Resize(cap int) int {
+	c.flushInserts()
+	return c.evictAll(c.categories.Resize(cap))
+}
+
+// ResizeCategory sets the max number of traces in a specific category, which
+// takes precedence over the default set via [Collector.Resize], and resizes the
+// category like Resize. The size applies even if the category doesn't exist
+// yet. A capacity of zero or less removes the specific size of the category,
+// so it has the default size again. It returns the number of evicted traces.
+func (c *Collector) ResizeCategory(category string, cap int) int {
+	c.flushInserts()
+	return c.evictAll(c.categories.ResizeOne(category, cap))
+}
+
+// CategorySize returns the max number of traces in the category, which is its
+// specific size, if it has one, or the default size otherwise.
+func (c *Collector) CategorySize(category string) int {
+	return c.categories.CapOf(category)
+}
+
+// CategoryInfo describes the traces currently retained in a single category of
+// a collector. Oldest and Newest are the start times of the oldest and newest
+// traces, and are zero if the category is empty.
+type CategoryInfo struct {
+	Category string    `json:"category"`
+	Count    int       `json:"count"`
+	Oldest   time.Time `json:"oldest"`
+	Newest   time.Time `json:"newest"`
+}
+
+// Categories returns information about every category in the collector,
+// ordered by name. Categories which have had traces, but are currently empty,
+// are included with a count of zero. It's much cheaper than a search, and is
+// meant for e.g. autocompletion of category names.
+func (c *Collector) Categories() []CategoryInfo {
+	c.flushInserts()
+	c.maybePrune()
+
+	c.readers.Add(1) // see Search
+	defer c.readers.Add(-1)
+
+	ringBufs := c.categories.GetAll()
+	infos := make([]CategoryInfo, 0, len(ringBufs))
+	for category, rb := range ringBufs {
+		info := CategoryInfo{Category: category}
+		newest, oldest, count := rb.Stats()
+		if count > 0 {
+			info.Count = count
+			info.Oldest = oldest.Started()
+			info.Newest = newest.Started()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Category < infos[j].Category
+	})
+	return infos
+}
+
+func (c *Collector) evictAll(dropped []Trace) int {
+	for _, droppedTrace := range dropped {
+		c.evict(droppedTrace)
+	}
+	return len(dropped)
+}
+
 // NewTrace produces a new trace in the collector with the given category,
 // injects it into the given context, and returns a new derived context
 // containing the trace, as well as the new trace itself.
 func (c *Collector) NewTrace(ctx context.Context, category string) (context.Context, Trace) {
+	return c.NewTraceWithSource(ctx, c.source, category)
+}
+
+// NewTraceWithSource is like NewTrace, but the new trace has the given source,
+// rather than the source of the collector. This allows one process, with one
+// collector, to distinguish the traces of several logical components, e.g. the
+// subsystems of a modular monolith, which can then be selected by source in
+// searches and streams, like traces from separate processes. If the source is
+// empty, the source of the collector is used.
+//
+// Categories, and their sizes and stats, are shared by all sources.
+//
+//	billing := func(ctx context.Context, category string) (context.Context, trc.Trace) {
+//		return collector.NewTraceWithSource(ctx, "billing", category)
+//	}
+//	handler = trcweb.Middleware(billing, categorize)(handler)
+func (c *Collector) NewTraceWithSource(ctx context.Context, source string, category string) (context.Context, Trace) {
 	if tr, ok := MaybeGet(ctx); ok {
 		tr.LazyTracef("(+ %s)", category)
 		return ctx, tr
 	}
 
-	ctx, tr := c.newTrace(ctx, c.source, category, publishDecorator(c.broker))
+	if source == "" {
+		source = c.source
+	}
+
+	c.maybePrune()
+
+	builtin := c.builtin
+	if c.publish == nil || c.publish(category) {
+		builtin = c.builtinPub
+	}
+
+	ctx, tr := c.newTrace(ctx, source, category, builtin...)
 
 	for _, d := range c.decorators {
 		tr = d(tr)
 	}
 
-	if droppedTrace, didDrop := c.categories.GetOrCreate(category).Add(tr); didDrop {
-		maybeFree(droppedTrace)
+	if c.watchContext {
+		tr = watchContext(ctx, tr)
+	}
+
+	// Finished traces are counted by a hook in the core trace, if possible, to
+	// avoid another wrapper.
+	counters := c.counters.get(category)
+	counters.created.Add(1)
+	if !setFinishHook(tr, counters) {
+		tr = &countTrace{Trace: tr, counters: counters}
+	}
+
+	if rate, ok := c.sampleRate[category]; ok && rand.Float64() >= rate {
+		counters.sampled.Add(1)
+		return Put(ctx, tr) // not retained, so never evicted, or free'd
+	}
+
+	if min, ok := c.retainMin[category]; ok {
+		return Put(ctx, &retainTrace{
+			Trace:   tr,
+			min:     min,
+			ringBuf: c.categories.GetOrCreate(category),
+			evict:   c.evict,
+		})
 	}
 
+	c.insert(category, tr)
+
 	return Put(ctx, tr)
 }
 
@@ -137,80 +521,578 @@ func (c *Collector) NewTrace(ctx context.Context, category string) (context.Cont
 func (c *Collector) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
 	var (
 		tr            = Get(ctx)
-		begin         = time.Now()
+		clock         = c.getClock()
+		begin         = clock.Now()
 		normalizeErrs = req.Normalize()
 		stats         = NewSearchStats(req.Bucketing)
 		totalCount    = 0
 		matchCount    = 0
 		traces        = []*StaticTrace{}
+		budget        = newSearchBudget(ctx, clock, begin, req)
+		otherSources  = map[string]bool{} // e.g. added traces
 	)
 
-	for _, ringBuf := range c.categories.GetAll() { // TODO: could do these concurrently
-		var categoryTraces []*StaticTrace
-		ringBuf.Walk(func(candidate Trace) error {
+	// Pending inserts are added, and expired traces are pruned, before the
+	// search, but some traces may remain, or expire during the search, so
+	// they're also checked individually below.
+	c.flushInserts()
+	c.maybePrune()
+	expired := c.expiredFunc(begin)
+
+	// Searches operate on snapshots of each ring buffer, so they don't block
+	// the insertion of new traces. Traces dropped from a ring buffer while
+	// searches are active may still be in a snapshot, so they're not free'd.
+	c.readers.Add(1)
+	defer c.readers.Add(-1)
+
+	// Categories are matched once per ring buffer, rather than once per trace,
+	// which matters for category patterns.
+	traceFilter := req.Filter.withoutCategory()
+
+	for category, ringBuf := range c.categories.GetAll() { // TODO: could do these concurrently
+		var (
+			categoryTraces  []*StaticTrace
+			candidates      []Trace // matching traces, if the search is sorted
+			sorted          = req.Sort != SearchSortNewest
+			categoryAllowed = req.Filter.allowCategory(category)
+		)
+
+		// Selected traces are copied, and compared to the baseline of their
+		// category, if there is one.
+		outlierThreshold, haveThreshold := c.counters.outlierThreshold(category)
+		selectTrace := func(tr Trace) {
+			st := c.newSearchTrace(tr).TrimStacks(req.StackDepth).TrimLevels(req.MinEventLevel)
+			if haveThreshold {
+				st.TraceBaselineP99 = outlierThreshold
+				st.TraceOutlier = st.TraceDuration > outlierThreshold
+			}
+			categoryTraces = append(categoryTraces, st)
+			matchCount++
+		}
+
+		for _, candidate := range append(ringBuf.Snapshot(), c.evictedPins(category)...) {
+			// Expired traces are treated as if they've already been pruned.
+			if expired(candidate) {
+				continue
+			}
+
 			// Every candidate trace should be observed.
-			stats.Observe(candidate)
+			if rate, ok := c.sampleRate[candidate.Category()]; ok {
+				stats.ObserveSampled(rate, candidate)
+			} else {
+				stats.Observe(candidate)
+			}
 			totalCount++
 
+			// Traces normally have the source of the collector, but not e.g.
+			// traces which were added directly, which should still be
+			// reported as sources, so they can be selected by filters.
+			if source := candidate.Source(); source != c.source {
+				otherSources[source] = true
+			}
+
+			// Stats-only searches don't select any traces.
+			if req.StatsOnly {
+				continue
+			}
+
+			// If the filter won't allow the category, then we won't select any
+			// traces from it, and there's no need to evaluate them.
+			if !categoryAllowed {
+				continue
+			}
+
 			// If we already have the max number of traces from this category,
 			// then we won't select any more. We do this first, because it's
-			// cheaper than checking allow.
-			if len(categoryTraces) >= req.Limit {
-				return nil
+			// cheaper than checking allow. Snapshots are newest first, so this
+			// only works for the default sort.
+			if !sorted && len(categoryTraces) >= req.Limit {
+				continue
+			}
+
+			// If the search budget is exhausted, then we won't evaluate any
+			// more traces, but we still observe them above.
+			if !budget.allow() {
+				continue
 			}
 
 			// If the filter won't allow this trace, then we won't select it.
-			if !req.Filter.Allow(candidate) {
-				return nil
+			if !traceFilter.Allow(candidate) {
+				continue
 			}
 
-			// Otherwise, collect a static copy of the trace.
-			categoryTraces = append(categoryTraces, NewSearchTrace(candidate).TrimStacks(req.StackDepth))
-			matchCount++
-			return nil
-		})
+			// Otherwise, select the trace, or, if the search is sorted, keep
+			// it as a candidate until every trace has been evaluated.
+			if sorted {
+				candidates = append(candidates, candidate)
+			} else {
+				selectTrace(candidate)
+			}
+		}
+
+		for _, candidate := range selectTraces(candidates, req.Sort, req.Limit) {
+			selectTrace(candidate)
+		}
+
 		traces = append(traces, categoryTraces...)
 	}
 
-	// Sort most recent first.
-	sort.Sort(staticTracesNewestFirst(traces))
+	// Sort the traces from every category together.
+	sortStaticTraces(traces, req.Sort)
+
+	stats.setAsOf(begin.UTC())
+	stats.setQuantiles()
 
-	// Take only the most recent traces as per the limit.
+	// Take only the first traces as per the limit.
 	if len(traces) > req.Limit {
 		traces = traces[:req.Limit]
 	}
 
 	tr.LazyTracef("%s -> total %d, matched %d, returned %d", c.source, totalCount, matchCount, len(traces))
 
+	if err := budget.err(); err != nil {
+		tr.LazyTracef("%s: %v", c.source, err)
+		normalizeErrs = append(normalizeErrs, fmt.Errorf("%s: %w", c.source, err))
+	}
+
+	sources := []string{c.source}
+	for source := range otherSources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources[1:])
+
+	duration := clockSince(clock, begin)
+	c.lastSearch.Store(int64(duration))
+
 	return &SearchResponse{
 		Request:    req,
-		Sources:    []string{c.source},
+		Sources:    sources,
+		Versions:   Versions{c.source: Version()},
 		TotalCount: totalCount,
 		MatchCount: matchCount,
 		Traces:     traces,
 		Stats:      stats,
 		Problems:   trcutil.FlattenErrors(normalizeErrs...),
-		Duration:   time.Since(begin),
+		Duration:   duration,
 	}, nil
 }
 
+// Export calls fn with a static copy of every trace in the collector which
+// passes the filter, including full stacks. Unlike search, there is no limit on
+// the number of traces. Traces are copied one category at a time, and fn is
+// called outside of any lock, so a slow fn provides natural backpressure
+// without blocking the creation of new traces. Export stops and returns the
+// first error returned by fn, or the context error if the context is canceled.
+func (c *Collector) Export(ctx context.Context, f Filter, fn func(*StaticTrace) error) error {
+	tr := Get(ctx)
+
+	if normalizeErrs := f.Normalize(); len(normalizeErrs) > 0 {
+		return fmt.Errorf("filter: %s", strings.Join(trcutil.FlattenErrors(normalizeErrs...), "; "))
+	}
+
+	c.flushInserts()
+	c.maybePrune()
+	expired := c.expiredFunc(c.getClock().Now())
+
+	var exported int
+	for category, ringBuf := range c.categories.GetAll() {
+		categoryTraces := func() []*StaticTrace {
+			c.readers.Add(1) // see Search
+			defer c.readers.Add(-1)
+
+			var categoryTraces []*StaticTrace
+			for _, candidate := range append(ringBuf.Snapshot(), c.evictedPins(category)...) {
+				if !expired(candidate) && f.Allow(candidate) {
+					categoryTraces = append(categoryTraces, c.newSearchTrace(candidate))
+				}
+			}
+			return categoryTraces
+		}()
+
+		for _, st := range categoryTraces {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(st); err != nil {
+				return err
+			}
+			exported++
+		}
+	}
+
+	tr.LazyTracef("%s -> exported %d", c.source, exported)
+
+	return nil
+}
+
+// ErrTraceNotFound is returned by [Collector.Annotate] when there's no trace
+// with the given ID in the collector.
+var ErrTraceNotFound = errors.New("trace not found")
+
+// ErrTraceFinished is returned by [Collector.Annotate] when the trace with the
+// given ID is already finished.
+var ErrTraceFinished = errors.New("trace already finished")
+
+// Annotate adds a normal event to the active trace in the collector with the
+// given ID. It's meant for external systems which want to attach information,
+// e.g. a deployment marker, to in-flight traces. The event has no stack.
+//
+// Annotate returns ErrTraceNotFound if no trace with the ID is in the
+// collector, which includes active traces in categories which are subject to
+// RetainMinDuration, and ErrTraceFinished if the trace is already finished.
+func (c *Collector) Annotate(id string, format string, args ...any) error {
+	c.flushInserts()
+
+	c.readers.Add(1) // see Search
+	defer c.readers.Add(-1)
+
+	for _, ringBuf := range c.categories.GetAll() {
+		for _, candidate := range ringBuf.Snapshot() {
+			if candidate.ID() != id {
+				continue
+			}
+			if candidate.Finished() {
+				return ErrTraceFinished
+			}
+			mergeEvents(candidate, []Event{{
+				When: c.getClock().Now().UTC(),
+				What: safeSprintf(format, args...),
+			}})
+			return nil
+		}
+	}
+
+	return ErrTraceNotFound
+}
+
+// Clear removes every finished trace which passes the filter from the
+// collector, and returns the number of removed traces. Active traces are never
+// removed, as they're still in use, and neither are pinned traces, see Pin.
+// Removed traces are passed to OnEvict.
+func (c *Collector) Clear(ctx context.Context, f Filter) (int, error) {
+	tr := Get(ctx)
+
+	if normalizeErrs := f.Normalize(); len(normalizeErrs) > 0 {
+		return 0, fmt.Errorf("filter: %s", strings.Join(trcutil.FlattenErrors(normalizeErrs...), "; "))
+	}
+
+	c.flushInserts()
+	pinned := c.pinnedIDs()
+	cleared := c.categories.Prune(func(candidate Trace) bool {
+		return candidate.Finished() && !pinned[candidate.ID()] && f.Allow(candidate)
+	})
+	for _, clearedTrace := range cleared {
+		c.evict(clearedTrace)
+	}
+
+	tr.LazyTracef("%s: cleared %d trace(s)", c.source, len(cleared))
+
+	return len(cleared), nil
+}
+
+// Add inserts existing traces directly into the collector, e.g. static traces
+// decoded from an archive, so that they can be searched like any other trace.
+// Decorators, sampling, RetainMinDuration, and streaming don't apply to added
+// traces, which are subject only to the category size and max age.
+func (c *Collector) Add(traces ...Trace) {
+	for _, tr := range traces {
+		c.add(tr)
+	}
+}
+
 // Stream traces matching the filter to the channel, returning when the context
 // is canceled. See [Broker.Stream] for more details.
 func (c *Collector) Stream(ctx context.Context, f Filter, ch chan<- Trace) (StreamStats, error) {
 	return c.broker.Stream(ctx, f, ch)
 }
 
+// StreamWithOptions is like Stream, but with options. See
+// [Broker.StreamWithOptions] for more details.
+func (c *Collector) StreamWithOptions(ctx context.Context, f Filter, ch chan<- Trace, opts StreamOptions) (StreamStats, error) {
+	return c.broker.StreamWithOptions(ctx, f, ch, opts)
+}
+
 // StreamStats returns statistics about a currently active subscription.
 func (c *Collector) StreamStats(ctx context.Context, ch chan<- Trace) (StreamStats, error) {
 	return c.broker.StreamStats(ctx, ch)
 }
 
+// Subscriptions returns a description of every active stream subscription to
+// the collector. See [Broker.Subscriptions] for more details.
+func (c *Collector) Subscriptions() []SubscriptionInfo {
+	return c.broker.Subscriptions()
+}
+
+// newSearchTrace is like NewSearchTrace, but ensures the collector metadata is
+// included, even if the trace is wrapped by decorators which hide it, marks
+// pinned traces, and applies the collector stack filters.
+func (c *Collector) newSearchTrace(tr Trace) *StaticTrace {
+	st := NewSearchTrace(tr)
+	if st.TraceMetadata == nil {
+		st.TraceMetadata = c.metadata
+	}
+	st.TracePinned = c.isPinned(tr)
+	return st.FilterStacks(c.stackFilters...)
+}
+
+func (c *Collector) getClock() Clock {
+	if c.clock != nil {
+		return c.clock
+	}
+	return getClock()
+}
+
 //
 //
 //
 
+// evict is called for each trace which has been dropped from a ring buffer. It
+// calls the OnEvict hook, if any, and then frees the trace, unless there are
+// active readers, which may still be using the trace via a snapshot. In that
+// case, the trace is left to the GC. Pinned traces aren't evicted until they're
+// unpinned.
+func (c *Collector) evict(tr Trace) {
+	if c.keepPinned(tr) {
+		return
+	}
+	c.counters.get(tr.Category()).evicted.Add(1)
+	if c.onEvict != nil {
+		c.onEvict(tr)
+	}
+	if c.readers.Load() > 0 {
+		return
+	}
+	maybeFree(tr)
+}
+
+// finish is called for each trace created in the collector, when it's finished,
+// and calls the OnFinish hook, if any, either directly or via the queue.
+func (c *Collector) finish(tr Trace) {
+	if c.onFinish == nil {
+		return
+	}
+
+	if c.finishQueue == nil {
+		c.onFinish(tr)
+		return
+	}
+
+	select {
+	case c.finishQueue <- c.newSearchTrace(tr):
+	default:
+		c.counters.get(tr.Category()).finishDropped.Add(1)
+	}
+}
+
+// onFinishQueueSize is the number of finished traces which can be queued for an
+// asynchronous OnFinish hook before traces are dropped.
+const onFinishQueueSize = 1024
+
+// expiredFunc returns a function which reports whether a trace has exceeded the
+// max age of the collector as of the given time. Pinned traces never expire.
+func (c *Collector) expiredFunc(now time.Time) func(Trace) bool {
+	if c.maxAge <= 0 {
+		return func(Trace) bool { return false }
+	}
+	var (
+		cutoff = now.Add(-c.maxAge)
+		pinned = c.pinnedIDs()
+	)
+	return func(tr Trace) bool {
+		return tr.Finished() && tr.Started().Add(tr.Duration()).Before(cutoff) && !pinned[tr.ID()]
+	}
+}
+
+// maybePrune removes expired traces from the collector, if the collector has a
+// max age, and if enough time has passed since the previous prune. Pruning
+// visits every trace, so it's done at most a few times per max age.
+func (c *Collector) maybePrune() {
+	if c.maxAge <= 0 {
+		return
+	}
+
+	var (
+		now  = c.getClock().Now()
+		next = c.nextPrune.Load()
+	)
+	if now.UnixNano() < next {
+		return
+	}
+	if !c.nextPrune.CompareAndSwap(next, now.Add(c.maxAge/collectorPrunesPerMaxAge).UnixNano()) {
+		return // another goroutine is pruning
+	}
+
+	for _, expiredTrace := range c.categories.Prune(c.expiredFunc(now)) {
+		c.evict(expiredTrace)
+	}
+}
+
+const collectorPrunesPerMaxAge = 10
+
+// searchBudget enforces the execution budget of a search request, as well as
+// the cancelation of its context.
+type searchBudget struct {
+	ctx         context.Context
+	clock       Clock
+	begin       time.Time
+	maxScan     int
+	maxDuration time.Duration
+	scanned     int
+	exhausted   error
+}
+
+// searchBudgetCheckEvery is how many traces are scanned between checks of the
+// clock and the context, which are relatively expensive.
+const searchBudgetCheckEvery = 64
+
+func newSearchBudget(ctx context.Context, clock Clock, begin time.Time, req *SearchRequest) *searchBudget {
+	return &searchBudget{
+		ctx:         ctx,
+		clock:       clock,
+		begin:       begin,
+		maxScan:     req.MaxScan,
+		maxDuration: req.MaxDuration,
+	}
+}
+
+// allow returns true if another trace may be scanned.
+func (b *searchBudget) allow() bool {
+	if b.exhausted != nil {
+		return false
+	}
+
+	switch {
+	case b.maxScan > 0 && b.scanned >= b.maxScan:
+		b.exhausted = fmt.Errorf("search budget exhausted: scanned max %d trace(s), results are partial", b.maxScan)
+	case b.scanned%searchBudgetCheckEvery == 0 && b.maxDuration > 0 && clockSince(b.clock, b.begin) >= b.maxDuration:
+		b.exhausted = fmt.Errorf("search budget exhausted: exceeded max duration %s after %d trace(s), results are partial", b.maxDuration, b.scanned)
+	case b.scanned%searchBudgetCheckEvery == 0 && b.ctx.Err() != nil:
+		b.exhausted = fmt.Errorf("search stopped after %d trace(s), results are partial: %w", b.scanned, b.ctx.Err())
+	}
+
+	if b.exhausted != nil {
+		return false
+	}
+
+	b.scanned++
+	return true
+}
+
+// err returns a non-nil error if the budget was exhausted.
+func (b *searchBudget) err() error {
+	return b.exhausted
+}
+
 func maybeFree(tr Trace) {
 	if f, ok := tr.(interface{ Free() }); ok {
 		f.Free()
 	}
 }
+
+// retainTrace defers adding a trace to its ring buffer until the trace is
+// finished, and only adds it if it errored, or took at least the min duration.
+// Traces which aren't retained aren't free'd, as callers may still be using
+// them, and will instead be GC'd.
+type retainTrace struct {
+	Trace
+	min      time.Duration
+	ringBuf  *trcringbuf.RingBuffer[Trace]
+	evict    func(Trace)
+	finished atomic.Bool
+}
+
+var _ interface{ Free() } = (*retainTrace)(nil)
+
+func (rtr *retainTrace) Unwrap() Trace {
+	return rtr.Trace
+}
+
+func (rtr *retainTrace) Finish() {
+	if !rtr.finished.CompareAndSwap(false, true) || rtr.Trace.Finished() {
+		return // concurrent calls to Finish must add the trace only once
+	}
+
+	rtr.Trace.Finish()
+
+	if !rtr.Trace.Errored() && rtr.Trace.Duration() < rtr.min {
+		return
+	}
+
+	if droppedTrace, didDrop := rtr.ringBuf.Add(rtr); didDrop {
+		rtr.evict(droppedTrace)
+	}
+}
+
+func (rtr *retainTrace) Free() {
+	maybeFree(rtr.Trace)
+}
+
+//
+//
+//
+
+// TeeDecorator returns a decorator which copies each decorated trace into the
+// dst collector when the trace is finished, if allow returns true for the
+// finished trace. If allow is nil, every trace is copied. The copy is static,
+// and keeps the source and category of the original trace.
+//
+// This is useful to keep a secondary collector with a different size or max
+// age, e.g. a small, long-lived collector of only errored traces, alongside a
+// high-churn general collector.
+//
+//	errors := trc.NewCollector(trc.CollectorConfig{Source: "errors"})
+//	general := trc.NewCollector(trc.CollectorConfig{
+//		Decorators: []trc.DecoratorFunc{
+//			trc.TeeDecorator(errors, func(tr trc.Trace) bool { return tr.Errored() }),
+//		},
+//	})
+func TeeDecorator(dst *Collector, allow func(Trace) bool) DecoratorFunc {
+	return func(tr Trace) Trace {
+		return &teeTrace{Trace: tr, dst: dst, allow: allow}
+	}
+}
+
+// add a trace directly to the collector, bypassing decorators, sampling, and
+// min durations.
+func (c *Collector) add(tr Trace) {
+	c.maybePrune()
+
+	if tr.Finished() {
+		c.counters.get(tr.Category()).observeBaseline(tr)
+	}
+
+	if droppedTrace, didDrop := c.categories.GetOrCreate(tr.Category()).Add(tr); didDrop {
+		c.evict(droppedTrace)
+	}
+}
+
+type teeTrace struct {
+	Trace
+	dst      *Collector
+	allow    func(Trace) bool
+	finished atomic.Bool
+}
+
+var _ interface{ Free() } = (*teeTrace)(nil)
+
+func (ttr *teeTrace) Unwrap() Trace {
+	return ttr.Trace
+}
+
+func (ttr *teeTrace) Finish() {
+	if !ttr.finished.CompareAndSwap(false, true) || ttr.Trace.Finished() {
+		return // concurrent calls to Finish must copy the trace only once
+	}
+
+	ttr.Trace.Finish()
+
+	if ttr.allow != nil && !ttr.allow(ttr.Trace) {
+		return
+	}
+
+	ttr.dst.add(NewSearchTrace(ttr.Trace))
+}
+
+func (ttr *teeTrace) Free() {
+	maybeFree(ttr.Trace)
+}
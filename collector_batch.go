@@ -0,0 +1,136 @@
+package trc
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// insertBatches buffers new traces in shards, before they're added to the ring
+// buffers of their categories, so that goroutines creating traces concurrently
+// don't contend on the same locks. See [CollectorConfig.InsertBatchSize].
+type insertBatches struct {
+	size     int
+	interval time.Duration
+	shards   []*insertShard
+	next     atomic.Uint64
+	local    sync.Pool // *insertShard
+}
+
+// insertShard is a batch of pending traces. There are GOMAXPROCS shards, and
+// each P typically uses the same shard, via the pool, so shards are rarely
+// contended.
+type insertShard struct {
+	mtx     sync.Mutex
+	pending []pendingTrace
+	oldest  time.Time // when the first pending trace was buffered
+}
+
+type pendingTrace struct {
+	category string
+	tr       Trace
+}
+
+// defaultInsertBatchInterval is used when InsertBatchSize is set, but
+// InsertBatchInterval isn't.
+const defaultInsertBatchInterval = 100 * time.Millisecond
+
+func newInsertBatches(size int, interval time.Duration) *insertBatches {
+	if interval <= 0 {
+		interval = defaultInsertBatchInterval
+	}
+
+	b := &insertBatches{
+		size:     size,
+		interval: interval,
+		shards:   make([]*insertShard, runtime.GOMAXPROCS(0)),
+	}
+	for i := range b.shards {
+		b.shards[i] = &insertShard{pending: make([]pendingTrace, 0, size)}
+	}
+
+	// The pool caches a shard per P. If the cache is cleared, e.g. by the GC,
+	// shards are handed out again round-robin. Every shard remains in the
+	// shards slice, so no pending traces are lost.
+	b.local.New = func() any {
+		return b.shards[b.next.Add(1)%uint64(len(b.shards))]
+	}
+
+	return b
+}
+
+// insert adds a new trace to the ring buffer of its category, either directly,
+// or via a batch, if the collector batches inserts.
+func (c *Collector) insert(category string, tr Trace) {
+	if c.batches == nil {
+		if droppedTrace, didDrop := c.categories.GetOrCreate(category).Add(tr); didDrop {
+			c.evict(droppedTrace)
+		}
+		return
+	}
+
+	var (
+		now   = c.getClock().Now()
+		shard = c.batches.local.Get().(*insertShard)
+	)
+	defer c.batches.local.Put(shard)
+
+	shard.mtx.Lock()
+	if len(shard.pending) <= 0 {
+		shard.oldest = now
+	}
+	shard.pending = append(shard.pending, pendingTrace{category: category, tr: tr})
+	full := len(shard.pending) >= c.batches.size || now.Sub(shard.oldest) >= c.batches.interval
+	shard.mtx.Unlock()
+
+	if full {
+		c.flushShard(shard)
+	}
+}
+
+// flushInserts adds every pending trace to the ring buffers of their
+// categories. It's called before every read of the ring buffers, so pending
+// traces are always visible to e.g. searches. It must not be called while
+// holding the pins lock, as dropped traces are evicted.
+func (c *Collector) flushInserts() {
+	if c.batches == nil {
+		return
+	}
+	for _, shard := range c.batches.shards {
+		c.flushShard(shard)
+	}
+}
+
+// flushShard adds the pending traces in the shard to the ring buffers of their
+// categories, taking each ring buffer lock once per category, rather than once
+// per trace. The shard is locked until the traces are added, so that concurrent
+// callers of flushInserts don't return before the traces are visible. Dropped
+// traces are evicted after the shard is unlocked.
+func (c *Collector) flushShard(shard *insertShard) {
+	dropped := func() []Trace {
+		shard.mtx.Lock()
+		defer shard.mtx.Unlock()
+
+		if len(shard.pending) <= 0 {
+			return nil
+		}
+
+		byCategory := map[string][]Trace{}
+		for _, p := range shard.pending {
+			byCategory[p.category] = append(byCategory[p.category], p.tr)
+		}
+
+		var dropped []Trace
+		for category, traces := range byCategory {
+			dropped = append(dropped, c.categories.GetOrCreate(category).AddAll(traces)...)
+		}
+
+		clear(shard.pending) // don't retain traces
+		shard.pending = shard.pending[:0]
+
+		return dropped
+	}()
+
+	c.evictAll(dropped)
+}
@@ -0,0 +1,75 @@
+package trc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// watchContext returns a trace which, if the context is done before the trace
+// is finished, adds an event describing the context error, and sets the status
+// of the trace accordingly, see [ContextStatus]. Contexts which can never be
+// done, e.g. context.Background, aren't watched.
+func watchContext(ctx context.Context, tr Trace) Trace {
+	if ctx.Done() == nil {
+		return tr
+	}
+
+	wtr := &watchTrace{Trace: tr, done: make(chan struct{})}
+	wtr.stop = context.AfterFunc(ctx, func() {
+		defer close(wtr.done)
+		wtr.contextDone(ctx)
+	})
+	return wtr
+}
+
+// watchTrace stops watching its context when it's finished. If the context is
+// already done, Finish waits for the event to be added, so the trace is never
+// modified after it's finished, and possibly reused.
+type watchTrace struct {
+	Trace
+	stop func() bool
+	done chan struct{}
+	once sync.Once
+}
+
+var _ interface{ Free() } = (*watchTrace)(nil)
+
+func (wtr *watchTrace) Unwrap() Trace {
+	return wtr.Trace
+}
+
+func (wtr *watchTrace) contextDone(ctx context.Context) {
+	if wtr.Trace.Finished() {
+		return
+	}
+
+	var (
+		err   = ctx.Err()
+		cause = context.Cause(ctx)
+		took  = trcutil.HumanizeDuration(wtr.Trace.Duration())
+	)
+	if cause != nil && cause != err {
+		wtr.Trace.Tracef("%v after %s (%v)", err, took, cause)
+	} else {
+		wtr.Trace.Tracef("%v after %s", err, took)
+	}
+
+	if Status(wtr.Trace) == "" {
+		SetStatus(wtr.Trace, ContextStatus(err))
+	}
+}
+
+func (wtr *watchTrace) Finish() {
+	wtr.once.Do(func() {
+		if !wtr.stop() {
+			<-wtr.done
+		}
+	})
+	wtr.Trace.Finish()
+}
+
+func (wtr *watchTrace) Free() {
+	maybeFree(wtr.Trace)
+}
@@ -0,0 +1,94 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CollectorGroup is a set of isolated collectors, identified by name, which
+// share a common config. It's intended for multi-tenant services, where each
+// tenant gets its own collector, so that searches and streams for one tenant
+// can never observe the traces of another.
+//
+// Each collector in the group is created from the group config, with the
+// following changes: the Broker is always a new broker, so that streams are
+// isolated, and the Metadata includes a "tenant" key, set to the name of the
+// collector.
+type CollectorGroup struct {
+	cfg CollectorConfig
+
+	mtx        sync.Mutex
+	collectors map[string]*Collector
+}
+
+// CollectorGroupMetadataKey is the metadata key added to every trace created
+// by a collector in a collector group, with the name of the collector.
+const CollectorGroupMetadataKey = "tenant"
+
+// NewCollectorGroup returns a new and empty collector group. Collectors are
+// created from the config on first use, see [CollectorGroup.Collector].
+func NewCollectorGroup(cfg CollectorConfig) *CollectorGroup {
+	return &CollectorGroup{
+		cfg:        cfg,
+		collectors: map[string]*Collector{},
+	}
+}
+
+// Collector returns the collector with the given name, creating it if it
+// doesn't already exist. The name must not be empty.
+func (g *CollectorGroup) Collector(name string) *Collector {
+	if name == "" {
+		panic(fmt.Errorf("trc: empty collector group name"))
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if c, ok := g.collectors[name]; ok {
+		return c
+	}
+
+	cfg := g.cfg
+	cfg.Broker = nil // each collector must have its own broker
+	cfg.Metadata = make(Metadata, len(g.cfg.Metadata)+1)
+	for k, v := range g.cfg.Metadata {
+		cfg.Metadata[k] = v
+	}
+	cfg.Metadata[CollectorGroupMetadataKey] = name
+
+	c := NewCollector(cfg)
+	g.collectors[name] = c
+	return c
+}
+
+// Lookup returns the collector with the given name, if it exists. Unlike
+// Collector, it never creates a new collector, so it's safe to call with
+// untrusted names, e.g. from HTTP requests.
+func (g *CollectorGroup) Lookup(name string) (*Collector, bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	c, ok := g.collectors[name]
+	return c, ok
+}
+
+// Names returns the names of every collector in the group, in sorted order.
+func (g *CollectorGroup) Names() []string {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	names := make([]string, 0, len(g.collectors))
+	for name := range g.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTrace creates a new trace in the collector with the given name, creating
+// the collector if it doesn't already exist. See [Collector.NewTrace].
+func (g *CollectorGroup) NewTrace(ctx context.Context, name, category string) (context.Context, Trace) {
+	return g.Collector(name).NewTrace(ctx, category)
+}
@@ -0,0 +1,51 @@
+package trc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestCollectorGroup(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		group = trc.NewCollectorGroup(trc.CollectorConfig{
+			Source:   "gateway",
+			Metadata: trc.Metadata{"region": "eu"},
+		})
+	)
+
+	_, tr := group.NewTrace(ctx, "alpha", "request")
+	tr.Tracef("alpha event")
+	tr.Finish()
+
+	_, tr = group.NewTrace(ctx, "beta", "request")
+	tr.Tracef("beta event")
+	tr.Finish()
+
+	ExpectEqual(t, "alpha beta", strings.Join(group.Names(), " "))
+
+	if _, ok := group.Lookup("gamma"); ok {
+		t.Errorf("Lookup(gamma): want false, have true")
+	}
+	ExpectEqual(t, "alpha beta", strings.Join(group.Names(), " "))
+
+	alpha, ok := group.Lookup("alpha")
+	if !ok {
+		t.Fatalf("Lookup(alpha): want true, have false")
+	}
+	if group.Collector("alpha") != alpha {
+		t.Errorf("Collector(alpha): want existing collector")
+	}
+
+	res, err := alpha.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	AssertEqual(t, "alpha event", res.Traces[0].Events()[0].What)
+	AssertEqual(t, "gateway", res.Traces[0].Source())
+	AssertEqual(t, "region=eu tenant=alpha", res.Traces[0].TraceMetadata.String())
+}
@@ -0,0 +1,154 @@
+package trc
+
+import (
+	"sort"
+)
+
+// collectorPin is a trace which has been pinned in a collector. Evicted means
+// the trace has been dropped from its ring buffer, and is retained only by the
+// pin.
+type collectorPin struct {
+	tr      Trace
+	evicted bool
+}
+
+// Pin prevents the trace with the given ID from being evicted from the
+// collector, until it's unpinned. Pinned traces remain visible to searches and
+// exports even after newer traces would otherwise have overwritten them, they
+// don't expire due to MaxAge, and they aren't removed by Clear. This is useful
+// to keep an exemplar trace around for the duration of an investigation.
+//
+// Pin returns ErrTraceNotFound if no trace with the ID is in the collector.
+// Pinning an already-pinned trace has no effect.
+func (c *Collector) Pin(id string) error {
+	c.flushInserts() // before the lock, see flushInserts
+
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	if _, ok := c.pins[id]; ok {
+		return nil
+	}
+
+	// Evictions which happen while we look for the trace must take the slow
+	// path in keepPinned, and wait for the lock.
+	c.pinsLen.Add(1)
+	defer func() { c.pinsLen.Store(int64(len(c.pins))) }()
+
+	c.readers.Add(1) // see Search
+	defer c.readers.Add(-1)
+
+	for _, ringBuf := range c.categories.GetAll() {
+		for _, candidate := range ringBuf.Snapshot() {
+			if candidate.ID() != id {
+				continue
+			}
+			if c.pins == nil {
+				c.pins = map[string]*collectorPin{}
+			}
+			c.pins[id] = &collectorPin{tr: candidate}
+			return nil
+		}
+	}
+
+	return ErrTraceNotFound
+}
+
+// Unpin reverses Pin. If the trace would have been evicted while it was
+// pinned, it's evicted immediately, and passed to OnEvict.
+//
+// Unpin returns ErrTraceNotFound if no trace with the ID is pinned.
+func (c *Collector) Unpin(id string) error {
+	pin, ok := func() (*collectorPin, bool) {
+		c.pinsMtx.Lock()
+		defer c.pinsMtx.Unlock()
+		pin, ok := c.pins[id]
+		delete(c.pins, id)
+		c.pinsLen.Store(int64(len(c.pins)))
+		return pin, ok
+	}()
+	if !ok {
+		return ErrTraceNotFound
+	}
+
+	if pin.evicted {
+		c.evict(pin.tr)
+	}
+
+	return nil
+}
+
+// Pinned returns the IDs of the pinned traces in the collector, sorted.
+func (c *Collector) Pinned() []string {
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	ids := make([]string, 0, len(c.pins))
+	for id := range c.pins {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// keepPinned is called for each trace dropped from a ring buffer, and returns
+// true if the trace is pinned, in which case it's retained by the pin rather
+// than evicted. It's called for almost every new trace, once the collector is
+// full, so it avoids the lock when there are no pins.
+func (c *Collector) keepPinned(tr Trace) bool {
+	if c.pinsLen.Load() <= 0 {
+		return false
+	}
+
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	pin, ok := c.pins[tr.ID()]
+	if !ok || pin.tr != tr {
+		return false
+	}
+	pin.evicted = true
+	return true
+}
+
+// isPinned returns true if the trace is pinned.
+func (c *Collector) isPinned(tr Trace) bool {
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	_, ok := c.pins[tr.ID()]
+	return ok
+}
+
+// pinnedIDs returns the set of pinned trace IDs, or nil if there are none.
+func (c *Collector) pinnedIDs() map[string]bool {
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	if len(c.pins) <= 0 {
+		return nil
+	}
+	ids := make(map[string]bool, len(c.pins))
+	for id := range c.pins {
+		ids[id] = true
+	}
+	return ids
+}
+
+// evictedPins returns the pinned traces in the category which have been
+// dropped from its ring buffer, and so aren't in its snapshots.
+func (c *Collector) evictedPins(category string) []Trace {
+	c.pinsMtx.Lock()
+	defer c.pinsMtx.Unlock()
+
+	var traces []Trace
+	for _, pin := range c.pins {
+		if pin.evicted && pin.tr.Category() == category {
+			traces = append(traces, pin.tr)
+		}
+	}
+	sort.Slice(traces, func(i, j int) bool {
+		return traces[i].Started().After(traces[j].Started())
+	})
+	return traces
+}
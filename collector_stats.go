@@ -0,0 +1,281 @@
+package trc
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CollectorStats are counters describing the traces which have passed through a
+// collector, grouped by category. Unlike [SearchStats], which describe the
+// traces currently in the collector, collector stats are cumulative since the
+// collector was constructed, and so include traces which have been evicted.
+type CollectorStats struct {
+	Source     string                   `json:"source"`
+	Since      time.Time                `json:"since"`
+	Categories []CollectorCategoryStats `json:"categories"`
+
+	// LastSearchDuration is how long the most recent search of the collector
+	// took, or zero if there haven't been any searches.
+	LastSearchDuration time.Duration `json:"last_search_duration,omitempty"`
+
+	// Streams describes the current stream subscribers of the collector.
+	Streams BrokerStats `json:"streams"`
+}
+
+// CollectorCategoryStats are the cumulative counters for a single category in a
+// collector. See [Collector.Stats].
+type CollectorCategoryStats struct {
+	Category string `json:"category"`
+
+	// Created is the number of traces created in the category.
+	Created uint64 `json:"created"`
+
+	// Finished is the number of traces in the category which were finished.
+	Finished uint64 `json:"finished"`
+
+	// Evicted is the number of traces dropped from the category, because they
+	// were overwritten by newer traces, the category was resized, they
+	// exceeded the max age, or they were cleared.
+	Evicted uint64 `json:"evicted"`
+
+	// Sampled is the number of traces created in the category which weren't
+	// retained, due to the sample rate of the category.
+	Sampled uint64 `json:"sampled"`
+
+	// FinishDropped is the number of traces in the category which weren't
+	// passed to an asynchronous OnFinish hook, because its queue was full. See
+	// [CollectorConfig.OnFinishWorkers].
+	FinishDropped uint64 `json:"finish_dropped,omitempty"`
+
+	// Retained is the number of traces currently in the category.
+	Retained int `json:"retained"`
+
+	// Capacity is the max number of traces in the category.
+	Capacity int `json:"capacity"`
+
+	// BaselineP99 is the p99 duration of recently finished traces in the
+	// category, or zero if there isn't enough data. See
+	// [CollectorConfig.BaselineHalfLife].
+	BaselineP99 time.Duration `json:"baseline_p99,omitempty"`
+
+	// BaselineErrorRate is the fraction of recently finished traces in the
+	// category which errored, or zero if there isn't enough data.
+	BaselineErrorRate float64 `json:"baseline_error_rate,omitempty"`
+}
+
+// EvictionRate returns the average number of evictions per second, over the
+// given duration, typically the time since [CollectorStats.Since]. A category
+// with a consistently high eviction rate, relative to its creation rate, may
+// need a larger capacity, see [Collector.SetCategorySize].
+func (cs CollectorCategoryStats) EvictionRate(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(cs.Evicted) / d.Seconds()
+}
+
+// Stats returns cumulative counters for every category in the collector,
+// ordered by category name.
+func (c *Collector) Stats() CollectorStats {
+	counters := c.counters.getAll()
+
+	// Categories with traces but no counters can exist if traces were added
+	// via e.g. a TeeDecorator, so the ring buffers are checked separately.
+	c.flushInserts()
+	ringBufs := c.categories.GetAll()
+	for category := range ringBufs {
+		if _, ok := counters[category]; !ok {
+			counters[category] = c.counters.get(category)
+		}
+	}
+
+	stats := CollectorStats{
+		Source:             c.source,
+		Since:              c.counters.since,
+		Categories:         make([]CollectorCategoryStats, 0, len(counters)),
+		LastSearchDuration: time.Duration(c.lastSearch.Load()),
+		Streams:            c.broker.Stats(),
+	}
+
+	capacity := c.categories.Cap()
+	for category, cc := range counters {
+		var retained int
+		if rb, ok := ringBufs[category]; ok {
+			_, _, retained = rb.Stats()
+		}
+		var (
+			p99       time.Duration
+			errorRate float64
+		)
+		if cc.baseline != nil {
+			p99, _ = cc.baseline.p99()
+			errorRate, _ = cc.baseline.errorRate()
+		}
+		stats.Categories = append(stats.Categories, CollectorCategoryStats{
+			Category:          category,
+			Created:           cc.created.Load(),
+			Finished:          cc.finished.Load(),
+			Evicted:           cc.evicted.Load(),
+			Sampled:           cc.sampled.Load(),
+			FinishDropped:     cc.finishDropped.Load(),
+			Retained:          retained,
+			Capacity:          capacity,
+			BaselineP99:       p99,
+			BaselineErrorRate: errorRate,
+		})
+	}
+
+	sort.Slice(stats.Categories, func(i, j int) bool {
+		return stats.Categories[i].Category < stats.Categories[j].Category
+	})
+
+	return stats
+}
+
+//
+//
+//
+
+type collectorCounters struct {
+	since    time.Time
+	halfLife time.Duration // baselines are disabled if <= 0
+	finish   func(Trace)   // called for every finished trace, see traceFinished
+
+	mtx        sync.RWMutex
+	categories map[string]*categoryCounters
+}
+
+type categoryCounters struct {
+	created       atomic.Uint64
+	finished      atomic.Uint64
+	evicted       atomic.Uint64
+	sampled       atomic.Uint64
+	finishDropped atomic.Uint64
+	baseline      *baseline // nil if baselines are disabled
+	heatmap       *heatmapWindow
+	finish        func(Trace)
+}
+
+var _ finishHook = (*categoryCounters)(nil)
+
+func newCollectorCounters(since time.Time, halfLife time.Duration, finish func(Trace)) *collectorCounters {
+	return &collectorCounters{
+		since:      since,
+		halfLife:   halfLife,
+		finish:     finish,
+		categories: map[string]*categoryCounters{},
+	}
+}
+
+// get is called for every new trace, so existing categories take only a read
+// lock, to avoid contention between concurrent callers.
+func (cc *collectorCounters) get(category string) *categoryCounters {
+	cc.mtx.RLock()
+	c, ok := cc.categories[category]
+	cc.mtx.RUnlock()
+	if ok {
+		return c
+	}
+
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+
+	c, ok = cc.categories[category]
+	if !ok {
+		c = &categoryCounters{heatmap: newHeatmapWindow(), finish: cc.finish}
+		if cc.halfLife > 0 {
+			c.baseline = newBaseline(cc.halfLife)
+		}
+		cc.categories[category] = c
+	}
+
+	return c
+}
+
+// outlierThreshold returns the p99 duration of the category's baseline, and
+// false if there's no baseline, or it doesn't have enough data.
+func (cc *collectorCounters) outlierThreshold(category string) (time.Duration, bool) {
+	cc.mtx.RLock()
+	c, ok := cc.categories[category]
+	cc.mtx.RUnlock()
+
+	if !ok || c.baseline == nil {
+		return 0, false
+	}
+
+	return c.baseline.p99()
+}
+
+// traceFinished increments the finished counter, updates the baseline and the
+// heatmap, and calls the finish func. It must be called once per trace, either
+// as the finish hook of a core trace, or by a countTrace.
+func (c *categoryCounters) traceFinished(tr Trace) {
+	c.finished.Add(1)
+	c.observeBaseline(tr)
+	c.observeHeatmap(tr)
+	if c.finish != nil {
+		c.finish(tr)
+	}
+}
+
+// observeBaseline adds the finished trace to the baseline, if enabled.
+func (c *categoryCounters) observeBaseline(tr Trace) {
+	if c.baseline == nil {
+		return
+	}
+	duration := tr.Duration()
+	c.baseline.observe(tr.Started().Add(duration), duration, tr.Errored())
+}
+
+// observeHeatmap adds the finished trace to the heatmap.
+func (c *categoryCounters) observeHeatmap(tr Trace) {
+	duration := tr.Duration()
+	c.heatmap.observe(tr.Started().Add(duration), duration, tr.Errored())
+}
+
+func (cc *collectorCounters) getAll() map[string]*categoryCounters {
+	cc.mtx.RLock()
+	defer cc.mtx.RUnlock()
+
+	all := make(map[string]*categoryCounters, len(cc.categories))
+	for category, c := range cc.categories {
+		all[category] = c
+	}
+
+	return all
+}
+
+// countTrace calls traceFinished for its category the first time it's finished,
+// for traces which don't wrap a core trace, and so can't use a finish hook. The
+// underlying trace is finished first, so it may be evicted, by a concurrent
+// insert, before Finish returns, and so it isn't free'd until Finish returns.
+type countTrace struct {
+	Trace
+	counters *categoryCounters
+	finished atomic.Bool
+	done     atomic.Bool // Finish has returned
+}
+
+var _ interface{ Free() } = (*countTrace)(nil)
+
+func (ctr *countTrace) Unwrap() Trace {
+	return ctr.Trace
+}
+
+func (ctr *countTrace) Finish() {
+	if !ctr.finished.CompareAndSwap(false, true) || ctr.Trace.Finished() {
+		return // concurrent calls to Finish must count the trace only once
+	}
+	ctr.Trace.Finish()
+	ctr.counters.traceFinished(ctr)
+	ctr.done.Store(true)
+}
+
+func (ctr *countTrace) Free() {
+	if !ctr.done.Load() {
+		return // still in use by Finish, will be GC'd
+	}
+	maybeFree(ctr.Trace)
+}
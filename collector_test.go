@@ -2,7 +2,14 @@ package trc_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/peterbourgon/trc"
 )
@@ -129,3 +136,1407 @@ func TestCollectorResize(t *testing.T) {
 		AssertEqual(t, ids[len(ids)-fewer], res.Traces[len(res.Traces)-1].ID()) // last trace in the result "moves up" as older traces were dropped
 	}
 }
+
+func TestCollectorResizeCategory(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		create    = func(category string, n int) []string {
+			var ids []string
+			for i := 0; i < n; i++ {
+				_, tr := collector.NewTrace(ctx, category)
+				tr.Finish()
+				ids = append(ids, tr.ID())
+			}
+			return ids
+		}
+		count = func(category string) int {
+			res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Category: category}, Limit: trc.SearchLimitMax})
+			AssertNoError(t, err)
+			return len(res.Traces)
+		}
+	)
+
+	fooIDs := create("foo", 20)
+	create("bar", 20)
+
+	ExpectEqual(t, 1000, collector.CategorySize("foo"))
+	ExpectEqual(t, 15, collector.ResizeCategory("foo", 5))
+	ExpectEqual(t, 5, collector.CategorySize("foo"))
+	ExpectEqual(t, 5, count("foo"))
+	ExpectEqual(t, 20, count("bar"))
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Category: "foo"}})
+	AssertNoError(t, err)
+	ExpectEqual(t, fooIDs[len(fooIDs)-1], res.Traces[0].ID()) // newest are kept
+
+	// The default size doesn't affect categories with a specific size.
+	ExpectEqual(t, 10, collector.Resize(10))
+	ExpectEqual(t, 5, count("foo"))
+	ExpectEqual(t, 10, count("bar"))
+
+	// Specific sizes apply to categories created later.
+	ExpectEqual(t, 0, collector.ResizeCategory("baz", 3))
+	create("baz", 10)
+	ExpectEqual(t, 3, count("baz"))
+
+	// Removing the specific size restores the default, and growing keeps
+	// existing traces.
+	ExpectEqual(t, 0, collector.ResizeCategory("foo", 0))
+	ExpectEqual(t, 10, collector.CategorySize("foo"))
+	ExpectEqual(t, 5, count("foo"))
+	create("foo", 20)
+	ExpectEqual(t, 10, count("foo"))
+}
+
+func TestCollectorCategories(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		started   = map[string][]time.Time{}
+	)
+	for _, category := range []string{"foo", "bar", "foo", "foo"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Finish()
+		started[category] = append(started[category], tr.Started())
+	}
+
+	infos := collector.Categories()
+	AssertEqual(t, 2, len(infos))
+	ExpectEqual(t, trc.CategoryInfo{Category: "bar", Count: 1, Oldest: started["bar"][0], Newest: started["bar"][0]}, infos[0])
+	ExpectEqual(t, trc.CategoryInfo{Category: "foo", Count: 3, Oldest: started["foo"][0], Newest: started["foo"][2]}, infos[1])
+
+	n, err := collector.Clear(ctx, trc.Filter{Category: "bar"})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, n)
+
+	infos = collector.Categories()
+	AssertEqual(t, 2, len(infos))
+	ExpectEqual(t, trc.CategoryInfo{Category: "bar"}, infos[0]) // empty, but still known
+}
+
+func TestCollectorCategoryPatterns(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+	for _, category := range []string{"GET /api/users", "POST /api/users", "GET /api/items", "kv/get", "kv/put", "kv", "*"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Finish()
+	}
+
+	for pattern, want := range map[string]int{
+		"GET *":          2,
+		"* /api/users":   2,
+		"*/api/*":        3,
+		"GET */items":    1,
+		"kv/*":           2,
+		"kv*":            3,
+		"kv":             1,
+		"*":              7,
+		"**":             7,
+		"k*v*/*t":        2,
+		"GET /api/users": 1,
+		"PUT *":          0,
+		"kv/get/*":       0,
+	} {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Category: pattern}})
+		AssertNoError(t, err)
+		if have := res.MatchCount; want != have {
+			t.Errorf("Category %q: want %d, have %d", pattern, want, have)
+		}
+		if have := res.TotalCount; have != 7 {
+			t.Errorf("Category %q: want total count 7, have %d", pattern, have)
+		}
+
+		res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: fmt.Sprintf("cat:%q", pattern)}})
+		AssertNoError(t, err)
+		if have := res.MatchCount; want != have {
+			t.Errorf("Query cat:%q: want %d, have %d", pattern, want, have)
+		}
+	}
+
+	f := trc.Filter{Category: "kv/*"}
+	ExpectEqual(t, true, f.Allow(&trc.StaticTrace{TraceCategory: "kv/get"}))
+	ExpectEqual(t, false, f.Allow(&trc.StaticTrace{TraceCategory: "kv"}))
+
+	n, err := collector.Clear(ctx, trc.Filter{Category: "*/api/*"})
+	AssertNoError(t, err)
+	AssertEqual(t, 3, n)
+}
+
+func TestCollectorRetainMinDuration(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Clock:             clock,
+			RetainMinDuration: map[string]time.Duration{"hot": 10 * time.Millisecond},
+		})
+	)
+
+	create := func(category string, d time.Duration, errored bool) string {
+		_, tr := collector.NewTrace(ctx, category)
+		if errored {
+			tr.Errorf("error")
+		}
+		clock.Advance(d)
+		tr.Finish()
+		return tr.ID()
+	}
+
+	var (
+		_    = create("hot", 1*time.Millisecond, false)  // too fast, not retained
+		id2  = create("hot", 20*time.Millisecond, false) // slow, retained
+		id3  = create("hot", 1*time.Millisecond, true)   // fast but errored, retained
+		id4  = create("cold", 1*time.Millisecond, false) // other category, retained
+		want = map[string]bool{id2: true, id3: true, id4: true}
+	)
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, len(want), res.TotalCount)
+	for _, tr := range res.Traces {
+		ExpectEqual(t, true, want[tr.ID()])
+	}
+
+	// Active traces in thresholded categories aren't visible.
+	_, tr := collector.NewTrace(ctx, "hot")
+	defer tr.Finish()
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{IsActive: true}})
+	AssertNoError(t, err)
+	AssertEqual(t, 0, res.MatchCount)
+}
+
+func TestCollectorRetainConcurrentFinish(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{
+			RetainMinDuration: map[string]time.Duration{"hot": time.Nanosecond},
+			Decorators:        []trc.DecoratorFunc{slowFinishDecorator(10 * time.Millisecond)},
+		})
+	)
+
+	_, tr := collector.NewTrace(ctx, "hot")
+	time.Sleep(time.Millisecond)
+
+	var (
+		start = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			tr.Finish()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, res.TotalCount)
+	AssertEqual(t, tr.ID(), res.Traces[0].ID())
+}
+
+func TestCollectorSampleRates(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{
+			SampleRates: map[string]float64{"hot": 0.25},
+		})
+	)
+
+	for i := 0; i < 400; i++ {
+		_, tr := collector.NewTrace(ctx, "hot")
+		tr.Finish()
+	}
+	for i := 0; i < 10; i++ {
+		_, tr := collector.NewTrace(ctx, "cold")
+		tr.Finish()
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{StatsOnly: true})
+	AssertNoError(t, err)
+
+	hot := res.Stats.Categories["hot"]
+	ExpectEqual(t, true, hot.IsSampled())
+	ExpectEqual(t, true, hot.TotalCount() > 0 && hot.TotalCount() < 400)
+	ExpectEqual(t, hot.TotalCount(), hot.SampledCount)
+	ExpectEqual(t, float64(4*hot.TotalCount()), hot.ExtrapolatedTotalCount())
+	ExpectEqual(t, 0.25, hot.SampleRate())
+
+	cold := res.Stats.Categories["cold"]
+	ExpectEqual(t, false, cold.IsSampled())
+	ExpectEqual(t, 10.0, cold.ExtrapolatedTotalCount())
+	ExpectEqual(t, 1.0, cold.SampleRate())
+
+	overall := res.Stats.Overall()
+	ExpectEqual(t, true, overall.IsSampled())
+	ExpectEqual(t, float64(4*hot.TotalCount()+10), overall.ExtrapolatedTotalCount())
+}
+
+func TestCollectorStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{
+			SampleRates: map[string]float64{"hot": 0.5},
+		}).SetCategorySize(10)
+	)
+
+	for i := 0; i < 25; i++ {
+		_, tr := collector.NewTrace(ctx, "cold")
+		tr.Finish()
+		tr.Finish() // counted once
+	}
+	_, active := collector.NewTrace(ctx, "cold")
+	defer active.Finish()
+
+	for i := 0; i < 100; i++ {
+		_, tr := collector.NewTrace(ctx, "hot")
+		tr.Finish()
+	}
+
+	stats := collector.Stats()
+	AssertEqual(t, 2, len(stats.Categories))
+
+	cold := stats.Categories[0]
+	ExpectEqual(t, "cold", cold.Category)
+	ExpectEqual(t, uint64(26), cold.Created)
+	ExpectEqual(t, uint64(25), cold.Finished)
+	ExpectEqual(t, uint64(16), cold.Evicted)
+	ExpectEqual(t, uint64(0), cold.Sampled)
+	ExpectEqual(t, 10, cold.Retained)
+	ExpectEqual(t, 10, cold.Capacity)
+
+	hot := stats.Categories[1]
+	ExpectEqual(t, "hot", hot.Category)
+	ExpectEqual(t, uint64(100), hot.Created)
+	ExpectEqual(t, uint64(100), hot.Finished)
+	ExpectEqual(t, true, hot.Sampled > 0 && hot.Sampled < 100)
+	ExpectEqual(t, hot.Created-hot.Sampled, hot.Evicted+uint64(hot.Retained))
+}
+
+func TestCollectorBaselines(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+		finish    = func(category string, took time.Duration, errored bool) trc.Trace {
+			_, tr := collector.NewTrace(ctx, category)
+			if errored {
+				tr.Errorf("failed")
+			}
+			clock.Advance(took)
+			tr.Finish()
+			return tr
+		}
+	)
+
+	// Too few traces for a baseline.
+	finish("foo", time.Second, false)
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	ExpectEqual(t, false, res.Traces[0].IsOutlier())
+	ExpectEqual(t, time.Duration(0), res.Traces[0].BaselineP99())
+
+	for i := 0; i < 200; i++ {
+		finish("foo", time.Millisecond, i%10 == 0)
+	}
+	slowID := finish("foo", time.Second, false).ID()
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Limit: trc.SearchLimitMax})
+	AssertNoError(t, err)
+	var outliers []string
+	for _, st := range res.Traces {
+		if st.IsOutlier() {
+			outliers = append(outliers, st.ID())
+		}
+	}
+	AssertEqual(t, 2, len(outliers)) // both 1s traces
+	ExpectEqual(t, slowID, outliers[0])
+	ExpectEqual(t, true, res.Traces[0].BaselineP99() >= time.Millisecond)
+	ExpectEqual(t, true, res.Traces[0].BaselineP99() < 2*time.Millisecond)
+
+	stats := collector.Stats()
+	AssertEqual(t, 1, len(stats.Categories))
+	ExpectEqual(t, res.Traces[0].BaselineP99(), stats.Categories[0].BaselineP99)
+	ExpectEqual(t, true, stats.Categories[0].BaselineErrorRate > 0.05 && stats.Categories[0].BaselineErrorRate < 0.15)
+
+	// Disabled baselines never flag outliers.
+	disabled := trc.NewCollector(trc.CollectorConfig{BaselineHalfLife: -1})
+	for i := 0; i < 100; i++ {
+		_, tr := disabled.NewTrace(ctx, "foo")
+		tr.Finish()
+	}
+	res, err = disabled.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	ExpectEqual(t, time.Duration(0), res.Traces[0].BaselineP99())
+}
+
+func TestCollectorHeatmap(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+		finish    = func(category string, took time.Duration, errored bool) {
+			_, tr := collector.NewTrace(ctx, category)
+			if errored {
+				tr.Errorf("failed")
+			}
+			clock.Advance(took)
+			tr.Finish()
+		}
+	)
+
+	finish("foo", 2*time.Millisecond, false)  // bucket 2 (1ms)
+	finish("foo", 2*time.Millisecond, false)  // bucket 2 (1ms)
+	finish("foo", 2*time.Second, false)       // bucket 8 (1s)
+	finish("bar", 20*time.Millisecond, false) // bucket 4 (10ms)
+	finish("bar", time.Millisecond, true)     // errored
+	clock.Advance(time.Minute)
+	finish("foo", 200*time.Millisecond, false) // bucket 7 (100ms), next column
+
+	all := collector.Heatmap("")
+	AssertEqual(t, 60, len(all.Columns))
+	ExpectEqual(t, time.Minute, all.Interval)
+	ExpectEqual(t, clock.Now().Truncate(time.Minute), all.Columns[59].Start)
+	ExpectEqual(t, all.Columns[59].Start.Add(-time.Minute), all.Columns[58].Start)
+	ExpectEqual(t, "[0 0 2 0 1 0 0 0 1]", fmt.Sprint(all.Columns[58].Counts))
+	ExpectEqual(t, 1, all.Columns[58].Errored)
+	ExpectEqual(t, 5, all.Columns[58].Total())
+	ExpectEqual(t, "[0 0 0 0 0 0 0 1 0]", fmt.Sprint(all.Columns[59].Counts))
+	ExpectEqual(t, 0, all.Columns[0].Total())
+	ExpectEqual(t, 2, all.Max())
+
+	bar := collector.Heatmap("bar")
+	ExpectEqual(t, "bar", bar.Category)
+	ExpectEqual(t, "[0 0 0 0 1 0 0 0 0]", fmt.Sprint(bar.Columns[58].Counts))
+	ExpectEqual(t, 1, bar.Columns[58].Errored)
+	ExpectEqual(t, 0, bar.Columns[59].Total())
+
+	// Columns older than the window are dropped, and their slots reused.
+	clock.Advance(59 * time.Minute)
+	finish("foo", time.Millisecond, false)
+	all = collector.Heatmap("")
+	ExpectEqual(t, 1, all.Columns[0].Total()) // the 200ms trace
+	ExpectEqual(t, "[0 0 1 0 0 0 0 0 0]", fmt.Sprint(all.Columns[59].Counts))
+	total := 0
+	for _, col := range all.Columns {
+		total += col.Total()
+	}
+	ExpectEqual(t, 2, total)
+}
+
+func TestCollectorSearchSort(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		clock = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		c1    = trc.NewCollector(trc.CollectorConfig{Source: "c1", Clock: clock})
+		c2    = trc.NewCollector(trc.CollectorConfig{Source: "c2", Clock: clock})
+	)
+
+	// Durations are distinct, and not correlated with start time. Traces are
+	// spread over both collectors, and two categories in each.
+	for i, d := range []time.Duration{5, 1, 9, 3, 7, 2, 8, 4, 6} {
+		c, category := c1, "foo"
+		if i%2 == 1 {
+			c = c2
+		}
+		if i%3 == 1 {
+			category = "bar"
+		}
+		_, tr := c.NewTrace(ctx, category)
+		if d%3 == 0 {
+			tr.Errorf("multiple of three")
+		}
+		clock.Advance(d * time.Millisecond)
+		tr.Finish()
+	}
+
+	durations := func(traces []*trc.StaticTrace) []time.Duration {
+		var res []time.Duration
+		for _, st := range traces {
+			res = append(res, st.Duration())
+		}
+		return res
+	}
+
+	for _, searcher := range []trc.Searcher{c1, trc.MultiSearcher{c1, c2}} {
+		res, err := searcher.Search(ctx, &trc.SearchRequest{Sort: trc.SearchSortSlowest, Limit: 3})
+		AssertNoError(t, err)
+		ExpectEqual(t, fmt.Sprint(durations(res.Traces)), fmt.Sprint(slowest(durations(allTraces(t, searcher)), 3)))
+	}
+
+	res, err := trc.MultiSearcher{c1, c2}.Search(ctx, &trc.SearchRequest{Sort: trc.SearchSortSlowest, Limit: 3})
+	AssertNoError(t, err)
+	ExpectEqual(t, "[9ms 8ms 7ms]", fmt.Sprint(durations(res.Traces)))
+
+	res, err = trc.MultiSearcher{c1, c2}.Search(ctx, &trc.SearchRequest{Sort: trc.SearchSortOldest, Limit: 2})
+	AssertNoError(t, err)
+	ExpectEqual(t, "[5ms 1ms]", fmt.Sprint(durations(res.Traces)))
+
+	res, err = trc.MultiSearcher{c1, c2}.Search(ctx, &trc.SearchRequest{Sort: trc.SearchSortErrored, Limit: 4})
+	AssertNoError(t, err)
+	ExpectEqual(t, "[6ms 3ms 9ms 4ms]", fmt.Sprint(durations(res.Traces)))
+
+	res, err = c1.Search(ctx, &trc.SearchRequest{Sort: "bogus"})
+	AssertNoError(t, err)
+	ExpectEqual(t, trc.SearchSortNewest, res.Request.Sort)
+	ExpectEqual(t, 1, len(res.Problems))
+}
+
+func TestSearchVersions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx = context.Background()
+		c1  = trc.NewCollector(trc.CollectorConfig{Source: "c1"})
+		c2  = trc.NewCollector(trc.CollectorConfig{Source: "c2"})
+	)
+
+	ExpectEqual(t, true, trc.Version() != "")
+
+	res, err := trc.MultiSearcher{c1, c2}.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	ExpectEqual(t, 2, len(res.Versions))
+	ExpectEqual(t, trc.Version(), res.Versions["c1"])
+	ExpectEqual(t, trc.Version(), res.Versions["c2"])
+	ExpectEqual(t, fmt.Sprint([]string{trc.Version()}), fmt.Sprint(res.Versions.Distinct()))
+
+	mixed := trc.Versions{"a": "v1.0.0", "b": "v1.1.0", "c": "v1.0.0"}
+	ExpectEqual(t, "[v1.0.0 v1.1.0]", fmt.Sprint(mixed.Distinct()))
+}
+
+func allTraces(t *testing.T, s trc.Searcher) []*trc.StaticTrace {
+	t.Helper()
+	res, err := s.Search(context.Background(), &trc.SearchRequest{Limit: trc.SearchLimitMax})
+	AssertNoError(t, err)
+	return res.Traces
+}
+
+func slowest(ds []time.Duration, n int) []time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] > ds[j] })
+	if len(ds) > n {
+		ds = ds[:n]
+	}
+	return ds
+}
+
+func TestCollectorSearchBudget(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+	)
+
+	for i := 0; i < 100; i++ {
+		_, tr := collector.NewTrace(ctx, "foo")
+		tr.Tracef("event %d", i)
+		tr.Finish()
+	}
+
+	hasProblem := func(res *trc.SearchResponse, substr string) bool {
+		for _, p := range res.Problems {
+			if strings.Contains(p, substr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("max scan", func(t *testing.T) {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Limit: 100, MaxScan: 10, Filter: trc.Filter{Query: "event"}})
+		AssertNoError(t, err)
+		ExpectEqual(t, 100, res.TotalCount)
+		ExpectEqual(t, 10, res.MatchCount)
+		ExpectEqual(t, 100, res.Stats.Overall().TotalCount())
+		ExpectEqual(t, true, hasProblem(res, "budget exhausted"))
+	})
+
+	t.Run("within budget", func(t *testing.T) {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Limit: 100, MaxScan: 1000, MaxDuration: time.Minute})
+		AssertNoError(t, err)
+		ExpectEqual(t, 100, res.MatchCount)
+		ExpectEqual(t, 0, len(res.Problems))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		res, err := collector.Search(ctx, &trc.SearchRequest{Limit: 100})
+		AssertNoError(t, err)
+		ExpectEqual(t, 0, res.MatchCount)
+		ExpectEqual(t, true, hasProblem(res, "context canceled"))
+	})
+}
+
+func TestTeeDecorator(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		errors  = trc.NewCollector(trc.CollectorConfig{Source: "errors"})
+		general = trc.NewCollector(trc.CollectorConfig{
+			Decorators: []trc.DecoratorFunc{
+				trc.TeeDecorator(errors, func(tr trc.Trace) bool { return tr.Errored() }),
+			},
+		}).SetCategorySize(2)
+		erroredIDs = map[string]bool{}
+	)
+
+	for i := 0; i < 10; i++ {
+		_, tr := general.NewTrace(ctx, "api")
+		if i%4 == 0 {
+			tr.Errorf("request %d failed", i)
+			erroredIDs[tr.ID()] = true
+		}
+		tr.Finish()
+	}
+
+	res, err := general.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, res.TotalCount)
+
+	res, err = errors.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 3, res.TotalCount)
+	for _, tr := range res.Traces {
+		ExpectEqual(t, true, erroredIDs[tr.ID()])
+		ExpectEqual(t, "api", tr.Category())
+		ExpectEqual(t, 1, len(tr.Events()))
+	}
+
+	t.Run("concurrent finish", func(t *testing.T) {
+		var (
+			all = trc.NewCollector(trc.CollectorConfig{})
+			src = trc.NewCollector(trc.CollectorConfig{
+				Decorators: []trc.DecoratorFunc{
+					slowFinishDecorator(10 * time.Millisecond),
+					trc.TeeDecorator(all, nil),
+				},
+			})
+			_, tr = src.NewTrace(ctx, "api")
+			start = make(chan struct{})
+			wg    sync.WaitGroup
+		)
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				tr.Finish()
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		res, err := all.Search(ctx, &trc.SearchRequest{})
+		AssertNoError(t, err)
+		AssertEqual(t, 1, res.TotalCount)
+	})
+}
+
+func TestConditionalDecorator(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		order     []string
+		decorator = func(name string) trc.DecoratorFunc {
+			return func(tr trc.Trace) trc.Trace {
+				order = append(order, name+" "+tr.Category())
+				return tr
+			}
+		}
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Decorators: []trc.DecoratorFunc{
+				decorator("first"),
+				trc.ConditionalDecorator(trc.MatchCategories("api", "db/*"), decorator("second")),
+				decorator("third"),
+			},
+		})
+	)
+
+	for _, category := range []string{"api", "api2", "db/query", "db"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Finish()
+	}
+
+	AssertEqual(t, strings.Join([]string{
+		"first api", "second api", "third api",
+		"first api2", "third api2",
+		"first db/query", "second db/query", "third db/query",
+		"first db", "third db",
+	}, "\n"), strings.Join(order, "\n"))
+}
+
+func TestCollectorPublish(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		collector   = trc.NewCollector(trc.CollectorConfig{Publish: trc.MatchCategories("api")})
+		tracec      = make(chan trc.Trace, 10)
+		donec       = make(chan struct{})
+	)
+	defer cancel()
+
+	go func() {
+		defer close(donec)
+		collector.Stream(ctx, trc.Filter{IsFinished: true}, tracec)
+	}()
+	for {
+		if _, err := collector.StreamStats(ctx, tracec); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, category := range []string{"db", "api", "db"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Finish()
+	}
+
+	cancel()
+	<-donec
+	close(tracec)
+
+	var categories []string
+	for tr := range tracec {
+		categories = append(categories, tr.Category())
+	}
+	ExpectEqual(t, "api", strings.Join(categories, " "))
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	AssertNoError(t, err)
+	ExpectEqual(t, 3, res.TotalCount) // unpublished traces are still collected
+}
+
+func TestCollectorMetadata(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		metadata  = trc.Metadata{"host": "abc", "region": "us-east"}
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Metadata:   metadata,
+			Decorators: []trc.DecoratorFunc{trc.LogDecorator(io.Discard)},
+		})
+		tracec = make(chan trc.Trace, 10)
+		donec  = make(chan struct{})
+	)
+
+	metadata["host"] = "modified" // collector should have a copy
+
+	streamctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(donec)
+		collector.Stream(streamctx, trc.Filter{IsFinished: true}, tracec)
+	}()
+	for {
+		if _, err := collector.StreamStats(ctx, tracec); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, tr := collector.NewTrace(ctx, "category")
+	tr.Tracef("event")
+	tr.Finish()
+
+	streamed := (<-tracec).(*trc.StaticTrace)
+	AssertEqual(t, "host=abc region=us-east", streamed.Metadata().String())
+	cancel()
+	<-donec
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	AssertEqual(t, "host=abc region=us-east", res.Traces[0].Metadata().String())
+}
+
+func TestCollectorConcurrentSearch(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector().SetCategorySize(10)
+		done      = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, tr := collector.NewTrace(ctx, "category")
+				tr.Tracef("event")
+				tr.Finish()
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: "event"}})
+		AssertNoError(t, err)
+		for _, tr := range res.Traces {
+			AssertEqual(t, "category", tr.Category())
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestCollectorOnEvict(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		evicted []string
+		ids     []string
+	)
+
+	collector := trc.NewCollector(trc.CollectorConfig{
+		OnEvict: func(tr trc.Trace) { evicted = append(evicted, tr.ID()) },
+	}).SetCategorySize(3)
+
+	for i := 0; i < 5; i++ {
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Finish()
+		ids = append(ids, tr.ID())
+	}
+	AssertEqual(t, strings.Join(ids[:2], " "), strings.Join(evicted, " "))
+
+	collector.SetCategorySize(1)
+	AssertEqual(t, 4, len(evicted))
+	sort.Strings(evicted[2:])
+	AssertEqual(t, strings.Join(ids[2:4], " "), strings.Join(evicted[2:], " "))
+}
+
+func TestCollectorPin(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		evicted []string
+		ids     []string
+	)
+
+	collector := trc.NewCollector(trc.CollectorConfig{
+		OnEvict: func(tr trc.Trace) { evicted = append(evicted, tr.ID()) },
+	}).SetCategorySize(2)
+
+	for i := 0; i < 2; i++ {
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Finish()
+		ids = append(ids, tr.ID())
+	}
+
+	AssertEqual(t, trc.ErrTraceNotFound, collector.Pin("unknown"))
+	AssertNoError(t, collector.Pin(ids[0]))
+	AssertNoError(t, collector.Pin(ids[0])) // idempotent
+	AssertEqual(t, ids[0], strings.Join(collector.Pinned(), " "))
+
+	for i := 0; i < 3; i++ {
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Finish()
+		ids = append(ids, tr.ID())
+	}
+	AssertEqual(t, strings.Join(ids[1:3], " "), strings.Join(evicted, " ")) // pinned trace isn't evicted
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{IDs: []string{ids[0]}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	ExpectEqual(t, true, res.Traces[0].IsPinned())
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 3, len(res.Traces))
+	ExpectEqual(t, ids[0], res.Traces[2].ID())
+	ExpectEqual(t, false, res.Traces[0].IsPinned())
+
+	n, err := collector.Clear(ctx, trc.Filter{})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, n)
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	ExpectEqual(t, ids[0], res.Traces[0].ID())
+
+	evicted = evicted[:0]
+	AssertNoError(t, collector.Unpin(ids[0]))
+	AssertEqual(t, trc.ErrTraceNotFound, collector.Unpin(ids[0]))
+	AssertEqual(t, ids[0], strings.Join(evicted, " ")) // evicted when unpinned
+	AssertEqual(t, 0, len(collector.Pinned()))
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 0, len(res.Traces))
+}
+
+func TestCollectorPinMaxAge(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock, MaxAge: time.Minute})
+	)
+
+	_, pinned := collector.NewTrace(ctx, "category")
+	pinned.Finish()
+	_, other := collector.NewTrace(ctx, "category")
+	other.Finish()
+	AssertNoError(t, collector.Pin(pinned.ID()))
+
+	clock.Advance(time.Hour)
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	ExpectEqual(t, pinned.ID(), res.Traces[0].ID())
+}
+
+func TestCollectorOnFinish(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("sync", func(t *testing.T) {
+		t.Parallel()
+
+		var finished []string
+		collector := trc.NewCollector(trc.CollectorConfig{
+			SampleRates:       map[string]float64{"sampled": 0.01},
+			RetainMinDuration: map[string]time.Duration{"slow": time.Hour},
+			OnFinish: func(tr trc.Trace) {
+				ExpectEqual(t, true, tr.Finished())
+				finished = append(finished, tr.Category())
+			},
+		})
+
+		for i, category := range []string{"normal", "sampled", "slow"} {
+			_, tr := collector.NewTrace(ctx, category)
+			AssertEqual(t, i, len(finished))
+			tr.Finish()
+			tr.Finish() // only called once
+		}
+		AssertEqual(t, "normal sampled slow", strings.Join(finished, " "))
+	})
+
+	t.Run("async", func(t *testing.T) {
+		t.Parallel()
+
+		finished := make(chan trc.Trace, 10)
+		collector := trc.NewCollector(trc.CollectorConfig{
+			OnFinish:        func(tr trc.Trace) { finished <- tr },
+			OnFinishWorkers: 2,
+		})
+
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Errorf("oops")
+		tr.Finish()
+
+		select {
+		case got := <-finished:
+			_, isStatic := got.(*trc.StaticTrace)
+			ExpectEqual(t, true, isStatic)
+			ExpectEqual(t, tr.ID(), got.ID())
+			ExpectEqual(t, true, got.Errored())
+			ExpectEqual(t, 1, len(got.Events()))
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for OnFinish")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tc := range []struct {
+			name      string
+			decorator trc.DecoratorFunc
+		}{
+			{"core", slowFinishDecorator(10 * time.Millisecond)},
+			{"opaque", func(tr trc.Trace) trc.Trace { return &opaqueTrace{slowFinishDecorator(10 * time.Millisecond)(tr)} }},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				var finished atomic.Int64
+				collector := trc.NewCollector(trc.CollectorConfig{
+					Decorators: []trc.DecoratorFunc{tc.decorator},
+					OnFinish:   func(tr trc.Trace) { finished.Add(1) },
+				})
+
+				_, tr := collector.NewTrace(ctx, "category")
+
+				var (
+					start = make(chan struct{})
+					wg    sync.WaitGroup
+				)
+				for i := 0; i < 100; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						<-start
+						tr.Finish()
+					}()
+				}
+				close(start)
+				wg.Wait()
+
+				AssertEqual(t, int64(1), finished.Load())
+				AssertEqual(t, uint64(1), collector.Stats().Categories[0].Finished)
+			})
+		}
+	})
+}
+
+func TestCollectorEventRewriter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	redact := strings.NewReplacer("hunter2", "[REDACTED]").Replace
+
+	for _, tc := range []struct {
+		name     string
+		newTrace trc.NewTraceFunc
+	}{
+		{"core", trc.New},
+		{"wrapped", func(ctx context.Context, source, category string, decorators ...trc.DecoratorFunc) (context.Context, trc.Trace) {
+			ctx, tr := trc.New(ctx, source, category)
+			tr = &opaqueTrace{Trace: tr}
+			for _, d := range decorators {
+				tr = d(tr)
+			}
+			return trc.Put(ctx, tr)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			collector := trc.NewCollector(trc.CollectorConfig{
+				NewTrace:      tc.newTrace,
+				EventRewriter: redact,
+			})
+
+			ctx, tr := collector.NewTrace(ctx, "login")
+			secret := &mutableStringer{"hunter2"}
+			tr.Tracef("password hunter2")
+			tr.LazyTracef("password %v", secret)
+			tr.Errorf("bad password %s", "hunter2")
+			secret.s = "changed" // lazy events must already be formatted
+
+			_, child := trc.Child(ctx, "child")
+			child.Tracef("child hunter2")
+			child.Finish()
+			tr.Finish()
+
+			var have []string
+			for _, ev := range tr.Events() {
+				have = append(have, ev.What)
+			}
+			AssertEqual(t, "password [REDACTED] | password [REDACTED] | bad password [REDACTED] | child child [REDACTED]", strings.Join(have, " | "))
+		})
+	}
+}
+
+// opaqueTrace hides the optional methods of the wrapped trace, except for those
+// which the collector requires.
+type opaqueTrace struct{ trc.Trace }
+
+func (otr *opaqueTrace) MergeEvents(events []trc.Event) {
+	otr.Trace.(interface{ MergeEvents([]trc.Event) }).MergeEvents(events)
+}
+
+// slowFinishTrace widens the window between a call to Finish and the trace
+// reporting that it's finished, to provoke races between concurrent calls.
+type slowFinishTrace struct {
+	trc.Trace
+	delay time.Duration
+}
+
+func slowFinishDecorator(delay time.Duration) trc.DecoratorFunc {
+	return func(tr trc.Trace) trc.Trace { return &slowFinishTrace{Trace: tr, delay: delay} }
+}
+
+func (str *slowFinishTrace) Unwrap() trc.Trace { return str.Trace }
+
+func (str *slowFinishTrace) Finish() {
+	time.Sleep(str.delay)
+	str.Trace.Finish()
+}
+
+type mutableStringer struct{ s string }
+
+func (ms *mutableStringer) String() string { return ms.s }
+
+func TestCollectorInsertBatch(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		mtx     sync.Mutex
+		evicted []string
+		ids     []string
+	)
+
+	collector := trc.NewCollector(trc.CollectorConfig{
+		InsertBatchSize:     100,
+		InsertBatchInterval: time.Hour,
+		OnEvict: func(tr trc.Trace) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			evicted = append(evicted, tr.ID())
+		},
+	}).SetCategorySize(3)
+
+	getEvicted := func() string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		sorted := append([]string(nil), evicted...)
+		sort.Strings(sorted)
+		return strings.Join(sorted, " ")
+	}
+
+	search := func() string {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Limit: 10})
+		AssertNoError(t, err)
+		var found []string
+		for _, tr := range res.Traces {
+			found = append(found, tr.ID())
+		}
+		sort.Strings(found)
+		return strings.Join(found, " ")
+	}
+
+	for i := 0; i < 5; i++ {
+		_, tr := collector.NewTrace(ctx, "category")
+		tr.Finish()
+		ids = append(ids, tr.ID())
+	}
+
+	// Pending traces aren't evicted until they're added, but they're added
+	// before every read, so they're always visible.
+	AssertEqual(t, "", getEvicted())
+	AssertEqual(t, strings.Join(ids[2:], " "), search())
+	AssertEqual(t, strings.Join(ids[:2], " "), getEvicted())
+
+	_, tr := collector.NewTrace(ctx, "category")
+	tr.Finish()
+	ids = append(ids, tr.ID())
+	AssertNoError(t, collector.Pin(ids[5])) // pending traces can be pinned
+	AssertEqual(t, strings.Join(ids[:3], " "), getEvicted())
+}
+
+func TestCollectorInsertBatchConcurrent(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{InsertBatchSize: 16}).SetCategorySize(100)
+		wg        sync.WaitGroup
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, tr := collector.NewTrace(ctx, fmt.Sprintf("category%d", j%2))
+				tr.Tracef("event %d", j)
+				tr.Finish()
+				if j%100 == 0 {
+					_, err := collector.Search(ctx, &trc.SearchRequest{})
+					AssertNoError(t, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := collector.Stats()
+	AssertEqual(t, 2, len(stats.Categories))
+	for _, cs := range stats.Categories {
+		ExpectEqual(t, uint64(4000), cs.Created)
+		ExpectEqual(t, 100, cs.Retained)
+		ExpectEqual(t, uint64(3900), cs.Evicted)
+	}
+}
+
+func TestCollectorMaxAge(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		evicted   []string
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Clock:   clock,
+			MaxAge:  time.Hour,
+			OnEvict: func(tr trc.Trace) { evicted = append(evicted, tr.ID()) },
+		})
+	)
+
+	// Capture IDs up front, as evicted traces may be free'd.
+	_, old := collector.NewTrace(ctx, "quiet")
+	old.Finish()
+	oldID := old.ID()
+
+	_, active := collector.NewTrace(ctx, "quiet")
+	activeID := active.ID()
+
+	clock.Advance(30 * time.Minute)
+	_, recent := collector.NewTrace(ctx, "busy")
+	recent.Finish()
+	recentID := recent.ID()
+
+	search := func() []string {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Limit: 10})
+		AssertNoError(t, err)
+		var ids []string
+		for _, tr := range res.Traces {
+			ids = append(ids, tr.ID())
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	ids := []string{oldID, activeID, recentID}
+	sort.Strings(ids)
+	AssertEqual(t, strings.Join(ids, " "), strings.Join(search(), " "))
+	AssertEqual(t, 0, len(evicted))
+
+	clock.Advance(45 * time.Minute)
+	ids = []string{activeID, recentID}
+	sort.Strings(ids)
+	AssertEqual(t, strings.Join(ids, " "), strings.Join(search(), " "))
+	AssertEqual(t, oldID, strings.Join(evicted, " "))
+
+	clock.Advance(time.Hour)
+	AssertEqual(t, activeID, strings.Join(search(), " "))
+	AssertEqual(t, oldID+" "+recentID, strings.Join(evicted, " "))
+}
+
+func TestCollectorWatchdog(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+	)
+
+	_, stuck := collector.NewTrace(ctx, "api")
+	_, fine := collector.NewTrace(ctx, "api")
+	fine.Finish()
+	clock.Advance(time.Minute)
+	_, recent := collector.NewTrace(ctx, "api")
+	defer recent.Finish()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go collector.Watchdog(ctx, trc.WatchdogConfig{Threshold: 30 * time.Second, Interval: 10 * time.Millisecond})
+
+	search := func() []*trc.StaticTrace {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Category: "stuck"}})
+		AssertNoError(t, err)
+		return res.Traces
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(search()) <= 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond) // stuck traces are only flagged once
+	traces := search()
+	AssertEqual(t, 1, len(traces))
+	ExpectEqual(t, true, traces[0].Errored())
+	AssertEqual(t, 1, len(traces[0].Events()))
+	ExpectEqual(t, true, strings.Contains(traces[0].Events()[0].What, stuck.ID()))
+
+	var flagged bool
+	for _, ev := range stuck.Events() {
+		flagged = flagged || strings.Contains(ev.What, "watchdog: stuck")
+	}
+	ExpectEqual(t, true, flagged)
+	ExpectEqual(t, false, stuck.Errored())
+}
+
+func TestCollectorWatchContext(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewCollector(trc.CollectorConfig{WatchContext: true})
+
+	// A trace whose context is canceled while it's active.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, canceled := collector.NewTrace(ctx, "canceled")
+	cancel()
+
+	// A trace whose deadline is exceeded while it's active.
+	ctx, cancel = context.WithTimeoutCause(context.Background(), time.Millisecond, fmt.Errorf("too slow"))
+	defer cancel()
+	_, timeout := collector.NewTrace(ctx, "timeout")
+	<-ctx.Done()
+
+	// A trace which is finished before its context is canceled.
+	ctx, cancel = context.WithCancel(context.Background())
+	_, finished := collector.NewTrace(ctx, "finished")
+	finished.Finish()
+	cancel()
+
+	// A trace with a status, which is kept.
+	ctx, cancel = context.WithCancel(context.Background())
+	_, custom := collector.NewTrace(ctx, "custom")
+	trc.SetStatus(custom, "custom")
+	cancel()
+
+	for _, tr := range []trc.Trace{canceled, timeout, finished, custom} {
+		tr.Finish()
+		tr.Finish()
+	}
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 4, res.MatchCount)
+
+	for _, tr := range res.Traces {
+		var events []string
+		for _, ev := range tr.Events() {
+			events = append(events, ev.What)
+		}
+		all := strings.Join(events, "; ")
+
+		switch tr.Category() {
+		case "canceled":
+			ExpectEqual(t, trc.StatusCanceled, tr.Status())
+			ExpectEqual(t, true, strings.HasPrefix(all, "context canceled after "))
+		case "timeout":
+			ExpectEqual(t, trc.StatusTimeout, tr.Status())
+			ExpectEqual(t, true, strings.HasPrefix(all, "context deadline exceeded after "))
+			ExpectEqual(t, true, strings.HasSuffix(all, " (too slow)"))
+		case "finished":
+			ExpectEqual(t, "", tr.Status())
+			ExpectEqual(t, "", all)
+		case "custom":
+			ExpectEqual(t, "custom", tr.Status())
+			ExpectEqual(t, true, strings.HasPrefix(all, "context canceled after "))
+		}
+		ExpectEqual(t, false, tr.Errored())
+	}
+}
+
+func TestCollectorAdd(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	started := time.Now().Add(-time.Hour).UTC()
+
+	collector := trc.NewCollector(trc.CollectorConfig{Source: "replay"}).SetCategorySize(2)
+	collector.Add(
+		&trc.StaticTrace{TraceSource: "a", TraceID: "1", TraceCategory: "foo", TraceStarted: started, TraceDuration: time.Second, TraceFinished: true},
+		&trc.StaticTrace{TraceSource: "b", TraceID: "2", TraceCategory: "foo", TraceStarted: started, TraceDuration: time.Second, TraceFinished: true, TraceErrored: true},
+		&trc.StaticTrace{TraceSource: "b", TraceID: "3", TraceCategory: "foo", TraceStarted: started, TraceDuration: time.Second, TraceFinished: true},
+		&trc.StaticTrace{TraceSource: "a", TraceID: "4", TraceCategory: "bar", TraceStarted: started, TraceDuration: time.Millisecond, TraceFinished: true},
+	)
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 3, res.TotalCount)
+
+	var ids []string
+	for _, tr := range res.Traces {
+		ids = append(ids, tr.ID())
+	}
+	sort.Strings(ids)
+	AssertEqual(t, "2 3 4", strings.Join(ids, " "))
+
+	AssertEqual(t, "replay a b", strings.Join(res.Sources, " "))
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Sources: []string{"a"}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, res.MatchCount)
+	AssertEqual(t, "4", res.Traces[0].ID())
+	AssertEqual(t, "replay a b", strings.Join(res.Sources, " "))
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Sources: []string{""}, ExcludeSources: []string{"", "a"}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, res.MatchCount)
+}
+
+func TestCollectorNewTraceWithSource(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{Source: "monolith"})
+		tracec    = make(chan trc.Trace, 10)
+	)
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		collector.Stream(streamCtx, trc.Filter{Sources: []string{"billing"}, IsFinished: true}, tracec)
+	}()
+	for {
+		stats, err := collector.StreamStats(ctx, tracec)
+		if err == nil && stats.Sends == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, source := range []string{"billing", "search", "", "billing"} {
+		ctx, tr := collector.NewTraceWithSource(ctx, source, "api")
+		_, child := collector.NewTraceWithSource(ctx, "ignored", "nested") // uses the existing trace
+		AssertEqual(t, tr, child)
+		tr.Finish()
+	}
+
+	streamed := <-tracec
+	AssertEqual(t, "billing", streamed.Source())
+	cancel()
+	<-donec
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 4, res.MatchCount)
+	AssertEqual(t, "monolith billing search", strings.Join(res.Sources, " "))
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Sources: []string{"billing"}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, res.MatchCount)
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Sources: []string{"monolith"}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, res.MatchCount)
+}
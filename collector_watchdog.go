@@ -0,0 +1,148 @@
+package trc
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// WatchdogConfig configures [Collector.Watchdog].
+type WatchdogConfig struct {
+	// Threshold is the duration after which an active trace is considered to
+	// be stuck. Required.
+	Threshold time.Duration
+
+	// Interval is how often active traces are checked. If not provided, a
+	// default of Threshold/2, but at least one second, is used.
+	Interval time.Duration
+
+	// Category of the traces created for stuck traces. If not provided, the
+	// default of "stuck" is used.
+	Category string
+
+	// Goroutines, if true, means the stacks of every goroutine are recorded in
+	// the trace created for each check which finds newly stuck traces. This can
+	// be very large, and briefly stops the world, so use it carefully.
+	Goroutines bool
+}
+
+// Normalize ensures the config is valid, setting default values where
+// appropriate, and returns any errors.
+func (cfg *WatchdogConfig) Normalize() []error {
+	var errs []error
+
+	if cfg.Threshold <= 0 {
+		errs = append(errs, fmt.Errorf("threshold must be greater than zero"))
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = iff(cfg.Threshold/2 > time.Second, cfg.Threshold/2, time.Second)
+	}
+
+	if cfg.Category == "" {
+		cfg.Category = "stuck"
+	}
+
+	return errs
+}
+
+// Watchdog periodically checks the active traces in the collector, and flags
+// those which have been active for longer than the configured threshold, e.g.
+// wedged requests. Each stuck trace is flagged once, by adding a normal event
+// to it, and by creating a new, finished, errored trace in the collector,
+// in the configured category, which identifies it. Those traces are visible to
+// search, and to streams, like any other trace, so it's easy to e.g. subscribe
+// to stuck traces.
+//
+// Active traces in categories which are subject to RetainMinDuration aren't in
+// the collector until they finish, and so can't be checked.
+//
+// Watchdog blocks until the context is canceled, so it's typically run in its
+// own goroutine.
+func (c *Collector) Watchdog(ctx context.Context, cfg WatchdogConfig) error {
+	if errs := cfg.Normalize(); len(errs) > 0 {
+		return fmt.Errorf("invalid watchdog config: %s", strings.Join(trcutil.FlattenErrors(errs...), "; "))
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	flagged := map[string]bool{}
+	for {
+		select {
+		case <-ticker.C:
+			flagged = c.checkStuck(cfg, flagged)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkStuck flags active traces which have exceeded the threshold, and which
+// haven't already been flagged. It returns the IDs of every currently stuck
+// trace, so that traces which finish are forgotten.
+func (c *Collector) checkStuck(cfg WatchdogConfig, flagged map[string]bool) map[string]bool {
+	c.flushInserts()
+
+	c.readers.Add(1) // see Search
+	defer c.readers.Add(-1)
+
+	var (
+		stuck = map[string]bool{}
+		fresh []Trace
+	)
+	for category, ringBuf := range c.categories.GetAll() {
+		if category == cfg.Category {
+			continue
+		}
+		for _, candidate := range ringBuf.Snapshot() {
+			if candidate.Finished() || candidate.Duration() < cfg.Threshold {
+				continue
+			}
+			id := candidate.ID()
+			stuck[id] = true
+			if !flagged[id] {
+				fresh = append(fresh, candidate)
+			}
+		}
+	}
+
+	if len(fresh) <= 0 {
+		return stuck
+	}
+
+	_, tr := c.NewTrace(context.Background(), cfg.Category)
+	defer tr.Finish()
+
+	for _, candidate := range fresh {
+		duration := candidate.Duration()
+		mergeEvents(candidate, []Event{{
+			When: c.getClock().Now().UTC(),
+			What: fmt.Sprintf("watchdog: stuck, active for %s", trcutil.HumanizeDuration(duration)),
+		}})
+		tr.Errorf("stuck trace %s (%s), active for %s", candidate.ID(), candidate.Category(), trcutil.HumanizeDuration(duration))
+	}
+
+	if cfg.Goroutines {
+		tr.LazyTracef("goroutines:\n%s", goroutineStacks())
+	}
+
+	return stuck
+}
+
+// goroutineStacks returns the stacks of every goroutine, up to a max size.
+func goroutineStacks() []byte {
+	const maxSize = 1 << 20
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxSize {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
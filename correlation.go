@@ -0,0 +1,31 @@
+package trc
+
+import "context"
+
+type correlationContextKey struct{}
+
+// WithCorrelationID returns a context containing the given correlation ID. A
+// correlation ID is an identifier from outside of trc, e.g. the trace ID from a
+// W3C traceparent header, or a request ID, which connects a trace to other
+// traces or logs, typically in other services. Traces created with the
+// returned context, e.g. via [New] or [Collector.NewTrace], are assigned the
+// correlation ID, which is included in search and stream results.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationContextKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID in the context, set via
+// [WithCorrelationID], if any.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationContextKey{}).(string)
+	return id
+}
+
+// CorrelationID returns the correlation ID of the trace, if any, by checking if
+// the trace implements the method CorrelationID() string.
+func CorrelationID(tr Trace) string {
+	if c, ok := optional[interface{ CorrelationID() string }](tr); ok {
+		return c.CorrelationID()
+	}
+	return ""
+}
@@ -13,8 +13,57 @@ import (
 // DecoratorFunc is a function that decorates a trace in some way. It's similar
 // to an HTTP middleware. Decorators can be provided to a [Collector] and will
 // be applied to every trace created in that collector.
+//
+// Decorators are applied in order, each one wrapping the trace returned by the
+// previous one, so the last decorator is the outermost. Calls to the methods of
+// the decorated trace, e.g. Tracef or Finish, reach the last decorator first,
+// and the first decorator last, just before the underlying trace. A collector
+// applies its own built-in decorators, e.g. the one which publishes traces to
+// its broker, before any user-provided decorators.
+//
+// Decorators which wrap a trace should implement Unwrap() Trace, returning the
+// wrapped trace, see [Unwrap]. Helpers which call optional methods, like
+// [Status] and [SetStatus], check every trace in the chain of wrapped traces,
+// so a decorator which embeds a Trace and implements Unwrap doesn't need to
+// forward those methods. A decorator which changes events, e.g. by overriding
+// Tracef, should also implement Debugf, Warnf, and MergeEvents, so that events
+// added via those methods are changed too.
 type DecoratorFunc func(Trace) Trace
 
+// ConditionalDecorator returns a decorator which applies the given decorator
+// only to traces in categories for which match returns true. Traces in other
+// categories are returned as-is. See [MatchCategories] for a convenient way to
+// construct the match function.
+//
+//	collector := trc.NewCollector(trc.CollectorConfig{
+//		Decorators: []trc.DecoratorFunc{
+//			trc.ConditionalDecorator(trc.MatchCategories("api", "api/*"), trc.LogDecorator(os.Stderr)),
+//		},
+//	})
+func ConditionalDecorator(match func(category string) bool, d DecoratorFunc) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if !match(tr.Category()) {
+			return tr
+		}
+		return d(tr)
+	}
+}
+
+// MatchCategories returns a function which returns true for categories that
+// match any of the patterns, using the same syntax as [Filter.Category], i.e. *
+// matches any sequence of characters.
+func MatchCategories(patterns ...string) func(category string) bool {
+	patterns = append([]string(nil), patterns...)
+	return func(category string) bool {
+		for _, pattern := range patterns {
+			if matchCategory(pattern, category) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
 //
 //
 //
@@ -23,6 +72,9 @@ type DecoratorFunc func(Trace) Trace
 // is created, on every event, and when the trace is finished. The logged string
 // is a reduced form of the full trace, containing only the trace ID and the
 // single event that triggered the log.
+//
+// To log complete traces when they finish, in a structured format, and with
+// filtering, use a [TraceLogger] instead.
 func LogDecorator(dst io.Writer) DecoratorFunc {
 	return func(tr Trace) Trace {
 		ltr := &logTrace{
@@ -55,6 +107,10 @@ type logTrace struct {
 
 var _ interface{ Free() } = (*logTrace)(nil)
 
+func (ltr *logTrace) Unwrap() Trace {
+	return ltr.Trace
+}
+
 func (ltr *logTrace) Tracef(format string, args ...any) {
 	ltr.logEvent(format, args...)
 	ltr.Trace.Tracef(format, args...)
@@ -75,6 +131,23 @@ func (ltr *logTrace) LazyErrorf(format string, args ...any) {
 	ltr.Trace.LazyErrorf(format, args...)
 }
 
+func (ltr *logTrace) Debugf(format string, args ...any) {
+	ltr.logEvent("DEBUG: "+format, args...)
+	Debugf(ltr.Trace, format, args...)
+}
+
+func (ltr *logTrace) Warnf(format string, args ...any) {
+	ltr.logEvent("WARN: "+format, args...)
+	Warnf(ltr.Trace, format, args...)
+}
+
+func (ltr *logTrace) MergeEvents(events []Event) {
+	for _, ev := range events {
+		ltr.logEvent(iff(ev.IsError, "ERROR: ", "")+"%s", ev.What)
+	}
+	mergeEvents(ltr.Trace, events)
+}
+
 func (ltr *logTrace) Finish() {
 	ltr.Trace.Finish()
 	var (
@@ -87,6 +160,9 @@ func (ltr *logTrace) Finish() {
 	default:
 		outcome = "success"
 	}
+	if status := Status(ltr.Trace); status != "" {
+		outcome += " (" + status + ")"
+	}
 	ltr.logEvent("done, %s, %s", outcome, duration)
 }
 
@@ -105,6 +181,94 @@ func (ltr *logTrace) Free() {
 //
 //
 
+func maxBytesDecorator(maxBytes int) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if maxBytes > 0 {
+			SetMaxBytes(tr, maxBytes)
+		}
+		return tr
+	}
+}
+
+func foldEventsDecorator(fold bool) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if fold {
+			SetFoldEvents(tr, true)
+		}
+		return tr
+	}
+}
+
+// eventRewriterDecorator applies the rewrite function to the text of every
+// event in the trace, before it's stored. Traces which support it, like core
+// traces, apply it directly. Other traces are wrapped, so that events are
+// formatted and rewritten before they're passed to the trace.
+func eventRewriterDecorator(rewrite func(string) string) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if rewrite == nil {
+			return tr
+		}
+		if _, ok := SetEventRewriter(tr, rewrite); ok {
+			return tr
+		}
+		return &rewriteTrace{Trace: tr, rewrite: rewrite}
+	}
+}
+
+type rewriteTrace struct {
+	Trace
+	rewrite func(string) string
+}
+
+var _ interface{ Free() } = (*rewriteTrace)(nil)
+
+func (rtr *rewriteTrace) Unwrap() Trace {
+	return rtr.Trace
+}
+
+func (rtr *rewriteTrace) Tracef(format string, args ...any) {
+	rtr.Trace.Tracef("%s", rtr.rewrite(safeSprintf(format, args...)))
+}
+
+func (rtr *rewriteTrace) LazyTracef(format string, args ...any) {
+	rtr.Trace.Tracef("%s", rtr.rewrite(safeSprintf(format, args...))) // args must not be retained
+}
+
+func (rtr *rewriteTrace) Errorf(format string, args ...any) {
+	rtr.Trace.Errorf("%s", rtr.rewrite(safeSprintf(format, args...)))
+}
+
+func (rtr *rewriteTrace) LazyErrorf(format string, args ...any) {
+	rtr.Trace.Errorf("%s", rtr.rewrite(safeSprintf(format, args...))) // args must not be retained
+}
+
+func (rtr *rewriteTrace) Debugf(format string, args ...any) {
+	Debugf(rtr.Trace, "%s", rtr.rewrite(safeSprintf(format, args...)))
+}
+
+func (rtr *rewriteTrace) Warnf(format string, args ...any) {
+	Warnf(rtr.Trace, "%s", rtr.rewrite(safeSprintf(format, args...)))
+}
+
+func (rtr *rewriteTrace) MergeEvents(events []Event) {
+	rewritten := make([]Event, len(events))
+	for i, ev := range events {
+		ev.What = rtr.rewrite(ev.What)
+		rewritten[i] = ev
+	}
+	mergeEvents(rtr.Trace, rewritten)
+}
+
+func (rtr *rewriteTrace) Free() {
+	if f, ok := rtr.Trace.(interface{ Free() }); ok {
+		f.Free()
+	}
+}
+
+//
+//
+//
+
 func publishDecorator(p publisher) DecoratorFunc {
 	return func(tr Trace) Trace {
 		ptr := &publishTrace{
@@ -127,6 +291,10 @@ type publishTrace struct {
 
 var _ interface{ Free() } = (*publishTrace)(nil)
 
+func (ptr *publishTrace) Unwrap() Trace {
+	return ptr.Trace
+}
+
 func (ptr *publishTrace) Tracef(format string, args ...any) {
 	ptr.Trace.Tracef(format, args...)
 	ptr.p.Publish(context.Background(), ptr.Trace)
@@ -147,6 +315,21 @@ func (ptr *publishTrace) LazyErrorf(format string, args ...any) {
 	ptr.p.Publish(context.Background(), ptr.Trace)
 }
 
+func (ptr *publishTrace) Debugf(format string, args ...any) {
+	Debugf(ptr.Trace, format, args...)
+	ptr.p.Publish(context.Background(), ptr.Trace)
+}
+
+func (ptr *publishTrace) Warnf(format string, args ...any) {
+	Warnf(ptr.Trace, format, args...)
+	ptr.p.Publish(context.Background(), ptr.Trace)
+}
+
+func (ptr *publishTrace) MergeEvents(events []Event) {
+	mergeEvents(ptr.Trace, events)
+	ptr.p.Publish(context.Background(), ptr.Trace)
+}
+
 func (ptr *publishTrace) Finish() {
 	ptr.Trace.Finish()
 	ptr.p.Publish(context.Background(), ptr.Trace)
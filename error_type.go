@@ -0,0 +1,122 @@
+package trc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ErrorType returns a short, stable classification of the error, suitable for
+// grouping errors of the same kind, e.g. in search stats. When Errorf or
+// LazyErrorf is called with an error argument, the type of the first such
+// error is recorded in the event, see [Event], and in the trace, see
+// [TraceErrorTypes], which can be filtered by it, see [Filter].
+//
+// If any error in the chain, see [errors.As], implements the method
+// ErrorType() string, the first such non-empty result is used. Otherwise, well
+// known sentinel errors, like [context.Canceled] or [io.EOF], are classified by
+// name. Otherwise, the error is classified by the dynamic type of the first
+// error in the chain which isn't a generic wrapper, like the errors produced by
+// [fmt.Errorf] or [errors.Join], e.g. "*fs.PathError". Errors without a more
+// specific type, e.g. those produced by [errors.New], are classified as
+// "error". ErrorType returns the empty string for a nil error.
+func ErrorType(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var typed interface{ ErrorType() string }
+	if errors.As(err, &typed) {
+		if s := typed.ErrorType(); s != "" {
+			return s
+		}
+	}
+
+	for _, s := range errorTypeSentinels {
+		if errors.Is(err, s.err) {
+			return s.name
+		}
+	}
+
+	for {
+		var next error
+		switch x := err.(type) {
+		case interface{ Unwrap() error }:
+			next = x.Unwrap()
+		case interface{ Unwrap() []error }:
+			if errs := x.Unwrap(); len(errs) > 0 {
+				next = errs[0]
+			}
+		}
+		if typ := fmt.Sprintf("%T", err); next == nil || !isGenericErrorWrapper(typ) {
+			return iff(typ == plainErrorType, "error", typ)
+		}
+		err = next
+	}
+}
+
+// TraceErrorTypes returns the distinct error types recorded in the trace, in
+// the order they were first recorded, by checking if the trace implements the
+// method ErrorTypes() []string.
+func TraceErrorTypes(tr Trace) []string {
+	if t, ok := optional[interface{ ErrorTypes() []string }](tr); ok {
+		return t.ErrorTypes()
+	}
+	return nil
+}
+
+var errorTypeSentinels = []struct {
+	err  error
+	name string
+}{
+	{context.Canceled, "context.Canceled"},
+	{context.DeadlineExceeded, "context.DeadlineExceeded"},
+	{io.EOF, "io.EOF"},
+	{io.ErrUnexpectedEOF, "io.ErrUnexpectedEOF"},
+	{fs.ErrNotExist, "fs.ErrNotExist"},
+	{fs.ErrExist, "fs.ErrExist"},
+	{fs.ErrPermission, "fs.ErrPermission"},
+	{fs.ErrClosed, "fs.ErrClosed"},
+}
+
+var (
+	plainErrorType        = fmt.Sprintf("%T", errors.New(""))
+	genericErrorWrapType  = fmt.Sprintf("%T", fmt.Errorf("%w", io.EOF))
+	genericErrorWrapsType = fmt.Sprintf("%T", fmt.Errorf("%w %w", io.EOF, io.EOF))
+	genericErrorJoinType  = fmt.Sprintf("%T", errors.Join(io.EOF))
+)
+
+func isGenericErrorWrapper(typ string) bool {
+	switch typ {
+	case genericErrorWrapType, genericErrorWrapsType, genericErrorJoinType:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxTraceErrorTypes is the maximum number of distinct error types which are
+// recorded in a single trace.
+const maxTraceErrorTypes = 8
+
+// argsErrorType returns the type of the first non-nil error in args, or the
+// empty string if there is no such error.
+func argsErrorType(args []any) string {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && err != nil {
+			return ErrorType(err)
+		}
+	}
+	return ""
+}
+
+// appendErrorType appends the type to types, if it's not empty and not already
+// present, up to maxTraceErrorTypes.
+func appendErrorType(types []string, typ string) []string {
+	if typ == "" || len(types) >= maxTraceErrorTypes || contains(types, typ) {
+		return types
+	}
+	return append(types, typ)
+}
@@ -0,0 +1,96 @@
+package trc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+type classifiedError struct{ class string }
+
+func (e classifiedError) Error() string     { return "classified: " + e.class }
+func (e classifiedError) ErrorType() string { return e.class }
+
+func TestErrorType(t *testing.T) {
+	t.Parallel()
+
+	_, pathErr := os.Open("/does/not/exist")
+
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("plain"), "error"},
+		{fmt.Errorf("no wrap %v", io.EOF), "error"},
+		{io.EOF, "io.EOF"},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "context.DeadlineExceeded"},
+		{pathErr, "fs.ErrNotExist"},
+		{&fs.PathError{Op: "open", Path: "x", Err: errors.New("weird")}, "*fs.PathError"},
+		{fmt.Errorf("a: %w", fmt.Errorf("b: %w", &fs.PathError{Err: errors.New("weird")})), "*fs.PathError"},
+		{errors.Join(&fs.PathError{Err: errors.New("weird")}, io.EOF), "io.EOF"},
+		{errors.Join(&fs.PathError{Err: errors.New("weird")}, errors.New("other")), "*fs.PathError"},
+		{fmt.Errorf("x: %w", classifiedError{"quota"}), "quota"},
+		{classifiedError{""}, "trc_test.classifiedError"},
+	} {
+		if want, have := tc.want, trc.ErrorType(tc.err); want != have {
+			t.Errorf("%v: want %q, have %q", tc.err, want, have)
+		}
+	}
+}
+
+func TestTraceErrorTypes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+
+	{
+		_, tr := collector.NewTrace(ctx, "api")
+		tr.Errorf("read: %v", io.EOF)
+		tr.LazyErrorf("again: %v", io.EOF)
+		tr.Errorf("no error argument")
+		tr.Finish()
+		AssertEqual(t, "[io.EOF]", fmt.Sprint(trc.TraceErrorTypes(tr)))
+	}
+	{
+		ctx, tr := collector.NewTrace(ctx, "api")
+		_, child := trc.Child(ctx, "child")
+		child.Errorf("quota: %v", classifiedError{"quota"})
+		child.Finish()
+		tr.Errorf("timeout: %v", context.DeadlineExceeded)
+		tr.Finish()
+		AssertEqual(t, "[quota context.DeadlineExceeded]", fmt.Sprint(trc.TraceErrorTypes(tr)))
+	}
+	{
+		_, tr := collector.NewTrace(ctx, "db")
+		tr.Errorf("timeout: %v", context.DeadlineExceeded)
+		tr.Finish()
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: "errtype:context.DeadlineExceeded"}})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, res.MatchCount)
+	for _, st := range res.Traces {
+		for _, ev := range st.TraceEvents {
+			if ev.IsError && ev.ErrorType == "" {
+				t.Errorf("%s: error event %q has no error type", st.TraceCategory, ev.What)
+			}
+		}
+	}
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{ErrorTypes: []string{"quota", "io.EOF"}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 2, res.MatchCount)
+
+	api := res.Stats.Categories["api"]
+	AssertEqual(t, "[{context.DeadlineExceeded 1} {io.EOF 1} {quota 1}]", fmt.Sprint(api.TopErrorTypes(0)))
+	overall := res.Stats.Overall()
+	AssertEqual(t, "[{context.DeadlineExceeded 2}]", fmt.Sprint(overall.TopErrorTypes(1)))
+}
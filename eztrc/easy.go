@@ -21,8 +21,12 @@ package eztrc
 import (
 	"context"
 	"net/http"
+	"runtime"
+	"strings"
+	"sync"
 
 	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
 	"github.com/peterbourgon/trc/trcweb"
 )
 
@@ -54,6 +58,96 @@ func New(ctx context.Context, category string) (context.Context, trc.Trace) {
 	return collector.NewTrace(ctx, category)
 }
 
+// NewWithSource is like [New], but the new trace has the given source, rather
+// than the source of the global trace collector. See
+// [trc.Collector.NewTraceWithSource].
+func NewWithSource(ctx context.Context, source, category string) (context.Context, trc.Trace) {
+	return collector.NewTraceWithSource(ctx, source, category)
+}
+
+// Auto is like [New], but the category is derived from the calling function,
+// so library code can create consistently categorized traces without
+// hardcoding category strings. The category is the name of the package, i.e.
+// the last element of its import path, followed by the name of the function,
+// or the type and method, without any closure suffixes, e.g. "api.handleUser"
+// or "api.Server.ServeHTTP". Categories are cached by caller.
+//
+// Auto also returns a function which finishes the trace.
+//
+//	func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+//	    ctx, tr, finish := eztrc.Auto(r.Context())
+//	    defer finish()
+//	    ...
+//	}
+func Auto(ctx context.Context) (context.Context, trc.Trace, func()) {
+	category := "(unknown)"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		category = autoCategory(pc)
+	}
+	ctx, tr := collector.NewTrace(ctx, category)
+	return ctx, tr, tr.Finish
+}
+
+var autoCategories sync.Map // pc uintptr -> category string
+
+// autoCategory returns the category for a caller of Auto at pc.
+func autoCategory(pc uintptr) string {
+	if category, ok := autoCategories.Load(pc); ok {
+		return category.(string)
+	}
+
+	category := "(unknown)"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		category = funcCategory(fn.Name())
+	}
+
+	autoCategories.Store(pc, category)
+	return category
+}
+
+// funcCategory converts a fully-qualified function name, like
+// "github.com/foo/api.(*Server[...]).handle.func1", to a category, like
+// "api.Server.handle".
+func funcCategory(name string) string {
+	pkg := trcutil.FuncPackage(name)
+	if pkg == "" {
+		return name
+	}
+
+	// Type arguments of generic functions and types are elided as "[...]",
+	// which contains dots, so they're removed before the name is split.
+	name = strings.ReplaceAll(name[len(pkg)+1:], "[...]", "")
+
+	elems := strings.Split(name, ".")
+	for len(elems) > 1 && isClosureName(elems[len(elems)-1]) {
+		elems = elems[:len(elems)-1]
+	}
+	for i := range elems {
+		elems[i] = strings.Trim(elems[i], "(*)")
+	}
+	return pkg[strings.LastIndex(pkg, "/")+1:] + "." + strings.Join(elems, ".")
+}
+
+// isClosureName returns true for the names that the compiler gives to closures
+// and their generated wrappers, e.g. "func1", "1", or "gowrap2".
+func isClosureName(s string) bool {
+	for _, prefix := range []string{"func", "gowrap", "deferwrap"} {
+		if strings.HasPrefix(s, prefix) {
+			s = s[len(prefix):]
+			break
+		}
+	}
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // Region calls [trc.Region].
 func Region(ctx context.Context, name string) (context.Context, trc.Trace, func()) {
 	return trc.Region(ctx, name)
@@ -64,6 +158,11 @@ func Prefix(ctx context.Context, format string, args ...any) (context.Context, t
 	return trc.Prefix(ctx, format, args...)
 }
 
+// Child calls [trc.Child].
+func Child(ctx context.Context, format string, args ...any) (context.Context, trc.Trace) {
+	return trc.Child(ctx, format, args...)
+}
+
 // Get calls [trc.Get].
 func Get(ctx context.Context) trc.Trace {
 	return trc.Get(ctx)
@@ -97,3 +196,25 @@ func Errorf(ctx context.Context, format string, args ...any) {
 func LazyErrorf(ctx context.Context, format string, args ...any) {
 	trc.Get(ctx).LazyErrorf(format, args...)
 }
+
+// Debugf adds a new debug event to the trace in the context, see [trc.Debugf].
+// Arguments are evaluated immediately.
+func Debugf(ctx context.Context, format string, args ...any) {
+	trc.Debugf(trc.Get(ctx), format, args...)
+}
+
+// Warnf adds a new warning event to the trace in the context, see [trc.Warnf].
+// Arguments are evaluated immediately.
+func Warnf(ctx context.Context, format string, args ...any) {
+	trc.Warnf(trc.Get(ctx), format, args...)
+}
+
+// Bind calls [trc.Bind].
+func Bind(ctx context.Context) func() {
+	return trc.Bind(ctx)
+}
+
+// Current calls [trc.Current].
+func Current() trc.Trace {
+	return trc.Current()
+}
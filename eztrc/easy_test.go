@@ -0,0 +1,78 @@
+package eztrc
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestFuncCategory(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"main.main", "main.main"},
+		{"main.init.func1", "main.init"},
+		{"github.com/foo/api.handle", "api.handle"},
+		{"github.com/foo/api.Server.handle", "api.Server.handle"},
+		{"github.com/foo/api.(*Server).handle", "api.Server.handle"},
+		{"github.com/foo/api.handle.func1", "api.handle"},
+		{"github.com/foo/api.handle.func1.2", "api.handle"},
+		{"github.com/foo/api.(*Server).handle.func1.1", "api.Server.handle"},
+		{"github.com/foo/api.(*Server).handle.gowrap1", "api.Server.handle"},
+		{"github.com/foo/api.(*Server).handle.deferwrap2", "api.Server.handle"},
+		{"github.com/foo/api.handle[...]", "api.handle"},
+		{"github.com/foo/api.handle[...].func1", "api.handle"},
+		{"github.com/foo/api.Server[...].handle", "api.Server.handle"},
+		{"github.com/foo/api.(*Server[...]).handle", "api.Server.handle"},
+		{"github.com/foo/api.(*Server[...]).handle.func1.3", "api.Server.handle"},
+		{"github.com/foo/api.func1", "api.func1"},
+		{"gopkg.in/foo%2ev2.(*Client).Do", "foo%2ev2.Client.Do"},
+		{"handle", "handle"},
+	} {
+		if want, have := tc.want, funcCategory(tc.name); want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, want, have)
+		}
+	}
+}
+
+func TestFuncCategoryRuntime(t *testing.T) {
+	t.Parallel()
+
+	var (
+		s       = &genericServer[int]{}
+		method  = s.handle()
+		closure = s.handleClosure()
+		nested  = func() uintptr { return func() uintptr { return callerPC() }() }()
+	)
+
+	for _, tc := range []struct {
+		name string
+		pc   uintptr
+		want string
+	}{
+		{"generic method", method, "eztrc.genericServer.handle"},
+		{"generic method closure", closure, "eztrc.genericServer.handleClosure"},
+		{"nested closures", nested, "eztrc.TestFuncCategoryRuntime"},
+	} {
+		if want, have := tc.want, autoCategory(tc.pc); want != have {
+			t.Errorf("%s: %s: want %q, have %q", tc.name, runtime.FuncForPC(tc.pc).Name(), want, have)
+		}
+	}
+}
+
+type genericServer[T any] struct{}
+
+func (s *genericServer[T]) handle() uintptr {
+	return callerPC()
+}
+
+func (s *genericServer[T]) handleClosure() uintptr {
+	return func() uintptr { return callerPC() }()
+}
+
+func callerPC() uintptr {
+	pc, _, _, _ := runtime.Caller(1)
+	return pc
+}
@@ -0,0 +1,106 @@
+package eztrc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/peterbourgon/trc"
+)
+
+// Environment variables read by [ConfigureFromEnv].
+const (
+	// SourceEnvKey sets the source name of the global collector.
+	SourceEnvKey = "TRC_SOURCE"
+
+	// CategorySizeEnvKey sets the max number of traces kept in each category
+	// of the global collector, via [trc.Collector.SetCategorySize].
+	CategorySizeEnvKey = "TRC_CATEGORY_SIZE"
+
+	// MaxTracesEnvKey is a synonym for CategorySizeEnvKey, which takes
+	// precedence if both are set.
+	MaxTracesEnvKey = "TRC_MAX_TRACES"
+
+	// MaxEventsEnvKey sets the max number of events stored in each trace, via
+	// [trc.SetTraceMaxEvents].
+	MaxEventsEnvKey = "TRC_MAX_EVENTS"
+
+	// StacksEnvKey enables or disables trace event stacks, via
+	// [trc.SetTraceStacks].
+	StacksEnvKey = "TRC_STACKS"
+
+	// ConfigureFromEnvKey, if set to a true value, e.g. "1" or "true", means
+	// [ConfigureFromEnv] is called automatically when the package is
+	// initialized. Any errors are written to stderr.
+	ConfigureFromEnvKey = "TRC_CONFIGURE_FROM_ENV"
+)
+
+func init() {
+	autoConfigureFromEnv(os.Getenv, os.Stderr)
+}
+
+// autoConfigureFromEnv calls configureFromEnv if ConfigureFromEnvKey is set to
+// a true value, and writes any errors to w.
+func autoConfigureFromEnv(getenv func(string) string, w io.Writer) {
+	if ok, _ := strconv.ParseBool(getenv(ConfigureFromEnvKey)); !ok {
+		return
+	}
+	if err := configureFromEnv(getenv); err != nil {
+		fmt.Fprintf(w, "eztrc: configure from env: %v\n", err)
+	}
+}
+
+// ConfigureFromEnv configures the global collector, and package trc, from the
+// environment variables documented above. Unset or empty variables are
+// ignored. Invalid values are reported in the returned error, and don't
+// prevent valid values from being applied.
+//
+// Settings which apply to new traces, like max events and stacks, don't affect
+// traces which have already been created, so ConfigureFromEnv should be called
+// early, e.g. at the start of func main.
+func ConfigureFromEnv() error {
+	return configureFromEnv(os.Getenv)
+}
+
+func configureFromEnv(getenv func(string) string) error {
+	var errs []error
+
+	if s := getenv(SourceEnvKey); s != "" {
+		collector.SetSourceName(s)
+	}
+
+	sizeKey := CategorySizeEnvKey
+	if getenv(sizeKey) == "" {
+		sizeKey = MaxTracesEnvKey
+	}
+	if s := getenv(sizeKey); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", sizeKey, s))
+		} else {
+			collector.SetCategorySize(n)
+		}
+	}
+
+	if s := getenv(MaxEventsEnvKey); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", MaxEventsEnvKey, s))
+		} else {
+			trc.SetTraceMaxEvents(n)
+		}
+	}
+
+	if s := getenv(StacksEnvKey); s != "" {
+		enable, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", StacksEnvKey, s))
+		} else {
+			trc.SetTraceStacks(enable)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,210 @@
+package eztrc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestConfigureFromEnv(t *testing.T) {
+	// Not parallel, because it changes the global collector and package trc.
+
+	initial := getEnvState(t)
+	t.Cleanup(func() { setEnvState(initial) })
+
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		want    func(*envState)
+		wantErr []string
+	}{
+		{
+			name: "unset",
+			env:  map[string]string{},
+			want: func(*envState) {},
+		},
+		{
+			name: "empty",
+			env:  map[string]string{SourceEnvKey: "", CategorySizeEnvKey: "", MaxTracesEnvKey: "", MaxEventsEnvKey: "", StacksEnvKey: ""},
+			want: func(*envState) {},
+		},
+		{
+			name: "source",
+			env:  map[string]string{SourceEnvKey: "my-service"},
+			want: func(s *envState) { s.source = "my-service" },
+		},
+		{
+			name: "category size",
+			env:  map[string]string{CategorySizeEnvKey: "123"},
+			want: func(s *envState) { s.categorySize = 123 },
+		},
+		{
+			name: "max traces",
+			env:  map[string]string{MaxTracesEnvKey: "45"},
+			want: func(s *envState) { s.categorySize = 45 },
+		},
+		{
+			name: "category size takes precedence over max traces",
+			env:  map[string]string{CategorySizeEnvKey: "10", MaxTracesEnvKey: "20"},
+			want: func(s *envState) { s.categorySize = 10 },
+		},
+		{
+			name: "max events",
+			env:  map[string]string{MaxEventsEnvKey: "500"},
+			want: func(s *envState) { s.maxEvents = 500 },
+		},
+		{
+			name: "stacks disabled",
+			env:  map[string]string{StacksEnvKey: "false"},
+			want: func(s *envState) { s.stacks = false },
+		},
+		{
+			name: "all",
+			env:  map[string]string{SourceEnvKey: "all", CategorySizeEnvKey: "7", MaxEventsEnvKey: "50", StacksEnvKey: "0"},
+			want: func(s *envState) { s.source, s.categorySize, s.maxEvents, s.stacks = "all", 7, 50, false },
+		},
+		{
+			name:    "invalid category size",
+			env:     map[string]string{CategorySizeEnvKey: "many"},
+			want:    func(*envState) {},
+			wantErr: []string{CategorySizeEnvKey + `: invalid value "many"`},
+		},
+		{
+			name:    "zero category size",
+			env:     map[string]string{CategorySizeEnvKey: "0"},
+			want:    func(*envState) {},
+			wantErr: []string{CategorySizeEnvKey + `: invalid value "0"`},
+		},
+		{
+			name:    "negative max traces",
+			env:     map[string]string{MaxTracesEnvKey: "-1"},
+			want:    func(*envState) {},
+			wantErr: []string{MaxTracesEnvKey + `: invalid value "-1"`},
+		},
+		{
+			name:    "invalid max events",
+			env:     map[string]string{MaxEventsEnvKey: "1k"},
+			want:    func(*envState) {},
+			wantErr: []string{MaxEventsEnvKey + `: invalid value "1k"`},
+		},
+		{
+			name:    "invalid stacks",
+			env:     map[string]string{StacksEnvKey: "maybe"},
+			want:    func(*envState) {},
+			wantErr: []string{StacksEnvKey + `: invalid value "maybe"`},
+		},
+		{
+			name:    "invalid values don't prevent valid values",
+			env:     map[string]string{SourceEnvKey: "partial", CategorySizeEnvKey: "x", MaxEventsEnvKey: "y", StacksEnvKey: "false"},
+			want:    func(s *envState) { s.source, s.stacks = "partial", false },
+			wantErr: []string{CategorySizeEnvKey + `: invalid value "x"`, MaxEventsEnvKey + `: invalid value "y"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnvState(initial)
+
+			err := configureFromEnv(func(key string) string { return tc.env[key] })
+			switch {
+			case len(tc.wantErr) == 0 && err != nil:
+				t.Errorf("error: want none, have %v", err)
+			case len(tc.wantErr) > 0 && err == nil:
+				t.Errorf("error: want %q, have none", tc.wantErr)
+			}
+			for _, want := range tc.wantErr {
+				if err != nil && !strings.Contains(err.Error(), want) {
+					t.Errorf("error: want %q, have %v", want, err)
+				}
+			}
+
+			want := initial
+			tc.want(&want)
+			if have := getEnvState(t); want != have {
+				t.Errorf("state: want %+v, have %+v", want, have)
+			}
+		})
+	}
+}
+
+func TestAutoConfigureFromEnv(t *testing.T) {
+	// Not parallel, because it changes the global collector.
+
+	initial := getEnvState(t)
+	t.Cleanup(func() { setEnvState(initial) })
+
+	for _, tc := range []struct {
+		name       string
+		enable     string
+		source     string
+		wantSource string
+		wantOutput string
+	}{
+		{name: "unset", enable: "", source: "auto", wantSource: initial.source},
+		{name: "false", enable: "false", source: "auto", wantSource: initial.source},
+		{name: "invalid", enable: "yes please", source: "auto", wantSource: initial.source},
+		{name: "true", enable: "true", source: "auto", wantSource: "auto"},
+		{name: "one", enable: "1", source: "auto", wantSource: "auto"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnvState(initial)
+
+			env := map[string]string{ConfigureFromEnvKey: tc.enable, SourceEnvKey: tc.source}
+			var output strings.Builder
+			autoConfigureFromEnv(func(key string) string { return env[key] }, &output)
+
+			if want, have := tc.wantSource, getEnvState(t).source; want != have {
+				t.Errorf("source: want %q, have %q", want, have)
+			}
+			if want, have := "", output.String(); want != have {
+				t.Errorf("output: want %q, have %q", want, have)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		setEnvState(initial)
+
+		env := map[string]string{ConfigureFromEnvKey: "true", StacksEnvKey: "maybe"}
+		var output strings.Builder
+		autoConfigureFromEnv(func(key string) string { return env[key] }, &output)
+
+		if want, have := `eztrc: configure from env: `+StacksEnvKey+`: invalid value "maybe"`, output.String(); !strings.HasPrefix(have, want) {
+			t.Errorf("output: want %q, have %q", want, have)
+		}
+	})
+}
+
+// envState is the configuration which can be set via environment variables.
+type envState struct {
+	source       string
+	categorySize int
+	maxEvents    int
+	stacks       bool
+}
+
+func getEnvState(t *testing.T) envState {
+	t.Helper()
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sources) != 1 {
+		t.Fatalf("sources: want 1, have %v", res.Sources)
+	}
+
+	return envState{
+		source:       res.Sources[0],
+		categorySize: collector.CategorySize(""),
+		maxEvents:    trc.TraceMaxEvents(),
+		stacks:       trc.TraceStacks(),
+	}
+}
+
+func setEnvState(s envState) {
+	collector.SetSourceName(s.source)
+	collector.SetCategorySize(s.categorySize)
+	trc.SetTraceMaxEvents(s.maxEvents)
+	trc.SetTraceStacks(s.stacks)
+}
@@ -9,23 +9,91 @@ import (
 
 // Filter is a set of rules that can be applied to an individual trace, which
 // will either be allowed (pass) or rejected (fail).
+//
+// IDs match either trace IDs, display IDs, see [DisplayID], or correlation IDs,
+// see [WithCorrelationID].
+//
+// Category matches category names exactly, unless it contains *, which matches
+// any sequence of characters, e.g. "GET /api/*" or "kv/*". The category of a
+// trace is checked by Allow, but collectors check each category name once per
+// search, rather than once per trace.
+//
+// Statuses allow only traces with one of the given statuses, see [SetStatus],
+// and ExcludeStatuses reject traces with any of the given statuses.
+//
+// Tags allow only traces with all of the given tags, see [WithTags]. Each tag
+// is either key=value, matching traces where the tag has that value, or key,
+// matching traces which have the tag with any value.
+//
+// ErrorTypes allow only traces with at least one of the given error types, see
+// [ErrorType].
+//
+// MinEvents and MaxEvents allow only traces with at least, or at most, the
+// given number of events, as returned by Events. For example, a MaxEvents of
+// zero selects suspiciously quiet traces, and a MinEvents of the max events
+// per trace, see [SetMaxEvents], selects traces which may be truncated. HasErrorEvents
+// allows only traces with at least one error event, regardless of whether the
+// trace as a whole is errored, e.g. to find traces which recovered from
+// errors. These conditions read every event, so they're relatively expensive.
+//
+// Query is parsed with [ParseFilterQuery], so it can contain conditions, e.g.
+// "category:api err:true timeout", in addition to a regexp matched against
+// events. Conditions in the query apply in addition to the other fields.
+//
+// MatchEvents only affects streaming. When set, IsErrored and Query are applied
+// to each individual event rather than the trace as a whole, and streamed
+// traces contain only the matching events. This is mostly useful when
+// streaming events, to receive e.g. only error events, or only events matching
+// a regexp, rather than every event of every matching trace.
 type Filter struct {
-	Sources     []string       `json:"sources,omitempty"`
-	IDs         []string       `json:"ids,omitempty"`
-	Category    string         `json:"category,omitempty"`
-	IsActive    bool           `json:"is_active,omitempty"`
-	IsFinished  bool           `json:"is_finished,omitempty"`
-	MinDuration *time.Duration `json:"min_duration,omitempty"`
-	IsSuccess   bool           `json:"is_success,omitempty"`
-	IsErrored   bool           `json:"is_errored,omitempty"`
-	Query       string         `json:"query,omitempty"`
-	regexp      *regexp.Regexp
+	Sources         []string       `json:"sources,omitempty"`
+	ExcludeSources  []string       `json:"exclude_sources,omitempty"`
+	IDs             []string       `json:"ids,omitempty"`
+	Category        string         `json:"category,omitempty"`
+	IsActive        bool           `json:"is_active,omitempty"`
+	IsFinished      bool           `json:"is_finished,omitempty"`
+	MinDuration     *time.Duration `json:"min_duration,omitempty"`
+	IsSuccess       bool           `json:"is_success,omitempty"`
+	IsErrored       bool           `json:"is_errored,omitempty"`
+	Statuses        []string       `json:"statuses,omitempty"`
+	ExcludeStatuses []string       `json:"exclude_statuses,omitempty"`
+	Tags            []string       `json:"tags,omitempty"`
+	ErrorTypes      []string       `json:"error_types,omitempty"`
+	MinEvents       *int           `json:"min_events,omitempty"`
+	MaxEvents       *int           `json:"max_events,omitempty"`
+	HasErrorEvents  bool           `json:"has_error_events,omitempty"`
+	Query           string         `json:"query,omitempty"`
+	MatchEvents     bool           `json:"match_events,omitempty"`
+	regexp          *regexp.Regexp
+	conditions      *Filter // from Query
 }
 
 // Normalize must be called before the filter can be used.
 func (f *Filter) Normalize() []error {
 	var errs []error
 
+	// Empty values typically come from e.g. an "all sources" option in a form,
+	// and would otherwise match no traces at all.
+	f.Sources = withoutEmpty(f.Sources)
+	f.ExcludeSources = withoutEmpty(f.ExcludeSources)
+	f.IDs = withoutEmpty(f.IDs)
+	f.Statuses = withoutEmpty(f.Statuses)
+	f.ExcludeStatuses = withoutEmpty(f.ExcludeStatuses)
+	f.Tags = withoutEmpty(f.Tags)
+	f.ErrorTypes = withoutEmpty(f.ErrorTypes)
+
+	if f.MinEvents != nil && *f.MinEvents < 0 {
+		errs = append(errs, fmt.Errorf("min events (%d) can't be negative", *f.MinEvents))
+	}
+
+	if f.MaxEvents != nil && *f.MaxEvents < 0 {
+		errs = append(errs, fmt.Errorf("max events (%d) can't be negative", *f.MaxEvents))
+	}
+
+	if f.MinEvents != nil && f.MaxEvents != nil && *f.MinEvents > *f.MaxEvents {
+		errs = append(errs, fmt.Errorf("min events (%d) can't be greater than max events (%d)", *f.MinEvents, *f.MaxEvents))
+	}
+
 	if err := f.initializeQueryRegexp(); err != nil {
 		errs = append(errs, fmt.Errorf("query: %w", err))
 	}
@@ -41,6 +109,10 @@ func (f Filter) String() string {
 		elems = append(elems, fmt.Sprintf("Sources=%v", f.Sources))
 	}
 
+	if len(f.ExcludeSources) > 0 {
+		elems = append(elems, fmt.Sprintf("ExcludeSources=%v", f.ExcludeSources))
+	}
+
 	if len(f.IDs) > 0 {
 		elems = append(elems, fmt.Sprintf("IDs=%v", f.Sources))
 	}
@@ -69,10 +141,42 @@ func (f Filter) String() string {
 		elems = append(elems, "IsErrored")
 	}
 
+	if len(f.Statuses) > 0 {
+		elems = append(elems, fmt.Sprintf("Statuses=%v", f.Statuses))
+	}
+
+	if len(f.ExcludeStatuses) > 0 {
+		elems = append(elems, fmt.Sprintf("ExcludeStatuses=%v", f.ExcludeStatuses))
+	}
+
+	if len(f.Tags) > 0 {
+		elems = append(elems, fmt.Sprintf("Tags=%v", f.Tags))
+	}
+
+	if len(f.ErrorTypes) > 0 {
+		elems = append(elems, fmt.Sprintf("ErrorTypes=%v", f.ErrorTypes))
+	}
+
+	if f.MinEvents != nil {
+		elems = append(elems, fmt.Sprintf("MinEvents=%d", *f.MinEvents))
+	}
+
+	if f.MaxEvents != nil {
+		elems = append(elems, fmt.Sprintf("MaxEvents=%d", *f.MaxEvents))
+	}
+
+	if f.HasErrorEvents {
+		elems = append(elems, "HasErrorEvents")
+	}
+
 	if f.Query != "" {
 		elems = append(elems, fmt.Sprintf("Query='%s'", f.Query))
 	}
 
+	if f.MatchEvents {
+		elems = append(elems, "MatchEvents")
+	}
+
 	if len(elems) <= 0 {
 		return "(allow all)"
 	}
@@ -96,10 +200,21 @@ func (f *Filter) Allow(tr Trace) bool {
 		}
 	}
 
+	if len(f.ExcludeSources) > 0 {
+		for _, source := range f.ExcludeSources {
+			if source == tr.Source() {
+				return false
+			}
+		}
+	}
+
 	if len(f.IDs) > 0 {
-		var found bool
+		var (
+			found       bool
+			correlation = CorrelationID(tr)
+		)
 		for _, id := range f.IDs {
-			if id == tr.ID() {
+			if traceID := tr.ID(); id == traceID || isDisplayIDOf(id, traceID) || (correlation != "" && id == correlation) {
 				found = true
 				break
 			}
@@ -110,7 +225,7 @@ func (f *Filter) Allow(tr Trace) bool {
 	}
 
 	if f.Category != "" {
-		if tr.Category() != f.Category {
+		if !matchCategory(f.Category, tr.Category()) {
 			return false
 		}
 	}
@@ -148,20 +263,60 @@ func (f *Filter) Allow(tr Trace) bool {
 		}
 	}
 
+	if len(f.Statuses) > 0 || len(f.ExcludeStatuses) > 0 {
+		status := Status(tr)
+		if len(f.Statuses) > 0 && !contains(f.Statuses, status) {
+			return false
+		}
+		if contains(f.ExcludeStatuses, status) {
+			return false
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		tags := TraceTags(tr)
+		for _, cond := range f.Tags {
+			if !tags.Match(cond) {
+				return false
+			}
+		}
+	}
+
+	if len(f.ErrorTypes) > 0 {
+		var found bool
+		for _, typ := range TraceErrorTypes(tr) {
+			if contains(f.ErrorTypes, typ) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.MinEvents != nil || f.MaxEvents != nil || f.HasErrorEvents {
+		events := tr.Events()
+		if f.MinEvents != nil && len(events) < *f.MinEvents {
+			return false
+		}
+		if f.MaxEvents != nil && len(events) > *f.MaxEvents {
+			return false
+		}
+		if f.HasErrorEvents && !hasErrorEvent(events) {
+			return false
+		}
+	}
+
 	f.initializeQueryRegexp()
+	if f.conditions != nil && !f.conditions.Allow(tr) {
+		return false
+	}
 	if f.regexp != nil {
 		for _, ev := range tr.Events() {
-			if f.regexp.MatchString(ev.What) {
+			if f.matchQuery(ev) {
 				return true
 			}
-			for _, c := range ev.Stack {
-				if f.regexp.MatchString(c.Function) {
-					return true
-				}
-				if f.regexp.MatchString(c.CompactFileLine()) {
-					return true
-				}
-			}
 		}
 		return false
 	}
@@ -169,8 +324,106 @@ func (f *Filter) Allow(tr Trace) bool {
 	return true
 }
 
+// allowEvents is used when streaming. If MatchEvents is false, it's equivalent
+// to Allow. If MatchEvents is true, the IsErrored and Query conditions are
+// applied to individual events, rather than to the trace as a whole, and the
+// returned trace contains only those events which satisfy both conditions. A
+// trace with no such events isn't allowed.
+func (f *Filter) allowEvents(str *StaticTrace) (*StaticTrace, bool) {
+	if !f.MatchEvents {
+		return str, f.Allow(str)
+	}
+
+	f.initializeQueryRegexp()
+	isErrored := f.IsErrored || (f.conditions != nil && f.conditions.IsErrored)
+
+	traceLevel := *f
+	traceLevel.IsErrored, traceLevel.Query, traceLevel.regexp = false, "", nil
+	if f.conditions != nil {
+		conditions := *f.conditions
+		conditions.IsErrored = false
+		traceLevel.conditions = &conditions
+	}
+	if !traceLevel.Allow(str) {
+		return nil, false
+	}
+
+	events := make([]Event, 0, len(str.TraceEvents))
+	for _, ev := range str.TraceEvents {
+		if isErrored && !ev.IsError {
+			continue
+		}
+		if f.regexp != nil && !f.matchQuery(ev) {
+			continue
+		}
+		events = append(events, ev)
+	}
+
+	switch {
+	case len(events) <= 0:
+		return nil, false
+	case len(events) == len(str.TraceEvents):
+		return str, true
+	default:
+		reduced := *str
+		reduced.TraceEvents = events
+		return &reduced, true
+	}
+}
+
+// allowCategory returns true if traces in the category may be allowed by the
+// filter, considering both the Category field and any category in the query.
+func (f *Filter) allowCategory(category string) bool {
+	f.initializeQueryRegexp()
+	if f.Category != "" && !matchCategory(f.Category, category) {
+		return false
+	}
+	if f.conditions != nil && f.conditions.Category != "" && !matchCategory(f.conditions.Category, category) {
+		return false
+	}
+	return true
+}
+
+// withoutCategory returns a copy of the filter which doesn't check the category
+// of traces, for use after allowCategory has checked the category already.
+func (f *Filter) withoutCategory() *Filter {
+	f.initializeQueryRegexp()
+	cp := *f
+	cp.Category = ""
+	if f.conditions != nil && f.conditions.Category != "" {
+		conditions := *f.conditions
+		conditions.Category = ""
+		cp.conditions = &conditions
+	}
+	return &cp
+}
+
+func hasErrorEvent(events []Event) bool {
+	for _, ev := range events {
+		if ev.IsError {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchQuery(ev Event) bool {
+	if f.regexp.MatchString(ev.What) {
+		return true
+	}
+	for _, c := range ev.Stack {
+		if f.regexp.MatchString(c.Function) {
+			return true
+		}
+		if f.regexp.MatchString(c.CompactFileLine()) {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Filter) initializeQueryRegexp() error {
-	if f.regexp != nil {
+	if f.regexp != nil || f.conditions != nil {
 		return nil
 	}
 
@@ -178,13 +431,79 @@ func (f *Filter) initializeQueryRegexp() error {
 		return nil
 	}
 
-	re, err := regexp.Compile(f.Query)
+	parsed, err := ParseFilterQuery(f.Query)
 	if err != nil {
 		f.Query = ""
 		return fmt.Errorf("invalid, ignoring (%w)", err)
+	}
 
+	if parsed.Query != "" {
+		re, err := regexp.Compile(parsed.Query)
+		if err != nil {
+			f.Query = ""
+			return fmt.Errorf("invalid, ignoring (%w)", err)
+		}
+		f.regexp = re
+	}
+
+	if !parsed.isZeroExceptQuery() {
+		parsed.Query = ""
+		f.conditions = &parsed
 	}
 
-	f.regexp = re
 	return nil
 }
+
+// matchCategory returns true if the category matches the pattern, where * in
+// the pattern matches any sequence of characters, and everything else matches
+// literally.
+func matchCategory(pattern, category string) bool {
+	first, rest, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == category
+	}
+
+	if !strings.HasPrefix(category, first) {
+		return false
+	}
+	category = category[len(first):]
+
+	parts := strings.Split(rest, "*")
+	last := parts[len(parts)-1]
+	for _, part := range parts[:len(parts)-1] {
+		i := strings.Index(category, part)
+		if i < 0 {
+			return false
+		}
+		category = category[i+len(part):]
+	}
+
+	return strings.HasSuffix(category, last)
+}
+
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutEmpty returns the strings which aren't empty. The input slice is
+// returned as-is if it has no empty strings, and is never modified.
+func withoutEmpty(a []string) []string {
+	for i := range a {
+		if a[i] != "" {
+			continue
+		}
+		res := append([]string(nil), a[:i]...)
+		for _, s := range a[i+1:] {
+			if s != "" {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return a
+}
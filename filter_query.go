@@ -0,0 +1,268 @@
+package trc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParseFilterQuery parses a query written in the filter query language, and
+// returns the equivalent filter. The query language is a sequence of terms
+// separated by whitespace, where each term is one of the following.
+//
+//	category:NAME     (or cat:NAME)       traces in the category, * is a wildcard
+//	source:NAME       (or src:NAME)       traces from the source, repeatable
+//	-source:NAME      (or -src:NAME)      traces not from the source, repeatable
+//	id:ID                                 traces with the ID, repeatable
+//	err:true          (or errored:true)   errored traces, or successful if false
+//	status:STATUS                         traces with the status, repeatable
+//	-status:STATUS                        traces without the status, repeatable
+//	tag:KEY=VALUE     (or tag:KEY)        traces with the tag, repeatable
+//	errtype:TYPE                          traces with the error type, repeatable
+//	active:true                           active traces, or finished if false
+//	finished:true                         finished traces, or active if false
+//	dur>DURATION      (or duration>=...)  finished traces of at least DURATION
+//	events>=N         (or >, <, <=, :N)   traces with a number of events
+//	errevents:true                        traces with at least one error event
+//	"quoted text"                         events containing the literal text
+//	text                                  events matching the regexp
+//
+// Values may be quoted, e.g. category:"my category". Terms with unknown keys
+// are treated as text. All text terms are joined, separated by single spaces,
+// into the Query of the returned filter, where quoted text is escaped so that
+// it matches literally. All other terms must be satisfied by a trace for it to
+// be allowed by the filter.
+//
+// The Query field of a filter is always interpreted via this function, so the
+// query language can be used anywhere a query is accepted, e.g. in the web UI
+// search box, the -q flag of the trc CLI, or the JSON API.
+func ParseFilterQuery(q string) (Filter, error) {
+	var (
+		f     Filter
+		texts []string
+	)
+
+	terms, err := splitFilterQuery(q)
+	if err != nil {
+		return Filter{}, err
+	}
+
+	for _, t := range terms {
+		if !t.isKeyValue {
+			texts = append(texts, t.text())
+			continue
+		}
+
+		switch t.key {
+		case "category", "cat":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			f.Category = t.value
+
+		case "source", "src":
+			switch {
+			case t.op != ":":
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			case t.negate:
+				f.ExcludeSources = append(f.ExcludeSources, t.value)
+			default:
+				f.Sources = append(f.Sources, t.value)
+			}
+
+		case "status":
+			switch {
+			case t.op != ":":
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			case t.negate:
+				f.ExcludeStatuses = append(f.ExcludeStatuses, t.value)
+			default:
+				f.Statuses = append(f.Statuses, t.value)
+			}
+
+		case "tag":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			f.Tags = append(f.Tags, t.value)
+
+		case "errtype":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			f.ErrorTypes = append(f.ErrorTypes, t.value)
+
+		case "id":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			f.IDs = append(f.IDs, t.value)
+
+		case "err", "errored", "active", "finished":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			b, err := strconv.ParseBool(t.value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s: %w", t.raw, err)
+			}
+			switch t.key {
+			case "err", "errored":
+				f.IsErrored, f.IsSuccess = b, !b
+			case "active":
+				f.IsActive, f.IsFinished = b, !b
+			case "finished":
+				f.IsFinished, f.IsActive = b, !b
+			}
+
+		case "dur", "duration":
+			if t.negate || (t.op != ">" && t.op != ">=") {
+				return Filter{}, fmt.Errorf("%s: only minimum durations are supported", t.raw)
+			}
+			d, err := time.ParseDuration(t.value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s: %w", t.raw, err)
+			}
+			f.MinDuration = &d
+
+		case "events":
+			if t.negate {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			n, err := strconv.Atoi(t.value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s: %w", t.raw, err)
+			}
+			switch t.op {
+			case ":":
+				f.MinEvents, f.MaxEvents = &n, &n
+			case ">=":
+				f.MinEvents = &n
+			case ">":
+				n++
+				f.MinEvents = &n
+			case "<=":
+				f.MaxEvents = &n
+			case "<":
+				n--
+				f.MaxEvents = &n
+			}
+
+		case "errevents":
+			if t.negate || t.op != ":" {
+				return Filter{}, fmt.Errorf("%s: unsupported", t.raw)
+			}
+			b, err := strconv.ParseBool(t.value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s: %w", t.raw, err)
+			}
+			if !b {
+				return Filter{}, fmt.Errorf("%s: only errevents:true is supported", t.raw)
+			}
+			f.HasErrorEvents = true
+
+		default:
+			texts = append(texts, t.text())
+		}
+	}
+
+	f.Query = strings.Join(texts, " ")
+
+	return f, nil
+}
+
+// isZeroExceptQuery returns true if the filter has no conditions besides the
+// query, i.e. it's the result of parsing a query with only text terms.
+func (f *Filter) isZeroExceptQuery() bool {
+	return len(f.Sources) <= 0 &&
+		len(f.ExcludeSources) <= 0 &&
+		len(f.IDs) <= 0 &&
+		f.Category == "" &&
+		!f.IsActive &&
+		!f.IsFinished &&
+		f.MinDuration == nil &&
+		!f.IsSuccess &&
+		!f.IsErrored &&
+		len(f.Statuses) <= 0 &&
+		len(f.ExcludeStatuses) <= 0 &&
+		len(f.Tags) <= 0 &&
+		len(f.ErrorTypes) <= 0 &&
+		f.MinEvents == nil &&
+		f.MaxEvents == nil &&
+		!f.HasErrorEvents
+}
+
+type filterQueryTerm struct {
+	raw        string
+	isKeyValue bool
+	negate     bool
+	key        string
+	op         string
+	value      string
+	quoted     bool
+}
+
+// text returns the term as a regexp, for use in a query.
+func (t filterQueryTerm) text() string {
+	if t.quoted && !t.isKeyValue {
+		return regexp.QuoteMeta(t.value)
+	}
+	return t.raw
+}
+
+var filterQueryKeyValue = regexp.MustCompile(`^(-?)([a-z]+)(:|>=|>|<=|<)(.*)$`)
+
+func splitFilterQuery(q string) ([]filterQueryTerm, error) {
+	var (
+		terms []filterQueryTerm
+		rs    = []rune(q)
+	)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		// Scan a single term, which ends at unquoted whitespace.
+		var (
+			start   = i
+			value   strings.Builder
+			quoted  bool
+			inQuote bool
+		)
+		for ; i < len(rs) && (inQuote || !unicode.IsSpace(rs[i])); i++ {
+			switch {
+			case rs[i] == '"':
+				inQuote, quoted = !inQuote, true
+			case rs[i] == '\\' && inQuote && i+1 < len(rs):
+				i++
+				value.WriteRune(rs[i])
+			default:
+				value.WriteRune(rs[i])
+			}
+		}
+		if inQuote {
+			return nil, fmt.Errorf("unterminated quote in %s", string(rs[start:]))
+		}
+
+		t := filterQueryTerm{
+			raw:    string(rs[start:i]),
+			value:  value.String(),
+			quoted: quoted,
+		}
+		// Only event counts have maximums, so other terms with < or <=, e.g.
+		// dur<1s, are treated as text.
+		if m := filterQueryKeyValue.FindStringSubmatch(t.value); m != nil && !strings.HasPrefix(t.raw, `"`) && (m[2] == "events" || !strings.HasPrefix(m[3], "<")) {
+			t.isKeyValue = true
+			t.negate = m[1] == "-"
+			t.key = m[2]
+			t.op = m[3]
+			t.value = m[4]
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
@@ -0,0 +1,151 @@
+package trc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/peterbourgon/trc"
+)
+
+func TestParseFilterQuery(t *testing.T) {
+	t.Parallel()
+
+	d250ms := 250 * time.Millisecond
+	zero, one, three := 0, 1, 3
+
+	for _, tc := range []struct {
+		query string
+		want  trc.Filter
+		err   bool
+	}{
+		{query: ``, want: trc.Filter{}},
+		{query: `foo|bar`, want: trc.Filter{Query: `foo|bar`}},
+		{query: `  a   b `, want: trc.Filter{Query: `a b`}},
+		{query: `category:api`, want: trc.Filter{Category: "api"}},
+		{query: `cat:"my category"`, want: trc.Filter{Category: "my category"}},
+		{query: `src:a source:b -source:canary`, want: trc.Filter{Sources: []string{"a", "b"}, ExcludeSources: []string{"canary"}}},
+		{query: `err:true`, want: trc.Filter{IsErrored: true}},
+		{query: `errored:false`, want: trc.Filter{IsSuccess: true}},
+		{query: `err:true -status:canceled`, want: trc.Filter{IsErrored: true, ExcludeStatuses: []string{"canceled"}}},
+		{query: `status:timeout status:canceled`, want: trc.Filter{Statuses: []string{"timeout", "canceled"}}},
+		{query: `tag:route=/api tag:tenant`, want: trc.Filter{Tags: []string{"route=/api", "tenant"}}},
+		{query: `-tag:tenant`, err: true},
+		{query: `errtype:io.EOF errtype:*fs.PathError`, want: trc.Filter{ErrorTypes: []string{"io.EOF", "*fs.PathError"}}},
+		{query: `active:false`, want: trc.Filter{IsFinished: true}},
+		{query: `dur>250ms`, want: trc.Filter{MinDuration: &d250ms}},
+		{query: `duration>=250ms`, want: trc.Filter{MinDuration: &d250ms}},
+		{query: `"a.b (c)"`, want: trc.Filter{Query: `a\.b \(c\)`}},
+		{query: `foo:bar`, want: trc.Filter{Query: `foo:bar`}},
+		{query: `category:api err:true dur>250ms "timeout" -source:canary`, want: trc.Filter{
+			Category:       "api",
+			IsErrored:      true,
+			MinDuration:    &d250ms,
+			Query:          "timeout",
+			ExcludeSources: []string{"canary"},
+		}},
+		{query: `err:maybe`, err: true},
+		{query: `dur<1s`, want: trc.Filter{Query: `dur<1s`}},
+		{query: `dur:1s`, err: true},
+		{query: `events:0`, want: trc.Filter{MinEvents: &zero, MaxEvents: &zero}},
+		{query: `events>0`, want: trc.Filter{MinEvents: &one}},
+		{query: `events>=1 events<=3`, want: trc.Filter{MinEvents: &one, MaxEvents: &three}},
+		{query: `events<1`, want: trc.Filter{MaxEvents: &zero}},
+		{query: `events:many`, err: true},
+		{query: `-events:0`, err: true},
+		{query: `errevents:true err:false`, want: trc.Filter{HasErrorEvents: true, IsSuccess: true}},
+		{query: `errevents:false`, err: true},
+		{query: `status<ok`, want: trc.Filter{Query: `status<ok`}},
+		{query: `"unterminated`, err: true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			have, err := trc.ParseFilterQuery(tc.query)
+			if want, have := tc.err, err != nil; want != have {
+				t.Fatalf("error: want %v, have %v (%v)", want, have, err)
+			}
+			if tc.err {
+				return
+			}
+			if !cmp.Equal(tc.want, have, cmpopts.IgnoreUnexported(trc.Filter{})) {
+				t.Error(cmp.Diff(tc.want, have, cmpopts.IgnoreUnexported(trc.Filter{})))
+			}
+		})
+	}
+}
+
+func TestFilterQueryConditions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+	for _, category := range []string{"api", "api", "db"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Tracef("request timeout")
+		if category == "db" {
+			tr.Errorf("failed")
+		}
+		tr.Finish()
+	}
+	{
+		_, tr := collector.NewTrace(ctx, "api")
+		tr.Errorf("request timeout")
+		tr.Finish()
+	}
+
+	for query, want := range map[string]int{
+		"timeout":                  4,
+		"cat:api timeout":          3,
+		"cat:api err:true timeout": 1,
+		"err:true":                 2,
+		"err:false cat:db":         0,
+	} {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: query}})
+		AssertNoError(t, err)
+		if have := res.MatchCount; want != have {
+			t.Errorf("%q: want %d, have %d", query, want, have)
+		}
+	}
+}
+
+func TestFilterEvents(t *testing.T) {
+	t.Parallel()
+
+	var (
+		quiet     = &trc.StaticTrace{TraceID: "quiet"}
+		normal    = &trc.StaticTrace{TraceID: "normal", TraceEvents: []trc.Event{{What: "a"}, {What: "b"}, {What: "c"}}}
+		recovered = &trc.StaticTrace{TraceID: "recovered", TraceEvents: []trc.Event{{What: "a", IsError: true}, {What: "retry ok"}}}
+		failed    = &trc.StaticTrace{TraceID: "failed", TraceErrored: true, TraceEvents: []trc.Event{{What: "a", IsError: true}}}
+		traces    = []*trc.StaticTrace{quiet, normal, recovered, failed}
+	)
+
+	for query, want := range map[string]string{
+		"events:0":                 "quiet",
+		"events>0":                 "normal recovered failed",
+		"events>=2 events<3":       "recovered",
+		"events<=1":                "quiet failed",
+		"errevents:true":           "recovered failed",
+		"errevents:true err:false": "recovered",
+	} {
+		f := trc.Filter{Query: query}
+		if errs := f.Normalize(); len(errs) > 0 {
+			t.Fatalf("%q: %v", query, errs)
+		}
+		var ids []string
+		for _, tr := range traces {
+			if f.Allow(tr) {
+				ids = append(ids, tr.ID())
+			}
+		}
+		if have := strings.Join(ids, " "); want != have {
+			t.Errorf("%q: want %q, have %q", query, want, have)
+		}
+	}
+
+	three, two := 3, 2
+	if errs := (&trc.Filter{MinEvents: &three, MaxEvents: &two}).Normalize(); len(errs) != 1 {
+		t.Errorf("min > max: want 1 error, have %v", errs)
+	}
+}
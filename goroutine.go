@@ -0,0 +1,129 @@
+package trc
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Bind associates the trace in the context with the calling goroutine, so that
+// code which doesn't have access to the context can still add events to the
+// trace via [Current]. It returns a function which removes the association,
+// and which must be called, typically via defer, before the goroutine returns.
+//
+// Bind is meant for legacy code where threading a context through every call
+// isn't practical. Bindings are strictly per-goroutine: goroutines started by
+// the bound goroutine don't inherit its trace, and need their own call to Bind.
+// Prefer passing contexts, and use Bind only at the boundary where the context
+// is lost.
+//
+// Typical usage is as follows.
+//
+//	func handle(w http.ResponseWriter, r *http.Request) {
+//	    defer trc.Bind(r.Context())()
+//	    legacyCodeWithoutContext()
+//	}
+//
+//	func deeplyNestedLegacyFunction() {
+//	    trc.Current().Tracef("still traced")
+//	}
+//
+// Bindings nest: the returned function restores whatever binding, if any, was
+// in effect when Bind was called. If the context doesn't contain a trace, Bind
+// binds nothing, and the returned function is a no-op.
+//
+// Bind determines the goroutine ID by parsing the output of [runtime.Stack],
+// which takes on the order of a microsecond. Bind and Current should be used
+// at coarse boundaries, not in tight loops.
+func Bind(ctx context.Context) func() {
+	tr, ok := MaybeGet(ctx)
+	if !ok {
+		return func() {}
+	}
+
+	gid := goroutineID()
+	prev, hadPrev := goroutineTraces.swap(gid, tr)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if hadPrev {
+				goroutineTraces.swap(gid, prev)
+			} else {
+				goroutineTraces.delete(gid)
+			}
+		})
+	}
+}
+
+// Current returns the trace bound to the calling goroutine via [Bind]. If no
+// trace is bound, an "orphan" trace is created and returned, as with [Get].
+func Current() Trace {
+	if tr, ok := MaybeCurrent(); ok {
+		return tr
+	}
+	return newCoreTrace(getClock(), "", "(orphan)")
+}
+
+// MaybeCurrent returns the trace bound to the calling goroutine via [Bind], if
+// it exists. If not, MaybeCurrent returns a nil trace and false.
+func MaybeCurrent() (Trace, bool) {
+	return goroutineTraces.get()
+}
+
+// goroutineTraces is only written by Bind, so programs which don't use it pay
+// nothing more than an atomic load in Current.
+var goroutineTraces = &goroutineRegistry{traces: map[uint64]Trace{}}
+
+type goroutineRegistry struct {
+	count  atomic.Int64
+	mtx    sync.Mutex
+	traces map[uint64]Trace
+}
+
+func (r *goroutineRegistry) get() (Trace, bool) {
+	if r.count.Load() <= 0 {
+		return nil, false
+	}
+	gid := goroutineID()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	tr, ok := r.traces[gid]
+	return tr, ok
+}
+
+func (r *goroutineRegistry) swap(gid uint64, tr Trace) (Trace, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	prev, ok := r.traces[gid]
+	r.traces[gid] = tr
+	r.count.Store(int64(len(r.traces)))
+	return prev, ok
+}
+
+func (r *goroutineRegistry) delete(gid uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.traces, gid)
+	r.count.Store(int64(len(r.traces)))
+}
+
+// goroutineID returns the ID of the calling goroutine, parsed from the header
+// line of its stack trace, e.g. "goroutine 123 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	const prefix = "goroutine "
+	if len(b) < len(prefix) || string(b[:len(prefix)]) != prefix {
+		return 0
+	}
+	var id uint64
+	for _, c := range b[len(prefix):] {
+		if c < '0' || c > '9' {
+			break
+		}
+		id = id*10 + uint64(c-'0')
+	}
+	return id
+}
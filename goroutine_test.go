@@ -0,0 +1,60 @@
+package trc_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestBind(t *testing.T) {
+	t.Parallel()
+
+	legacy := func(what string) {
+		trc.Current().Tracef("%s", what)
+	}
+
+	ctx := context.Background()
+	ctx, outer := trc.New(ctx, "source", "category")
+	func() {
+		defer trc.Bind(ctx)()
+		legacy("outer 1")
+
+		innerctx, inner := trc.New(context.Background(), "source", "category")
+		func() {
+			defer trc.Bind(innerctx)()
+			legacy("inner 1")
+		}()
+		inner.Finish()
+
+		legacy("outer 2")
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := trc.MaybeCurrent(); ok {
+				t.Errorf("goroutine unexpectedly inherited bound trace")
+			}
+		}()
+		wg.Wait()
+	}()
+	outer.Finish()
+
+	if _, ok := trc.MaybeCurrent(); ok {
+		t.Errorf("trace still bound after unbind")
+	}
+
+	var have []string
+	for _, ev := range outer.Events() {
+		have = append(have, ev.What)
+	}
+	ExpectEqual(t, "outer 1|outer 2", strings.Join(have, "|"))
+
+	defer trc.Bind(context.Background())() // no trace in context: no-op
+	if _, ok := trc.MaybeCurrent(); ok {
+		t.Errorf("empty context bound a trace")
+	}
+}
@@ -0,0 +1,184 @@
+package trc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Heatmap describes the durations of the traces in a category which finished
+// within a recent window of time, as a grid of counts, with one column per
+// interval of time, e.g. one minute, and one row per duration bucket. It's
+// useful to spot e.g. when a latency regression started. See
+// [Collector.Heatmap].
+type Heatmap struct {
+	Category  string          `json:"category,omitempty"`
+	Interval  time.Duration   `json:"interval"`
+	Bucketing []time.Duration `json:"bucketing"`
+	Columns   []HeatmapColumn `json:"columns"`
+}
+
+// HeatmapColumn is the traces which finished in a single interval of a
+// heatmap. Counts has one element per bucket of the heatmap, where Counts[i] is
+// the number of successful traces with a duration of at least Bucketing[i],
+// and less than Bucketing[i+1], if it exists. Unlike the bucket counts in
+// [CategoryStats], the counts aren't cumulative. Errored traces are counted
+// separately, regardless of their duration.
+type HeatmapColumn struct {
+	Start   time.Time `json:"start"`
+	Counts  []int     `json:"counts"`
+	Errored int       `json:"errored"`
+}
+
+// Total returns the total number of traces in the column, including errored
+// traces.
+func (hc HeatmapColumn) Total() int {
+	total := hc.Errored
+	for _, n := range hc.Counts {
+		total += n
+	}
+	return total
+}
+
+// Max returns the largest count of any cell in the heatmap, including errored
+// counts.
+func (h Heatmap) Max() int {
+	var max int
+	for _, col := range h.Columns {
+		for _, n := range col.Counts {
+			max = iff(n > max, n, max)
+		}
+		max = iff(col.Errored > max, col.Errored, max)
+	}
+	return max
+}
+
+const (
+	heatmapInterval = time.Minute
+	heatmapColumns  = 60
+)
+
+// Heatmap returns a heatmap of the traces in the category which finished in
+// the last hour, with one column per minute, oldest first, and one row per
+// duration bucket of [DefaultBucketing]. If category is empty, the heatmap
+// includes every category. Heatmaps are maintained by the collector as traces
+// finish, and so include traces which have been evicted, or which weren't
+// retained, e.g. due to sampling. They don't include traces which were added
+// via [Collector.Add].
+func (c *Collector) Heatmap(category string) Heatmap {
+	var (
+		now = c.getClock().Now()
+		h   = newHeatmap(now)
+	)
+
+	h.Category = category
+
+	counters := c.counters.getAll()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names) // deterministic
+
+	for _, name := range names {
+		if category != "" && name != category {
+			continue
+		}
+		counters[name].heatmap.addTo(&h)
+	}
+
+	return h
+}
+
+//
+//
+//
+
+// heatmapWindow is a ring of columns, each covering one interval of time.
+// Columns are reused as time passes, so only the most recent columns are kept.
+type heatmapWindow struct {
+	mtx     sync.Mutex
+	columns [heatmapColumns]heatmapWindowColumn
+}
+
+type heatmapWindowColumn struct {
+	start   int64 // unix nanos, truncated to the interval
+	counts  []int // per bucket of DefaultBucketing
+	errored int
+}
+
+func newHeatmapWindow() *heatmapWindow {
+	return &heatmapWindow{}
+}
+
+func heatmapSlot(t time.Time) (int64, int) {
+	start := t.Truncate(heatmapInterval).UnixNano()
+	return start, int((start / int64(heatmapInterval)) % heatmapColumns)
+}
+
+// observe a trace which finished at the given time.
+func (hw *heatmapWindow) observe(finished time.Time, duration time.Duration, errored bool) {
+	start, slot := heatmapSlot(finished)
+
+	hw.mtx.Lock()
+	defer hw.mtx.Unlock()
+
+	col := &hw.columns[slot]
+	switch {
+	case col.start > start:
+		return // finished too long ago, the slot has been reused
+	case col.start < start:
+		col.start = start
+		col.counts = make([]int, len(DefaultBucketing))
+		col.errored = 0
+	}
+
+	if errored {
+		col.errored++
+		return
+	}
+
+	for i := len(DefaultBucketing) - 1; i >= 0; i-- {
+		if duration >= DefaultBucketing[i] {
+			col.counts[i]++
+			break
+		}
+	}
+}
+
+// newHeatmap returns an empty heatmap, with columns for the window ending at
+// now.
+func newHeatmap(now time.Time) Heatmap {
+	h := Heatmap{
+		Interval:  heatmapInterval,
+		Bucketing: DefaultBucketing,
+		Columns:   make([]HeatmapColumn, heatmapColumns),
+	}
+	last := now.Truncate(heatmapInterval)
+	for i := range h.Columns {
+		h.Columns[i] = HeatmapColumn{
+			Start:  last.Add(-time.Duration(heatmapColumns-1-i) * heatmapInterval).UTC(),
+			Counts: make([]int, len(DefaultBucketing)),
+		}
+	}
+	return h
+}
+
+// addTo adds the counts of the window to the heatmap, which must have been
+// produced by newHeatmap.
+func (hw *heatmapWindow) addTo(h *Heatmap) {
+	hw.mtx.Lock()
+	defer hw.mtx.Unlock()
+
+	for i := range h.Columns {
+		start, slot := heatmapSlot(h.Columns[i].Start)
+		col := &hw.columns[slot]
+		if col.start != start {
+			continue // no traces finished in this interval
+		}
+		for j, n := range col.counts {
+			h.Columns[i].Counts[j] += n
+		}
+		h.Columns[i].Errored += col.errored
+	}
+}
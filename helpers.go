@@ -2,9 +2,10 @@ package trc
 
 import (
 	"context"
+	"runtime"
 	"runtime/trace"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/peterbourgon/trc/internal/trcutil"
 )
@@ -23,7 +24,7 @@ func Get(ctx context.Context) Trace {
 		return tr
 	}
 
-	return newCoreTrace("", "(orphan)")
+	return newCoreTrace(getClock(), "", "(orphan)")
 }
 
 // MaybeGet returns the trace in the context, if it exists. If not, MaybeGet
@@ -38,7 +39,7 @@ func MaybeGet(ctx context.Context) (Trace, bool) {
 // method with the given max events value. Returns the given trace, and a
 // boolean representing whether or not the call was successful.
 func SetMaxEvents(tr Trace, maxEvents int) (Trace, bool) {
-	m, ok := tr.(interface{ SetMaxEvents(int) })
+	m, ok := optional[interface{ SetMaxEvents(int) }](tr)
 	if !ok {
 		return tr, false
 	}
@@ -46,6 +47,62 @@ func SetMaxEvents(tr Trace, maxEvents int) (Trace, bool) {
 	return tr, true
 }
 
+// SetMaxBytes tries to set the max size of the event text for a specific
+// trace, by checking if the trace implements the method SetMaxBytes(int), and,
+// if so, calling that method with the given max bytes value. Returns the given
+// trace, and a boolean representing whether or not the call was successful.
+//
+// Once a core trace has the maximum number of bytes of event text, the text of
+// the event which exceeded the limit is truncated, and additional events
+// increment the same "truncated" counter as events beyond the max event count.
+// Lazy events are formatted when they're recorded, so that their size is known.
+// A max bytes value of zero or less means no limit, which is the default, and
+// the minimum is 1024.
+func SetMaxBytes(tr Trace, maxBytes int) (Trace, bool) {
+	m, ok := optional[interface{ SetMaxBytes(int) }](tr)
+	if !ok {
+		return tr, false
+	}
+	m.SetMaxBytes(maxBytes)
+	return tr, true
+}
+
+// SetFoldEvents tries to enable or disable event folding for a specific trace,
+// by checking if the trace implements the method SetFoldEvents(bool), and, if
+// so, calling that method with the given value. Returns the given trace, and a
+// boolean representing whether or not the call was successful.
+//
+// When folding is enabled, an event which is identical to the previous event
+// in the trace, i.e. which has the same text and error state, isn't stored as
+// a new event. Instead, the Repeat count of the previous event is incremented.
+// This keeps e.g. retry loops from filling the trace with identical events.
+func SetFoldEvents(tr Trace, fold bool) (Trace, bool) {
+	m, ok := optional[interface{ SetFoldEvents(bool) }](tr)
+	if !ok {
+		return tr, false
+	}
+	m.SetFoldEvents(fold)
+	return tr, true
+}
+
+// SetEventRewriter tries to set a function which rewrites the text of every
+// subsequent event in a specific trace before it's stored, by checking if the
+// trace implements the method SetEventRewriter(func(string) string), and, if
+// so, calling that method with the given function. Returns the given trace,
+// and a boolean representing whether or not the call was successful.
+//
+// The rewrite function is called with the trace locked, so it should be fast,
+// and must not use the trace. Lazy events are formatted immediately, rather
+// than when they're read, so that their args aren't retained.
+func SetEventRewriter(tr Trace, rewrite func(string) string) (Trace, bool) {
+	m, ok := optional[interface{ SetEventRewriter(func(string) string) }](tr)
+	if !ok {
+		return tr, false
+	}
+	m.SetEventRewriter(rewrite)
+	return tr, true
+}
+
 // Region provides more detailed tracing of regions of code, usually functions,
 // which is visible in the trace event "what" text. It decorates the trace in
 // the context by annotating events with the provided name, and also creates a
@@ -72,14 +129,15 @@ func SetMaxEvents(tr Trace, maxEvents int) (Trace, bool) {
 //
 // Region can significantly impact performance. Use it sparingly.
 func Region(ctx context.Context, name string) (context.Context, Trace, func()) {
-	begin := time.Now()
+	clock := getClock()
+	begin := clock.Now()
 	inputTrace := Get(ctx)
 	outputContext, outputTrace := Prefix(ctx, "·")
 	region := trace.StartRegion(outputContext, name)
 
 	inputTrace.LazyTracef("→ " + name)
 	finish := func() {
-		took := time.Since(begin)
+		took := clockSince(clock, begin)
 		inputTrace.LazyTracef("← "+name+" [%s]", trcutil.HumanizeDuration(took))
 		region.End()
 	}
@@ -87,6 +145,45 @@ func Region(ctx context.Context, name string) (context.Context, Trace, func()) {
 	return outputContext, outputTrace, finish
 }
 
+// RegionAuto is like [Region], but the name of the region is derived from the
+// calling function, so it doesn't drift from the function name after a
+// refactor. The name omits the package, e.g. "foo" for a function foo, or
+// "(*T).Method" for a method. Function names are cached by caller.
+//
+//	func foo(ctx context.Context, id int) {
+//	    ctx, tr, finish := trc.RegionAuto(ctx)
+//	    defer finish()
+//	    ...
+//	}
+func RegionAuto(ctx context.Context) (context.Context, Trace, func()) {
+	pc, _, _, ok := runtime.Caller(1)
+	if !ok {
+		return Region(ctx, "(unknown)")
+	}
+	return Region(ctx, regionName(pc))
+}
+
+var regionNames sync.Map // pc uintptr -> name string
+
+// regionName returns the name of the function containing pc, without the
+// package path and name.
+func regionName(pc uintptr) string {
+	if name, ok := regionNames.Load(pc); ok {
+		return name.(string)
+	}
+
+	name := "(unknown)"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+		if pkg := trcutil.FuncPackage(name); pkg != "" {
+			name = name[len(pkg)+1:]
+		}
+	}
+
+	regionNames.Store(pc, name)
+	return name
+}
+
 // Prefix decorates the trace in the context such that every trace event will be
 // prefixed with the string specified by format and args. Those args are not
 // evaluated when Prefix is called, but are instead prefixed to the format and
@@ -115,6 +212,10 @@ type prefixTrace struct {
 	args   []any
 }
 
+func (ptr *prefixTrace) Unwrap() Trace {
+	return ptr.Trace
+}
+
 func (ptr *prefixTrace) Tracef(format string, args ...any) {
 	ptr.Trace.Tracef(ptr.format+format, append(ptr.args, args...)...)
 }
@@ -130,3 +231,115 @@ func (ptr *prefixTrace) Errorf(format string, args ...any) {
 func (ptr *prefixTrace) LazyErrorf(format string, args ...any) {
 	ptr.Trace.LazyErrorf(ptr.format+format, append(ptr.args, args...)...)
 }
+
+func (ptr *prefixTrace) Debugf(format string, args ...any) {
+	Debugf(ptr.Trace, ptr.format+format, append(ptr.args, args...)...)
+}
+
+func (ptr *prefixTrace) Warnf(format string, args ...any) {
+	Warnf(ptr.Trace, ptr.format+format, append(ptr.args, args...)...)
+}
+
+func (ptr *prefixTrace) MergeEvents(events []Event) {
+	prefix := safeSprintf(ptr.format, ptr.args...)
+	prefixed := make([]Event, len(events))
+	for i, ev := range events {
+		ev.What = prefix + ev.What
+		prefixed[i] = ev
+	}
+	mergeEvents(ptr.Trace, prefixed)
+}
+
+// Child creates a child of the trace in the context, intended for a unit of
+// work that runs concurrently with other units of work in the same parent
+// trace, e.g. one of several worker goroutines. It returns a new context
+// containing the child, as well as the child itself.
+//
+// Events added to the child are collected separately from the parent, and so
+// don't contend with events added to the parent or to other children. When
+// the child is finished, its events are prefixed with the string specified by
+// format and args, and merged into the parent, interleaved by timestamp. If any
+// child event is an error, the parent is marked as errored. A child must be
+// finished for its events to be visible in the parent.
+//
+// Typical usage is as follows.
+//
+//	for i := 0; i < n; i++ {
+//	    go func(i int) {
+//	        ctx, tr := trc.Child(ctx, "worker %d", i)
+//	        defer tr.Finish()
+//	        ...
+//	    }(i)
+//	}
+//
+// A child has the same ID, source, and category as its parent, and uses the
+// same clock, see [NewWithClock].
+func Child(ctx context.Context, format string, args ...any) (context.Context, Trace) {
+	parent := Get(ctx)
+
+	prefix := strings.TrimSpace(safeSprintf(format, args...))
+	if prefix != "" {
+		prefix += " "
+	}
+
+	child := &childTrace{
+		Trace:  newCoreTrace(traceClock(parent), parent.Source(), parent.Category()),
+		parent: parent,
+		prefix: prefix,
+	}
+
+	return Put(ctx, child)
+}
+
+type childTrace struct {
+	Trace
+
+	parent Trace
+	prefix string
+	once   sync.Once
+}
+
+func (ctr *childTrace) Unwrap() Trace { return ctr.Trace }
+
+func (ctr *childTrace) ID() string { return ctr.parent.ID() }
+
+func (ctr *childTrace) Source() string { return ctr.parent.Source() }
+
+func (ctr *childTrace) Category() string { return ctr.parent.Category() }
+
+func (ctr *childTrace) CorrelationID() string { return CorrelationID(ctr.parent) }
+
+func (ctr *childTrace) Tags() Tags { return TraceTags(ctr.parent) }
+
+func (ctr *childTrace) ErrorTypes() []string { return TraceErrorTypes(ctr.parent) }
+
+func (ctr *childTrace) SetStatus(status string) { SetStatus(ctr.parent, status) }
+
+func (ctr *childTrace) Status() string { return Status(ctr.parent) }
+
+func (ctr *childTrace) Finish() {
+	ctr.once.Do(func() {
+		ctr.Trace.Finish()
+		events := ctr.Trace.Events()
+		for i := range events {
+			events[i].What = ctr.prefix + events[i].What
+		}
+		mergeEvents(ctr.parent, events)
+	})
+}
+
+// mergeEvents merges the events into the trace, via the optional MergeEvents
+// method if it exists, or by adding them as normal events otherwise.
+func mergeEvents(tr Trace, events []Event) {
+	if m, ok := optional[interface{ MergeEvents([]Event) }](tr); ok {
+		m.MergeEvents(events)
+		return
+	}
+	for _, ev := range events {
+		if ev.IsError {
+			tr.Errorf("%s", ev.What)
+		} else {
+			tr.Tracef("%s", ev.What)
+		}
+	}
+}
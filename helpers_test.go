@@ -3,6 +3,7 @@ package trc_test
 import (
 	"context"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/peterbourgon/trc"
@@ -43,6 +44,41 @@ func TestRegion(t *testing.T) {
 	}
 }
 
+func TestRegionAuto(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ctx, tr := trc.New(ctx, "source", "category")
+	regionAutoHelper(ctx)
+	func() {
+		_, _, finish := trc.RegionAuto(ctx)
+		finish()
+	}()
+	tr.Finish()
+
+	want := []string{
+		"→ regionAutoHelper",
+		"← regionAutoHelper",
+		"→ TestRegionAuto.func1",
+		"← TestRegionAuto.func1",
+	}
+
+	if want, have := len(want), len(tr.Events()); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+
+	for i, ev := range tr.Events() {
+		if !strings.HasPrefix(ev.What, want[i]) {
+			t.Errorf("event %d/%d: want prefix %q, have %q", i+1, len(tr.Events()), want[i], ev.What)
+		}
+	}
+}
+
+func regionAutoHelper(ctx context.Context) {
+	_, _, finish := trc.RegionAuto(ctx)
+	defer finish()
+}
+
 func TestPrefix(t *testing.T) {
 	t.Parallel()
 
@@ -78,3 +114,50 @@ func TestPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestChild(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+	ctx, tr := collector.NewTrace(ctx, "category")
+	tr.Tracef("before")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, child := trc.Child(ctx, "worker %d", i)
+			defer child.Finish()
+			AssertEqual(t, tr.ID(), child.ID())
+			for j := 0; j < 10; j++ {
+				child.Tracef("event %d", j)
+			}
+			if i == 3 {
+				child.Errorf("failed")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	tr.Tracef("after")
+	tr.Finish()
+
+	events := tr.Events()
+	AssertEqual(t, 1+4*10+1+1, len(events))
+	AssertEqual(t, "before", events[0].What)
+	AssertEqual(t, "after", events[len(events)-1].What)
+	AssertEqual(t, true, tr.Errored())
+
+	var workerEvents int
+	for i, ev := range events {
+		if i > 0 && ev.When.Before(events[i-1].When) {
+			t.Errorf("event %d (%s) is before event %d (%s)", i, ev.When, i-1, events[i-1].When)
+		}
+		if strings.HasPrefix(ev.What, "worker ") {
+			workerEvents++
+		}
+	}
+	AssertEqual(t, 4*10+1, workerEvents)
+}
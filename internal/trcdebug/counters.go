@@ -20,24 +20,14 @@ var (
 	// CoreEventNewCount tracks when a new core event is requested.
 	CoreEventNewCount atomic.Uint64
 
-	// CoreEventAllocCount tracks when the core event pool allocs a new value.
+	// CoreEventAllocCount tracks when a core trace allocs a new chunk of
+	// events.
 	CoreEventAllocCount atomic.Uint64
 
-	// CoreEventFreeCount tracks when a core event returns to the pool.
+	// CoreEventFreeCount tracks when a core event is released with its trace.
 	CoreEventFreeCount atomic.Uint64
 
-	// CoreEventLostCount tracks when a core event is lost (see above).
-	CoreEventLostCount atomic.Uint64
-
-	// StringerNewCount tracks when a new stringer is requested.
-	StringerNewCount atomic.Uint64
-
-	// StringerAllocCount tracks when the stringer pool allocs a new value.
-	StringerAllocCount atomic.Uint64
-
-	// StringerFreeCount tracks when a stringer returns to the pool.
-	StringerFreeCount atomic.Uint64
-
-	// StringerLostCount tracks when a stringer is lost (see above).
-	StringerLostCount atomic.Uint64
+	// FormatPanicCount tracks when formatting an event's format string and
+	// args panics, e.g. due to a buggy String method, and is recovered.
+	FormatPanicCount atomic.Uint64
 )
@@ -2,6 +2,7 @@ package trcringbuf
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // RingBuffer is a fixed-size collection of recent items.
@@ -10,6 +11,14 @@ type RingBuffer[T any] struct {
 	buf []T // fully allocated at construction
 	cur int // index for next write, walk backwards to read
 	len int // count of actual values
+
+	gen  atomic.Uint64               // incremented on every modification
+	snap atomic.Pointer[snapshot[T]] // most recent snapshot, maybe stale
+}
+
+type snapshot[T any] struct {
+	gen  uint64
+	vals []T
 }
 
 // NewRingBuffer returns an empty ring buffer of items, pre-allocated with the
@@ -84,11 +93,20 @@ func (rb *RingBuffer[T]) Resize(cap int) (dropped []T) {
 	rb.buf = buf
 	rb.cur = cur
 	rb.len = fill
+	rb.gen.Add(1)
 
 	// Done.
 	return dropped
 }
 
+// Cap returns the capacity of the ring buffer.
+func (rb *RingBuffer[T]) Cap() int {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	return len(rb.buf)
+}
+
 // Add the value to the ring buffer. If the ring buffer was full and an item was
 // overwritten by this add, return that item and true, otherwise return a zero
 // value item and false.
@@ -121,10 +139,104 @@ func (rb *RingBuffer[T]) Add(val T) (dropped T, ok bool) {
 		rb.cur -= len(rb.buf)
 	}
 
+	// Invalidate any snapshot.
+	rb.gen.Add(1)
+
 	// Done.
 	return dropped, ok
 }
 
+// AddAll adds the values to the ring buffer, in order, as if by individual
+// calls to Add, but while holding the lock only once. It returns every value
+// which was overwritten, including values from the given values, if there are
+// more of them than the capacity of the ring buffer.
+func (rb *RingBuffer[T]) AddAll(vals []T) (dropped []T) {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	// Safety first.
+	if cap(rb.buf) <= 0 || len(vals) <= 0 {
+		return nil
+	}
+
+	for _, val := range vals {
+		// Capture any overwritten value so it can be returned.
+		if rb.len >= len(rb.buf) {
+			dropped = append(dropped, rb.buf[rb.cur])
+		}
+
+		// Write the value and advance the write cursor, as in Add.
+		rb.buf[rb.cur] = val
+		if rb.len < len(rb.buf) {
+			rb.len += 1
+		}
+		rb.cur += 1
+		if rb.cur >= len(rb.buf) {
+			rb.cur -= len(rb.buf)
+		}
+	}
+
+	// Invalidate any snapshot.
+	rb.gen.Add(1)
+
+	// Done.
+	return dropped
+}
+
+// Prune removes every value in the ring buffer for which the given function
+// returns true, and returns those dropped values. The order of the remaining
+// values is preserved. Prune takes an exclusive lock on the ring buffer, and
+// visits every value, so it should be called sparingly.
+func (rb *RingBuffer[T]) Prune(drop func(T) bool) (dropped []T) {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	// Walk the values from most recent to oldest, moving each kept value to
+	// the write index, which trails the read index, so the move is safe.
+	wridx := rb.cur - 1
+	for i := 0; i < rb.len; i++ {
+		rdidx := rb.cur - 1 - i
+		if rdidx < 0 {
+			rdidx += len(rb.buf)
+		}
+
+		val := rb.buf[rdidx]
+		if drop(val) {
+			dropped = append(dropped, val)
+			continue
+		}
+
+		if wridx < 0 {
+			wridx += len(rb.buf)
+		}
+		rb.buf[wridx] = val
+		wridx--
+	}
+
+	// Nothing dropped means nothing changed.
+	if len(dropped) <= 0 {
+		return nil
+	}
+
+	// Zero out the slots which are no longer used, so the dropped values can
+	// be garbage collected.
+	var zero T
+	for i := 0; i < len(dropped); i++ {
+		if wridx < 0 {
+			wridx += len(rb.buf)
+		}
+		rb.buf[wridx] = zero
+		wridx--
+	}
+
+	// The write cursor is unchanged, as the most recent values are kept at
+	// the end of the buffer.
+	rb.len -= len(dropped)
+	rb.gen.Add(1)
+
+	return dropped
+}
+
 // Walk calls the given function for each value in the ring buffer, starting
 // with the most recent value, and ending with the oldest value. Walk takes an
 // exclusive lock on the ring buffer, which blocks other calls like Add.
@@ -151,6 +263,42 @@ func (rb *RingBuffer[T]) Walk(fn func(T) error) error {
 	return nil
 }
 
+// Snapshot returns the values in the ring buffer, starting with the most recent
+// value, and ending with the oldest value. The returned slice is immutable, and
+// may be shared with other callers, so it must not be modified.
+//
+// Snapshots are cached, and re-used until the ring buffer is modified, so that
+// concurrent readers don't need to copy the values, or hold the lock, while
+// they process them. A snapshot reflects the state of the ring buffer at some
+// point during the call, and doesn't observe subsequent modifications.
+func (rb *RingBuffer[T]) Snapshot() []T {
+	if s := rb.snap.Load(); s != nil && s.gen == rb.gen.Load() {
+		return s.vals
+	}
+
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	// Another caller may have taken a snapshot while we waited for the lock.
+	gen := rb.gen.Load()
+	if s := rb.snap.Load(); s != nil && s.gen == gen {
+		return s.vals
+	}
+
+	vals := make([]T, rb.len)
+	for i := range vals {
+		cur := rb.cur - 1 - i
+		if cur < 0 {
+			cur += len(rb.buf)
+		}
+		vals[i] = rb.buf[cur]
+	}
+
+	rb.snap.Store(&snapshot[T]{gen: gen, vals: vals})
+
+	return vals
+}
+
 // Stats returns the newest and oldest values in the ring buffer, as well as the
 // total number of values stored in the ring buffer.
 func (rb *RingBuffer[T]) Stats() (newest, oldest T, count int) {
@@ -183,10 +331,13 @@ func (rb *RingBuffer[T]) Stats() (newest, oldest T, count int) {
 //
 //
 
-// RingBuffers collects individual ring buffers by string key.
+// RingBuffers collects individual ring buffers by string key. Each ring buffer
+// has the default capacity of the set, unless its key has a specific capacity,
+// see [RingBuffers.ResizeOne].
 type RingBuffers[T any] struct {
 	mtx  sync.Mutex
 	cap  int
+	caps map[string]int // specific capacities, by key
 	bufs map[string]*RingBuffer[T]
 }
 
@@ -195,6 +346,7 @@ type RingBuffers[T any] struct {
 func NewRingBuffers[T any](cap int) *RingBuffers[T] {
 	return &RingBuffers[T]{
 		cap:  cap,
+		caps: map[string]int{},
 		bufs: map[string]*RingBuffer[T]{},
 	}
 }
@@ -207,7 +359,7 @@ func (rbs *RingBuffers[T]) GetOrCreate(category string) *RingBuffer[T] {
 
 	rb, ok := rbs.bufs[category]
 	if !ok {
-		rb = NewRingBuffer[T](rbs.cap)
+		rb = NewRingBuffer[T](rbs.capOf(category))
 		rbs.bufs[category] = rb
 	}
 
@@ -227,7 +379,33 @@ func (rbs *RingBuffers[T]) GetAll() map[string]*RingBuffer[T] {
 	return all
 }
 
-// Resize all of the ring buffers in the set to the new capacity.
+// Cap returns the default capacity of each ring buffer in the set.
+func (rbs *RingBuffers[T]) Cap() int {
+	rbs.mtx.Lock()
+	defer rbs.mtx.Unlock()
+
+	return rbs.cap
+}
+
+// CapOf returns the capacity of the ring buffer with the given key, which is
+// its specific capacity, if it has one, or the default capacity otherwise. The
+// ring buffer doesn't need to exist.
+func (rbs *RingBuffers[T]) CapOf(key string) int {
+	rbs.mtx.Lock()
+	defer rbs.mtx.Unlock()
+
+	return rbs.capOf(key)
+}
+
+func (rbs *RingBuffers[T]) capOf(key string) int {
+	if cap, ok := rbs.caps[key]; ok {
+		return cap
+	}
+	return rbs.cap
+}
+
+// Resize sets the default capacity of the set, and resizes every ring buffer
+// which doesn't have a specific capacity to the new default capacity.
 func (rbs *RingBuffers[T]) Resize(cap int) (dropped []T) {
 	if cap <= 0 {
 		return
@@ -238,9 +416,41 @@ func (rbs *RingBuffers[T]) Resize(cap int) (dropped []T) {
 
 	rbs.cap = cap
 
-	for _, rb := range rbs.bufs {
+	for key, rb := range rbs.bufs {
+		if _, ok := rbs.caps[key]; ok {
+			continue
+		}
 		dropped = append(dropped, rb.Resize(cap)...)
 	}
 
 	return dropped
 }
+
+// ResizeOne sets the specific capacity of the ring buffer with the given key,
+// and resizes it, if it exists. The capacity applies to the ring buffer even if
+// it's created later. A capacity of zero or less removes the specific capacity,
+// so the ring buffer has the default capacity of the set.
+func (rbs *RingBuffers[T]) ResizeOne(key string, cap int) (dropped []T) {
+	rbs.mtx.Lock()
+	defer rbs.mtx.Unlock()
+
+	if cap > 0 {
+		rbs.caps[key] = cap
+	} else {
+		delete(rbs.caps, key)
+	}
+
+	if rb, ok := rbs.bufs[key]; ok {
+		dropped = rb.Resize(rbs.capOf(key))
+	}
+
+	return dropped
+}
+
+// Prune all of the ring buffers in the set, via [RingBuffer.Prune].
+func (rbs *RingBuffers[T]) Prune(drop func(T) bool) (dropped []T) {
+	for _, rb := range rbs.GetAll() {
+		dropped = append(dropped, rb.Prune(drop)...)
+	}
+	return dropped
+}
@@ -191,6 +191,34 @@ func TestRingBufferResize(t *testing.T) {
 	assertEqual(t, top(10), []int{7, 6, 5, 4})
 }
 
+func TestRingBuffersResizeOne(t *testing.T) {
+	t.Parallel()
+
+	rbs := NewRingBuffers[int](3)
+	for i := 1; i <= 3; i++ {
+		rbs.GetOrCreate("a").Add(i)
+		rbs.GetOrCreate("b").Add(i)
+	}
+
+	assertEqual(t, rbs.ResizeOne("a", 1), []int{2, 1})
+	assertEqual(t, rbs.CapOf("a"), 1)
+	assertEqual(t, rbs.CapOf("b"), 3)
+
+	// Specific capacities apply to ring buffers created later.
+	assertEqual(t, rbs.ResizeOne("c", 5), nil)
+	assertEqual(t, rbs.GetOrCreate("c").Cap(), 5)
+
+	// The default capacity doesn't apply to ring buffers with a specific one.
+	assertEqual(t, rbs.Resize(2), []int{1})
+	assertEqual(t, rbs.CapOf("a"), 1)
+	assertEqual(t, rbs.CapOf("b"), 2)
+	assertEqual(t, rbs.CapOf("c"), 5)
+
+	// Removing the specific capacity restores the default.
+	assertEqual(t, rbs.ResizeOne("c", 0), nil)
+	assertEqual(t, rbs.GetOrCreate("c").Cap(), 2)
+}
+
 func BenchmarkRingBuffer(b *testing.B) {
 	for _, cap := range []int{100, 1000, 10000, 100000} {
 		b.Run(strconv.Itoa(cap), func(b *testing.B) {
@@ -268,3 +296,74 @@ func BenchmarkRingBufferParallel(b *testing.B) {
 		}
 	}
 }
+
+func TestRingBufferSnapshot(t *testing.T) {
+	t.Parallel()
+
+	rb := NewRingBuffer[int](3)
+	assertEqual(t, rb.Snapshot(), []int{})
+
+	rb.Add(1)
+	rb.Add(2)
+	s1 := rb.Snapshot()
+	assertEqual(t, s1, []int{2, 1})
+
+	s2 := rb.Snapshot()
+	assertEqual(t, &s1[0], &s2[0]) // cached
+
+	rb.Add(3)
+	rb.Add(4)
+	assertEqual(t, s1, []int{2, 1}) // unchanged
+	assertEqual(t, rb.Snapshot(), []int{4, 3, 2})
+
+	rb.Resize(2)
+	assertEqual(t, rb.Snapshot(), []int{4, 3})
+}
+
+func TestRingBufferPrune(t *testing.T) {
+	t.Parallel()
+
+	rb := NewRingBuffer[int](5)
+	for i := 1; i <= 7; i++ {
+		rb.Add(i) // 7 6 5 4 3
+	}
+
+	even := func(i int) bool { return i%2 == 0 }
+	assertEqual(t, rb.Prune(even), []int{6, 4})
+	assertEqual(t, rb.Snapshot(), []int{7, 5, 3})
+	assertEqual(t, rb.Prune(even), []int(nil))
+
+	rb.Add(8)
+	rb.Add(9)
+	rb.Add(10)
+	assertEqual(t, rb.Snapshot(), []int{10, 9, 8, 7, 5})
+
+	newest, oldest, count := rb.Stats()
+	assertEqual(t, newest, 10)
+	assertEqual(t, oldest, 5)
+	assertEqual(t, count, 5)
+
+	assertEqual(t, rb.Prune(func(int) bool { return true }), []int{10, 9, 8, 7, 5})
+	assertEqual(t, rb.Snapshot(), []int{})
+
+	rb.Add(11)
+	assertEqual(t, rb.Snapshot(), []int{11})
+}
+
+func TestRingBufferAddAll(t *testing.T) {
+	t.Parallel()
+
+	rb := NewRingBuffer[int](3)
+	assertEqual(t, rb.AddAll(nil), []int(nil))
+	assertEqual(t, rb.AddAll([]int{1, 2}), []int(nil))
+	assertEqual(t, rb.Snapshot(), []int{2, 1})
+
+	assertEqual(t, rb.AddAll([]int{3, 4}), []int{1})
+	assertEqual(t, rb.Snapshot(), []int{4, 3, 2})
+
+	assertEqual(t, rb.AddAll([]int{5, 6, 7, 8}), []int{2, 3, 4, 5})
+	assertEqual(t, rb.Snapshot(), []int{8, 7, 6})
+
+	rb.Add(9)
+	assertEqual(t, rb.Snapshot(), []int{9, 8, 7})
+}
@@ -0,0 +1,17 @@
+package trcutil
+
+import "strings"
+
+// FuncPackage returns the import path of the package containing the function
+// with the given fully-qualified name, e.g. "net/http" for
+// "net/http.(*Server).Serve", or the empty string if there's no package.
+func FuncPackage(name string) string {
+	var (
+		lastSlash = strings.LastIndex(name, "/")
+		dot       = strings.Index(name[lastSlash+1:], ".")
+	)
+	if dot < 0 {
+		return ""
+	}
+	return name[:lastSlash+1+dot]
+}
@@ -1,7 +1,15 @@
 package trc
 
 import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/peterbourgon/trc/internal/trcdebug"
 )
 
 func BenchmarkNewCoreEvent(b *testing.B) {
@@ -20,7 +28,184 @@ func BenchmarkNewCoreEvent(b *testing.B) {
 	})
 }
 
-func f0(flags uint8)  { _ = newCoreEvent(flags, "static string") }
+func BenchmarkCoreTraceTracef(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		nostack uint8
+		format  string
+		args    []any
+	}{
+		{"static no stacks", flagNoStack, "static string", nil},
+		{"static with stacks", 0, "static string", nil},
+		{"format no stacks", flagNoStack, "format %d", []any{1}},
+		{"format with stacks", 0, "format %d", []any{1}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			tr := newCoreTrace(systemClock{}, "source", "category")
+			tr.nostackflag = tc.nostack
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tr.Tracef(tc.format, tc.args...)
+				resetCoreTraceEvents(tr)
+			}
+		})
+	}
+}
+
+func TestCoreTraceTracefStaticAllocs(t *testing.T) {
+	// Not parallel, because AllocsPerRun panics during parallel tests.
+
+	if raceEnabled {
+		t.Skip("allocations aren't reliable under the race detector")
+	}
+
+	tr := newCoreTrace(systemClock{}, "source", "category")
+	tr.nostackflag = flagNoStack
+
+	for _, fn := range []func(string, ...any){tr.Tracef, tr.LazyTracef, tr.Errorf, tr.LazyErrorf} {
+		allocs := testing.AllocsPerRun(1000, func() {
+			fn("static string")
+			resetCoreTraceEvents(tr)
+		})
+		if allocs != 0 {
+			t.Errorf("want 0 allocs/op, have %v", allocs)
+		}
+	}
+
+	tr.Tracef("static string")
+	tr.Tracef("formatted %d", 1)
+	tr.LazyTracef("lazy %s", "formatted")
+	var have []string
+	for _, ev := range tr.Events() {
+		have = append(have, ev.What)
+	}
+	if want, have := "static string|formatted 1|lazy formatted", strings.Join(have, "|"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestCoreTraceEventChunks(t *testing.T) {
+	t.Parallel()
+
+	tr := newCoreTrace(systemClock{}, "source", "category")
+	tr.nostackflag = flagNoStack
+
+	var n int
+	for i := 0; i < coreEventChunksRetained+2; i++ {
+		n += coreEventChunkSize(i)
+	}
+	n++ // one more event, to allocate one more chunk
+	for i := 0; i < n; i++ {
+		tr.LazyTracef("event %d", i)
+	}
+	if want, have := coreEventChunksRetained+3, len(tr.chunks); want != have {
+		t.Errorf("chunks: want %d, have %d", want, have)
+	}
+	for i, want := range []int{4, 8, 16, 32, 32, 32, 32} {
+		if have := len(tr.chunks[i]); want != have {
+			t.Errorf("chunk %d: want %d events, have %d", i, want, have)
+		}
+	}
+
+	events := tr.Events()
+	if want, have := n, len(events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+	if want, have := "event 40", events[40].What; want != have {
+		t.Errorf("event 40: want %q, have %q", want, have)
+	}
+
+	tr.Finish()
+	tr.resetEvents()
+	if want, have := coreEventChunksRetained, len(tr.chunks); want != have {
+		t.Errorf("chunks after reset: want %d, have %d", want, have)
+	}
+	for _, chunk := range tr.chunks {
+		for _, cev := range chunk {
+			if cev.args != nil || cev.text != "" {
+				t.Fatalf("event not cleared after reset")
+			}
+		}
+	}
+}
+
+func TestCoreTraceMergeEventsLimit(t *testing.T) {
+	t.Parallel()
+
+	tr := newCoreTrace(systemClock{}, "source", "category")
+	tr.nostackflag = flagNoStack
+	tr.SetMaxEvents(traceMaxEventsMin)
+	for i := 0; i < 5; i++ {
+		tr.LazyTracef("event %d", i)
+	}
+
+	events := make([]Event, 10000)
+	for i := range events {
+		events[i] = Event{When: time.Now().Add(time.Duration(i) * time.Second), What: fmt.Sprintf("merged %d", i)}
+	}
+	events[len(events)-1].IsError = true
+	tr.MergeEvents(events)
+
+	if want, have := 2, len(tr.chunks); want != have { // 4+8 events >= traceMaxEventsMin
+		t.Errorf("chunks: want %d, have %d", want, have)
+	}
+	if want, have := traceMaxEventsMin, tr.chunksused; want != have {
+		t.Errorf("chunks used: want %d, have %d", want, have)
+	}
+	if want, have := 5+len(events)-traceMaxEventsMin, tr.truncated; want != have {
+		t.Errorf("truncated: want %d, have %d", want, have)
+	}
+	if !tr.Errored() {
+		t.Errorf("dropped error event didn't mark the trace errored")
+	}
+	if want, have := "merged 4", tr.events[len(tr.events)-1].text; want != have {
+		t.Errorf("last event: want %q, have %q", want, have)
+	}
+}
+
+func TestCoreTraceFirstEventBytes(t *testing.T) {
+	// Not parallel, because concurrent tests would skew the measured bytes.
+
+	if raceEnabled {
+		t.Skip("allocations aren't reliable under the race detector")
+	}
+
+	bytesPerOp := func(events int) int64 {
+		const n = 1000
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < n; i++ {
+			tr := newCoreTrace(systemClock{}, "source", "category")
+			tr.nostackflag = flagNoStack
+			for j := 0; j < events; j++ {
+				tr.Tracef("static string")
+			}
+		}
+		runtime.ReadMemStats(&after)
+		return int64(after.TotalAlloc-before.TotalAlloc) / n
+	}
+
+	// The first event of a trace should only allocate the smallest chunk, and
+	// the events slice, rather than a full size chunk. The limit allows for
+	// some noise in the measurement.
+	var (
+		none  = bytesPerOp(0)
+		one   = bytesPerOp(1)
+		limit = int64(unsafe.Sizeof(coreEvent{})) * coreEventChunkMinSize * 2
+	)
+	if have := one - none; have > limit {
+		t.Errorf("first event: want <= %dB/op, have %dB/op", limit, have)
+	}
+}
+
+func resetCoreTraceEvents(tr *coreTrace) {
+	tr.resetEvents()
+}
+
+func f0(flags uint8)  { var cev coreEvent; cev.reset(systemClock{}, flags, "static string") }
 func f1(flags uint8)  { f0(flags) }
 func f2(flags uint8)  { f1(flags) }
 func f3(flags uint8)  { f2(flags) }
@@ -37,3 +222,67 @@ func f13(flags uint8) { f12(flags) }
 func f14(flags uint8) { f13(flags) }
 func f15(flags uint8) { f14(flags) }
 func f16(flags uint8) { f15(flags) }
+
+func TestSafeSprintfPanicCount(t *testing.T) {
+	// Not parallel, because it checks a global counter.
+
+	before := trcdebug.FormatPanicCount.Load()
+
+	// Text which merely looks like a panic caught by fmt isn't counted.
+	if want, have := "arg (PANIC=nope)", safeSprintf("arg %s", "(PANIC=nope)"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := before, trcdebug.FormatPanicCount.Load(); want != have {
+		t.Errorf("count: want %d, have %d", want, have)
+	}
+
+	// A panic which escapes fmt is recovered and counted.
+	if have := safeSprintf("arg %v", escapingPanicStringer{}); !strings.HasPrefix(have, "formatting panic: ") {
+		t.Errorf("want formatting panic, have %q", have)
+	}
+	if want, have := before+1, trcdebug.FormatPanicCount.Load(); want != have {
+		t.Errorf("count: want %d, have %d", want, have)
+	}
+}
+
+// escapingPanicStringer panics with itself, which escapes fmt's own recover.
+type escapingPanicStringer struct{}
+
+func (s escapingPanicStringer) String() string { panic(s) }
+
+func TestModuleVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		bi   debug.BuildInfo
+		want string
+	}{
+		{
+			name: "dependency",
+			bi:   debug.BuildInfo{Main: debug.Module{Path: "example.com/app"}, Deps: []*debug.Module{{Path: modulePath, Version: "v1.2.3"}}},
+			want: "v1.2.3",
+		},
+		{
+			name: "replaced dependency",
+			bi:   debug.BuildInfo{Main: debug.Module{Path: "example.com/app"}, Deps: []*debug.Module{{Path: modulePath, Version: "v1.2.3", Replace: &debug.Module{Path: "../trc"}}}},
+			want: "v1.2.3",
+		},
+		{
+			name: "main module",
+			bi:   debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "(devel)"}, Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "0123456789abcdef"}}},
+			want: "(devel) 0123456789ab",
+		},
+		{
+			name: "absent",
+			bi:   debug.BuildInfo{Main: debug.Module{Path: "example.com/app"}},
+			want: "unknown",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if want, have := tc.want, moduleVersion(&tc.bi); want != have {
+				t.Errorf("want %q, have %q", want, have)
+			}
+		})
+	}
+}
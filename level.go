@@ -0,0 +1,151 @@
+package trc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of an event. Normal events, added via e.g. Tracef, are
+// [LevelInfo], and error events, added via e.g. Errorf, are [LevelError].
+// Events with other levels are added via [Debugf] and [Warnf].
+//
+// Levels allow verbose events, which are useful during development, to be
+// dropped by a collector, see [CollectorConfig.MinEventLevel], or hidden from
+// search results, see [SearchRequest.MinEventLevel], so they don't drown out
+// the events which matter to operators.
+type Level string
+
+const (
+	// LevelDebug is for verbose events, e.g. intermediate values.
+	LevelDebug Level = "debug"
+
+	// LevelInfo is for normal events. It's the level of events added via
+	// Tracef and LazyTracef.
+	LevelInfo Level = "info"
+
+	// LevelWarn is for events which may indicate a problem, but which don't
+	// mark the trace as errored.
+	LevelWarn Level = "warn"
+
+	// LevelError is for error events. It's the level of events added via
+	// Errorf and LazyErrorf.
+	LevelError Level = "error"
+)
+
+// Levels is the set of valid levels, from least to most severe.
+var Levels = []Level{
+	LevelDebug,
+	LevelInfo,
+	LevelWarn,
+	LevelError,
+}
+
+// ParseLevel returns the level with the given name, ignoring case. The names
+// "warning" and "err" are accepted as aliases.
+func ParseLevel(s string) (Level, error) {
+	switch l := Level(strings.ToLower(strings.TrimSpace(s))); l {
+	case "warning":
+		return LevelWarn, nil
+	case "err":
+		return LevelError, nil
+	default:
+		if err := l.validate(); err != nil {
+			return "", err
+		}
+		return l, nil
+	}
+}
+
+func (l Level) validate() error {
+	for _, valid := range Levels {
+		if l == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid level %q", l)
+}
+
+// rank orders levels by severity. The empty level is treated as LevelInfo.
+func (l Level) rank() int {
+	switch l {
+	case LevelDebug:
+		return 0
+	case LevelWarn:
+		return 2
+	case LevelError:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Allows returns true if an event at the given level is at least as severe as
+// l, i.e. if l is used as a minimum level, the event is kept. An empty level
+// allows every event.
+func (l Level) Allows(level Level) bool {
+	return l == "" || level.rank() >= l.rank()
+}
+
+// EventLevel returns the level of the event, which is [LevelError] for error
+// events, [LevelInfo] for normal events, and the level of the event otherwise.
+func (ev Event) EventLevel() Level {
+	switch {
+	case ev.IsError:
+		return LevelError
+	case ev.Level == "":
+		return LevelInfo
+	default:
+		return ev.Level
+	}
+}
+
+// Debugf tries to add a debug event to the trace, by checking if the trace
+// implements the method Debugf(string, ...any), and, if so, calling that
+// method with the given format string and args. Otherwise, a normal event is
+// added via Tracef. Args are evaluated immediately.
+func Debugf(tr Trace, format string, args ...any) {
+	if d, ok := optional[interface{ Debugf(string, ...any) }](tr); ok {
+		d.Debugf(format, args...)
+		return
+	}
+	tr.Tracef(format, args...)
+}
+
+// Warnf tries to add a warning event to the trace, by checking if the trace
+// implements the method Warnf(string, ...any), and, if so, calling that method
+// with the given format string and args. Otherwise, a normal event is added via
+// Tracef. Warning events don't mark the trace as errored. Args are evaluated
+// immediately.
+func Warnf(tr Trace, format string, args ...any) {
+	if w, ok := optional[interface{ Warnf(string, ...any) }](tr); ok {
+		w.Warnf(format, args...)
+		return
+	}
+	tr.Tracef(format, args...)
+}
+
+// SetMinLevel tries to set the minimum level of the events stored in a specific
+// trace, by checking if the trace implements the method SetMinLevel(Level),
+// and, if so, calling that method with the given level. Returns the given
+// trace, and a boolean representing whether or not the call was successful.
+//
+// Events below the minimum level are dropped when they're added, before they're
+// formatted, so they cost almost nothing. Error events are never dropped. An
+// empty level, which is the default, means every event is stored.
+func SetMinLevel(tr Trace, level Level) (Trace, bool) {
+	m, ok := optional[interface{ SetMinLevel(Level) }](tr)
+	if !ok {
+		return tr, false
+	}
+	m.SetMinLevel(level)
+	return tr, true
+}
+
+func minLevelDecorator(level Level) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if level != "" {
+			SetMinLevel(tr, level)
+		}
+		return tr
+	}
+}
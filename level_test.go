@@ -0,0 +1,126 @@
+package trc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]trc.Level{
+		"debug":   trc.LevelDebug,
+		"INFO":    trc.LevelInfo,
+		" warn ":  trc.LevelWarn,
+		"warning": trc.LevelWarn,
+		"err":     trc.LevelError,
+		"error":   trc.LevelError,
+	} {
+		have, err := trc.ParseLevel(input)
+		AssertNoError(t, err)
+		ExpectEqual(t, want, have)
+	}
+
+	_, err := trc.ParseLevel("verbose")
+	ExpectEqual(t, true, err != nil)
+}
+
+func TestLevelAllows(t *testing.T) {
+	t.Parallel()
+
+	ExpectEqual(t, true, trc.Level("").Allows(trc.LevelDebug))
+	ExpectEqual(t, true, trc.LevelDebug.Allows(trc.LevelDebug))
+	ExpectEqual(t, false, trc.LevelInfo.Allows(trc.LevelDebug))
+	ExpectEqual(t, true, trc.LevelInfo.Allows(""))
+	ExpectEqual(t, false, trc.LevelWarn.Allows(trc.LevelInfo))
+	ExpectEqual(t, true, trc.LevelWarn.Allows(trc.LevelError))
+
+	ExpectEqual(t, trc.LevelInfo, trc.Event{}.EventLevel())
+	ExpectEqual(t, trc.LevelError, trc.Event{IsError: true}.EventLevel())
+	ExpectEqual(t, trc.LevelWarn, trc.Event{Level: trc.LevelWarn}.EventLevel())
+}
+
+func TestTraceLevels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, tr := trc.New(ctx, "source", "category")
+	trc.Debugf(tr, "debug %d", 1)
+	tr.Tracef("info %d", 2)
+	trc.Warnf(tr, "warn %d", 3)
+	tr.Errorf("error %d", 4)
+	tr.Finish()
+
+	events := tr.Events()
+	AssertEqual(t, 4, len(events))
+	ExpectEqual(t, trc.LevelDebug, events[0].Level)
+	ExpectEqual(t, trc.Level(""), events[1].Level)
+	ExpectEqual(t, trc.LevelWarn, events[2].Level)
+	ExpectEqual(t, trc.LevelError, events[3].EventLevel())
+	ExpectEqual(t, true, tr.Errored())
+
+	_, warned := trc.New(ctx, "source", "category")
+	trc.Warnf(warned, "warning")
+	warned.Finish()
+	ExpectEqual(t, false, warned.Errored())
+
+	_, filtered := trc.New(ctx, "source", "category")
+	_, ok := trc.SetMinLevel(filtered, trc.LevelWarn)
+	AssertEqual(t, true, ok)
+	trc.Debugf(filtered, "debug")
+	filtered.Tracef("info")
+	filtered.LazyTracef("lazy info")
+	trc.Warnf(filtered, "warn")
+	filtered.Errorf("error")
+	filtered.Finish()
+	events = filtered.Events()
+	AssertEqual(t, 2, len(events))
+	ExpectEqual(t, "warn", events[0].What)
+	ExpectEqual(t, "error", events[1].What)
+
+	// Folding only applies to events with the same level.
+	_, folded := trc.New(ctx, "source", "category")
+	trc.SetFoldEvents(folded, true)
+	folded.Tracef("retry")
+	trc.Debugf(folded, "retry")
+	trc.Debugf(folded, "retry")
+	folded.Finish()
+	events = folded.Events()
+	AssertEqual(t, 2, len(events))
+	ExpectEqual(t, trc.LevelDebug, events[1].Level)
+	ExpectEqual(t, 2, events[1].Repeat)
+}
+
+func TestCollectorEventLevels(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{MinEventLevel: trc.LevelInfo})
+	)
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	trc.Debugf(tr, "dropped")
+	tr.Tracef("kept")
+	trc.Warnf(tr, "also kept")
+	tr.Finish()
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	AssertEqual(t, 2, len(res.Traces[0].Events()))
+
+	res, err = collector.Search(ctx, &trc.SearchRequest{MinEventLevel: trc.LevelWarn})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	events := res.Traces[0].Events()
+	AssertEqual(t, 1, len(events))
+	ExpectEqual(t, "also kept", events[0].What)
+
+	req := &trc.SearchRequest{MinEventLevel: "verbose"}
+	ExpectEqual(t, 1, len(req.Normalize()))
+	ExpectEqual(t, trc.Level(""), req.MinEventLevel)
+}
@@ -0,0 +1,69 @@
+package trc
+
+import (
+	"sort"
+	"strings"
+)
+
+// Metadata is static information about the origin of a trace, e.g. hostname,
+// region, or build version, as key/value pairs. Metadata is shared between
+// traces, and so must not be modified.
+type Metadata map[string]string
+
+// String returns the metadata as space-separated key=value pairs, sorted by
+// key.
+func (md Metadata) String() string {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(md[k])
+	}
+	return sb.String()
+}
+
+func traceMetadata(tr Trace) Metadata {
+	if m, ok := optional[interface{ Metadata() Metadata }](tr); ok {
+		return m.Metadata()
+	}
+	return nil
+}
+
+func metadataDecorator(md Metadata) DecoratorFunc {
+	return func(tr Trace) Trace {
+		if len(md) <= 0 {
+			return tr
+		}
+		return &metadataTrace{Trace: tr, md: md}
+	}
+}
+
+type metadataTrace struct {
+	Trace
+	md Metadata
+}
+
+var _ interface{ Free() } = (*metadataTrace)(nil)
+
+func (mtr *metadataTrace) Unwrap() Trace {
+	return mtr.Trace
+}
+
+func (mtr *metadataTrace) Metadata() Metadata {
+	return mtr.md
+}
+
+func (mtr *metadataTrace) Free() {
+	if f, ok := mtr.Trace.(interface{ Free() }); ok {
+		f.Free()
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package trc
+
+const raceEnabled = false
@@ -0,0 +1,7 @@
+//go:build race
+
+package trc
+
+// sync.Pool randomly drops items under the race detector, so tests which
+// count allocations aren't reliable.
+const raceEnabled = true
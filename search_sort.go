@@ -0,0 +1,118 @@
+package trc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SearchSort determines the order of the traces in a search response, and so,
+// together with the limit, which matching traces are selected.
+type SearchSort string
+
+const (
+	// SearchSortNewest orders traces by start time, newest first. It's the
+	// default.
+	SearchSortNewest SearchSort = "newest"
+
+	// SearchSortOldest orders traces by start time, oldest first.
+	SearchSortOldest SearchSort = "oldest"
+
+	// SearchSortSlowest orders traces by duration, longest first.
+	SearchSortSlowest SearchSort = "slowest"
+
+	// SearchSortErrored orders errored traces before successful traces, and
+	// otherwise by start time, newest first.
+	SearchSortErrored SearchSort = "errored"
+)
+
+// SearchSorts is the set of valid search sorts.
+var SearchSorts = []SearchSort{
+	SearchSortNewest,
+	SearchSortOldest,
+	SearchSortSlowest,
+	SearchSortErrored,
+}
+
+func (s SearchSort) validate() error {
+	for _, valid := range SearchSorts {
+		if s == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid sort %q", s)
+}
+
+// traceSortKey captures the values of a trace which are relevant to sorting.
+// Active traces change over time, so these values are captured once, to keep
+// the order consistent while sorting.
+type traceSortKey struct {
+	id       string
+	started  time.Time
+	duration time.Duration
+	errored  bool
+}
+
+func newTraceSortKey(tr Trace) traceSortKey {
+	return traceSortKey{
+		id:       tr.ID(),
+		started:  tr.Started(),
+		duration: tr.Duration(),
+		errored:  tr.Errored(),
+	}
+}
+
+// less reports whether a should be ordered before b. Ties are broken by start
+// time, newest first, and then by ID, so the order is always deterministic.
+func (s SearchSort) less(a, b traceSortKey) bool {
+	switch s {
+	case SearchSortOldest:
+		if !a.started.Equal(b.started) {
+			return a.started.Before(b.started)
+		}
+	case SearchSortSlowest:
+		if a.duration != b.duration {
+			return a.duration > b.duration
+		}
+	case SearchSortErrored:
+		if a.errored != b.errored {
+			return a.errored
+		}
+	}
+
+	if !a.started.Equal(b.started) {
+		return a.started.After(b.started)
+	}
+	return a.id > b.id
+}
+
+// sortStaticTraces sorts the traces in place, according to the search sort.
+func sortStaticTraces(sts []*StaticTrace, s SearchSort) {
+	sort.Slice(sts, func(i, j int) bool {
+		return s.less(newTraceSortKey(sts[i]), newTraceSortKey(sts[j]))
+	})
+}
+
+// selectTraces returns at most limit of the traces, ordered according to the
+// search sort.
+func selectTraces(trs []Trace, s SearchSort, limit int) []Trace {
+	keys := make([]traceSortKey, len(trs))
+	for i, tr := range trs {
+		keys[i] = newTraceSortKey(tr)
+	}
+	idx := make([]int, len(trs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return s.less(keys[idx[i]], keys[idx[j]])
+	})
+	if len(idx) > limit {
+		idx = idx[:limit]
+	}
+	res := make([]Trace, len(idx))
+	for i, j := range idx {
+		res[i] = trs[j]
+	}
+	return res
+}
@@ -2,6 +2,7 @@ package trc
 
 import (
 	"fmt"
+	"maps"
 	"sort"
 	"time"
 )
@@ -45,7 +46,9 @@ func (ss *SearchStats) Observe(trs ...Trace) {
 			ss.Categories[category] = cs
 		}
 
-		cs.EventCount += len(tr.Events())
+		events := tr.Events()
+		cs.EventCount += len(events)
+		cs.ByteCount += eventsBytes(events)
 
 		var (
 			traceStarted  = tr.Started()
@@ -70,11 +73,36 @@ func (ss *SearchStats) Observe(trs ...Trace) {
 			cs.ErroredCount++
 		}
 
+		for _, typ := range TraceErrorTypes(tr) {
+			if cs.ErrorTypes == nil {
+				cs.ErrorTypes = map[string]int{}
+			}
+			cs.ErrorTypes[typ]++
+		}
+
 		cs.Oldest = olderOf(cs.Oldest, traceStarted)
 		cs.Newest = newerOf(cs.Newest, traceStarted)
 	}
 }
 
+// ObserveSampled observes the given traces into the search stats, like
+// Observe, and additionally records that they were retained by sampling at the
+// given rate, i.e. the fraction of traces in their category which are kept.
+// Each trace is taken to represent 1/rate traces when extrapolating totals.
+func (ss *SearchStats) ObserveSampled(rate float64, trs ...Trace) {
+	ss.Observe(trs...)
+
+	if rate <= 0 || rate >= 1 {
+		return
+	}
+
+	for _, tr := range trs {
+		cs := ss.Categories[tr.Category()]
+		cs.SampledCount++
+		cs.SampledWeight += 1 / rate
+	}
+}
+
 // Merge the other stats into this one.
 func (ss *SearchStats) Merge(other *SearchStats) {
 	if other.IsZero() {
@@ -83,6 +111,7 @@ func (ss *SearchStats) Merge(other *SearchStats) {
 
 	if ss.IsZero() {
 		*ss = *other
+		ss.setQuantiles()
 		return
 	}
 
@@ -94,11 +123,29 @@ func (ss *SearchStats) Merge(other *SearchStats) {
 		ours, ok := ss.Categories[category]
 		if !ok {
 			cp := *theirs
+			cp.ErrorTypes = maps.Clone(theirs.ErrorTypes)
 			ss.Categories[category] = &cp
 			continue
 		}
 		ours.Merge(theirs)
 	}
+
+	ss.setQuantiles()
+}
+
+// setAsOf sets when the stats of every category were computed.
+func (ss *SearchStats) setAsOf(t time.Time) {
+	for _, cs := range ss.Categories {
+		cs.asof = t
+	}
+}
+
+// setQuantiles sets the quantile fields of every category from its bucket
+// counts.
+func (ss *SearchStats) setQuantiles() {
+	for _, cs := range ss.Categories {
+		cs.setQuantiles(ss.Bucketing)
+	}
 }
 
 // Overall returns a synthetic category stats representing all categories.
@@ -112,6 +159,7 @@ func (ss *SearchStats) Overall() *CategoryStats {
 	}
 	overall.tracerate = tracerate
 	overall.eventrate = eventrate
+	overall.setQuantiles(ss.Bucketing)
 	return overall
 }
 
@@ -143,6 +191,31 @@ type CategoryStats struct {
 	Oldest       time.Time `json:"oldest"`
 	Newest       time.Time `json:"newest"`
 
+	// SampledCount is the number of observed traces which were retained by
+	// sampling, and SampledWeight is the number of traces they represent, i.e.
+	// the sum of the inverse of their sample rates. Both are zero for
+	// categories which aren't sampled.
+	SampledCount  int     `json:"sampled_count,omitempty"`
+	SampledWeight float64 `json:"sampled_weight,omitempty"`
+
+	// P50, P90, and P99 are approximate duration quantiles of the successful
+	// finished traces in the category, estimated from the bucket counts, see
+	// [CategoryStats.Quantile]. They're set by searches, and are zero if there
+	// are no such traces.
+	P50 time.Duration `json:"p50,omitempty"`
+	P90 time.Duration `json:"p90,omitempty"`
+	P99 time.Duration `json:"p99,omitempty"`
+
+	// ByteCount is the approximate total size of the events of every observed
+	// trace, i.e. the sum of the lengths of their text and stacks.
+	ByteCount int `json:"byte_count,omitempty"`
+
+	// ErrorTypes is the number of observed traces with each error type, see
+	// [ErrorType]. A trace with several error types is counted once for each
+	// of them.
+	ErrorTypes map[string]int `json:"error_types,omitempty"`
+
+	asof      time.Time // when the stats were computed, per the collector clock
 	tracerate float64
 	eventrate float64
 }
@@ -190,6 +263,105 @@ func (cs *CategoryStats) TotalCount() int {
 	return total
 }
 
+// Quantile estimates the duration at quantile q, in the range 0 to 1, of the
+// successful finished traces in the category, given the bucketing of the search
+// stats which produced the bucket counts. The estimate is linearly interpolated
+// within the bucket which contains the quantile; estimates within the last
+// bucket, which has no upper bound, are the lower bound of that bucket. It
+// returns zero if there are no successful finished traces.
+func (cs *CategoryStats) Quantile(bucketing []time.Duration, q float64) time.Duration {
+	counts := cs.BucketCounts
+	if len(bucketing) <= 0 || len(bucketing) != len(counts) || counts[0] <= 0 {
+		return 0
+	}
+
+	var (
+		total = counts[0]
+		rank  = q * float64(total)
+	)
+	for i := range bucketing {
+		if i == len(bucketing)-1 {
+			return bucketing[i]
+		}
+		below := float64(total - counts[i+1]) // traces with duration < bucketing[i+1]
+		if below < rank {
+			continue
+		}
+		var (
+			lo      = bucketing[i]
+			hi      = bucketing[i+1]
+			inside  = float64(counts[i] - counts[i+1])
+			before  = float64(total - counts[i])
+			percent = iff(inside > 0, (rank-before)/inside, 0)
+		)
+		return lo + time.Duration(percent*float64(hi-lo))
+	}
+	return bucketing[len(bucketing)-1]
+}
+
+func (cs *CategoryStats) setQuantiles(bucketing []time.Duration) {
+	cs.P50 = cs.Quantile(bucketing, 0.50)
+	cs.P90 = cs.Quantile(bucketing, 0.90)
+	cs.P99 = cs.Quantile(bucketing, 0.99)
+}
+
+// ErrorTypeCount is the number of traces with a specific error type.
+type ErrorTypeCount struct {
+	ErrorType string `json:"error_type"`
+	Count     int    `json:"count"`
+}
+
+// TopErrorTypes returns up to n of the most common error types in the
+// category, ordered by count descending, and then by error type. If n is zero
+// or less, all error types are returned.
+func (cs *CategoryStats) TopErrorTypes(n int) []ErrorTypeCount {
+	top := make([]ErrorTypeCount, 0, len(cs.ErrorTypes))
+	for typ, count := range cs.ErrorTypes {
+		top = append(top, ErrorTypeCount{ErrorType: typ, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].ErrorType < top[j].ErrorType
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// IsSampled returns true if any traces in the category were retained by
+// sampling, which means the observed counts are less than the actual counts.
+func (cs *CategoryStats) IsSampled() bool {
+	return cs.SampledCount > 0
+}
+
+// SampleRate returns the effective fraction of traces in the category which
+// were retained, i.e. the observed total divided by the extrapolated total. It
+// returns 1 for categories which aren't sampled.
+func (cs *CategoryStats) SampleRate() float64 {
+	extrapolated := cs.ExtrapolatedTotalCount()
+	if !cs.IsSampled() || extrapolated <= 0 {
+		return 1
+	}
+	return float64(cs.TotalCount()) / extrapolated
+}
+
+// ExtrapolatedTotalCount returns the estimated total number of traces in the
+// category, including those which were dropped by sampling. For categories
+// which aren't sampled, it's the same as TotalCount.
+func (cs *CategoryStats) ExtrapolatedTotalCount() float64 {
+	return float64(cs.TotalCount()-cs.SampledCount) + cs.SampledWeight
+}
+
+// ExtrapolatedTraceRate is an approximate measure of traces per second in this
+// category, including those which were dropped by sampling. For categories
+// which aren't sampled, it's the same as TraceRate.
+func (cs *CategoryStats) ExtrapolatedTraceRate() float64 {
+	return cs.TraceRate() / cs.SampleRate()
+}
+
 // TraceRate is an approximate measure of traces per second in this category.
 func (cs *CategoryStats) TraceRate() (r float64) {
 	if cs.tracerate != 0 {
@@ -202,7 +374,7 @@ func (cs *CategoryStats) TraceRate() (r float64) {
 
 	var (
 		total      = cs.TotalCount()
-		delta      = time.Since(cs.Oldest)
+		delta      = cs.sinceOldest()
 		totalZero  = total <= 0
 		deltaZero  = delta <= 0
 		newestZero = cs.Newest.IsZero()
@@ -228,7 +400,7 @@ func (cs *CategoryStats) EventRate() (r float64) {
 
 	var (
 		total      = cs.EventCount
-		delta      = time.Since(cs.Oldest)
+		delta      = cs.sinceOldest()
 		totalZero  = total <= 0
 		deltaZero  = delta <= 0
 		newestZero = cs.Newest.IsZero()
@@ -242,6 +414,15 @@ func (cs *CategoryStats) EventRate() (r float64) {
 	return float64(total) / float64(delta.Seconds())
 }
 
+// sinceOldest returns the time between the oldest trace and when the stats were
+// computed, or now, if that isn't known.
+func (cs *CategoryStats) sinceOldest() time.Duration {
+	if cs.asof.IsZero() {
+		return clockSince(getClock(), cs.Oldest)
+	}
+	return cs.asof.Sub(cs.Oldest)
+}
+
 // Merge the other category stats into this one.
 func (cs *CategoryStats) Merge(other *CategoryStats) {
 	if other.IsZero() {
@@ -250,6 +431,7 @@ func (cs *CategoryStats) Merge(other *CategoryStats) {
 
 	if cs.IsZero() {
 		*cs = *other
+		cs.ErrorTypes = maps.Clone(other.ErrorTypes)
 		return
 	}
 
@@ -268,8 +450,21 @@ func (cs *CategoryStats) Merge(other *CategoryStats) {
 
 	cs.ErroredCount += other.ErroredCount
 
+	cs.SampledCount += other.SampledCount
+	cs.SampledWeight += other.SampledWeight
+
+	cs.ByteCount += other.ByteCount
+
+	for typ, n := range other.ErrorTypes {
+		if cs.ErrorTypes == nil {
+			cs.ErrorTypes = map[string]int{}
+		}
+		cs.ErrorTypes[typ] += n
+	}
+
 	cs.Oldest = olderOf(cs.Oldest, other.Oldest)
 	cs.Newest = newerOf(cs.Newest, other.Newest)
+	cs.asof = newerOf(cs.asof, other.asof)
 
 	cs.tracerate = cs.TraceRate() + other.TraceRate()
 	cs.eventrate = cs.EventRate() + other.EventRate()
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/peterbourgon/trc"
 )
@@ -75,3 +76,67 @@ func TestSearchStatsIsZero(t *testing.T) {
 		AssertEqual(t, false, ss.IsZero())
 	})
 }
+
+func TestSearchStatsQuantiles(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		clock     = &manualClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+		bucketing = []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond, 100 * time.Millisecond}
+	)
+
+	// 80 traces in [0, 10ms), 10 in [10ms, 20ms), and 10 in [100ms, ...).
+	for i := 0; i < 100; i++ {
+		_, tr := collector.NewTrace(ctx, "category")
+		switch {
+		case i < 80:
+			clock.Advance(5 * time.Millisecond)
+		case i < 90:
+			clock.Advance(15 * time.Millisecond)
+		default:
+			clock.Advance(time.Second)
+		}
+		tr.Finish()
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{Bucketing: bucketing, StatsOnly: true})
+	AssertNoError(t, err)
+
+	cs := res.Stats.Categories["category"]
+	ExpectEqual(t, 6250*time.Microsecond, cs.P50)
+	ExpectEqual(t, 20*time.Millisecond, cs.P90)
+	ExpectEqual(t, 100*time.Millisecond, cs.P99)
+	ExpectEqual(t, cs.P50, cs.Quantile(bucketing, 0.50))
+
+	overall := res.Stats.Overall()
+	ExpectEqual(t, cs.P50, overall.P50)
+
+	ExpectEqual(t, time.Duration(0), trc.NewCategoryStats("empty", bucketing).Quantile(bucketing, 0.5))
+}
+
+func TestCategoryStatsQuantile(t *testing.T) {
+	t.Parallel()
+
+	var (
+		bucketing = []time.Duration{0, 10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+		cs        = trc.CategoryStats{BucketCounts: []int{100, 50, 10, 1}} // 50 in [0,10ms), 40 in [10ms,100ms), 9 in [100ms,1s), 1 in [1s,∞)
+	)
+
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.00, 0},
+		{0.25, 5 * time.Millisecond},
+		{0.50, 10 * time.Millisecond},
+		{0.70, 55 * time.Millisecond},
+		{0.99, time.Second},
+		{1.00, time.Second},
+	} {
+		if want, have := tc.want, cs.Quantile(bucketing, tc.q); want != have {
+			t.Errorf("q=%.2f: want %v, have %v", tc.q, want, have)
+		}
+	}
+}
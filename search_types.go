@@ -22,11 +22,38 @@ type Searcher interface {
 //
 
 // SearchRequest describes a complete search request.
+//
+// If StatsOnly is true, the search only computes stats, and doesn't select any
+// traces, so the response has no traces, and a match count of zero. This makes
+// searches over many remote searchers much cheaper, e.g. to render an overview,
+// with full traces fetched by subsequent, more specific, search requests.
+//
+// MaxScan and MaxDuration, if greater than zero, are an execution budget for
+// each searcher. MaxScan limits the number of traces evaluated against the
+// filter, and MaxDuration limits the time spent doing so. Once the budget is
+// exhausted, or the context is canceled, no more traces are evaluated, and the
+// response contains the traces selected so far, complete stats, and a problem
+// noting that the results are partial. This protects against pathological
+// searches, e.g. an expensive regexp over a full collector.
+//
+// Sort determines the order of the selected traces, and, together with Limit,
+// which matching traces are selected. For example, SearchSortSlowest with a
+// limit of 10 selects the 10 slowest matching traces. The default is
+// SearchSortNewest.
+//
+// MinEventLevel, if provided, removes the events below that level from the
+// selected traces, e.g. LevelInfo hides debug events. It doesn't affect which
+// traces are selected.
 type SearchRequest struct {
-	Bucketing  []time.Duration `json:"bucketing,omitempty"`
-	Filter     Filter          `json:"filter,omitempty"`
-	Limit      int             `json:"limit,omitempty"`
-	StackDepth int             `json:"stack_depth,omitempty"` // 0 is default stacks, -1 for no stacks
+	Bucketing     []time.Duration `json:"bucketing,omitempty"`
+	Filter        Filter          `json:"filter,omitempty"`
+	Limit         int             `json:"limit,omitempty"`
+	StackDepth    int             `json:"stack_depth,omitempty"` // 0 is default stacks, -1 for no stacks
+	StatsOnly     bool            `json:"stats_only,omitempty"`
+	MaxScan       int             `json:"max_scan,omitempty"`
+	MaxDuration   time.Duration   `json:"max_duration,omitempty"`
+	Sort          SearchSort      `json:"sort,omitempty"`
+	MinEventLevel Level           `json:"min_event_level,omitempty"`
 }
 
 // Normalize ensures the search request is valid, modifying it if necessary. It
@@ -57,6 +84,29 @@ func (req *SearchRequest) Normalize() []error {
 		req.Limit = SearchLimitMax
 	}
 
+	if req.MaxScan < 0 {
+		req.MaxScan = 0
+	}
+
+	if req.MaxDuration < 0 {
+		req.MaxDuration = 0
+	}
+
+	if req.Sort == "" {
+		req.Sort = SearchSortNewest
+	}
+	if err := req.Sort.validate(); err != nil {
+		errs = append(errs, err)
+		req.Sort = SearchSortNewest
+	}
+
+	if req.MinEventLevel != "" {
+		if err := req.MinEventLevel.validate(); err != nil {
+			errs = append(errs, err)
+			req.MinEventLevel = ""
+		}
+	}
+
 	return errs
 }
 
@@ -80,6 +130,26 @@ func (req SearchRequest) String() string {
 		elems = append(elems, fmt.Sprintf("StackDepth:%d", req.StackDepth))
 	}
 
+	if req.StatsOnly {
+		elems = append(elems, "StatsOnly")
+	}
+
+	if req.MaxScan > 0 {
+		elems = append(elems, fmt.Sprintf("MaxScan:%d", req.MaxScan))
+	}
+
+	if req.MaxDuration > 0 {
+		elems = append(elems, fmt.Sprintf("MaxDuration:%s", req.MaxDuration))
+	}
+
+	if req.Sort != "" && req.Sort != SearchSortNewest {
+		elems = append(elems, fmt.Sprintf("Sort:%s", req.Sort))
+	}
+
+	if req.MinEventLevel != "" {
+		elems = append(elems, fmt.Sprintf("MinEventLevel:%s", req.MinEventLevel))
+	}
+
 	return strings.Join(elems, " ")
 }
 
@@ -115,6 +185,7 @@ var DefaultBucketing = []time.Duration{
 type SearchResponse struct {
 	Request    *SearchRequest `json:"request,omitempty"`
 	Sources    []string       `json:"sources"`
+	Versions   Versions       `json:"versions,omitempty"`
 	TotalCount int            `json:"total_count"`
 	MatchCount int            `json:"match_count"`
 	Traces     []*StaticTrace `json:"traces"`
@@ -152,7 +223,8 @@ func (ms MultiSearcher) Search(ctx context.Context, req *SearchRequest) (*Search
 	for i, s := range ms {
 		go func(id string, s Searcher) {
 			ctx, _ := Prefix(ctx, "<%s>", id)
-			res, err := s.Search(ctx, req)
+			req := *req // searchers may normalize the request concurrently
+			res, err := s.Search(ctx, &req)
 			tuplec <- tuple{id, res, err}
 		}(strconv.Itoa(i+1), s)
 	}
@@ -178,6 +250,7 @@ func (ms MultiSearcher) Search(ctx context.Context, req *SearchRequest) (*Search
 		case t.res != nil && t.err == nil: // success case
 			aggregate.Stats.Merge(t.res.Stats)
 			aggregate.Sources = append(aggregate.Sources, t.res.Sources...)
+			aggregate.Versions = aggregate.Versions.merge(t.res.Versions)
 			aggregate.TotalCount += t.res.TotalCount
 			aggregate.MatchCount += t.res.MatchCount
 			aggregate.Traces = append(aggregate.Traces, t.res.Traces...) // needs sort+limit
@@ -186,6 +259,7 @@ func (ms MultiSearcher) Search(ctx context.Context, req *SearchRequest) (*Search
 			tr.Tracef("%s: weird: valid result (accepting it) with error: %v", t.id, t.err)
 			aggregate.Stats.Merge(t.res.Stats)
 			aggregate.Sources = append(aggregate.Sources, t.res.Sources...)
+			aggregate.Versions = aggregate.Versions.merge(t.res.Versions)
 			aggregate.TotalCount += t.res.TotalCount
 			aggregate.MatchCount += t.res.MatchCount
 			aggregate.Traces = append(aggregate.Traces, t.res.Traces...) // needs sort+limit
@@ -198,9 +272,9 @@ func (ms MultiSearcher) Search(ctx context.Context, req *SearchRequest) (*Search
 
 	// At this point, the aggregate response has all of the raw data it's ever
 	// gonna get. We need to do a little bit of post-processing. First, we need
-	// to sort all of the selected traces by start time, and then limit them by
-	// the request limit.
-	sort.Sort(staticTracesNewestFirst(aggregate.Traces))
+	// to sort all of the selected traces according to the request, and then
+	// limit them by the request limit.
+	sortStaticTraces(aggregate.Traces, req.Sort)
 	if len(aggregate.Traces) > req.Limit {
 		aggregate.Traces = aggregate.Traces[:req.Limit]
 	}
@@ -0,0 +1,155 @@
+package trc
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// StackFilter decides whether a frame should be dropped from the stacks of
+// trace events. It returns true if the frame should be dropped.
+//
+// Stack filters can be registered globally via [RegisterStackFilter], in which
+// case they apply to every event in every trace, or provided to a specific
+// collector via [CollectorConfig.StackFilters], in which case they apply to
+// the traces returned by that collector's searches and exports.
+type StackFilter func(Frame) bool
+
+// RegisterStackFilter adds the filter to the global chain of stack filters,
+// which is applied when the stacks of trace events are first resolved. A frame
+// is dropped if any filter in the chain returns true. Frames from package trc
+// itself, like calls to Tracef, are always dropped.
+//
+// Stacks are resolved lazily, so registered filters may apply to events which
+// were created before the filter was registered. Filters should be registered
+// early, e.g. at the start of func main, and must be safe for concurrent use.
+func RegisterStackFilter(f StackFilter) {
+	stackFiltersMtx.Lock()
+	defer stackFiltersMtx.Unlock()
+
+	prev := *stackFilters.Load()
+	next := make([]StackFilter, 0, len(prev)+1)
+	next = append(next, prev...)
+	next = append(next, f)
+	stackFilters.Store(&next)
+}
+
+// SkipStdlibFrames is a stack filter which drops frames from packages in the
+// standard library, e.g. net/http or runtime, identified as packages whose
+// import path doesn't contain a dot in its first element.
+func SkipStdlibFrames(fr Frame) bool {
+	pkg := trcutil.FuncPackage(fr.Function)
+	if pkg == "" {
+		return false
+	}
+	first, _, _ := strings.Cut(pkg, "/")
+	return !strings.Contains(first, ".")
+}
+
+// SkipVendoredFrames is a stack filter which drops frames from vendored
+// packages, i.e. packages with a vendor directory in their import path.
+func SkipVendoredFrames(fr Frame) bool {
+	return strings.Contains(fr.Function, "/vendor/") || strings.HasPrefix(fr.Function, "vendor/")
+}
+
+// SkipFramePrefixes returns a stack filter which drops frames whose function
+// name, which includes the full package import path, has any of the given
+// prefixes. For example, the prefix "github.com/org/repo/internal/middleware"
+// drops every frame in that package, and its subpackages.
+func SkipFramePrefixes(prefixes ...string) StackFilter {
+	prefixes = append([]string(nil), prefixes...)
+	return func(fr Frame) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fr.Function, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterStacks removes every frame from the stacks of every event in the trace
+// for which any of the filters returns true.
+func (st *StaticTrace) FilterStacks(filters ...StackFilter) *StaticTrace {
+	if len(filters) <= 0 {
+		return st
+	}
+	for i, ev := range st.TraceEvents {
+		st.TraceEvents[i].Stack = filterFrames(ev.Stack, filters)
+	}
+	return st
+}
+
+//
+//
+//
+
+var (
+	stackFiltersMtx sync.Mutex
+	stackFilters    atomic.Pointer[[]StackFilter]
+)
+
+func init() {
+	stackFilters.Store(&[]StackFilter{skipTrcFrames})
+}
+
+// skipStackFrame returns true if the frame should be dropped, according to the
+// global chain of stack filters.
+func skipStackFrame(fr Frame) bool {
+	for _, f := range *stackFilters.Load() {
+		if f(fr) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipTrcFrames drops frames which are part of the tracing machinery itself,
+// rather than the code being traced.
+func skipTrcFrames(fr Frame) bool {
+	function := fr.Function
+	if !strings.HasPrefix(function, "github.com/peterbourgon/trc") {
+		return false // fast path
+	}
+	if strings.HasSuffix(function, "Tracef") || strings.HasSuffix(function, "Errorf") || strings.HasSuffix(function, "Debugf") || strings.HasSuffix(function, "Warnf") {
+		return true
+	}
+	if strings.HasPrefix(function, "github.com/peterbourgon/trc.Region") {
+		return true
+	}
+	if strings.HasPrefix(function, "github.com/peterbourgon/trc/eztrc.") {
+		return true
+	}
+	return false
+}
+
+// filterFrames returns the frames which aren't dropped by any of the filters.
+// Frames may be shared with the trace they came from, so if any frame is
+// dropped, a new slice is returned, rather than modifying the original.
+func filterFrames(frames []Frame, filters []StackFilter) []Frame {
+	for i, fr := range frames {
+		if !anyStackFilter(filters, fr) {
+			continue
+		}
+		res := make([]Frame, 0, len(frames)-1)
+		res = append(res, frames[:i]...)
+		for _, fr := range frames[i+1:] {
+			if !anyStackFilter(filters, fr) {
+				res = append(res, fr)
+			}
+		}
+		return res
+	}
+	return frames
+}
+
+func anyStackFilter(filters []StackFilter, fr Frame) bool {
+	for _, f := range filters {
+		if f(fr) {
+			return true
+		}
+	}
+	return false
+}
@@ -90,3 +90,60 @@ func TestEventStacks(t *testing.T) {
 		AssertEqual(t, want.what, events[i].What)
 	}
 }
+
+func TestStackFilters(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		function string
+		filter   trc.StackFilter
+		want     bool
+	}{
+		{"net/http.(*Server).Serve", trc.SkipStdlibFrames, true},
+		{"runtime.goexit", trc.SkipStdlibFrames, true},
+		{"main.main", trc.SkipStdlibFrames, true},
+		{"github.com/foo/bar.Baz", trc.SkipStdlibFrames, false},
+		{"github.com/foo/bar/vendor/github.com/qux/quux.Do", trc.SkipVendoredFrames, true},
+		{"github.com/foo/bar.Baz", trc.SkipVendoredFrames, false},
+		{"github.com/foo/bar/internal/mw.Wrap.func1", trc.SkipFramePrefixes("example.com/", "github.com/foo/bar/internal/mw"), true},
+		{"github.com/foo/bar/internal/app.Run", trc.SkipFramePrefixes("example.com/", "github.com/foo/bar/internal/mw"), false},
+	} {
+		if want, have := tc.want, tc.filter(trc.Frame{Function: tc.function}); want != have {
+			t.Errorf("%s: want %v, have %v", tc.function, want, have)
+		}
+	}
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewCollector(trc.CollectorConfig{
+			StackFilters: []trc.StackFilter{
+				trc.SkipFramePrefixes("github.com/peterbourgon/trc_test.testCallStackBar"),
+			},
+		})
+	)
+	ctx, tr := collector.NewTrace(ctx, "cat")
+	testCallStackFoo(t, ctx)
+	tr.Finish()
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+
+	var filtered, original int
+	for _, ev := range res.Traces[0].Events() {
+		for _, fr := range ev.Stack {
+			if strings.Contains(fr.Function, "testCallStackBar") {
+				filtered++
+			}
+		}
+	}
+	for _, ev := range tr.Events() {
+		for _, fr := range ev.Stack {
+			if strings.Contains(fr.Function, "testCallStackBar") {
+				original++
+			}
+		}
+	}
+	ExpectEqual(t, 0, filtered)
+	ExpectEqual(t, true, original > 0) // the trace itself is unaffected
+}
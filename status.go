@@ -0,0 +1,62 @@
+package trc
+
+import (
+	"context"
+	"errors"
+)
+
+// Statuses describe how a trace finished, in more detail than whether or not it
+// errored. A status is an arbitrary, short string, typically set just before a
+// trace is finished, and the empty string means no status was set. These
+// statuses are set automatically by e.g. trcweb middlewares.
+const (
+	// StatusCanceled means the operation was canceled by the caller, e.g.
+	// because an HTTP client disconnected before the response was written.
+	StatusCanceled = "canceled"
+
+	// StatusTimeout means the deadline of the operation was exceeded.
+	StatusTimeout = "timeout"
+)
+
+// SetStatus tries to set the status of a specific trace, by checking if the
+// trace implements the method SetStatus(string), and, if so, calling that
+// method with the given status. Returns the given trace, and a boolean
+// representing whether or not the call was successful.
+//
+// A status is distinct from whether or not the trace is errored. For example, a
+// request which failed because the client canceled it can be given the status
+// [StatusCanceled], so that it can be excluded from searches for genuine
+// errors, e.g. via the filter query "err:true -status:canceled". The status of
+// a finished trace can't be changed.
+func SetStatus(tr Trace, status string) (Trace, bool) {
+	s, ok := optional[interface{ SetStatus(string) }](tr)
+	if !ok {
+		return tr, false
+	}
+	s.SetStatus(status)
+	return tr, true
+}
+
+// Status returns the status of the trace, if any, by checking if the trace
+// implements the method Status() string.
+func Status(tr Trace) string {
+	if s, ok := optional[interface{ Status() string }](tr); ok {
+		return s.Status()
+	}
+	return ""
+}
+
+// ContextStatus returns the status which describes the error of a done
+// context, i.e. [StatusCanceled] for [context.Canceled], and [StatusTimeout]
+// for [context.DeadlineExceeded]. It returns the empty string for other
+// errors, including nil.
+func ContextStatus(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusTimeout
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,63 @@
+package trc_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewCollector(trc.CollectorConfig{
+		Decorators: []trc.DecoratorFunc{trc.LogDecorator(io.Discard)},
+		Metadata:   trc.Metadata{"region": "eu"},
+	})
+
+	for _, status := range []string{"", trc.StatusCanceled, trc.StatusTimeout} {
+		_, tr := collector.NewTrace(ctx, "api")
+		tr.Errorf("failed")
+		_, ok := trc.SetStatus(tr, status)
+		AssertEqual(t, true, ok)
+		AssertEqual(t, status, trc.Status(tr))
+		tr.Finish()
+		trc.SetStatus(tr, "ignored")
+		AssertEqual(t, status, trc.Status(tr))
+	}
+
+	for query, want := range map[string]int{
+		"err:true":                       3,
+		"err:true -status:canceled":      2,
+		"status:canceled":                1,
+		"status:canceled status:timeout": 2,
+	} {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: query}})
+		AssertNoError(t, err)
+		AssertEqual(t, want, res.MatchCount)
+		for _, st := range res.Traces {
+			AssertEqual(t, st.Status(), st.TraceStatus)
+		}
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Statuses: []string{""}}})
+	AssertNoError(t, err)
+	AssertEqual(t, 3, res.MatchCount) // empty statuses are ignored
+}
+
+func TestContextStatus(t *testing.T) {
+	t.Parallel()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	AssertEqual(t, trc.StatusCanceled, trc.ContextStatus(canceled.Err()))
+	AssertEqual(t, trc.StatusTimeout, trc.ContextStatus(expired.Err()))
+	AssertEqual(t, trc.StatusCanceled, trc.ContextStatus(fmt.Errorf("read: %w", context.Canceled)))
+	AssertEqual(t, "", trc.ContextStatus(nil))
+}
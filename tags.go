@@ -0,0 +1,64 @@
+package trc
+
+import (
+	"context"
+	"strings"
+)
+
+// Tags are key/value pairs which describe a specific trace, e.g. the route of
+// an HTTP request, or the tenant which made it. Unlike the category, tags can
+// carry several dimensions, each of which can be searched independently, see
+// [Filter]. Tags are assigned when a trace is created, via [WithTags], and
+// must not be modified.
+type Tags map[string]string
+
+// String returns the tags as space-separated key=value pairs, sorted by key.
+func (t Tags) String() string {
+	return Metadata(t).String()
+}
+
+// Match returns true if the tags satisfy the given condition, which is either
+// key=value, meaning the tag must have the value, or key, meaning the tag must
+// be present with any value.
+func (t Tags) Match(cond string) bool {
+	key, value, hasValue := strings.Cut(cond, "=")
+	have, ok := t[key]
+	return ok && (!hasValue || have == value)
+}
+
+type tagsContextKey struct{}
+
+// WithTags returns a context containing the given tags, in addition to any tags
+// already in the context, which are overwritten if they have the same key.
+// Traces created with the returned context, e.g. via [New] or
+// [Collector.NewTrace], are assigned the tags, which are included in search and
+// stream results.
+func WithTags(ctx context.Context, tags Tags) context.Context {
+	if len(tags) <= 0 {
+		return ctx
+	}
+	existing := TagsFromContext(ctx)
+	merged := make(Tags, len(existing)+len(tags))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, tagsContextKey{}, merged)
+}
+
+// TagsFromContext returns the tags in the context, set via [WithTags], if any.
+func TagsFromContext(ctx context.Context) Tags {
+	tags, _ := ctx.Value(tagsContextKey{}).(Tags)
+	return tags
+}
+
+// TraceTags returns the tags of the trace, if any, by checking if the trace
+// implements the method Tags() Tags.
+func TraceTags(tr Trace) Tags {
+	if t, ok := optional[interface{ Tags() Tags }](tr); ok {
+		return t.Tags()
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package trc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewCollector(trc.CollectorConfig{
+		Decorators: []trc.DecoratorFunc{trc.LogDecorator(io.Discard)},
+		Metadata:   trc.Metadata{"region": "eu"},
+	})
+
+	for _, tags := range []trc.Tags{
+		nil,
+		{"route": "/api", "tenant": "a"},
+		{"route": "/api", "tenant": "b"},
+		{"route": "/health"},
+	} {
+		_, tr := collector.NewTrace(trc.WithTags(ctx, tags), "http")
+		AssertEqual(t, tags.String(), trc.TraceTags(tr).String())
+		tr.Finish()
+	}
+
+	for query, want := range map[string]int{
+		"tag:route":                   3,
+		"tag:route=/api":              2,
+		"tag:route=/api tag:tenant=b": 1,
+		"tag:tenant=c":                0,
+	} {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{Query: query}})
+		AssertNoError(t, err)
+		AssertEqual(t, want, res.MatchCount)
+		for _, st := range res.Traces {
+			AssertEqual(t, st.Tags().String(), st.TraceTags.String())
+		}
+	}
+
+	merged := trc.WithTags(trc.WithTags(ctx, trc.Tags{"a": "1", "b": "2"}), trc.Tags{"b": "3"})
+	AssertEqual(t, "a=1 b=3", trc.TagsFromContext(merged).String())
+}
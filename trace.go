@@ -24,9 +24,42 @@ import (
 // callers to modify the maximum number of events that will be stored in the
 // trace. This method, if it exists, is called by [SetMaxEvents].
 //
+// Trace implementations may optionally implement SetFoldEvents(bool), to allow
+// callers to fold consecutive identical events into a single event with a
+// repeat count. This method, if it exists, is called by [SetFoldEvents].
+//
+// Trace implementations may optionally implement SetMaxBytes(int), to allow
+// callers to limit the total size of the event text stored in the trace. This
+// method, if it exists, is called by [SetMaxBytes].
+//
+// Trace implementations may optionally implement SetEventRewriter(func(string)
+// string), to allow callers to rewrite the text of events before they're
+// stored, e.g. to redact secrets. This method, if it exists, is called by
+// [SetEventRewriter].
+//
 // Trace implementations may optionally implement Free(), to release any
 // resources claimed by the trace to an e.g. [sync.Pool]. This method, if it
 // exists, is called by the [Collector] when a trace is dropped.
+//
+// Trace implementations may optionally implement MergeEvents([]Event), to add
+// events which occurred elsewhere, e.g. in a [Child] trace, interleaved with
+// existing events by timestamp. If an implementation doesn't have this method,
+// merged events are added as normal events, in the order they're provided.
+//
+// Trace implementations may optionally implement SetStatus(string) and Status()
+// string, to record how the trace finished, e.g. canceled, in more detail than
+// whether or not it errored. These methods, if they exist, are called by
+// [SetStatus] and [Status].
+//
+// Trace implementations may optionally implement Debugf(string, ...any) and
+// Warnf(string, ...any), to add events with those levels, and SetMinLevel(Level),
+// to drop events below a minimum level. These methods, if they exist, are
+// called by [Debugf], [Warnf], and [SetMinLevel].
+//
+// Trace implementations which wrap another trace, e.g. decorators, may
+// optionally implement Unwrap() Trace, returning the wrapped trace. Helpers
+// which call the optional methods above check the wrapped traces in turn, so
+// wrappers don't need to forward those methods. See [Unwrap].
 type Trace interface {
 	// ID returns an identifier for the trace which should be automatically
 	// generated during construction, and should be unique within a given
@@ -87,13 +120,79 @@ type Trace interface {
 	Events() []Event
 }
 
+// Unwrap returns the trace wrapped by tr, by checking if tr implements the
+// method Unwrap() Trace. If it doesn't, Unwrap returns nil.
+func Unwrap(tr Trace) Trace {
+	if u, ok := tr.(interface{ Unwrap() Trace }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+// optional returns the first trace in the chain of tr and the traces it wraps,
+// see [Unwrap], which implements T, typically an interface with one or more
+// optional methods.
+func optional[T any](tr Trace) (T, bool) {
+	for tr != nil {
+		if t, ok := tr.(T); ok {
+			return t, true
+		}
+		tr = Unwrap(tr)
+	}
+	var zero T
+	return zero, false
+}
+
 // Event is a traced event, similar to a log event, which is created in the
 // context of a specific trace, via methods like Tracef.
+//
+// Offset is the time between the start of the trace and the event, computed
+// when the event is snapshotted. It's measured with the monotonic clock, if
+// available, so, unlike the difference between two When values, it's not
+// affected by wall clock adjustments, e.g. NTP steps.
+//
+// Repeat is the number of consecutive identical events which have been folded
+// into this event, including the event itself, if event folding is enabled for
+// the trace, see [SetFoldEvents]. It's zero for events which weren't folded.
+//
+// ErrorType is the classification of the first error argument of an error
+// event, see [ErrorType]. It's empty for other events.
+//
+// Level is the level of events added via e.g. [Debugf] or [Warnf]. It's empty
+// for normal and error events, see [Event.EventLevel].
 type Event struct {
-	When    time.Time `json:"when"`
-	What    string    `json:"what"`
-	Stack   []Frame   `json:"stack,omitempty"`
-	IsError bool      `json:"is_error,omitempty"`
+	When      time.Time     `json:"when"`
+	Offset    time.Duration `json:"offset,omitempty"`
+	What      string        `json:"what"`
+	Stack     []Frame       `json:"stack,omitempty"`
+	IsError   bool          `json:"is_error,omitempty"`
+	ErrorType string        `json:"error_type,omitempty"`
+	Repeat    int           `json:"repeat,omitempty"`
+	Level     Level         `json:"level,omitempty"`
+}
+
+// eventsBytes returns the approximate size of the events in bytes, i.e. the sum
+// of the lengths of their text and the strings in their stacks.
+func eventsBytes(events []Event) int {
+	var n int
+	for _, ev := range events {
+		n += len(ev.What)
+		for _, fr := range ev.Stack {
+			n += len(fr.Function) + len(fr.FileLine)
+		}
+	}
+	return n
+}
+
+// OffsetFrom returns the offset of the event from start, which should be the
+// start time of the trace containing the event. It returns Offset, if it's set,
+// or else the difference between When and start, e.g. for events decoded from
+// older versions of this package.
+func (ev Event) OffsetFrom(start time.Time) time.Duration {
+	if ev.Offset != 0 {
+		return ev.Offset
+	}
+	return ev.When.Sub(start)
 }
 
 // Frame is a single call frame in an event's call stack.
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/oklog/ulid/v2"
 	"github.com/peterbourgon/trc/internal/trcdebug"
@@ -46,6 +47,12 @@ func SetTraceMaxEvents(n int) {
 	traceMaxEvents.Store(int32(n))
 }
 
+// TraceMaxEvents returns the max number of events stored in a core trace, see
+// [SetTraceMaxEvents].
+func TraceMaxEvents() int {
+	return int(traceMaxEvents.Load())
+}
+
 var traceNoStacks atomic.Bool
 
 // SetTraceStacks sets a boolean that determines whether trace events include
@@ -59,29 +66,122 @@ func SetTraceStacks(enable bool) {
 	traceNoStacks.Store(!enable)
 }
 
+// TraceStacks returns true if trace events include stack traces, see
+// [SetTraceStacks].
+func TraceStacks() bool {
+	return !traceNoStacks.Load()
+}
+
 //
 //
 //
 
+var traceIDGenerator atomic.Pointer[func(time.Time) string]
+
+// SetTraceIDGenerator sets a function which produces the IDs of new core
+// traces, given their start time. It can be used to e.g. embed a shard or
+// source prefix in trace IDs, or to produce shorter IDs. IDs should be unique,
+// at least within a collector. If gen is nil, or returns an empty string, the
+// default ULID is used.
+//
+// Changing this value does not affect traces that have already been created.
+func SetTraceIDGenerator(gen func(started time.Time) string) {
+	if gen == nil {
+		traceIDGenerator.Store(nil)
+		return
+	}
+	traceIDGenerator.Store(&gen)
+}
+
+// NewTraceID returns a new ID for a trace which started at the given time, via
+// the generator set via SetTraceIDGenerator, if any, or a ULID otherwise. It's
+// meant for traces which aren't created by this package, e.g. traces decoded
+// from external sources, which should have the same kind of IDs as core
+// traces. It returns an error if a ULID can't represent the time, because it's
+// before the Unix epoch, or too far in the future.
+func NewTraceID(started time.Time) (string, error) {
+	started = started.UTC()
+	if gen := traceIDGenerator.Load(); gen != nil {
+		if id := (*gen)(started); id != "" {
+			return id, nil
+		}
+	}
+	if started.Before(time.Unix(0, 0)) {
+		return "", fmt.Errorf("time %s is before the Unix epoch", started.Format(time.RFC3339))
+	}
+	id, err := ulid.New(ulid.Timestamp(started), traceIDEntropy)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// DisplayID returns a short form of the given trace ID, suitable for display
+// in tables and UIs, where the full ID is unwieldy. IDs longer than 12
+// characters are shortened to their final 8 characters, which, for ULIDs, are
+// the most random. Filters match display IDs as well as full IDs.
+//
+// Display IDs aren't guaranteed to be unique.
+func DisplayID(id string) string {
+	if len(id) <= displayIDMaxLength {
+		return id
+	}
+	return id[len(id)-displayIDLength:]
+}
+
+const (
+	displayIDMaxLength = 12
+	displayIDLength    = 8
+)
+
+// isDisplayIDOf returns true if s is the display ID of the given full ID.
+func isDisplayIDOf(s, id string) bool {
+	return len(s) == displayIDLength && len(id) > displayIDMaxLength && strings.HasSuffix(id, s)
+}
+
 var traceIDEntropy = ulid.DefaultEntropy()
 
 // coreTrace is the default, mutable implementation of a trace. Trace IDs are
-// ULIDs, using a default monotonic source of entropy. The maximum number of
+// ULIDs, using a default monotonic source of entropy, unless a generator is set
+// via SetTraceIDGenerator. The maximum number of
 // events that can be stored in a trace is set when the trace is created, based
 // on the current value of TraceMaxEvents.
+//
+// Events are allocated from chunks owned by the trace, which are recycled with
+// the trace, rather than individually. Chunks grow geometrically, so traces with
+// few events stay small. The events slice orders the allocated events by time.
 type coreTrace struct {
 	mtx         sync.Mutex
+	clock       Clock
 	source      string
 	id          ulid.ULID
+	customID    string
+	correlation string
+	tags        Tags
 	category    string
-	start       time.Time
+	start       time.Time // UTC, for display
+	startmono   time.Time // with a monotonic clock reading, if available
 	errored     bool
 	finished    bool
+	status      string
+	errortypes  []string // distinct, see ErrorType
 	duration    time.Duration
 	nostackflag uint8
 	events      []*coreEvent
+	chunks      []coreEventChunk
+	chunksused  int            // number of events allocated from chunks
+	chunknext   int            // index of the next chunk to allocate from
+	chunkfree   coreEventChunk // unused events in the current chunk
 	eventsmax   int
+	bytes       int // approximate size of event text, only tracked if bytesmax > 0
+	bytesmax    int // max bytes of event text, or 0 for no limit
 	truncated   int
+	fold        bool                // fold consecutive identical events
+	rewrite     func(string) string // applied to event text before storage
+	minlevel    Level               // events below this level are dropped
+	hook        finishHook          // called once, when the trace is finished
+	hooktr      Trace               // passed to the hook, see setFinishHook
+	hooking     bool                // the hook is running, so Free must not recycle
 }
 
 var _ Trace = (*coreTrace)(nil)
@@ -90,7 +190,26 @@ var _ Trace = (*coreTrace)(nil)
 // it into the given context. It returns a new context containing that trace,
 // and the trace itself.
 func New(ctx context.Context, source, category string, decorators ...DecoratorFunc) (context.Context, Trace) {
-	tr := Trace(newCoreTrace(source, category))
+	return newWithClock(getClock(), ctx, source, category, decorators...)
+}
+
+// NewWithClock returns a [NewTraceFunc] which behaves like [New], except that
+// the produced traces use the given clock, rather than the package clock set
+// via [SetClock].
+func NewWithClock(c Clock) NewTraceFunc {
+	if c == nil {
+		return New
+	}
+	return func(ctx context.Context, source, category string, decorators ...DecoratorFunc) (context.Context, Trace) {
+		return newWithClock(c, ctx, source, category, decorators...)
+	}
+}
+
+func newWithClock(c Clock, ctx context.Context, source, category string, decorators ...DecoratorFunc) (context.Context, Trace) {
+	core := newCoreTrace(c, source, category)
+	core.correlation = CorrelationIDFromContext(ctx)
+	core.tags = TagsFromContext(ctx)
+	tr := Trace(core)
 	for _, d := range decorators {
 		tr = d(tr)
 	}
@@ -108,22 +227,46 @@ var coreTracePool = sync.Pool{
 	},
 }
 
-// newCoreTrace starts a new trace with the given source and category.
-func newCoreTrace(source, category string) *coreTrace {
+// newCoreTrace starts a new trace with the given clock, source, and category.
+func newCoreTrace(clock Clock, source, category string) *coreTrace {
 	trcdebug.CoreTraceNewCount.Add(1)
-	now := time.Now().UTC()
+	mono := clock.Now()
+	now := mono.UTC() // strips the monotonic clock reading
 	tr := coreTracePool.Get().(*coreTrace)
-	tr.id = ulid.MustNew(ulid.Timestamp(now), traceIDEntropy) // defer String computation
+	tr.clock = clock
+	tr.customID = ""
+	if gen := traceIDGenerator.Load(); gen != nil {
+		tr.customID = (*gen)(now)
+	}
+	if tr.customID == "" {
+		tr.id = ulid.MustNew(ulid.Timestamp(now), traceIDEntropy) // defer String computation
+	}
+	tr.correlation = ""
+	tr.tags = nil
 	tr.source = source
 	tr.category = category
 	tr.start = now
+	tr.startmono = mono
 	tr.errored = false
 	tr.finished = false
+	tr.status = ""
+	tr.errortypes = nil
 	tr.duration = 0
 	tr.nostackflag = iff(traceNoStacks.Load(), flagNoStack, uint8(0))
 	tr.events = tr.events[:0]
+	tr.chunksused = 0
+	tr.chunknext = 0
+	tr.chunkfree = nil
 	tr.eventsmax = int(traceMaxEvents.Load())
+	tr.bytes = 0
+	tr.bytesmax = 0
 	tr.truncated = 0
+	tr.fold = false
+	tr.rewrite = nil
+	tr.minlevel = ""
+	tr.hook = nil
+	tr.hooktr = nil
+	tr.hooking = false
 	return tr
 }
 
@@ -135,9 +278,20 @@ func iff[T any](cond bool, yes, no T) T {
 }
 
 func (tr *coreTrace) ID() string {
+	if tr.customID != "" {
+		return tr.customID // immutable
+	}
 	return tr.id.String() // immutable
 }
 
+func (tr *coreTrace) CorrelationID() string {
+	return tr.correlation // immutable
+}
+
+func (tr *coreTrace) Tags() Tags {
+	return tr.tags // immutable
+}
+
 func (tr *coreTrace) Source() string {
 	return tr.source // immutable
 }
@@ -158,22 +312,28 @@ func (tr *coreTrace) Duration() time.Duration {
 		return tr.duration
 	}
 
-	return time.Since(tr.start)
+	return clockSince(tr.clock, tr.startmono)
 }
 
 func (tr *coreTrace) Tracef(format string, args ...any) {
 	tr.mtx.Lock()
 	defer tr.mtx.Unlock()
 
-	if tr.finished {
+	if tr.finished || !tr.minlevel.Allows(LevelInfo) {
 		return
 	}
 
 	switch {
-	case len(tr.events) >= tr.eventsmax:
+	case tr.fold && tr.maybeFold(flagNormal, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
 		tr.truncated++
 	default:
-		tr.events = append(tr.events, newCoreEvent(flagNormal|tr.nostackflag, format, args...))
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagNormal|tr.nostackflag, format, args...)
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
 	}
 }
 
@@ -181,15 +341,21 @@ func (tr *coreTrace) LazyTracef(format string, args ...any) {
 	tr.mtx.Lock()
 	defer tr.mtx.Unlock()
 
-	if tr.finished {
+	if tr.finished || !tr.minlevel.Allows(LevelInfo) {
 		return
 	}
 
 	switch {
-	case len(tr.events) >= tr.eventsmax:
+	case tr.fold && tr.maybeFold(flagLazy, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
 		tr.truncated++
 	default:
-		tr.events = append(tr.events, newCoreEvent(flagLazy|tr.nostackflag, format, args...))
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagLazy|tr.nostackflag, format, args...)
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
 	}
 }
 
@@ -202,12 +368,21 @@ func (tr *coreTrace) Errorf(format string, args ...any) {
 	}
 
 	tr.errored = true
+	errtype := argsErrorType(args)
+	tr.errortypes = appendErrorType(tr.errortypes, errtype)
 
 	switch {
-	case len(tr.events) >= tr.eventsmax:
+	case tr.fold && tr.maybeFold(flagError, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
 		tr.truncated++
 	default:
-		tr.events = append(tr.events, newCoreEvent(flagError|tr.nostackflag, format, args...))
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagError|tr.nostackflag, format, args...)
+		cev.errtype = errtype
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
 	}
 }
 
@@ -220,25 +395,126 @@ func (tr *coreTrace) LazyErrorf(format string, args ...any) {
 	}
 
 	tr.errored = true
+	errtype := argsErrorType(args)
+	tr.errortypes = appendErrorType(tr.errortypes, errtype)
 
 	switch {
-	case len(tr.events) >= tr.eventsmax:
+	case tr.fold && tr.maybeFold(flagLazy|flagError, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
 		tr.truncated++
 	default:
-		tr.events = append(tr.events, newCoreEvent(flagLazy|flagError|tr.nostackflag, format, args...))
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagLazy|flagError|tr.nostackflag, format, args...)
+		cev.errtype = errtype
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
 	}
 }
 
-func (tr *coreTrace) Finish() {
+func (tr *coreTrace) Debugf(format string, args ...any) {
 	tr.mtx.Lock()
 	defer tr.mtx.Unlock()
 
+	if tr.finished || !tr.minlevel.Allows(LevelDebug) {
+		return
+	}
+
+	switch {
+	case tr.fold && tr.maybeFold(flagDebug, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
+		tr.truncated++
+	default:
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagDebug|tr.nostackflag, format, args...)
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
+	}
+}
+
+func (tr *coreTrace) Warnf(format string, args ...any) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.finished || !tr.minlevel.Allows(LevelWarn) {
+		return
+	}
+
+	switch {
+	case tr.fold && tr.maybeFold(flagWarn, format, args):
+		// folded into the previous event
+	case len(tr.events) >= tr.eventsmax, tr.bytesmax > 0 && tr.bytes >= tr.bytesmax:
+		tr.truncated++
+	default:
+		cev := tr.newEvent()
+		cev.reset(tr.clock, flagWarn|tr.nostackflag, format, args...)
+		cev.maybeRewrite(tr.rewrite)
+		tr.maybeLimitBytes(cev)
+		tr.events = append(tr.events, cev)
+	}
+}
+
+func (tr *coreTrace) Finish() {
+	tr.mtx.Lock()
+
 	if tr.finished {
+		tr.mtx.Unlock()
 		return
 	}
 
 	tr.finished = true
-	tr.duration = time.Since(tr.start)
+	tr.duration = clockSince(tr.clock, tr.startmono)
+	hook, hooktr := tr.hook, tr.hooktr
+	tr.hooking = hook != nil
+	tr.mtx.Unlock()
+
+	if hook != nil {
+		hook.traceFinished(hooktr)
+
+		tr.mtx.Lock()
+		tr.hooking = false
+		tr.mtx.Unlock()
+	}
+}
+
+// finishHook is called once, when a core trace is finished, with the trace that
+// was given to setFinishHook, typically a decorated trace which wraps the core
+// trace. The core trace isn't free'd while the hook is running.
+type finishHook interface {
+	traceFinished(tr Trace)
+}
+
+// setFinishHook sets the finish hook of the core trace wrapped by tr, if there
+// is one, see [Unwrap]. It returns false if tr doesn't wrap a core trace, or if
+// the core trace is already finished, or already has a hook, in which case the
+// caller should call the hook itself.
+func setFinishHook(tr Trace, hook finishHook) bool {
+	core, ok := optional[*coreTrace](tr)
+	if !ok {
+		return false
+	}
+
+	core.mtx.Lock()
+	defer core.mtx.Unlock()
+
+	if core.finished || core.hook != nil {
+		return false
+	}
+
+	core.hook, core.hooktr = hook, tr
+	return true
+}
+
+// traceClock returns the clock of the core trace wrapped by tr, if there is
+// one, see [Unwrap], or else the package clock set via [SetClock].
+func traceClock(tr Trace) Clock {
+	if core, ok := optional[*coreTrace](tr); ok && core.clock != nil {
+		return core.clock // immutable
+	}
+	return getClock()
 }
 
 func (tr *coreTrace) Finished() bool {
@@ -255,6 +531,31 @@ func (tr *coreTrace) Errored() bool {
 	return tr.errored
 }
 
+func (tr *coreTrace) ErrorTypes() []string {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.errortypes[:len(tr.errortypes):len(tr.errortypes)] // append-only
+}
+
+func (tr *coreTrace) SetStatus(status string) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.finished {
+		return
+	}
+
+	tr.status = status
+}
+
+func (tr *coreTrace) Status() string {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	return tr.status
+}
+
 func (tr *coreTrace) Events() []Event {
 	return tr.EventsDetail(-1, true)
 }
@@ -268,16 +569,20 @@ func (tr *coreTrace) EventsDetail(n int, stacks bool) []Event {
 	}
 
 	latest := tr.events[len(tr.events)-n:]
-	events := snapshotEvents(latest, stacks)
+	events := snapshotEvents(latest, tr.startmono, stacks)
 
 	if tr.truncated > 0 {
+		now := tr.clock.Now()
 		events = append(events, Event{
-			When:    time.Now().UTC(),
+			When:    now.UTC(),
+			Offset:  now.Sub(tr.startmono),
 			What:    fmt.Sprintf("(truncated event count %d)", tr.truncated),
 			Stack:   nil,
 			IsError: false,
 		})
-		events = events[1:]
+		if len(tr.events) >= tr.eventsmax {
+			events = events[1:] // keep the total at the max, rather than max+1
+		}
 	}
 
 	return events
@@ -285,6 +590,52 @@ func (tr *coreTrace) EventsDetail(n int, stacks bool) []Event {
 
 //
 
+func (tr *coreTrace) MergeEvents(events []Event) {
+	if len(events) <= 0 {
+		return
+	}
+
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	if tr.finished {
+		return
+	}
+
+	// Events beyond the max are counted as truncated as they're reached, so
+	// that only the events which are kept are allocated.
+	merged := make([]*coreEvent, 0, min(len(tr.events)+len(events), tr.eventsmax))
+	var i, j int
+	for i < len(tr.events) || j < len(events) {
+		switch {
+		case j >= len(events) || (i < len(tr.events) && !tr.events[i].when.After(events[j].When)):
+			if len(merged) < tr.eventsmax {
+				merged = append(merged, tr.events[i])
+			} else {
+				tr.truncated++ // dropped events are released with the trace
+			}
+			i++
+		case !tr.minlevel.Allows(events[j].EventLevel()):
+			j++ // below the min level
+		default:
+			if len(merged) < tr.eventsmax {
+				cev := tr.newEvent()
+				cev.resetStatic(events[j])
+				cev.maybeRewrite(tr.rewrite)
+				tr.maybeLimitBytes(cev)
+				merged = append(merged, cev)
+			} else {
+				tr.truncated++
+			}
+			tr.errored = tr.errored || events[j].IsError
+			tr.errortypes = appendErrorType(tr.errortypes, events[j].ErrorType)
+			j++
+		}
+	}
+
+	tr.events = merged
+}
+
 func (tr *coreTrace) SetMaxEvents(max int) {
 	tr.mtx.Lock()
 	defer tr.mtx.Unlock()
@@ -299,45 +650,199 @@ func (tr *coreTrace) SetMaxEvents(max int) {
 	}
 }
 
+func (tr *coreTrace) SetFoldEvents(fold bool) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	tr.fold = fold
+}
+
+// maybeFold returns true if an event with the given flags, format, and args
+// would be identical to the most recent event in the trace, in which case the
+// repeat count of that event is incremented instead. It's called with the
+// mutex held.
+//
+// Events are only compared when their format strings are the same, which is
+// cheap, and lazy events with args are never folded, as that would require
+// formatting them.
+func (tr *coreTrace) maybeFold(flags uint8, format string, args []any) bool {
+	if len(tr.events) <= 0 {
+		return false
+	}
+
+	last := tr.events[len(tr.events)-1]
+	if last.format == "" || last.format != format || last.lazy || last.iserr != (flags&flagError != 0) || last.level != flagLevel(flags) {
+		return false
+	}
+
+	switch {
+	case isStaticFormat(format, args):
+		// same static text
+	case flags&flagLazy != 0:
+		return false
+	case tr.rewrite != nil && tr.rewrite(safeSprintf(format, args...)) != last.text:
+		return false
+	case tr.rewrite == nil && safeSprintf(format, args...) != last.text:
+		return false
+	}
+
+	last.repeat = iff(last.repeat > 0, last.repeat, 1) + 1
+	return true
+}
+
+func (tr *coreTrace) SetMaxBytes(max int) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	switch {
+	case max <= 0:
+		tr.bytesmax = 0
+	case max < traceMaxBytesMin:
+		tr.bytesmax = traceMaxBytesMin
+	default:
+		tr.bytesmax = max
+	}
+}
+
+// traceMaxBytesMin is the smallest byte limit that can be set on a core trace.
+const traceMaxBytesMin = 1024
+
+// maybeLimitBytes accounts for the text of the event, if the trace has a byte
+// limit, truncating the text if it would exceed the limit. Lazy events are
+// formatted immediately, so that their size is known. It's called with the
+// mutex held.
+func (tr *coreTrace) maybeLimitBytes(cev *coreEvent) {
+	if tr.bytesmax <= 0 {
+		return
+	}
+
+	text := cev.getWhat()
+	if remaining := tr.bytesmax - tr.bytes; len(text) > remaining {
+		cev.text = fmt.Sprintf("%s... (truncated %d bytes)", truncateUTF8(text, remaining), len(text)-remaining)
+	}
+	tr.bytes += len(cev.text)
+}
+
+func (tr *coreTrace) SetMinLevel(level Level) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	tr.minlevel = level
+}
+
+func (tr *coreTrace) SetEventRewriter(rewrite func(string) string) {
+	tr.mtx.Lock()
+	defer tr.mtx.Unlock()
+
+	tr.rewrite = rewrite
+}
+
 func (tr *coreTrace) Free() {
 	tr.mtx.Lock()
 	defer tr.mtx.Unlock()
 
-	if !tr.finished { // presumably still in use by caller(s)
+	if !tr.finished || tr.hooking { // presumably still in use by caller(s)
 		trcdebug.CoreTraceLostCount.Add(1)
 		return // can't recycle, will be GC'd
 	}
 
-	for _, ev := range tr.events {
-		ev.free() // TODO: these individual frees can show up in profiles, maybe pre-allocate?
-	}
-	tr.events = tr.events[:0]
+	tr.resetEvents()
+	tr.hook = nil
+	tr.hooktr = nil // don't pin the decorated trace in the pool
 
 	trcdebug.CoreTraceFreeCount.Add(1)
 	coreTracePool.Put(tr)
 }
 
+// newEvent returns the next unused event from the chunks of the trace,
+// allocating a new chunk if necessary. It's called with the mutex held.
+func (tr *coreTrace) newEvent() *coreEvent {
+	if len(tr.chunkfree) == 0 {
+		if tr.chunknext >= len(tr.chunks) {
+			trcdebug.CoreEventAllocCount.Add(1)
+			tr.chunks = append(tr.chunks, make(coreEventChunk, coreEventChunkSize(tr.chunknext)))
+		}
+		tr.chunkfree = tr.chunks[tr.chunknext]
+		tr.chunknext++
+	}
+	cev := &tr.chunkfree[0]
+	tr.chunkfree = tr.chunkfree[1:]
+	tr.chunksused++
+	trcdebug.CoreEventNewCount.Add(1)
+	return cev
+}
+
+// resetEvents releases every event in the trace, clearing references to their
+// args so that they can be GC'd. Chunks are retained for reuse, up to a limit,
+// so that a trace which once had many events doesn't pin them in the pool.
+func (tr *coreTrace) resetEvents() {
+	used := tr.chunksused
+	for _, chunk := range tr.chunks[:tr.chunknext] {
+		n := min(used, len(chunk))
+		for i := range chunk[:n] {
+			chunk[i].clear()
+		}
+		used -= n
+	}
+	trcdebug.CoreEventFreeCount.Add(uint64(tr.chunksused))
+
+	if len(tr.chunks) > coreEventChunksRetained {
+		for i := coreEventChunksRetained; i < len(tr.chunks); i++ {
+			tr.chunks[i] = nil
+		}
+		tr.chunks = tr.chunks[:coreEventChunksRetained]
+	}
+
+	tr.events = tr.events[:0]
+	tr.chunksused = 0
+	tr.chunknext = 0
+	tr.chunkfree = nil
+	tr.bytes = 0
+}
+
 //
 //
 //
 
-var coreEventPool = sync.Pool{
-	New: func() any {
-		trcdebug.CoreEventAllocCount.Add(1)
-		return &coreEvent{}
-	},
+// The first chunk allocated by a core trace has coreEventChunkMinSize events,
+// and each subsequent chunk is twice as large as the previous one, up to
+// coreEventChunkMaxSize events. coreEventChunksRetained is the max number of
+// chunks which are retained by a core trace when it's free'd.
+const (
+	coreEventChunkMinSize   = 4
+	coreEventChunkMaxSize   = 32
+	coreEventChunksRetained = 4
+)
+
+type coreEventChunk []coreEvent
+
+// coreEventChunkSize returns the number of events in the i'th chunk of a trace.
+func coreEventChunkSize(i int) int {
+	size := coreEventChunkMinSize
+	for ; i > 0 && size < coreEventChunkMaxSize; i-- {
+		size *= 2
+	}
+	return min(size, coreEventChunkMaxSize)
 }
 
 // coreEvent must exist in the context of a single parent core trace, and must
 // not be retained beyond the lifetime of that parent trace, especially after
-// the parent trace is free'd. It is not safe for concurrent use.
+// the parent trace is free'd. It is not safe for concurrent use, and is only
+// accessed with the mutex of the parent trace held.
 type coreEvent struct {
-	when  time.Time
-	what  *stringer
-	pc    [8]uintptr
-	pcn   int
-	stack []Frame
-	iserr bool
+	when    time.Time
+	text    string // static or formatted text, unless lazy
+	fmt     string // format string, if lazy
+	args    []any  // format args, if lazy
+	lazy    bool   // text must be formatted from fmt and args on first use
+	format  string // original format string, for folding
+	repeat  int    // count of folded events, if folded
+	pc      [8]uintptr
+	pcn     int
+	stack   []Frame
+	iserr   bool
+	errtype string // see ErrorType, only for error events
+	level   Level  // empty for normal and error events
 }
 
 const (
@@ -345,21 +850,39 @@ const (
 	flagLazy    = 0b0000_0001
 	flagError   = 0b0000_0010
 	flagNoStack = 0b0000_0100
+	flagDebug   = 0b0000_1000
+	flagWarn    = 0b0001_0000
 )
 
-func newCoreEvent(flags uint8, format string, args ...any) *coreEvent {
-	trcdebug.CoreEventNewCount.Add(1)
-
-	cev := coreEventPool.Get().(*coreEvent)
+// flagLevel returns the level of an event with the given flags, or the empty
+// level for normal and error events.
+func flagLevel(flags uint8) Level {
+	switch {
+	case flags&flagDebug != 0:
+		return LevelDebug
+	case flags&flagWarn != 0:
+		return LevelWarn
+	default:
+		return ""
+	}
+}
 
-	cev.when = time.Now().UTC()
+// reset initializes the event. It must be called directly by the method which
+// creates the event, e.g. Tracef, so that the call stack is correct.
+func (cev *coreEvent) reset(clock Clock, flags uint8, format string, args ...any) {
+	cev.when = clock.Now() // converted to UTC in snapshotEvents
 
-	if flags&flagLazy != 0 {
-		cev.what = newLazyStringer(format, args...)
-	} else {
-		cev.what = newNormalStringer(format, args...)
+	switch {
+	case isStaticFormat(format, args):
+		cev.text, cev.fmt, cev.args, cev.lazy = format, "", nil, false // fast path: no formatting
+	case flags&flagLazy != 0:
+		cev.text, cev.fmt, cev.args, cev.lazy = "", format, args, true
+	default:
+		cev.text, cev.fmt, cev.args, cev.lazy = safeSprintf(format, args...), "", nil, false
 	}
 
+	cev.format, cev.repeat = format, 0
+
 	cev.stack = cev.stack[:0] // be safe
 
 	if flags&flagNoStack != 0 {
@@ -369,27 +892,65 @@ func newCoreEvent(flags uint8, format string, args ...any) *coreEvent {
 	}
 
 	cev.iserr = flags&flagError != 0
+	cev.errtype = ""
+	cev.level = flagLevel(flags)
+}
 
-	return cev
+// resetStatic initializes the event from an existing event, e.g. one which is
+// merged from a child trace.
+func (cev *coreEvent) resetStatic(ev Event) {
+	cev.when = ev.When
+	cev.text, cev.fmt, cev.args, cev.lazy = ev.What, "", nil, false
+	cev.format, cev.repeat = "", ev.Repeat
+	cev.pcn = 0
+	cev.stack = append(cev.stack[:0], ev.Stack...)
+	cev.iserr = ev.IsError
+	cev.errtype = ev.ErrorType
+	cev.level = ev.Level
 }
 
-func (cev *coreEvent) getStack() []Frame {
-	if cev.pcn <= 0 {
-		return nil
+// isStaticFormat returns true if formatting the format string with the args
+// would produce the format string itself, i.e. if there are no args, and the
+// format string contains no verbs. In that case, formatting can be skipped.
+func isStaticFormat(format string, args []any) bool {
+	return len(args) == 0 && strings.IndexByte(format, '%') < 0
+}
+
+func (cev *coreEvent) getWhat() string {
+	if cev.lazy {
+		cev.text, cev.fmt, cev.args, cev.lazy = safeSprintf(cev.fmt, cev.args...), "", nil, false
 	}
+	return cev.text
+}
 
+// maybeRewrite applies the rewrite function, if it's not nil, to the text of
+// the event. Lazy events are formatted immediately, so that their args, which
+// may contain e.g. secrets, aren't retained.
+func (cev *coreEvent) maybeRewrite(rewrite func(string) string) {
+	if rewrite == nil {
+		return
+	}
+	cev.text = rewrite(cev.getWhat())
+}
+
+func (cev *coreEvent) getStack() []Frame {
 	if len(cev.stack) > 0 {
 		return cev.stack
 	}
 
+	if cev.pcn <= 0 {
+		return nil
+	}
+
 	stdframes := runtime.CallersFrames(cev.pc[:cev.pcn])
 	fr, more := stdframes.Next()
 	for more {
-		if !ignoreStackFrameFunction(fr.Function) {
-			cev.stack = append(cev.stack, Frame{
-				Function: fr.Function,
-				FileLine: fr.File + ":" + strconv.Itoa(fr.Line),
-			})
+		frame := Frame{
+			Function: fr.Function,
+			FileLine: fr.File + ":" + strconv.Itoa(fr.Line),
+		}
+		if !skipStackFrame(frame) {
+			cev.stack = append(cev.stack, frame)
 		}
 		fr, more = stdframes.Next()
 	}
@@ -397,16 +958,21 @@ func (cev *coreEvent) getStack() []Frame {
 	return cev.stack
 }
 
-func (cev *coreEvent) free() {
-	cev.what.free()
-	cev.what = nil
+// clear drops the references held by the event, but keeps the capacity of its
+// stack for reuse.
+func (cev *coreEvent) clear() {
+	cev.text, cev.fmt, cev.args, cev.lazy = "", "", nil, false
+	cev.format, cev.repeat = "", 0
 	cev.pcn = 0
 	cev.stack = cev.stack[:0]
-	trcdebug.CoreEventFreeCount.Add(1)
-	coreEventPool.Put(cev)
+	cev.errtype = ""
+	cev.level = ""
 }
 
-func snapshotEvents(cevs []*coreEvent, stacks bool) []Event {
+// snapshotEvents converts core events to events. Offsets are computed relative
+// to start, which should carry a monotonic clock reading, so that they're not
+// affected by wall clock adjustments.
+func snapshotEvents(cevs []*coreEvent, start time.Time, stacks bool) []Event {
 	res := make([]Event, len(cevs))
 	for i, cev := range cevs {
 		var stack []Frame
@@ -414,96 +980,61 @@ func snapshotEvents(cevs []*coreEvent, stacks bool) []Event {
 			stack = cev.getStack()
 		}
 		res[i] = Event{
-			When:    cev.when,
-			What:    cev.what.String(),
-			Stack:   stack,
-			IsError: cev.iserr,
+			When:      cev.when.UTC(),
+			Offset:    cev.when.Sub(start),
+			What:      cev.getWhat(),
+			Stack:     stack,
+			IsError:   cev.iserr,
+			ErrorType: cev.errtype,
+			Repeat:    cev.repeat,
+			Level:     cev.level,
 		}
 	}
 	return res
 }
 
-func ignoreStackFrameFunction(function string) bool {
-	if !strings.HasPrefix(function, "github.com/peterbourgon/trc") {
-		return false // fast path
-	}
-	if strings.HasSuffix(function, "Tracef") || strings.HasSuffix(function, "Errorf") {
-		return true
-	}
-	if strings.HasPrefix(function, "github.com/peterbourgon/trc.Region") {
-		return true
-	}
-	if strings.HasPrefix(function, "github.com/peterbourgon/trc/eztrc.") {
-		return true
-	}
-	return false
-}
-
 //
 //
 //
 
-var stringerPool = sync.Pool{
-	New: func() any {
-		trcdebug.StringerAllocCount.Add(1)
-		return &stringer{}
-	},
-}
-
-type stringer struct {
-	fmt  string
-	args []any
-	str  atomic.Value
-}
-
-type nullString struct {
-	valid bool
-	value string
-}
-
-var zeroNullString nullString // valid false, value empty
-
-func newNormalStringer(format string, args ...any) *stringer {
-	trcdebug.StringerNewCount.Add(1)
-	z := stringerPool.Get().(*stringer)
-	z.fmt = format
-	z.args = args
-	z.str.Store(nullString{valid: true, value: fmt.Sprintf(z.fmt, z.args...)}) // pre-compute the string
-	return z
-}
+// safeSprintf is fmt.Sprintf, except that a panic during formatting, e.g. from
+// a buggy String method, produces a "formatting panic" string rather than
+// crashing the program. Only such recovered panics are counted. Note that fmt
+// already recovers most panics from String and Error methods, rendering them
+// inline as "%!v(PANIC=...)", without a panic reaching this function.
+func safeSprintf(format string, args ...any) (s string) {
+	defer func() {
+		if x := recover(); x != nil {
+			trcdebug.FormatPanicCount.Add(1)
+			s = fmt.Sprintf("formatting panic: %s (format %q)", describePanic(x), format)
+		}
+	}()
 
-func newLazyStringer(format string, args ...any) *stringer {
-	trcdebug.StringerNewCount.Add(1)
-	z := stringerPool.Get().(*stringer)
-	z.fmt = format
-	z.args = args
-	z.str.Store(zeroNullString) // don't pre-compute the string
-	return z
+	return fmt.Sprintf(format, args...)
 }
 
-func (z *stringer) String() string {
-	// If we already have a valid string, return it.
-	ns := z.str.Load().(nullString)
-	if ns.valid {
-		return ns.value
+// truncateUTF8 returns the longest prefix of s which is at most n bytes, and
+// which doesn't end in the middle of a UTF-8 encoded rune.
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
 	}
-
-	// If we don't, do the formatting work and try to swap it in.
-	ns.valid = true
-	ns.value = fmt.Sprintf(z.fmt, z.args...)
-	if z.str.CompareAndSwap(zeroNullString, ns) {
-		return ns.value
+	if len(s) <= n {
+		return s
 	}
-
-	// If that didn't work, then take the value that snuck in.
-	ns = z.str.Load().(nullString)
-	if !ns.valid {
-		panic(fmt.Errorf("invalid state in pooled stringer"))
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return ns.value
+	return s[:n]
 }
 
-func (z *stringer) free() {
-	trcdebug.StringerFreeCount.Add(1)
-	stringerPool.Put(z)
+// describePanic returns a string representation of a recovered panic value,
+// which may itself panic when formatted, in which case only its type is used.
+func describePanic(x any) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = fmt.Sprintf("%T", x)
+		}
+	}()
+	return fmt.Sprint(x)
 }
@@ -0,0 +1,229 @@
+package trc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// TraceLogFormat is the format used by a [TraceLogger] to write traces.
+type TraceLogFormat string
+
+const (
+	// TraceLogFormatText writes each trace as a human-readable header line,
+	// followed by one indented line per event.
+	TraceLogFormatText TraceLogFormat = "text"
+
+	// TraceLogFormatNDJSON writes each trace as a single line of JSON, in the
+	// same form as a streamed trace, i.e. without stacks.
+	TraceLogFormatNDJSON TraceLogFormat = "ndjson"
+
+	// TraceLogFormatLogfmt writes each trace as a logfmt line, followed by one
+	// logfmt line per event, each including the trace ID.
+	TraceLogFormatLogfmt TraceLogFormat = "logfmt"
+)
+
+// TraceLogger writes finished traces to a destination writer. Unlike
+// [LogDecorator], which logs every individual event as it occurs, the trace
+// logger writes each trace once, when it's finished, in its entirety.
+//
+// Traces are connected to a trace logger via [TraceLogger.Decorator].
+type TraceLogger struct {
+	// Writer is the destination for logged traces. Required.
+	Writer io.Writer
+
+	// Format of logged traces. The default is TraceLogFormatText.
+	Format TraceLogFormat
+
+	// ErrorsOnly, if true, means only errored traces are logged.
+	ErrorsOnly bool
+
+	// MinDuration, if greater than zero, means only traces with at least this
+	// duration are logged. Errored traces are logged regardless of duration.
+	MinDuration time.Duration
+
+	// AfterWrite is called after each trace is written, with the current
+	// writer. It can be used to sync or flush that writer, and may return a
+	// different writer, which will be used for subsequent traces, e.g. to
+	// support log rotation. Returning a nil writer keeps the current writer.
+	// Optional.
+	AfterWrite func(w io.Writer) (io.Writer, error)
+
+	// OnError is called with errors from logging traces which are finished via
+	// [TraceLogger.Decorator], since those errors can't be returned to the
+	// caller of Finish. Optional.
+	OnError func(error)
+
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+// Decorator returns a decorator which logs each decorated trace to the trace
+// logger when that trace is finished.
+func (tl *TraceLogger) Decorator() DecoratorFunc {
+	return func(tr Trace) Trace {
+		return &loggedTrace{Trace: tr, tl: tl}
+	}
+}
+
+// Log the given trace to the trace logger's writer, if it passes the trace
+// logger's filters. The trace should typically be finished.
+func (tl *TraceLogger) Log(tr Trace) error {
+	if !tl.allow(tr) {
+		return nil
+	}
+
+	tl.mtx.Lock()
+	defer tl.mtx.Unlock()
+
+	if tl.Writer == nil {
+		return fmt.Errorf("no writer")
+	}
+
+	tl.buf.Reset()
+	switch tl.Format {
+	case TraceLogFormatNDJSON:
+		writeTraceNDJSON(&tl.buf, tr)
+	case TraceLogFormatLogfmt:
+		writeTraceLogfmt(&tl.buf, tr)
+	default:
+		writeTraceText(&tl.buf, tr)
+	}
+
+	if _, err := tl.buf.WriteTo(tl.Writer); err != nil {
+		return fmt.Errorf("write trace: %w", err)
+	}
+
+	if tl.AfterWrite != nil {
+		w, err := tl.AfterWrite(tl.Writer)
+		if err != nil {
+			return fmt.Errorf("after write: %w", err)
+		}
+		if w != nil {
+			tl.Writer = w
+		}
+	}
+
+	return nil
+}
+
+func (tl *TraceLogger) allow(tr Trace) bool {
+	errored := tr.Errored()
+
+	if tl.ErrorsOnly && !errored {
+		return false
+	}
+
+	if tl.MinDuration > 0 && !errored && tr.Duration() < tl.MinDuration {
+		return false
+	}
+
+	return true
+}
+
+func writeTraceText(buf *bytes.Buffer, tr Trace) {
+	outcome := iff(tr.Errored(), "errored", "success")
+	fmt.Fprintf(buf, "%s %s %s source '%s' category '%s' %s %s\n",
+		tr.Started().Format(time.RFC3339Nano),
+		tr.ID(),
+		iff(tr.Finished(), "finished", "active"),
+		tr.Source(),
+		tr.Category(),
+		outcome,
+		trcutil.HumanizeDuration(tr.Duration()),
+	)
+	var (
+		start = tr.Started()
+		prev  time.Duration
+	)
+	for _, ev := range eventsDetail(tr, 0, false) {
+		offset := ev.OffsetFrom(start)
+		fmt.Fprintf(buf, "    +%-10s %s%s%s\n",
+			trcutil.HumanizeDuration(offset-prev),
+			iff(ev.IsError, "ERROR: ", ""),
+			strings.TrimSuffix(ev.What, "\n"),
+			iff(ev.Repeat > 1, fmt.Sprintf(" (x%d)", ev.Repeat), ""),
+		)
+		prev = offset
+	}
+}
+
+func writeTraceNDJSON(buf *bytes.Buffer, tr Trace) {
+	if err := json.NewEncoder(buf).Encode(NewStreamTrace(tr)); err != nil {
+		buf.Reset()
+		fmt.Fprintf(buf, `{"id":%q,"error":%q}`+"\n", tr.ID(), err.Error())
+	}
+}
+
+func writeTraceLogfmt(buf *bytes.Buffer, tr Trace) {
+	id := tr.ID()
+	fmt.Fprintf(buf, "id=%s source=%s category=%s started=%s duration=%s finished=%t errored=%t\n",
+		id,
+		logfmtValue(tr.Source()),
+		logfmtValue(tr.Category()),
+		tr.Started().Format(time.RFC3339Nano),
+		tr.Duration(),
+		tr.Finished(),
+		tr.Errored(),
+	)
+	for i, ev := range eventsDetail(tr, 0, false) {
+		fmt.Fprintf(buf, "id=%s event=%d when=%s error=%t what=%s%s\n",
+			id,
+			i+1,
+			ev.When.Format(time.RFC3339Nano),
+			ev.IsError,
+			logfmtValue(ev.What),
+			iff(ev.Repeat > 1, fmt.Sprintf(" repeat=%d", ev.Repeat), ""),
+		)
+	}
+}
+
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if strings.ContainsAny(s, " \t\r\n\"=\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//
+//
+//
+
+type loggedTrace struct {
+	Trace
+	tl       *TraceLogger
+	finished atomic.Bool
+}
+
+var _ interface{ Free() } = (*loggedTrace)(nil)
+
+func (ltr *loggedTrace) Unwrap() Trace {
+	return ltr.Trace
+}
+
+func (ltr *loggedTrace) Finish() {
+	if !ltr.finished.CompareAndSwap(false, true) || ltr.Trace.Finished() {
+		return // concurrent calls to Finish must log the trace only once
+	}
+	ltr.Trace.Finish()
+	if err := ltr.tl.Log(ltr.Trace); err != nil && ltr.tl.OnError != nil {
+		ltr.tl.OnError(fmt.Errorf("log trace %s: %w", ltr.Trace.ID(), err))
+	}
+}
+
+func (ltr *loggedTrace) Free() {
+	if f, ok := ltr.Trace.(interface{ Free() }); ok {
+		f.Free()
+	}
+}
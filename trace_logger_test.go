@@ -0,0 +1,143 @@
+package trc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestTraceLogger(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		tl := &trc.TraceLogger{Writer: &buf}
+		_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+		tr.Tracef("hello")
+		tr.Errorf("world")
+		AssertEqual(t, 0, buf.Len()) // nothing until finish
+		tr.Finish()
+		tr.Finish() // only logs once
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		AssertEqual(t, 3, len(lines))
+		AssertEqual(t, true, strings.Contains(lines[0], tr.ID()))
+		AssertEqual(t, true, strings.Contains(lines[0], "errored"))
+		AssertEqual(t, true, strings.HasSuffix(lines[1], "hello"))
+		AssertEqual(t, true, strings.HasSuffix(lines[2], "ERROR: world"))
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		var buf bytes.Buffer
+		tl := &trc.TraceLogger{Writer: &buf, Format: trc.TraceLogFormatNDJSON}
+		_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+		tr.Tracef("hello")
+		tr.Finish()
+
+		var st trc.StaticTrace
+		AssertNoError(t, json.Unmarshal(buf.Bytes(), &st))
+		AssertEqual(t, tr.ID(), st.ID())
+		AssertEqual(t, 1, len(st.Events()))
+		AssertEqual(t, "hello", st.Events()[0].What)
+	})
+
+	t.Run("logfmt", func(t *testing.T) {
+		var buf bytes.Buffer
+		tl := &trc.TraceLogger{Writer: &buf, Format: trc.TraceLogFormatLogfmt}
+		_, tr := trc.New(ctx, "src", "my cat", tl.Decorator())
+		tr.Tracef("a b")
+		tr.Finish()
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		AssertEqual(t, 2, len(lines))
+		AssertEqual(t, true, strings.Contains(lines[0], `category="my cat"`))
+		AssertEqual(t, true, strings.HasSuffix(lines[1], `what="a b"`))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		var buf bytes.Buffer
+		tl := &trc.TraceLogger{Writer: &buf, ErrorsOnly: true}
+		{
+			_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+			tr.Finish()
+		}
+		AssertEqual(t, 0, buf.Len())
+		{
+			_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+			tr.Errorf("fail")
+			tr.Finish()
+		}
+		ExpectNotEqual(t, 0, buf.Len())
+
+		buf.Reset()
+		tl = &trc.TraceLogger{Writer: &buf, MinDuration: time.Hour}
+		{
+			_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+			tr.Finish()
+		}
+		AssertEqual(t, 0, buf.Len())
+	})
+
+	t.Run("after write", func(t *testing.T) {
+		var first, second bytes.Buffer
+		tl := &trc.TraceLogger{
+			Writer: &first,
+			AfterWrite: func(w io.Writer) (io.Writer, error) {
+				return &second, nil // rotate after every write
+			},
+		}
+		for i := 0; i < 2; i++ {
+			_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+			tr.Finish()
+		}
+		AssertEqual(t, 1, strings.Count(first.String(), "\n"))
+		AssertEqual(t, 1, strings.Count(second.String(), "\n"))
+	})
+
+	t.Run("concurrent finish", func(t *testing.T) {
+		var buf bytes.Buffer
+		tl := &trc.TraceLogger{Writer: &buf}
+		_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tr.Finish()
+			}()
+		}
+		wg.Wait()
+
+		AssertEqual(t, 1, strings.Count(buf.String(), tr.ID()))
+	})
+
+	t.Run("on error", func(t *testing.T) {
+		var errs []error
+		tl := &trc.TraceLogger{
+			Writer:  errorWriter{errors.New("disk full")},
+			OnError: func(err error) { errs = append(errs, err) },
+		}
+		_, tr := trc.New(ctx, "src", "cat", tl.Decorator())
+		tr.Finish()
+		tr.Finish()
+
+		AssertEqual(t, 1, len(errs))
+		AssertEqual(t, true, strings.Contains(errs[0].Error(), tr.ID()))
+		AssertEqual(t, true, strings.Contains(errs[0].Error(), "disk full"))
+	})
+}
+
+type errorWriter struct{ err error }
+
+func (w errorWriter) Write([]byte) (int, error) { return 0, w.err }
@@ -8,6 +8,8 @@ import (
 type StaticTrace struct {
 	TraceSource      string        `json:"source"`
 	TraceID          string        `json:"id"`
+	TraceCorrelation string        `json:"correlation_id,omitempty"`
+	TraceTags        Tags          `json:"tags,omitempty"`
 	TraceCategory    string        `json:"category"`
 	TraceStarted     time.Time     `json:"started"`
 	TraceDuration    time.Duration `json:"duration"`
@@ -15,22 +17,36 @@ type StaticTrace struct {
 	TraceDurationSec float64       `json:"duration_sec,omitempty"`
 	TraceFinished    bool          `json:"finished,omitempty"`
 	TraceErrored     bool          `json:"errored,omitempty"`
+	TraceStatus      string        `json:"status,omitempty"`
+	TraceErrorTypes  []string      `json:"error_types,omitempty"`
 	TraceEvents      []Event       `json:"events,omitempty"`
+	TraceMetadata    Metadata      `json:"metadata,omitempty"`
+	TraceOutlier     bool          `json:"outlier,omitempty"`
+	TraceBaselineP99 time.Duration `json:"baseline_p99,omitempty"`
+	TraceBytes       int           `json:"bytes,omitempty"`
+	TracePinned      bool          `json:"pinned,omitempty"`
 }
 
 var _ Trace = (*StaticTrace)(nil) // needs to be passed to Filter.Allow
 
 // NewSearchTrace produces a static trace intended for a search response.
 func NewSearchTrace(tr Trace) *StaticTrace {
+	events := tr.Events()
 	return &StaticTrace{
-		TraceSource:   tr.Source(),
-		TraceID:       tr.ID(),
-		TraceCategory: tr.Category(),
-		TraceStarted:  tr.Started(),
-		TraceDuration: tr.Duration(),
-		TraceFinished: tr.Finished(),
-		TraceErrored:  tr.Errored(),
-		TraceEvents:   tr.Events(),
+		TraceSource:      tr.Source(),
+		TraceID:          tr.ID(),
+		TraceCorrelation: CorrelationID(tr),
+		TraceTags:        TraceTags(tr),
+		TraceCategory:    tr.Category(),
+		TraceStarted:     tr.Started(),
+		TraceDuration:    tr.Duration(),
+		TraceFinished:    tr.Finished(),
+		TraceErrored:     tr.Errored(),
+		TraceStatus:      Status(tr),
+		TraceErrorTypes:  TraceErrorTypes(tr),
+		TraceEvents:      events,
+		TraceMetadata:    traceMetadata(tr),
+		TraceBytes:       eventsBytes(events),
 	}
 }
 
@@ -39,32 +55,15 @@ func NewSearchTrace(tr Trace) *StaticTrace {
 // every event.
 func NewStreamTrace(tr Trace) *StaticTrace {
 	var (
-		isActive          = !tr.Finished()
-		detail, canDetail = tr.(interface{ EventsDetail(int, bool) []Event })
-		events            = []Event{}
+		isActive = !tr.Finished()
+		events   = eventsDetail(tr, iff(isActive, 1, 0), false)
+		duration = tr.Duration()
 	)
-	switch {
-	case canDetail && isActive:
-		events = detail.EventsDetail(1, false)
-	case canDetail && !isActive:
-		events = detail.EventsDetail(-1, false)
-	case !canDetail && isActive:
-		events = tr.Events()
-		events = events[len(events)-1:]
-		for i := range events {
-			events[i].Stack = events[i].Stack[:0]
-		}
-	case !canDetail && !isActive:
-		events = tr.Events()
-		for i := range events {
-			events[i].Stack = events[i].Stack[:0]
-		}
-	}
-
-	duration := tr.Duration()
 	return &StaticTrace{
 		TraceSource:      tr.Source(),
 		TraceID:          tr.ID(),
+		TraceCorrelation: CorrelationID(tr),
+		TraceTags:        TraceTags(tr),
 		TraceCategory:    tr.Category(),
 		TraceStarted:     tr.Started(),
 		TraceDuration:    duration,
@@ -72,13 +71,42 @@ func NewStreamTrace(tr Trace) *StaticTrace {
 		TraceDurationSec: duration.Seconds(),
 		TraceFinished:    tr.Finished(),
 		TraceErrored:     tr.Errored(),
+		TraceStatus:      Status(tr),
+		TraceErrorTypes:  TraceErrorTypes(tr),
 		TraceEvents:      events,
+		TraceMetadata:    traceMetadata(tr),
+	}
+}
+
+// eventsDetail returns the most recent n events of the trace, or every event if
+// n is zero or less, with or without stacks. It calls the optional method
+// EventsDetail(int, bool) []Event of the trace, or of a trace it wraps, if it
+// exists, so that stacks and events which aren't needed aren't copied.
+func eventsDetail(tr Trace, n int, stacks bool) []Event {
+	if d, ok := optional[interface{ EventsDetail(int, bool) []Event }](tr); ok {
+		return d.EventsDetail(n, stacks)
+	}
+	events := tr.Events()
+	if n > 0 && n < len(events) {
+		events = events[len(events)-n:]
+	}
+	if !stacks {
+		for i := range events {
+			events[i].Stack = nil
+		}
 	}
+	return events
 }
 
 // ID implements the Trace interface.
 func (st *StaticTrace) ID() string { return st.TraceID }
 
+// CorrelationID returns the correlation ID of the trace, if any.
+func (st *StaticTrace) CorrelationID() string { return st.TraceCorrelation }
+
+// Tags returns the tags of the trace, if any, see [WithTags].
+func (st *StaticTrace) Tags() Tags { return st.TraceTags }
+
 // Source implements the Trace interface.
 func (st *StaticTrace) Source() string { return st.TraceSource }
 
@@ -109,12 +137,58 @@ func (st *StaticTrace) Finished() bool { return st.TraceFinished }
 // Errored implements the Trace interface.
 func (st *StaticTrace) Errored() bool { return st.TraceErrored }
 
+// Status returns the status of the trace, if any, see [SetStatus].
+func (st *StaticTrace) Status() string { return st.TraceStatus }
+
+// ErrorTypes returns the distinct error types recorded in the trace, if any,
+// see [ErrorType].
+func (st *StaticTrace) ErrorTypes() []string { return st.TraceErrorTypes }
+
 // Duration implements the Trace interface.
 func (st *StaticTrace) Duration() time.Duration { return st.TraceDuration }
 
 // Events implements the Trace interface.
 func (st *StaticTrace) Events() []Event { return st.TraceEvents }
 
+// IsOutlier returns true if the trace was slower than the p99 duration of
+// recently finished traces in its category, when it was selected by a search.
+// See [CollectorConfig.BaselineHalfLife].
+func (st *StaticTrace) IsOutlier() bool { return st.TraceOutlier }
+
+// IsPinned returns true if the trace was pinned in its collector, when it was
+// selected by a search. See [Collector.Pin].
+func (st *StaticTrace) IsPinned() bool { return st.TracePinned }
+
+// BaselineP99 returns the p99 duration of recently finished traces in the
+// category of the trace, when it was selected by a search, or zero if it's
+// unknown.
+func (st *StaticTrace) BaselineP99() time.Duration { return st.TraceBaselineP99 }
+
+// Bytes returns the approximate size of the events of the trace, i.e. the sum
+// of the lengths of their text and stacks, when it was selected by a search.
+// It reflects the complete trace, even if e.g. stacks were later removed from
+// the events.
+func (st *StaticTrace) Bytes() int { return st.TraceBytes }
+
+// Metadata returns the static metadata of the trace, if any.
+func (st *StaticTrace) Metadata() Metadata { return st.TraceMetadata }
+
+// TrimLevels removes every event in the trace which is below the min level. An
+// empty level means "no change".
+func (st *StaticTrace) TrimLevels(min Level) *StaticTrace {
+	if min == "" || min == LevelDebug {
+		return st
+	}
+	events := st.TraceEvents[:0]
+	for _, ev := range st.TraceEvents {
+		if min.Allows(ev.EventLevel()) {
+			events = append(events, ev)
+		}
+	}
+	st.TraceEvents = events
+	return st
+}
+
 // TrimStacks reduces the stacks of every event in the trace based on depth. A
 // depth of 0 means "no change" -- to remove stacks, use a depth of -1.
 func (st *StaticTrace) TrimStacks(depth int) *StaticTrace {
@@ -132,29 +206,3 @@ func (st *StaticTrace) TrimStacks(depth int) *StaticTrace {
 	}
 	return st
 }
-
-//
-//
-//
-
-type staticTracesNewestFirst []*StaticTrace
-
-func (sts staticTracesNewestFirst) Len() int { return len(sts) }
-
-func (sts staticTracesNewestFirst) Swap(i, j int) { sts[i], sts[j] = sts[j], sts[i] }
-
-func (sts staticTracesNewestFirst) Less(i, j int) bool {
-	var (
-		iStarted = sts[i].Started()
-		jStarted = sts[j].Started()
-	)
-	switch {
-	case iStarted.After(jStarted):
-		return true
-	case iStarted.Before(jStarted):
-		return false
-	default:
-		return sts[i].ID() > sts[j].ID()
-	}
-
-}
@@ -29,3 +29,61 @@ func TestStreamTraceDuration(t *testing.T) {
 		t.Errorf("Duration: want %v, have %v", want, have)
 	}
 }
+
+func TestStreamTraceEvents(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		wrap     func(trc.Trace) trc.Trace
+		fallback bool // events are read via Events, rather than EventsDetail
+	}{
+		{"core", func(tr trc.Trace) trc.Trace { return tr }, false},
+		{"unwrap", func(tr trc.Trace) trc.Trace { return &eventsCountingTrace{Trace: tr, unwrap: true} }, false},
+		{"opaque", func(tr trc.Trace) trc.Trace { return &eventsCountingTrace{Trace: tr} }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, tr := trc.New(context.Background(), "source", "category", tc.wrap)
+
+			AssertEqual(t, 0, len(trc.NewStreamTrace(tr).Events()))
+
+			for _, what := range []string{"a", "b", "c"} {
+				tr.Tracef("%s", what)
+			}
+			active := trc.NewStreamTrace(tr).Events()
+			AssertEqual(t, 1, len(active))
+			AssertEqual(t, "c", active[0].What)
+			AssertEqual(t, 0, len(active[0].Stack))
+
+			tr.Finish()
+			finished := trc.NewStreamTrace(tr).Events()
+			AssertEqual(t, 3, len(finished))
+			for _, ev := range finished {
+				AssertEqual(t, 0, len(ev.Stack))
+			}
+
+			if ectr, ok := tr.(*eventsCountingTrace); ok {
+				AssertEqual(t, tc.fallback, ectr.calls > 0)
+			}
+		})
+	}
+}
+
+// eventsCountingTrace counts calls to Events, and optionally implements Unwrap.
+type eventsCountingTrace struct {
+	trc.Trace
+	unwrap bool
+	calls  int
+}
+
+func (ectr *eventsCountingTrace) Unwrap() trc.Trace {
+	if !ectr.unwrap {
+		return nil
+	}
+	return ectr.Trace
+}
+
+func (ectr *eventsCountingTrace) Events() []trc.Event {
+	ectr.calls++
+	return ectr.Trace.Events()
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/peterbourgon/trc"
 )
@@ -220,3 +222,229 @@ func TestTraceContext(t *testing.T) {
 		}(ctx)
 	})
 }
+
+func TestFormattingPanic(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("caught by fmt", func(t *testing.T) {
+		_, tr := trc.New(ctx, "src", "cat")
+		tr.Tracef("value %v", panicStringer{"boom"})
+		tr.LazyTracef("value %v", panicStringer{"boom"})
+		tr.Finish()
+		for _, ev := range tr.Events() {
+			AssertEqual(t, true, strings.Contains(ev.What, "PANIC=String method: boom"))
+		}
+	})
+
+	t.Run("escapes fmt", func(t *testing.T) {
+		_, tr := trc.New(ctx, "src", "cat")
+		tr.Tracef("value %v", recursivePanicStringer{})
+		tr.LazyTracef("value %v", recursivePanicStringer{})
+		tr.Finish()
+		for _, ev := range tr.Events() {
+			AssertEqual(t, true, strings.HasPrefix(ev.What, "formatting panic: "))
+		}
+	})
+}
+
+func TestTraceIDGenerator(t *testing.T) {
+	// Not parallel, because the generator is global.
+
+	ctx := context.Background()
+
+	var n int
+	trc.SetTraceIDGenerator(func(time.Time) string { n++; return fmt.Sprintf("shard1-%d", n) })
+	_, tr1 := trc.New(ctx, "src", "cat")
+	_, tr2 := trc.New(ctx, "src", "cat")
+	id, err := trc.NewTraceID(time.Now())
+	trc.SetTraceIDGenerator(nil)
+	_, tr3 := trc.New(ctx, "src", "cat")
+
+	AssertEqual(t, "shard1-1", tr1.ID())
+	AssertEqual(t, "shard1-2", tr2.ID())
+	AssertEqual(t, 26, len(tr3.ID()))
+	AssertNoError(t, err)
+	AssertEqual(t, "shard1-3", id)
+
+	// Without a generator, external traces get ULIDs, which can't represent
+	// every time.
+	id, err = trc.NewTraceID(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	AssertNoError(t, err)
+	AssertEqual(t, 26, len(id))
+	_, err = trc.NewTraceID(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC))
+	ExpectEqual(t, true, err != nil)
+
+	// Short IDs are displayed in full, longer IDs are shortened.
+	AssertEqual(t, "shard1-1", trc.DisplayID(tr1.ID()))
+	AssertEqual(t, tr3.ID()[18:], trc.DisplayID(tr3.ID()))
+
+	// Filters match display IDs.
+	ExpectEqual(t, true, (&trc.Filter{IDs: []string{trc.DisplayID(tr3.ID())}}).Allow(tr3))
+	ExpectEqual(t, false, (&trc.Filter{IDs: []string{trc.DisplayID(tr3.ID())}}).Allow(tr1))
+	ExpectEqual(t, false, (&trc.Filter{IDs: []string{tr3.ID()[20:]}}).Allow(tr3))
+}
+
+func TestFoldEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("trace", func(t *testing.T) {
+		_, tr := trc.New(ctx, "src", "cat")
+		_, ok := trc.SetFoldEvents(tr, true)
+		AssertEqual(t, true, ok)
+
+		tr.Tracef("start")
+		for i := 0; i < 3; i++ {
+			tr.Tracef("retrying...")
+		}
+		for i := 0; i < 2; i++ {
+			tr.Tracef("attempt %d", i)
+		}
+		tr.Tracef("status %d", 503)
+		tr.Tracef("status %d", 503)
+		tr.Errorf("status %d", 503)
+		tr.LazyTracef("lazy %d", 1)
+		tr.LazyTracef("lazy %d", 1)
+		tr.Finish()
+
+		type result struct {
+			What    string
+			IsError bool
+			Repeat  int
+		}
+		var have []result
+		for _, ev := range tr.Events() {
+			have = append(have, result{ev.What, ev.IsError, ev.Repeat})
+		}
+		want := []result{
+			{"start", false, 0},
+			{"retrying...", false, 3},
+			{"attempt 0", false, 0},
+			{"attempt 1", false, 0},
+			{"status 503", false, 2},
+			{"status 503", true, 0},
+			{"lazy 1", false, 0},
+			{"lazy 1", false, 0},
+		}
+		if !reflect.DeepEqual(want, have) {
+			t.Fatalf("want %+v, have %+v", want, have)
+		}
+	})
+
+	t.Run("max events", func(t *testing.T) {
+		_, tr := trc.New(ctx, "src", "cat")
+		trc.SetMaxEvents(tr, 10)
+		trc.SetFoldEvents(tr, true)
+		for i := 0; i < 100; i++ {
+			tr.Tracef("retrying...")
+		}
+		tr.Finish()
+
+		events := tr.Events()
+		AssertEqual(t, 1, len(events))
+		AssertEqual(t, 100, events[0].Repeat)
+	})
+
+	t.Run("collector", func(t *testing.T) {
+		collector := trc.NewCollector(trc.CollectorConfig{FoldEvents: true})
+		_, tr := collector.NewTrace(ctx, "cat")
+		tr.Tracef("retrying...")
+		tr.Tracef("retrying...")
+		tr.Finish()
+
+		events := tr.Events()
+		AssertEqual(t, 1, len(events))
+		AssertEqual(t, 2, events[0].Repeat)
+	})
+}
+
+func TestMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	collector := trc.NewCollector(trc.CollectorConfig{MaxTraceBytes: 1024})
+	_, tr := collector.NewTrace(ctx, "cat")
+	big := strings.Repeat("x", 600)
+	tr.Tracef("%s", big)
+	tr.LazyTracef("%s", big)
+	tr.Tracef("dropped")
+	tr.Finish()
+
+	events := tr.Events()
+	AssertEqual(t, 3, len(events))
+	AssertEqual(t, big, events[0].What)
+	AssertEqual(t, strings.Repeat("x", 424)+"... (truncated 176 bytes)", events[1].What)
+	AssertEqual(t, "(truncated event count 1)", events[2].What)
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{})
+	AssertNoError(t, err)
+	AssertEqual(t, 1, len(res.Traces))
+	var want int
+	for _, ev := range res.Traces[0].Events() {
+		want += len(ev.What)
+		for _, fr := range ev.Stack {
+			want += len(fr.Function) + len(fr.FileLine)
+		}
+	}
+	AssertEqual(t, want, res.Traces[0].Bytes())
+	AssertEqual(t, want, res.Stats.Categories["cat"].ByteCount)
+}
+
+type panicStringer struct{ msg string }
+
+func (s panicStringer) String() string { panic(s.msg) }
+
+// recursivePanicStringer panics with itself, which fmt can't format while
+// recovering, and so escapes fmt's own recover.
+type recursivePanicStringer struct{}
+
+func (s recursivePanicStringer) String() string { panic(s) }
+
+func TestUnwrap(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = trc.WithTags(trc.WithCorrelationID(context.Background(), "corr"), trc.Tags{"k": "v"})
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Decorators: []trc.DecoratorFunc{func(tr trc.Trace) trc.Trace { return &unwrapTrace{tr} }},
+		})
+	)
+
+	ctx, tr := collector.NewTrace(ctx, "cat")
+	AssertEqual(t, true, trc.Unwrap(tr) != nil)
+	AssertEqual(t, "corr", trc.CorrelationID(tr))
+	AssertEqual(t, "v", trc.TraceTags(tr)["k"])
+
+	_, ok := trc.SetStatus(tr, trc.StatusCanceled)
+	AssertEqual(t, true, ok)
+	AssertEqual(t, trc.StatusCanceled, trc.Status(tr))
+
+	trc.Debugf(tr, "debug")
+	trc.Warnf(tr, "warn")
+	tr.Errorf("read: %v", io.EOF)
+
+	_, child := trc.Child(ctx, "child")
+	child.Tracef("merged")
+	child.Finish()
+	tr.Finish()
+
+	var have []string
+	for _, ev := range tr.Events() {
+		have = append(have, ev.What)
+	}
+	AssertEqual(t, "debug | warn | read: EOF | child merged", strings.Join(have, " | "))
+	AssertEqual(t, trc.LevelDebug, tr.Events()[0].Level)
+	AssertEqual(t, trc.LevelWarn, tr.Events()[1].Level)
+	AssertEqual(t, "[io.EOF]", fmt.Sprint(trc.TraceErrorTypes(tr)))
+
+	AssertEqual(t, nil, trc.Unwrap(&trc.StaticTrace{}))
+}
+
+// unwrapTrace is a minimal user decorator, which only implements Unwrap.
+type unwrapTrace struct{ trc.Trace }
+
+func (utr *unwrapTrace) Unwrap() trc.Trace { return utr.Trace }
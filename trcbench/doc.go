@@ -0,0 +1,6 @@
+// Package trcbench generates reproducible load against a trc.Collector, and
+// reports throughput, allocations, and search latency. It's meant to detect
+// performance regressions, e.g. in CI, by comparing the results of the same
+// configuration before and after a change. Results can be written in the Go
+// benchmark format, so they can be compared with benchstat.
+package trcbench
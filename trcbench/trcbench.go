@@ -0,0 +1,376 @@
+package trcbench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// Config describes the load generated by Run. The zero value is valid, and
+// creates traces without events, in a single category, as fast as possible,
+// for DefaultDuration.
+type Config struct {
+	// Collector receives the generated traces. If not provided, a new
+	// collector with source "trcbench" is used.
+	Collector *trc.Collector
+
+	// Traces, if greater than zero, is the total number of traces to create,
+	// which makes the load identical across runs, and is usually what you
+	// want in CI. Otherwise, traces are created until Duration elapses.
+	Traces int
+
+	// Duration is the max duration of the run. If zero, and Traces is zero,
+	// DefaultDuration is used. If zero, and Traces is greater than zero, the
+	// run lasts until every trace is created.
+	Duration time.Duration
+
+	// Rate, if greater than zero, is the target number of traces created per
+	// second, across all workers. Otherwise, traces are created as fast as
+	// possible.
+	Rate float64
+
+	// Concurrency is the number of goroutines creating traces. If zero,
+	// GOMAXPROCS is used.
+	Concurrency int
+
+	// Categories is the number of distinct categories, which are assigned to
+	// traces round-robin. If zero, a single category is used.
+	Categories int
+
+	// EventsPerTrace is the number of events added to each trace.
+	EventsPerTrace int
+
+	// ErrorRate is the fraction of traces, between 0 and 1, which are errored,
+	// by an additional error event. Errored traces are spread evenly, rather
+	// than chosen randomly, so runs are reproducible.
+	ErrorRate float64
+
+	// Stacks, if true, means events include stack traces, which is the
+	// default outside of this package, and can dominate the cost of events.
+	// It's set via [trc.SetTraceStacks] for the duration of the run, and then
+	// restored, so concurrent runs with different values interfere.
+	Stacks bool
+
+	// CategorySize, if greater than zero, is set as the category size of the
+	// collector before the run.
+	CategorySize int
+
+	// SearchInterval, if greater than zero, is the interval at which the
+	// collector is searched while traces are created, and the collector is
+	// searched once more after the load is finished. Search latency is
+	// reported in the result. If zero, no searches are performed.
+	SearchInterval time.Duration
+
+	// SearchLimit is the limit of each search request. If zero, 10 is used.
+	SearchLimit int
+
+	// InsertBatchSize is used as [trc.CollectorConfig.InsertBatchSize] of the
+	// new collector, if Collector isn't provided, so the throughput of batched
+	// inserts can be compared with individual inserts.
+	InsertBatchSize int
+}
+
+// DefaultDuration is used as the duration of runs which don't specify either
+// a duration or a number of traces.
+const DefaultDuration = 10 * time.Second
+
+func (cfg *Config) initialize() error {
+	switch {
+	case cfg.Traces < 0:
+		return fmt.Errorf("traces (%d) can't be negative", cfg.Traces)
+	case cfg.Duration < 0:
+		return fmt.Errorf("duration (%s) can't be negative", cfg.Duration)
+	case cfg.Rate < 0:
+		return fmt.Errorf("rate (%v) can't be negative", cfg.Rate)
+	case cfg.Concurrency < 0:
+		return fmt.Errorf("concurrency (%d) can't be negative", cfg.Concurrency)
+	case cfg.Categories < 0:
+		return fmt.Errorf("categories (%d) can't be negative", cfg.Categories)
+	case cfg.EventsPerTrace < 0:
+		return fmt.Errorf("events per trace (%d) can't be negative", cfg.EventsPerTrace)
+	case cfg.ErrorRate < 0 || cfg.ErrorRate > 1:
+		return fmt.Errorf("error rate (%v) must be between 0 and 1", cfg.ErrorRate)
+	case cfg.SearchInterval < 0:
+		return fmt.Errorf("search interval (%s) can't be negative", cfg.SearchInterval)
+	}
+
+	if cfg.Collector == nil {
+		cfg.Collector = trc.NewCollector(trc.CollectorConfig{Source: "trcbench", InsertBatchSize: cfg.InsertBatchSize})
+	}
+
+	if cfg.CategorySize > 0 {
+		cfg.Collector.SetCategorySize(cfg.CategorySize)
+	}
+
+	if cfg.Traces == 0 && cfg.Duration == 0 {
+		cfg.Duration = DefaultDuration
+	}
+
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	if cfg.Categories == 0 {
+		cfg.Categories = 1
+	}
+
+	if cfg.SearchLimit <= 0 {
+		cfg.SearchLimit = 10
+	}
+
+	return nil
+}
+
+// Result describes a completed run.
+//
+// Allocations are measured for the whole process, via [runtime.MemStats], so
+// they include the searches performed during the run, if any. For the most
+// precise allocation numbers, disable searches.
+type Result struct {
+	Traces          int           `json:"traces"`
+	Events          int           `json:"events"`
+	Errored         int           `json:"errored"`
+	Elapsed         time.Duration `json:"elapsed"`
+	TracesPerSecond float64       `json:"traces_per_second"`
+	EventsPerSecond float64       `json:"events_per_second"`
+	NsPerTrace      float64       `json:"ns_per_trace"`
+	AllocsPerTrace  float64       `json:"allocs_per_trace"`
+	BytesPerTrace   float64       `json:"bytes_per_trace"`
+	Searches        int           `json:"searches"`
+	SearchLatency   Latency       `json:"search_latency"`
+}
+
+// Latency summarizes a set of durations.
+type Latency struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+func newLatency(durations []time.Duration) Latency {
+	if len(durations) <= 0 {
+		return Latency{}
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+
+	quantile := func(q float64) time.Duration {
+		return durations[int(q*float64(len(durations)-1))]
+	}
+
+	return Latency{
+		Mean: sum / time.Duration(len(durations)),
+		P50:  quantile(0.50),
+		P90:  quantile(0.90),
+		P99:  quantile(0.99),
+		Max:  durations[len(durations)-1],
+	}
+}
+
+// WriteBenchmark writes the result to w in the Go benchmark format, so that
+// the results of different runs can be compared with benchstat. The name
+// identifies the configuration, and shouldn't contain whitespace.
+func (res Result) WriteBenchmark(w io.Writer, name string) error {
+	if _, err := fmt.Fprintf(w,
+		"BenchmarkTraces/%s %d %.1f ns/op %.1f B/op %.1f allocs/op %.1f traces/s %.1f events/s\n",
+		name, res.Traces, res.NsPerTrace, res.BytesPerTrace, res.AllocsPerTrace, res.TracesPerSecond, res.EventsPerSecond,
+	); err != nil {
+		return err
+	}
+
+	if res.Searches > 0 {
+		if _, err := fmt.Fprintf(w,
+			"BenchmarkSearch/%s %d %d ns/op %d p50-ns %d p90-ns %d p99-ns %d max-ns\n",
+			name, res.Searches, res.SearchLatency.Mean, res.SearchLatency.P50, res.SearchLatency.P90, res.SearchLatency.P99, res.SearchLatency.Max,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run generates load against the collector, as described by the config, and
+// returns the result. If the context is canceled before the run is finished,
+// Run returns the result so far, along with the context error.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if err := cfg.initialize(); err != nil {
+		return Result{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	defer trc.SetTraceStacks(trc.TraceStacks())
+	trc.SetTraceStacks(cfg.Stacks)
+
+	loadctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cfg.Duration > 0 {
+		loadctx, cancel = context.WithTimeout(loadctx, cfg.Duration)
+		defer cancel()
+	}
+
+	categories := make([]string, cfg.Categories)
+	for i := range categories {
+		categories[i] = fmt.Sprintf("category%d", i+1)
+	}
+
+	var (
+		searchctx, searchcancel = context.WithCancel(loadctx)
+		searchdone              = make(chan []time.Duration, 1)
+	)
+	defer searchcancel()
+	go func() {
+		searchdone <- searchLoop(searchctx, cfg)
+	}()
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var (
+		begin   = time.Now()
+		next    atomic.Int64
+		traces  atomic.Int64
+		events  atomic.Int64
+		errored atomic.Int64
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n := next.Add(1) - 1
+				if cfg.Traces > 0 && n >= int64(cfg.Traces) {
+					return
+				}
+
+				if cfg.Rate > 0 {
+					due := begin.Add(time.Duration(float64(n) / cfg.Rate * float64(time.Second)))
+					if !sleepUntil(loadctx, due) {
+						return
+					}
+				}
+
+				if loadctx.Err() != nil {
+					return
+				}
+
+				isErrored := cfg.ErrorRate > 0 && int64(float64(n+1)*cfg.ErrorRate) > int64(float64(n)*cfg.ErrorRate)
+				_, tr := cfg.Collector.NewTrace(loadctx, categories[n%int64(len(categories))])
+				for j := 0; j < cfg.EventsPerTrace; j++ {
+					tr.Tracef("event %d", j)
+				}
+				if isErrored {
+					tr.Errorf("event %d failed", cfg.EventsPerTrace)
+					errored.Add(1)
+				}
+				tr.Finish()
+
+				traces.Add(1)
+				events.Add(int64(cfg.EventsPerTrace) + iff(isErrored, int64(1), int64(0)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	var (
+		elapsed = time.Since(begin)
+		after   runtime.MemStats
+	)
+	runtime.ReadMemStats(&after)
+
+	searchcancel()
+	latencies := <-searchdone
+	if cfg.SearchInterval > 0 {
+		latencies = append(latencies, search(ctx, cfg))
+	}
+
+	res := Result{
+		Traces:        int(traces.Load()),
+		Events:        int(events.Load()),
+		Errored:       int(errored.Load()),
+		Elapsed:       elapsed,
+		Searches:      len(latencies),
+		SearchLatency: newLatency(latencies),
+	}
+	if elapsed > 0 {
+		res.TracesPerSecond = float64(res.Traces) / elapsed.Seconds()
+		res.EventsPerSecond = float64(res.Events) / elapsed.Seconds()
+	}
+	if res.Traces > 0 {
+		res.NsPerTrace = float64(elapsed.Nanoseconds()) / float64(res.Traces)
+		res.AllocsPerTrace = float64(after.Mallocs-before.Mallocs) / float64(res.Traces)
+		res.BytesPerTrace = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Traces)
+	}
+
+	return res, ctx.Err()
+}
+
+// searchLoop searches the collector at the configured interval, until the
+// context is canceled, and returns the latency of each search.
+func searchLoop(ctx context.Context, cfg Config) []time.Duration {
+	if cfg.SearchInterval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(cfg.SearchInterval)
+	defer ticker.Stop()
+
+	var latencies []time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return latencies
+		case <-ticker.C:
+			latencies = append(latencies, search(ctx, cfg))
+		}
+	}
+}
+
+// search performs a single search, and returns its latency. Errors are
+// ignored, as the collector only returns errors for invalid requests.
+func search(ctx context.Context, cfg Config) time.Duration {
+	begin := time.Now()
+	cfg.Collector.Search(ctx, &trc.SearchRequest{Limit: cfg.SearchLimit})
+	return time.Since(begin)
+}
+
+// sleepUntil waits until the given time, and returns true, or returns false if
+// the context is canceled first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func iff[T any](cond bool, yes, no T) T {
+	if cond {
+		return yes
+	}
+	return no
+}
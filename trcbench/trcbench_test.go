@@ -0,0 +1,166 @@
+package trcbench_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcbench"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+	)
+
+	res, err := trcbench.Run(ctx, trcbench.Config{
+		Collector:      collector,
+		Traces:         1000,
+		Concurrency:    4,
+		Categories:     3,
+		EventsPerTrace: 5,
+		ErrorRate:      0.1,
+		CategorySize:   1000,
+		SearchInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if want, have := 1000, res.Traces; want != have {
+		t.Errorf("traces: want %d, have %d", want, have)
+	}
+	if want, have := 100, res.Errored; want != have {
+		t.Errorf("errored: want %d, have %d", want, have)
+	}
+	if want, have := 1000*5+100, res.Events; want != have {
+		t.Errorf("events: want %d, have %d", want, have)
+	}
+	if res.Searches <= 0 {
+		t.Errorf("searches: want at least 1, have %d", res.Searches)
+	}
+	if res.TracesPerSecond <= 0 || res.NsPerTrace <= 0 {
+		t.Errorf("throughput: want positive, have %.1f traces/s, %.1f ns/trace", res.TracesPerSecond, res.NsPerTrace)
+	}
+	if l := res.SearchLatency; l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+		t.Errorf("search latency: quantiles out of order: %+v", l)
+	}
+
+	search, err := collector.Search(ctx, &trc.SearchRequest{})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if want, have := 1000, search.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+	if want, have := 3, len(search.Stats.Categories); want != have {
+		t.Errorf("categories: want %d, have %d", want, have)
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	t.Parallel()
+
+	res, err := trcbench.Run(context.Background(), trcbench.Config{
+		Traces: 20,
+		Rate:   200,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if want, have := 20, res.Traces; want != have {
+		t.Errorf("traces: want %d, have %d", want, have)
+	}
+	if min, have := 90*time.Millisecond, res.Elapsed; have < min {
+		t.Errorf("elapsed: want at least %s, have %s", min, have)
+	}
+	if want, have := 0, res.Searches; want != have {
+		t.Errorf("searches: want %d, have %d", want, have)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	t.Parallel()
+
+	res, err := trcbench.Run(context.Background(), trcbench.Config{
+		Duration: 50 * time.Millisecond,
+		Rate:     1000,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if res.Traces <= 0 || res.Traces > 100 {
+		t.Errorf("traces: want between 1 and 100, have %d", res.Traces)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []trcbench.Config{
+		{Traces: -1},
+		{Rate: -1},
+		{ErrorRate: 1.5},
+		{EventsPerTrace: -1},
+		{SearchInterval: -time.Second},
+	} {
+		if _, err := trcbench.Run(context.Background(), cfg); err == nil {
+			t.Errorf("%+v: want error, have none", cfg)
+		}
+	}
+}
+
+func TestResultWriteBenchmark(t *testing.T) {
+	t.Parallel()
+
+	res := trcbench.Result{
+		Traces:          100,
+		NsPerTrace:      1500,
+		BytesPerTrace:   512,
+		AllocsPerTrace:  4,
+		TracesPerSecond: 666666.7,
+		Searches:        2,
+		SearchLatency:   trcbench.Latency{Mean: 2000, P50: 1000, P90: 3000, P99: 3000, Max: 3000},
+	}
+
+	var buf bytes.Buffer
+	if err := res.WriteBenchmark(&buf, "foo"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"BenchmarkTraces/foo 100 1500.0 ns/op 512.0 B/op 4.0 allocs/op 666666.7 traces/s 0.0 events/s",
+		"BenchmarkSearch/foo 2 2000 ns/op 1000 p50-ns 3000 p90-ns 3000 p99-ns 3000 max-ns",
+	}, "\n") + "\n"
+	if have := buf.String(); want != have {
+		t.Errorf("want\n%s\nhave\n%s", want, have)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, cfg := range []trcbench.Config{
+		{EventsPerTrace: 0},
+		{EventsPerTrace: 10},
+		{EventsPerTrace: 10, Stacks: true},
+		{EventsPerTrace: 10, Categories: 10, ErrorRate: 0.1},
+	} {
+		b.Run(fmt.Sprintf("events=%d/stacks=%v/categories=%d", cfg.EventsPerTrace, cfg.Stacks, cfg.Categories), func(b *testing.B) {
+			cfg.Traces = b.N
+			res, err := trcbench.Run(context.Background(), cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(res.AllocsPerTrace, "allocs/trace")
+			b.ReportMetric(res.BytesPerTrace, "B/trace")
+		})
+	}
+}
@@ -0,0 +1,7 @@
+// Package trcproto encodes and decodes trc types as protobuf, according to the
+// schema in trc.proto. Protobuf is a more efficient alternative to JSON, for
+// e.g. search responses containing many large traces.
+//
+// The encoding is implemented directly on top of the protobuf wire format, so
+// the package has no dependencies beyond package trc itself.
+package trcproto
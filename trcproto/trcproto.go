@@ -0,0 +1,607 @@
+package trcproto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// ContentType is the media type of protobuf-encoded request and response
+// bodies, used for content negotiation.
+const ContentType = "application/x-protobuf"
+
+// MarshalStaticTrace encodes the trace as a StaticTrace message.
+func MarshalStaticTrace(st *trc.StaticTrace) []byte {
+	var e encoder
+	encodeStaticTrace(&e, st)
+	return e.b
+}
+
+// UnmarshalStaticTrace decodes a StaticTrace message.
+func UnmarshalStaticTrace(b []byte) (*trc.StaticTrace, error) {
+	st := &trc.StaticTrace{}
+	if err := decodeStaticTrace(&decoder{b}, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// MarshalSearchRequest encodes the request as a SearchRequest message.
+func MarshalSearchRequest(req *trc.SearchRequest) []byte {
+	var e encoder
+	encodeSearchRequest(&e, req)
+	return e.b
+}
+
+// UnmarshalSearchRequest decodes a SearchRequest message.
+func UnmarshalSearchRequest(b []byte) (*trc.SearchRequest, error) {
+	req := &trc.SearchRequest{}
+	if err := decodeSearchRequest(&decoder{b}, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// MarshalSearchResponse encodes the response as a SearchResponse message.
+func MarshalSearchResponse(res *trc.SearchResponse) []byte {
+	var e encoder
+	encodeSearchResponse(&e, res)
+	return e.b
+}
+
+// UnmarshalSearchResponse decodes a SearchResponse message.
+func UnmarshalSearchResponse(b []byte) (*trc.SearchResponse, error) {
+	res := &trc.SearchResponse{}
+	if err := decodeSearchResponse(&decoder{b}, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//
+//
+//
+
+func encodeStaticTrace(e *encoder, st *trc.StaticTrace) {
+	e.string(1, st.TraceSource)
+	e.string(2, st.TraceID)
+	e.string(3, st.TraceCorrelation)
+	e.string(4, st.TraceCategory)
+	e.time(5, st.TraceStarted)
+	e.int64(6, int64(st.TraceDuration))
+	e.bool(7, st.TraceFinished)
+	e.bool(8, st.TraceErrored)
+	for _, ev := range st.TraceEvents {
+		e.message(9, func(e *encoder) { encodeEvent(e, ev) })
+	}
+	for k, v := range st.TraceMetadata {
+		e.mapEntry(10, k, func(e *encoder) { e.string(2, v) })
+	}
+	e.bool(11, st.TraceOutlier)
+	e.int64(12, int64(st.TraceBaselineP99))
+	e.int64(13, int64(st.TraceBytes))
+	e.string(14, st.TraceStatus)
+	for k, v := range st.TraceTags {
+		e.mapEntry(15, k, func(e *encoder) { e.string(2, v) })
+	}
+	e.strings(16, st.TraceErrorTypes)
+	e.bool(17, st.TracePinned)
+}
+
+func decodeStaticTrace(d *decoder, st *trc.StaticTrace) error {
+	err := d.fields(func(num, typ int) (err error) {
+		switch num {
+		case 1:
+			st.TraceSource, err = d.string(typ)
+		case 2:
+			st.TraceID, err = d.string(typ)
+		case 3:
+			st.TraceCorrelation, err = d.string(typ)
+		case 4:
+			st.TraceCategory, err = d.string(typ)
+		case 5:
+			st.TraceStarted, err = d.time(typ)
+		case 6:
+			var v int64
+			v, err = d.int64(typ)
+			st.TraceDuration = time.Duration(v)
+		case 7:
+			st.TraceFinished, err = d.bool(typ)
+		case 8:
+			st.TraceErrored, err = d.bool(typ)
+		case 9:
+			var ev trc.Event
+			err = d.message(typ, func(d *decoder) error { return decodeEvent(d, &ev) })
+			st.TraceEvents = append(st.TraceEvents, ev)
+		case 10:
+			var k string
+			var v []byte
+			k, v, err = d.mapEntry(typ)
+			if st.TraceMetadata == nil {
+				st.TraceMetadata = trc.Metadata{}
+			}
+			st.TraceMetadata[k] = string(v)
+		case 11:
+			st.TraceOutlier, err = d.bool(typ)
+		case 12:
+			var v int64
+			v, err = d.int64(typ)
+			st.TraceBaselineP99 = time.Duration(v)
+		case 13:
+			var v int64
+			v, err = d.int64(typ)
+			st.TraceBytes = int(v)
+		case 14:
+			st.TraceStatus, err = d.string(typ)
+		case 15:
+			var k string
+			var v []byte
+			k, v, err = d.mapEntry(typ)
+			if st.TraceTags == nil {
+				st.TraceTags = trc.Tags{}
+			}
+			st.TraceTags[k] = string(v)
+		case 16:
+			var s string
+			s, err = d.string(typ)
+			st.TraceErrorTypes = append(st.TraceErrorTypes, s)
+		case 17:
+			st.TracePinned, err = d.bool(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("StaticTrace: %w", err)
+	}
+	return nil
+}
+
+func encodeEvent(e *encoder, ev trc.Event) {
+	e.time(1, ev.When)
+	e.string(2, ev.What)
+	for _, fr := range ev.Stack {
+		e.message(3, func(e *encoder) {
+			e.string(1, fr.Function)
+			e.string(2, fr.FileLine)
+		})
+	}
+	e.bool(4, ev.IsError)
+	e.int64(5, int64(ev.Offset))
+	e.int64(6, int64(ev.Repeat))
+	e.string(7, string(ev.Level))
+	e.string(8, ev.ErrorType)
+}
+
+func decodeEvent(d *decoder, ev *trc.Event) error {
+	return d.fields(func(num, typ int) (err error) {
+		switch num {
+		case 1:
+			ev.When, err = d.time(typ)
+		case 2:
+			ev.What, err = d.string(typ)
+		case 3:
+			var fr trc.Frame
+			err = d.message(typ, func(d *decoder) error { return decodeFrame(d, &fr) })
+			ev.Stack = append(ev.Stack, fr)
+		case 4:
+			ev.IsError, err = d.bool(typ)
+		case 5:
+			var v int64
+			v, err = d.int64(typ)
+			ev.Offset = time.Duration(v)
+		case 6:
+			var v int64
+			v, err = d.int64(typ)
+			ev.Repeat = int(v)
+		case 7:
+			var s string
+			s, err = d.string(typ)
+			ev.Level = trc.Level(s)
+		case 8:
+			ev.ErrorType, err = d.string(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+func decodeFrame(d *decoder, fr *trc.Frame) error {
+	return d.fields(func(num, typ int) (err error) {
+		switch num {
+		case 1:
+			fr.Function, err = d.string(typ)
+		case 2:
+			fr.FileLine, err = d.string(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+//
+//
+//
+
+func encodeSearchRequest(e *encoder, req *trc.SearchRequest) {
+	e.packed(1, durationsToInt64s(req.Bucketing))
+	e.message(2, func(e *encoder) { encodeFilter(e, req.Filter) })
+	e.int64(3, int64(req.Limit))
+	e.int64(4, int64(req.StackDepth))
+	e.bool(5, req.StatsOnly)
+	e.int64(6, int64(req.MaxScan))
+	e.int64(7, int64(req.MaxDuration))
+	e.string(8, string(req.Sort))
+	e.string(9, string(req.MinEventLevel))
+}
+
+func decodeSearchRequest(d *decoder, req *trc.SearchRequest) error {
+	var bucketing []int64
+	err := d.fields(func(num, typ int) (err error) {
+		var v int64
+		switch num {
+		case 1:
+			bucketing, err = d.repeatedInt64(typ, bucketing)
+		case 2:
+			err = d.message(typ, func(d *decoder) error { return decodeFilter(d, &req.Filter) })
+		case 3:
+			v, err = d.int64(typ)
+			req.Limit = int(v)
+		case 4:
+			v, err = d.int64(typ)
+			req.StackDepth = int(v)
+		case 5:
+			req.StatsOnly, err = d.bool(typ)
+		case 6:
+			v, err = d.int64(typ)
+			req.MaxScan = int(v)
+		case 7:
+			v, err = d.int64(typ)
+			req.MaxDuration = time.Duration(v)
+		case 8:
+			var s string
+			s, err = d.string(typ)
+			req.Sort = trc.SearchSort(s)
+		case 9:
+			var s string
+			s, err = d.string(typ)
+			req.MinEventLevel = trc.Level(s)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("SearchRequest: %w", err)
+	}
+	req.Bucketing = int64sToDurations(bucketing)
+	return nil
+}
+
+func encodeFilter(e *encoder, f trc.Filter) {
+	e.strings(1, f.Sources)
+	e.strings(2, f.ExcludeSources)
+	e.strings(3, f.IDs)
+	e.string(4, f.Category)
+	e.bool(5, f.IsActive)
+	e.bool(6, f.IsFinished)
+	if f.MinDuration != nil {
+		e.optionalInt64(7, int64(*f.MinDuration))
+	}
+	e.bool(8, f.IsSuccess)
+	e.bool(9, f.IsErrored)
+	e.string(10, f.Query)
+	e.bool(11, f.MatchEvents)
+	e.strings(12, f.Statuses)
+	e.strings(13, f.ExcludeStatuses)
+	e.strings(14, f.Tags)
+	e.strings(15, f.ErrorTypes)
+	if f.MinEvents != nil {
+		e.optionalInt64(16, int64(*f.MinEvents))
+	}
+	if f.MaxEvents != nil {
+		e.optionalInt64(17, int64(*f.MaxEvents))
+	}
+	e.bool(18, f.HasErrorEvents)
+}
+
+func decodeFilter(d *decoder, f *trc.Filter) error {
+	return d.fields(func(num, typ int) (err error) {
+		var s string
+		switch num {
+		case 1:
+			s, err = d.string(typ)
+			f.Sources = append(f.Sources, s)
+		case 2:
+			s, err = d.string(typ)
+			f.ExcludeSources = append(f.ExcludeSources, s)
+		case 3:
+			s, err = d.string(typ)
+			f.IDs = append(f.IDs, s)
+		case 4:
+			f.Category, err = d.string(typ)
+		case 5:
+			f.IsActive, err = d.bool(typ)
+		case 6:
+			f.IsFinished, err = d.bool(typ)
+		case 7:
+			var v int64
+			v, err = d.int64(typ)
+			dur := time.Duration(v)
+			f.MinDuration = &dur
+		case 8:
+			f.IsSuccess, err = d.bool(typ)
+		case 9:
+			f.IsErrored, err = d.bool(typ)
+		case 10:
+			f.Query, err = d.string(typ)
+		case 11:
+			f.MatchEvents, err = d.bool(typ)
+		case 12:
+			s, err = d.string(typ)
+			f.Statuses = append(f.Statuses, s)
+		case 13:
+			s, err = d.string(typ)
+			f.ExcludeStatuses = append(f.ExcludeStatuses, s)
+		case 14:
+			s, err = d.string(typ)
+			f.Tags = append(f.Tags, s)
+		case 15:
+			s, err = d.string(typ)
+			f.ErrorTypes = append(f.ErrorTypes, s)
+		case 16:
+			var v int64
+			v, err = d.int64(typ)
+			n := int(v)
+			f.MinEvents = &n
+		case 17:
+			var v int64
+			v, err = d.int64(typ)
+			n := int(v)
+			f.MaxEvents = &n
+		case 18:
+			f.HasErrorEvents, err = d.bool(typ)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+}
+
+//
+//
+//
+
+func encodeSearchResponse(e *encoder, res *trc.SearchResponse) {
+	if res.Request != nil {
+		e.message(1, func(e *encoder) { encodeSearchRequest(e, res.Request) })
+	}
+	e.strings(2, res.Sources)
+	e.int64(3, int64(res.TotalCount))
+	e.int64(4, int64(res.MatchCount))
+	for _, st := range res.Traces {
+		e.message(5, func(e *encoder) { encodeStaticTrace(e, st) })
+	}
+	if res.Stats != nil {
+		e.message(6, func(e *encoder) { encodeSearchStats(e, res.Stats) })
+	}
+	e.strings(7, res.Problems)
+	e.int64(8, int64(res.Duration))
+	for source, version := range res.Versions {
+		e.mapEntry(9, source, func(e *encoder) { e.string(2, version) })
+	}
+}
+
+func decodeSearchResponse(d *decoder, res *trc.SearchResponse) error {
+	err := d.fields(func(num, typ int) (err error) {
+		switch num {
+		case 1:
+			res.Request = &trc.SearchRequest{}
+			err = d.message(typ, func(d *decoder) error { return decodeSearchRequest(d, res.Request) })
+		case 2:
+			var s string
+			s, err = d.string(typ)
+			res.Sources = append(res.Sources, s)
+		case 3:
+			var v int64
+			v, err = d.int64(typ)
+			res.TotalCount = int(v)
+		case 4:
+			var v int64
+			v, err = d.int64(typ)
+			res.MatchCount = int(v)
+		case 5:
+			st := &trc.StaticTrace{}
+			err = d.message(typ, func(d *decoder) error { return decodeStaticTrace(d, st) })
+			res.Traces = append(res.Traces, st)
+		case 6:
+			res.Stats = &trc.SearchStats{}
+			err = d.message(typ, func(d *decoder) error { return decodeSearchStats(d, res.Stats) })
+		case 7:
+			var s string
+			s, err = d.string(typ)
+			res.Problems = append(res.Problems, s)
+		case 8:
+			var v int64
+			v, err = d.int64(typ)
+			res.Duration = time.Duration(v)
+		case 9:
+			var k string
+			var v []byte
+			k, v, err = d.mapEntry(typ)
+			if res.Versions == nil {
+				res.Versions = trc.Versions{}
+			}
+			res.Versions[k] = string(v)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("SearchResponse: %w", err)
+	}
+	return nil
+}
+
+func encodeSearchStats(e *encoder, ss *trc.SearchStats) {
+	e.packed(1, durationsToInt64s(ss.Bucketing))
+	for name, cs := range ss.Categories {
+		e.mapEntry(2, name, func(e *encoder) {
+			e.message(2, func(e *encoder) { encodeCategoryStats(e, cs) })
+		})
+	}
+}
+
+func decodeSearchStats(d *decoder, ss *trc.SearchStats) error {
+	var bucketing []int64
+	ss.Categories = map[string]*trc.CategoryStats{}
+	err := d.fields(func(num, typ int) (err error) {
+		switch num {
+		case 1:
+			bucketing, err = d.repeatedInt64(typ, bucketing)
+		case 2:
+			var name string
+			var val []byte
+			if name, val, err = d.mapEntry(typ); err != nil {
+				return err
+			}
+			cs := &trc.CategoryStats{}
+			if err = decodeCategoryStats(&decoder{val}, cs); err != nil {
+				return err
+			}
+			ss.Categories[name] = cs
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("SearchStats: %w", err)
+	}
+	ss.Bucketing = int64sToDurations(bucketing)
+	return nil
+}
+
+func encodeCategoryStats(e *encoder, cs *trc.CategoryStats) {
+	e.string(1, cs.Category)
+	e.int64(2, int64(cs.EventCount))
+	e.int64(3, int64(cs.ActiveCount))
+	bucketCounts := make([]int64, len(cs.BucketCounts))
+	for i, n := range cs.BucketCounts {
+		bucketCounts[i] = int64(n)
+	}
+	e.packed(4, bucketCounts)
+	e.int64(5, int64(cs.ErroredCount))
+	e.time(6, cs.Oldest)
+	e.time(7, cs.Newest)
+	e.int64(8, int64(cs.SampledCount))
+	e.double(9, cs.SampledWeight)
+	e.int64(10, int64(cs.P50))
+	e.int64(11, int64(cs.P90))
+	e.int64(12, int64(cs.P99))
+	e.int64(13, int64(cs.ByteCount))
+	for typ, n := range cs.ErrorTypes {
+		e.mapEntry(14, typ, func(e *encoder) { e.int64(2, int64(n)) })
+	}
+}
+
+func decodeCategoryStats(d *decoder, cs *trc.CategoryStats) error {
+	var bucketCounts []int64
+	err := d.fields(func(num, typ int) (err error) {
+		var v int64
+		switch num {
+		case 1:
+			cs.Category, err = d.string(typ)
+		case 2:
+			v, err = d.int64(typ)
+			cs.EventCount = int(v)
+		case 3:
+			v, err = d.int64(typ)
+			cs.ActiveCount = int(v)
+		case 4:
+			bucketCounts, err = d.repeatedInt64(typ, bucketCounts)
+		case 5:
+			v, err = d.int64(typ)
+			cs.ErroredCount = int(v)
+		case 6:
+			cs.Oldest, err = d.time(typ)
+		case 7:
+			cs.Newest, err = d.time(typ)
+		case 8:
+			v, err = d.int64(typ)
+			cs.SampledCount = int(v)
+		case 9:
+			cs.SampledWeight, err = d.double(typ)
+		case 10:
+			v, err = d.int64(typ)
+			cs.P50 = time.Duration(v)
+		case 11:
+			v, err = d.int64(typ)
+			cs.P90 = time.Duration(v)
+		case 12:
+			v, err = d.int64(typ)
+			cs.P99 = time.Duration(v)
+		case 13:
+			v, err = d.int64(typ)
+			cs.ByteCount = int(v)
+		case 14:
+			var errorType string
+			err = d.message(typ, func(d *decoder) error {
+				return d.fields(func(num, typ int) (err error) {
+					switch num {
+					case 1:
+						errorType, err = d.string(typ)
+					case 2:
+						v, err = d.int64(typ)
+					default:
+						err = d.skip(typ)
+					}
+					return err
+				})
+			})
+			if cs.ErrorTypes == nil {
+				cs.ErrorTypes = map[string]int{}
+			}
+			cs.ErrorTypes[errorType] = int(v)
+		default:
+			err = d.skip(typ)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("CategoryStats: %w", err)
+	}
+	cs.BucketCounts = make([]int, len(bucketCounts))
+	for i, n := range bucketCounts {
+		cs.BucketCounts[i] = int(n)
+	}
+	return nil
+}
+
+//
+//
+//
+
+func durationsToInt64s(ds []time.Duration) []int64 {
+	vs := make([]int64, len(ds))
+	for i, d := range ds {
+		vs[i] = int64(d)
+	}
+	return vs
+}
+
+func int64sToDurations(vs []int64) []time.Duration {
+	if len(vs) <= 0 {
+		return nil
+	}
+	ds := make([]time.Duration, len(vs))
+	for i, v := range vs {
+		ds[i] = time.Duration(v)
+	}
+	return ds
+}
@@ -0,0 +1,145 @@
+package trcproto_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcproto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var (
+		start = time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+		min   = 25 * time.Millisecond
+		zero  = time.Duration(0)
+		nmin  = 0
+		nmax  = 10
+		st    = &trc.StaticTrace{
+			TraceSource:      "source",
+			TraceID:          "id",
+			TraceCorrelation: "correlation",
+			TraceCategory:    "category",
+			TraceStarted:     start,
+			TraceDuration:    123 * time.Millisecond,
+			TraceFinished:    true,
+			TraceErrored:     true,
+			TraceStatus:      "canceled",
+			TraceErrorTypes:  []string{"io.EOF", "*fs.PathError"},
+			TraceEvents: []trc.Event{
+				{When: start.Add(time.Millisecond), Offset: time.Millisecond, What: "first", Stack: []trc.Frame{{Function: "main.main", FileLine: "main.go:12"}}},
+				{When: start.Add(2 * time.Millisecond), What: "", IsError: true, ErrorType: "io.EOF", Repeat: 3},
+				{When: start.Add(3 * time.Millisecond), What: "verbose", Level: trc.LevelDebug},
+			},
+			TraceMetadata:    trc.Metadata{"host": "abc", "region": "", "": "empty key"},
+			TraceTags:        trc.Tags{"route": "/api", "tenant": ""},
+			TraceOutlier:     true,
+			TraceBaselineP99: 40 * time.Millisecond,
+			TraceBytes:       5678,
+			TracePinned:      true,
+		}
+		req = &trc.SearchRequest{
+			Bucketing:     []time.Duration{0, time.Millisecond, time.Second},
+			Filter:        trc.Filter{Sources: []string{"a", "b"}, ExcludeSources: []string{"c"}, IDs: []string{"x"}, Category: "category", IsFinished: true, MinDuration: &min, IsErrored: true, Statuses: []string{"timeout"}, ExcludeStatuses: []string{"canceled"}, Tags: []string{"route=/api", "tenant"}, ErrorTypes: []string{"io.EOF"}, MinEvents: &nmin, MaxEvents: &nmax, HasErrorEvents: true, Query: "foo|bar", MatchEvents: true},
+			Limit:         25,
+			StackDepth:    -1,
+			StatsOnly:     true,
+			MaxScan:       1000,
+			MaxDuration:   time.Second,
+			Sort:          trc.SearchSortSlowest,
+			MinEventLevel: trc.LevelWarn,
+		}
+		res = &trc.SearchResponse{
+			Request:    req,
+			Sources:    []string{"a", "b"},
+			Versions:   trc.Versions{"a": "v1.2.3", "b": "(devel) 0123456789ab"},
+			TotalCount: 10,
+			MatchCount: 3,
+			Traces:     []*trc.StaticTrace{st, {TraceID: "empty"}},
+			Stats: &trc.SearchStats{
+				Bucketing: req.Bucketing,
+				Categories: map[string]*trc.CategoryStats{
+					"category": {Category: "category", EventCount: 5, ActiveCount: 1, BucketCounts: []int{3, 2, 0}, ErroredCount: 1, Oldest: start, Newest: start.Add(time.Hour), SampledCount: 2, SampledWeight: 2.5, P50: time.Millisecond, P90: 5 * time.Millisecond, P99: 9 * time.Millisecond, ByteCount: 1234, ErrorTypes: map[string]int{"io.EOF": 2, "error": 1}},
+					"empty":    {BucketCounts: []int{}},
+				},
+			},
+			Problems: []string{"problem 1", "problem 2"},
+			Duration: 7 * time.Millisecond,
+		}
+	)
+
+	opts := []cmp.Option{
+		cmpopts.IgnoreUnexported(trc.Filter{}, trc.CategoryStats{}),
+	}
+
+	t.Run("StaticTrace", func(t *testing.T) {
+		have, err := trcproto.UnmarshalStaticTrace(trcproto.MarshalStaticTrace(st))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(st, have, opts...) {
+			t.Error(cmp.Diff(st, have, opts...))
+		}
+	})
+
+	t.Run("SearchRequest", func(t *testing.T) {
+		have, err := trcproto.UnmarshalSearchRequest(trcproto.MarshalSearchRequest(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(req, have, opts...) {
+			t.Error(cmp.Diff(req, have, opts...))
+		}
+	})
+
+	t.Run("SearchRequest zero MinDuration", func(t *testing.T) {
+		req := &trc.SearchRequest{Filter: trc.Filter{MinDuration: &zero}}
+		have, err := trcproto.UnmarshalSearchRequest(trcproto.MarshalSearchRequest(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have.Filter.MinDuration == nil || *have.Filter.MinDuration != 0 {
+			t.Errorf("MinDuration: want pointer to zero, have %v", have.Filter.MinDuration)
+		}
+	})
+
+	t.Run("SearchResponse", func(t *testing.T) {
+		have, err := trcproto.UnmarshalSearchResponse(trcproto.MarshalSearchResponse(res))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(res, have, opts...) {
+			t.Error(cmp.Diff(res, have, opts...))
+		}
+	})
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	t.Parallel()
+
+	full := trcproto.MarshalSearchResponse(&trc.SearchResponse{
+		Sources: []string{"a"},
+		Traces:  []*trc.StaticTrace{{TraceID: "abc", TraceCategory: "def"}},
+	})
+
+	for name, input := range map[string][]byte{
+		"truncated message": full[:len(full)-1],
+		"truncated varint":  {3<<3 | 0, 0x80},
+		"wrong wire type":   {3<<3 | 2, 1, 'x'},
+		"field zero":        {0<<3 | 0, 1},
+	} {
+		if _, err := trcproto.UnmarshalSearchResponse(input); err == nil {
+			t.Errorf("%s: want error, have none", name)
+		}
+	}
+
+	// Unknown fields are skipped, for forward compatibility.
+	unknown := append([]byte{15<<3 | 0, 42, 14<<3 | 2, 1, 'x'}, full...)
+	if _, err := trcproto.UnmarshalSearchResponse(unknown); err != nil {
+		t.Errorf("unknown fields: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package trcproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Wire types, from the protobuf encoding spec.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated input")
+
+//
+//
+//
+
+// encoder appends protobuf fields to a byte slice. Scalar fields with zero
+// values are omitted, per proto3 semantics.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) tag(num int, typ int) {
+	e.b = binary.AppendUvarint(e.b, uint64(num)<<3|uint64(typ))
+}
+
+func (e *encoder) int64(num int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(num, wireVarint)
+	e.b = binary.AppendUvarint(e.b, uint64(v))
+}
+
+// optionalInt64 encodes a proto3 optional field, which is written even if it's
+// zero, so that its presence is preserved.
+func (e *encoder) optionalInt64(num int, v int64) {
+	e.tag(num, wireVarint)
+	e.b = binary.AppendUvarint(e.b, uint64(v))
+}
+
+func (e *encoder) bool(num int, v bool) {
+	if !v {
+		return
+	}
+	e.tag(num, wireVarint)
+	e.b = append(e.b, 1)
+}
+
+func (e *encoder) double(num int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(num, wireFixed64)
+	e.b = binary.LittleEndian.AppendUint64(e.b, math.Float64bits(v))
+}
+
+func (e *encoder) string(num int, v string) {
+	if v == "" {
+		return
+	}
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) strings(num int, vs []string) {
+	for _, v := range vs {
+		e.tag(num, wireBytes)
+		e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+		e.b = append(e.b, v...)
+	}
+}
+
+func (e *encoder) time(num int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	e.int64(num, t.UnixNano())
+}
+
+// packed encodes the values as a packed repeated varint field.
+func (e *encoder) packed(num int, vs []int64) {
+	if len(vs) <= 0 {
+		return
+	}
+	var inner []byte
+	for _, v := range vs {
+		inner = binary.AppendUvarint(inner, uint64(v))
+	}
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(inner)))
+	e.b = append(e.b, inner...)
+}
+
+// message encodes a length-delimited embedded message, written by fn. Unlike
+// scalars, messages are always written, so that e.g. empty elements of
+// repeated fields are preserved.
+func (e *encoder) message(num int, fn func(*encoder)) {
+	var inner encoder
+	fn(&inner)
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(inner.b)))
+	e.b = append(e.b, inner.b...)
+}
+
+// mapEntry encodes a map entry with a string key, and a value written by fn.
+func (e *encoder) mapEntry(num int, key string, fn func(*encoder)) {
+	e.message(num, func(e *encoder) {
+		e.string(1, key)
+		fn(e)
+	})
+}
+
+//
+//
+//
+
+// decoder reads protobuf fields from a byte slice.
+type decoder struct {
+	b []byte
+}
+
+// next returns the number and wire type of the next field, or false if the
+// input is exhausted.
+func (d *decoder) next() (num int, typ int, ok bool, err error) {
+	if len(d.b) <= 0 {
+		return 0, 0, false, nil
+	}
+	v, err := d.uvarint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	num, typ = int(v>>3), int(v&7)
+	if num <= 0 {
+		return 0, 0, false, fmt.Errorf("invalid field number %d", num)
+	}
+	return num, typ, true, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) int64(typ int) (int64, error) {
+	if typ != wireVarint {
+		return 0, fmt.Errorf("wire type %d: want varint", typ)
+	}
+	v, err := d.uvarint()
+	return int64(v), err
+}
+
+func (d *decoder) bool(typ int) (bool, error) {
+	v, err := d.int64(typ)
+	return v != 0, err
+}
+
+func (d *decoder) time(typ int) (time.Time, error) {
+	v, err := d.int64(typ)
+	if err != nil || v == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, v).UTC(), nil
+}
+
+func (d *decoder) double(typ int) (float64, error) {
+	if typ != wireFixed64 {
+		return 0, fmt.Errorf("wire type %d: want fixed64", typ)
+	}
+	if len(d.b) < 8 {
+		return 0, errTruncated
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v, nil
+}
+
+func (d *decoder) bytes(typ int) ([]byte, error) {
+	if typ != wireBytes {
+		return nil, fmt.Errorf("wire type %d: want length-delimited", typ)
+	}
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.b)) {
+		return nil, errTruncated
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) string(typ int) (string, error) {
+	b, err := d.bytes(typ)
+	return string(b), err
+}
+
+// repeatedInt64 decodes one occurrence of a repeated varint field, which may be
+// either packed or unpacked, and appends the value(s) to vs.
+func (d *decoder) repeatedInt64(typ int, vs []int64) ([]int64, error) {
+	if typ == wireVarint {
+		v, err := d.int64(typ)
+		return append(vs, v), err
+	}
+	b, err := d.bytes(typ)
+	if err != nil {
+		return vs, err
+	}
+	inner := decoder{b}
+	for len(inner.b) > 0 {
+		v, err := inner.uvarint()
+		if err != nil {
+			return vs, err
+		}
+		vs = append(vs, int64(v))
+	}
+	return vs, nil
+}
+
+// message decodes a length-delimited embedded message via fn.
+func (d *decoder) message(typ int, fn func(*decoder) error) error {
+	b, err := d.bytes(typ)
+	if err != nil {
+		return err
+	}
+	return fn(&decoder{b})
+}
+
+// skip discards the value of an unknown field, for forward compatibility.
+func (d *decoder) skip(typ int) error {
+	switch typ {
+	case wireVarint:
+		_, err := d.uvarint()
+		return err
+	case wireFixed64, wireFixed32:
+		n := 8
+		if typ == wireFixed32 {
+			n = 4
+		}
+		if len(d.b) < n {
+			return errTruncated
+		}
+		d.b = d.b[n:]
+		return nil
+	case wireBytes:
+		_, err := d.bytes(typ)
+		return err
+	default:
+		return fmt.Errorf("unsupported wire type %d", typ)
+	}
+}
+
+// fields calls fn for each field in the input, until the input is exhausted or
+// fn returns an error.
+func (d *decoder) fields(fn func(num, typ int) error) error {
+	for {
+		num, typ, ok, err := d.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := fn(num, typ); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+}
+
+// mapEntry decodes a map entry with a string key, and a length-delimited value,
+// i.e. a string or an embedded message.
+func (d *decoder) mapEntry(typ int) (key string, val []byte, err error) {
+	err = d.message(typ, func(d *decoder) error {
+		return d.fields(func(num, typ int) (err error) {
+			switch num {
+			case 1:
+				key, err = d.string(typ)
+			case 2:
+				val, err = d.bytes(typ)
+			default:
+				err = d.skip(typ)
+			}
+			return err
+		})
+	})
+	return key, val, err
+}
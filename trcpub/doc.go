@@ -0,0 +1,4 @@
+// Package trcpub publishes finished traces to a message bus, e.g. Kafka or
+// NATS, so they can be consumed by existing streaming pipelines, rather than
+// scraped from a trace server over HTTP.
+package trcpub
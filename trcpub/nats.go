@@ -0,0 +1,52 @@
+package trcpub
+
+import (
+	"context"
+	"fmt"
+)
+
+// NATSConn is the subset of a NATS connection used by the NATS publisher. It's
+// satisfied by *nats.Conn from github.com/nats-io/nats.go, which this package
+// doesn't depend on directly.
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+	FlushWithContext(ctx context.Context) error
+}
+
+// NATSPublisher publishes each trace as a NATS message to a subject.
+type NATSPublisher struct {
+	conn    NATSConn
+	subject func(Message) string
+}
+
+var _ Publisher = (*NATSPublisher)(nil)
+
+// NewNATSPublisher returns a publisher which publishes every trace to the
+// given subject.
+func NewNATSPublisher(conn NATSConn, subject string) *NATSPublisher {
+	return NewNATSPublisherFunc(conn, func(Message) string { return subject })
+}
+
+// NewNATSPublisherFunc is like NewNATSPublisher, but calls subject to determine
+// the subject of each message, e.g. to include the category of the trace.
+func NewNATSPublisherFunc(conn NATSConn, subject func(Message) string) *NATSPublisher {
+	return &NATSPublisher{
+		conn:    conn,
+		subject: subject,
+	}
+}
+
+// Publish implements Publisher. Messages are published individually, and then
+// flushed together, so the batch is published once it's been received by the
+// server.
+func (p *NATSPublisher) Publish(ctx context.Context, batch []Message) error {
+	for _, m := range batch {
+		if err := p.conn.Publish(p.subject(m), m.Value); err != nil {
+			return fmt.Errorf("publish %s: %w", m.Key, err)
+		}
+	}
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
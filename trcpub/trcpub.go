@@ -0,0 +1,361 @@
+package trcpub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcproto"
+)
+
+// Message is a single encoded trace, ready to be published.
+type Message struct {
+	// Key is the ID of the trace, which can be used e.g. as a Kafka message
+	// key, so all messages for a trace land in the same partition.
+	Key string
+
+	// Value is the encoded trace.
+	Value []byte
+}
+
+// Publisher publishes batches of messages to a message bus. Implementations
+// are called from a single goroutine, and should return only when the batch
+// has been accepted by the bus, or has failed.
+type Publisher interface {
+	Publish(ctx context.Context, batch []Message) error
+}
+
+// PublisherFunc adapts a function to a Publisher. It's the simplest way to
+// publish to a message bus whose client isn't directly supported. For example,
+// with github.com/segmentio/kafka-go:
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("localhost:9092"), Topic: "traces"}
+//	p := trcpub.PublisherFunc(func(ctx context.Context, batch []trcpub.Message) error {
+//		msgs := make([]kafka.Message, len(batch))
+//		for i, m := range batch {
+//			msgs[i] = kafka.Message{Key: []byte(m.Key), Value: m.Value}
+//		}
+//		return w.WriteMessages(ctx, msgs...)
+//	})
+type PublisherFunc func(ctx context.Context, batch []Message) error
+
+// Publish implements Publisher.
+func (f PublisherFunc) Publish(ctx context.Context, batch []Message) error {
+	return f(ctx, batch)
+}
+
+// EncodeFunc encodes a trace as a message value.
+type EncodeFunc func(*trc.StaticTrace) ([]byte, error)
+
+// EncodeJSON encodes the trace as JSON. It's the default encoding.
+func EncodeJSON(st *trc.StaticTrace) ([]byte, error) {
+	return json.Marshal(st)
+}
+
+// EncodeProtobuf encodes the trace as a protobuf StaticTrace message, as
+// defined by package trcproto.
+func EncodeProtobuf(st *trc.StaticTrace) ([]byte, error) {
+	return trcproto.MarshalStaticTrace(st), nil
+}
+
+//
+//
+//
+
+// QueueConfig is the configuration for a queue.
+type QueueConfig struct {
+	// Publisher is where batches of traces are published. Required.
+	Publisher Publisher
+
+	// Encode is used to encode each trace. If not provided, EncodeJSON is used.
+	Encode EncodeFunc
+
+	// Allow, if provided, is called for every finished trace, and only traces
+	// for which it returns true are published. Optional.
+	Allow func(trc.Trace) bool
+
+	// BatchSize is the max number of traces published in a single batch. The
+	// default is 100.
+	BatchSize int
+
+	// FlushInterval is the max time a trace waits in the queue before it's
+	// published, even if the batch isn't full. The default is 1 second.
+	FlushInterval time.Duration
+
+	// PublishTimeout is the max time allowed for each call to Publish. The
+	// default is 10 seconds.
+	PublishTimeout time.Duration
+
+	// QueueSize is the max number of traces waiting to be published. When the
+	// queue is full, e.g. because the message bus is slow or unavailable,
+	// newly finished traces are dropped, rather than blocking the caller. The
+	// default is 10000.
+	QueueSize int
+
+	// OnError is called with errors from encoding or publishing. Traces in a
+	// failed batch are dropped, and aren't retried. Optional.
+	OnError func(error)
+}
+
+// Normalize ensures the config is valid, setting defaults where necessary.
+func (cfg *QueueConfig) Normalize() []error {
+	var errs []error
+
+	if cfg.Publisher == nil {
+		errs = append(errs, errors.New("publisher is required"))
+	}
+
+	if cfg.Encode == nil {
+		cfg.Encode = EncodeJSON
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = 10 * time.Second
+	}
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+
+	if cfg.OnError == nil {
+		cfg.OnError = func(error) {}
+	}
+
+	return errs
+}
+
+// Queue publishes finished traces to a message bus, asynchronously, in batches.
+// Traces are added to the queue by the decorator returned by [Queue.Decorator],
+// which is typically provided to a [trc.Collector].
+//
+//	q, err := trcpub.NewQueue(trcpub.QueueConfig{Publisher: p})
+//	if err != nil { ... }
+//	defer q.Close(context.Background())
+//
+//	collector := trc.NewCollector(trc.CollectorConfig{
+//		Decorators: []trc.DecoratorFunc{q.Decorator()},
+//	})
+type Queue struct {
+	cfg    QueueConfig
+	queue  chan *trc.StaticTrace
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	stats  queueStats
+}
+
+// NewQueue returns a new queue, which begins publishing immediately. Callers
+// must Close the queue to flush any remaining traces.
+func NewQueue(cfg QueueConfig) (*Queue, error) {
+	if errs := cfg.Normalize(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	q := &Queue{
+		cfg:    cfg,
+		queue:  make(chan *trc.StaticTrace, cfg.QueueSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go q.loop()
+
+	return q, nil
+}
+
+// Decorator returns a decorator which adds each decorated trace to the queue
+// when it's finished, if it passes the Allow function, if any.
+func (q *Queue) Decorator() trc.DecoratorFunc {
+	return func(tr trc.Trace) trc.Trace {
+		return &queueTrace{Trace: tr, q: q}
+	}
+}
+
+// Enqueue adds a static copy of the trace to the queue, without blocking. It
+// returns false if the trace was dropped, because the queue is full or closed.
+func (q *Queue) Enqueue(tr trc.Trace) bool {
+	select {
+	case <-q.closed:
+		q.stats.dropped.Add(1)
+		return false
+	default:
+	}
+
+	// Copying the trace is relatively expensive, so full queues are checked
+	// first. The queue may still fill before the copy is sent.
+	if len(q.queue) >= cap(q.queue) {
+		q.stats.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case q.queue <- trc.NewSearchTrace(tr):
+		q.stats.enqueued.Add(1)
+		return true
+	default:
+		q.stats.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting new traces, and waits for queued traces to be
+// published, or for the context to be canceled, whichever comes first.
+func (q *Queue) Close(ctx context.Context) error {
+	q.once.Do(func() { close(q.closed) })
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueStats are counters describing the traces which have passed through a
+// queue.
+type QueueStats struct {
+	Enqueued  uint64 `json:"enqueued"`
+	Dropped   uint64 `json:"dropped"`   // because the queue was full or closed
+	Published uint64 `json:"published"` // in successful batches
+	Failed    uint64 `json:"failed"`    // in failed batches, or failed to encode
+}
+
+// Stats returns the current counters for the queue.
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Enqueued:  q.stats.enqueued.Load(),
+		Dropped:   q.stats.dropped.Load(),
+		Published: q.stats.published.Load(),
+		Failed:    q.stats.failed.Load(),
+	}
+}
+
+type queueStats struct {
+	enqueued  atomic.Uint64
+	dropped   atomic.Uint64
+	published atomic.Uint64
+	failed    atomic.Uint64
+}
+
+func (q *Queue) loop() {
+	defer close(q.done)
+
+	var (
+		batch  = make([]*trc.StaticTrace, 0, q.cfg.BatchSize)
+		ticker = time.NewTicker(q.cfg.FlushInterval)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case st := <-q.queue:
+			batch = append(batch, st)
+			if len(batch) >= q.cfg.BatchSize {
+				batch = q.publish(batch)
+			}
+
+		case <-ticker.C:
+			batch = q.publish(batch)
+
+		case <-q.closed:
+			// Drain whatever is left in the queue. Enqueue checks closed
+			// first, so few if any traces can be added after this point.
+			for {
+				select {
+				case st := <-q.queue:
+					batch = append(batch, st)
+					if len(batch) >= q.cfg.BatchSize {
+						batch = q.publish(batch)
+					}
+				default:
+					q.publish(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish encodes and publishes the batch, and returns it emptied, for reuse.
+func (q *Queue) publish(batch []*trc.StaticTrace) []*trc.StaticTrace {
+	if len(batch) <= 0 {
+		return batch
+	}
+
+	msgs := make([]Message, 0, len(batch))
+	for _, st := range batch {
+		value, err := q.cfg.Encode(st)
+		if err != nil {
+			q.stats.failed.Add(1)
+			q.cfg.OnError(fmt.Errorf("encode trace %s: %w", st.ID(), err))
+			continue
+		}
+		msgs = append(msgs, Message{Key: st.ID(), Value: value})
+	}
+
+	if len(msgs) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), q.cfg.PublishTimeout)
+		err := q.cfg.Publisher.Publish(ctx, msgs)
+		cancel()
+		if err != nil {
+			q.stats.failed.Add(uint64(len(msgs)))
+			q.cfg.OnError(fmt.Errorf("publish %d trace(s): %w", len(msgs), err))
+		} else {
+			q.stats.published.Add(uint64(len(msgs)))
+		}
+	}
+
+	for i := range batch {
+		batch[i] = nil // allow GC
+	}
+	return batch[:0]
+}
+
+//
+//
+//
+
+type queueTrace struct {
+	trc.Trace
+	q        *Queue
+	finished atomic.Bool
+}
+
+var _ interface{ Free() } = (*queueTrace)(nil)
+
+func (qtr *queueTrace) Unwrap() trc.Trace {
+	return qtr.Trace
+}
+
+func (qtr *queueTrace) Finish() {
+	if !qtr.finished.CompareAndSwap(false, true) || qtr.Trace.Finished() {
+		return // concurrent calls to Finish must enqueue the trace only once
+	}
+
+	qtr.Trace.Finish()
+
+	if qtr.q.cfg.Allow != nil && !qtr.q.cfg.Allow(qtr.Trace) {
+		return
+	}
+
+	qtr.q.Enqueue(qtr.Trace)
+}
+
+func (qtr *queueTrace) Free() {
+	if f, ok := qtr.Trace.(interface{ Free() }); ok {
+		f.Free()
+	}
+}
@@ -0,0 +1,210 @@
+package trcpub_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcpub"
+)
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		publisher = &mockPublisher{}
+		queue, _  = trcpub.NewQueue(trcpub.QueueConfig{
+			Publisher:     publisher,
+			Allow:         func(tr trc.Trace) bool { return tr.Category() != "skip" },
+			BatchSize:     3,
+			FlushInterval: time.Hour, // only full batches, and close, publish
+		})
+		collector = trc.NewCollector(trc.CollectorConfig{
+			Decorators: []trc.DecoratorFunc{queue.Decorator()},
+		})
+	)
+
+	var ids []string
+	for _, category := range []string{"foo", "bar", "skip", "foo", "bar"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Tracef("hello %s", category)
+		tr.Finish()
+		tr.Finish() // enqueued once
+		if category != "skip" {
+			ids = append(ids, tr.ID())
+		}
+	}
+
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	batches := publisher.get()
+	if want, have := 2, len(batches); want != have {
+		t.Fatalf("batches: want %d, have %d", want, have)
+	}
+	if want, have := 3, len(batches[0]); want != have {
+		t.Errorf("first batch: want %d, have %d", want, have)
+	}
+
+	var have []string
+	for _, batch := range batches {
+		for _, m := range batch {
+			var st trc.StaticTrace
+			if err := json.Unmarshal(m.Value, &st); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if want, have := m.Key, st.ID(); want != have {
+				t.Errorf("key: want %s, have %s", want, have)
+			}
+			have = append(have, st.ID())
+		}
+	}
+	if want, have := len(ids), len(have); want != have {
+		t.Fatalf("published: want %d, have %d", want, have)
+	}
+	for i := range ids {
+		if want, have := ids[i], have[i]; want != have {
+			t.Errorf("published %d: want %s, have %s", i, want, have)
+		}
+	}
+
+	stats := queue.Stats()
+	if want, have := (trcpub.QueueStats{Enqueued: 4, Published: 4}), stats; want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+
+	// Closed queues drop traces.
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+	if want, have := uint64(1), queue.Stats().Dropped; want != have {
+		t.Errorf("dropped after close: want %d, have %d", want, have)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		started   = make(chan struct{}, 10)
+		release   = make(chan struct{})
+		errs      = make(chan error, 10)
+		publisher = trcpub.PublisherFunc(func(ctx context.Context, batch []trcpub.Message) error {
+			started <- struct{}{}
+			<-release
+			return errors.New("bus unavailable")
+		})
+		queue, _ = trcpub.NewQueue(trcpub.QueueConfig{
+			Publisher: publisher,
+			BatchSize: 1,
+			QueueSize: 2,
+			OnError:   func(err error) { errs <- err },
+		})
+	)
+
+	// The first trace is taken by the publishing goroutine, which blocks, so
+	// the queue fills after two more traces, and the rest are dropped.
+	var copies atomic.Int64
+	for i := 0; i < 10; i++ {
+		_, tr := trc.New(ctx, "src", "cat")
+		tr.Finish()
+		queue.Enqueue(&copyCountingTrace{Trace: tr, copies: &copies})
+		if i == 0 {
+			<-started
+		}
+	}
+
+	close(release)
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if want, have := (trcpub.QueueStats{Enqueued: 3, Dropped: 7, Failed: 3}), queue.Stats(); want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+	if want, have := 3, len(errs); want != have {
+		t.Errorf("errors: want %d, have %d", want, have)
+	}
+	if want, have := int64(3), copies.Load(); want != have {
+		t.Errorf("copies: want %d, have %d", want, have)
+	}
+}
+
+func TestQueueConcurrentFinish(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		publisher = &mockPublisher{}
+		queue, _  = trcpub.NewQueue(trcpub.QueueConfig{Publisher: publisher})
+		slow      = func(tr trc.Trace) trc.Trace { return &slowFinishTrace{tr} }
+		_, tr     = trc.New(ctx, "src", "cat", slow, queue.Decorator())
+		start     = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			tr.Finish()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if want, have := (trcpub.QueueStats{Enqueued: 1, Published: 1}), queue.Stats(); want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+}
+
+// copyCountingTrace counts the number of times its events are read, i.e. the
+// number of times it's copied.
+type copyCountingTrace struct {
+	trc.Trace
+	copies *atomic.Int64
+}
+
+func (ctr *copyCountingTrace) Events() []trc.Event {
+	ctr.copies.Add(1)
+	return ctr.Trace.Events()
+}
+
+// slowFinishTrace widens the window between a call to Finish and the trace
+// reporting that it's finished, to provoke races between concurrent calls.
+type slowFinishTrace struct{ trc.Trace }
+
+func (str *slowFinishTrace) Unwrap() trc.Trace { return str.Trace }
+
+func (str *slowFinishTrace) Finish() {
+	time.Sleep(10 * time.Millisecond)
+	str.Trace.Finish()
+}
+
+type mockPublisher struct {
+	mtx     sync.Mutex
+	batches [][]trcpub.Message
+}
+
+func (p *mockPublisher) Publish(ctx context.Context, batch []trcpub.Message) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.batches = append(p.batches, append([]trcpub.Message(nil), batch...))
+	return nil
+}
+
+func (p *mockPublisher) get() [][]trcpub.Message {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.batches
+}
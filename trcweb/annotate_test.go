@@ -0,0 +1,79 @@
+package trcweb_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestAnnotate(t *testing.T) {
+	t.Parallel()
+
+	var (
+		collector   = trc.NewDefaultCollector()
+		_, active   = collector.NewTrace(context.Background(), "category")
+		_, finished = collector.NewTrace(context.Background(), "category")
+	)
+	finished.Finish()
+
+	server := trcweb.NewTraceServer(collector)
+
+	annotate := func(body string, token string) int {
+		r := httptest.NewRequest("POST", "/?annotate", strings.NewReader(body))
+		r.Header.Set("authorization", token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	body := `{"id":"` + active.ID() + `","text":"deployment started"}`
+
+	if want, have := http.StatusForbidden, annotate(body, "secret"); want != have {
+		t.Errorf("no authorizer: want %d, have %d", want, have)
+	}
+
+	server.Mutations.Authorize = func(r *http.Request) error {
+		if r.Header.Get("authorization") != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+
+	if want, have := http.StatusForbidden, annotate(body, "secret"); want != have {
+		t.Errorf("not enabled: want %d, have %d", want, have)
+	}
+
+	server.Mutations.Annotate = true
+
+	for _, tc := range []struct {
+		name  string
+		body  string
+		token string
+		want  int
+	}{
+		{"bad token", body, "wrong", http.StatusForbidden},
+		{"bad body", `{`, "secret", http.StatusBadRequest},
+		{"no text", `{"id":"` + active.ID() + `"}`, "secret", http.StatusBadRequest},
+		{"not found", `{"id":"nope","text":"x"}`, "secret", http.StatusNotFound},
+		{"finished", `{"id":"` + finished.ID() + `","text":"x"}`, "secret", http.StatusConflict},
+		{"success", body, "secret", http.StatusNoContent},
+	} {
+		if want, have := tc.want, annotate(tc.body, tc.token); want != have {
+			t.Errorf("%s: want %d, have %d", tc.name, want, have)
+		}
+	}
+
+	events := active.Events()
+	if want, have := 1, len(events); want != have {
+		t.Fatalf("events: want %d, have %d", want, have)
+	}
+	if want, have := "deployment started", events[0].What; want != have {
+		t.Errorf("event: want %q, have %q", want, have)
+	}
+}
@@ -0,0 +1,194 @@
+package trcweb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	texttemplate "text/template"
+
+	"github.com/peterbourgon/trc"
+)
+
+// AssetParam is the query parameter which identifies an asset, e.g. the CSS
+// or JS of the web interface, to be served by a [TraceServer]. Assets can also
+// be requested by path, e.g. /traces/assets/traces.css, if the trace server
+// is mounted to serve that path.
+const AssetParam = "asset"
+
+// servedAssets are the assets which can be served by a trace server. Other
+// assets, e.g. HTML templates, are only used for rendering pages.
+var servedAssets = map[string]bool{
+	"traces.css": true,
+	"traces.js":  true,
+}
+
+// renderedAsset is an asset, rendered as a template without data, so that any
+// overlays are applied.
+type renderedAsset struct {
+	body        []byte
+	fingerprint string
+}
+
+// assetName returns the name of the asset requested by r, if any.
+func assetName(r *http.Request) (string, bool) {
+	if name := r.URL.Query().Get(AssetParam); name != "" {
+		return name, true
+	}
+	if dir, name := path.Split(r.URL.Path); path.Base(dir) == "assets" && name != "" {
+		return name, true
+	}
+	return "", false
+}
+
+// getAsset returns the rendered asset, which is cached by the server, unless
+// AssetsDirEnvKey is set, in which case local files may change at any time.
+func (s *TraceServer) getAsset(ctx context.Context, name string) (*renderedAsset, error) {
+	if !servedAssets[name] {
+		return nil, fmt.Errorf("asset (%s) not found", name)
+	}
+
+	cache := os.Getenv(AssetsDirEnvKey) == ""
+	if cache {
+		s.assetsMtx.Lock()
+		asset, ok := s.assetsCache[name]
+		s.assetsMtx.Unlock()
+		if ok {
+			return asset, nil
+		}
+	}
+
+	body, err := renderAsset(ctx, assetsFS(s.Assets), name)
+	if err != nil {
+		return nil, err
+	}
+
+	asset := &renderedAsset{body: body, fingerprint: sha256hex(string(body))[:16]}
+
+	if cache {
+		s.assetsMtx.Lock()
+		if s.assetsCache == nil {
+			s.assetsCache = map[string]*renderedAsset{}
+		}
+		s.assetsCache[name] = asset
+		s.assetsMtx.Unlock()
+	}
+
+	return asset, nil
+}
+
+// renderAsset renders the named asset without data. Assets are executed as
+// text templates, rather than HTML templates, which would escape e.g. the <
+// characters in JS, as they're rendered on their own, rather than within a
+// style or script element. Local files in AssetsDirEnvKey are used, if they
+// exist, like renderTemplate.
+func renderAsset(ctx context.Context, fsys fs.FS, name string) (_ []byte, err error) {
+	tr := trc.Get(ctx)
+
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("PANIC: %v", x)
+		}
+	}()
+
+	templateRoot, err := texttemplate.New("root").Funcs(texttemplate.FuncMap(templateFuncs)).ParseFS(fsys, "*")
+	if err != nil {
+		return nil, fmt.Errorf("parse assets: %w", err)
+	}
+
+	if localFile := filepath.Join(filepath.Clean(os.Getenv(AssetsDirEnvKey)), name); fileExists(localFile) {
+		if templateRoot, err = templateRoot.ParseFiles(localFile); err != nil {
+			return nil, fmt.Errorf("parse local file: %w", err)
+		}
+		tr.LazyTracef("local file %s", localFile)
+	}
+
+	templateFile := templateRoot.Lookup(name)
+	if templateFile == nil {
+		return nil, fmt.Errorf("template (%s) not found", name)
+	}
+
+	var buf bytes.Buffer
+	if err := templateFile.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("execute template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// assetFuncs returns the template functions which link rendered pages to the
+// assets served by the trace server. Asset URLs are relative, so they work
+// regardless of where the server is mounted, and include the tenant, if any,
+// as well as the fingerprint of the asset, so they can be cached indefinitely.
+func (s *TraceServer) assetFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"AssetURL": func(name string) template.URL {
+			asset, err := s.getAsset(ctx, name)
+			if err != nil {
+				trc.Get(ctx).LazyErrorf("asset URL: %v", err)
+				return "" // the asset is inlined
+			}
+			q := url.Values{}
+			if s.tenant != "" {
+				q.Set(TenantParam, s.tenant)
+			}
+			q.Set(AssetParam, name)
+			q.Set("v", asset.fingerprint)
+			return template.URL("?" + q.Encode())
+		},
+	}
+}
+
+// handleAsset serves an asset with an ETag of its fingerprint. Requests which
+// include the current fingerprint, as produced by the AssetURL template
+// function, are cacheable indefinitely. Other requests must be revalidated.
+func (s *TraceServer) handleAsset(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx     = r.Context()
+		tr      = trc.Get(ctx)
+		name, _ = assetName(r)
+	)
+
+	asset, err := s.getAsset(ctx, name)
+	if err != nil {
+		tr.Errorf("get asset: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	etag := `"` + asset.fingerprint + `"`
+	w.Header().Set("etag", etag)
+	if r.URL.Query().Get("v") == asset.fingerprint {
+		w.Header().Set("cache-control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("cache-control", "no-cache")
+	}
+
+	if matchesETag(r, etag) {
+		tr.LazyTracef("asset %s not modified", name)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("content-type", ctype)
+
+	tr.LazyTracef("asset %s (%d bytes)", name, len(asset.body))
+
+	writeBody(ctx, w, r, http.StatusOK, asset.body)
+}
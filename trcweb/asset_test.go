@@ -0,0 +1,216 @@
+package trcweb
+
+import (
+	"context"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb/assets"
+)
+
+func TestTraceServerAssetsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	_, tr := collector.NewTrace(context.Background(), "foo")
+	tr.Finish()
+	server := NewTraceServer(collector)
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", target, nil)
+		for k, vs := range header {
+			r.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	// The page links to the assets, rather than inlining them.
+	page := get("/", http.Header{"Accept": {"text/html"}}).Body.String()
+	if strings.Contains(page, "function toggleStacksFor") {
+		t.Errorf("page inlines traces.js")
+	}
+	links := map[string]string{}
+	for _, m := range regexp.MustCompile(`(?:href|src)="(\?asset=([a-z.]+)[^"]*)"`).FindAllStringSubmatch(page, -1) {
+		links[m[2]] = html.UnescapeString(m[1])
+	}
+
+	for _, tc := range []struct {
+		name  string
+		ctype string
+		want  string
+	}{
+		{"traces.css", "text/css; charset=utf-8", ":root"},
+		{"traces.js", "text/javascript; charset=utf-8", "function toggleStacksFor"},
+	} {
+		link, ok := links[tc.name]
+		if !ok {
+			t.Errorf("%s: page doesn't link to asset", tc.name)
+			continue
+		}
+
+		w := get("/"+link, nil)
+		if want, have := http.StatusOK, w.Code; want != have {
+			t.Errorf("%s: code: want %d, have %d", tc.name, want, have)
+		}
+		if want, have := tc.ctype, w.Header().Get("content-type"); want != have {
+			t.Errorf("%s: content-type: want %q, have %q", tc.name, want, have)
+		}
+		if want, have := "public, max-age=31536000, immutable", w.Header().Get("cache-control"); want != have {
+			t.Errorf("%s: cache-control: want %q, have %q", tc.name, want, have)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: body doesn't contain %q", tc.name, tc.want)
+		}
+		if embedded, _ := fs.ReadFile(assets.FS, tc.name); string(embedded) != w.Body.String() {
+			t.Errorf("%s: body doesn't match embedded asset", tc.name)
+		}
+
+		etag := w.Header().Get("etag")
+		if etag == "" || !strings.Contains(link, strings.Trim(etag, `"`)) {
+			t.Errorf("%s: etag %q doesn't match link %q", tc.name, etag, link)
+		}
+
+		w = get("/"+link, http.Header{"If-None-Match": {etag}})
+		if want, have := http.StatusNotModified, w.Code; want != have {
+			t.Errorf("%s: conditional: code: want %d, have %d", tc.name, want, have)
+		}
+		if w.Body.Len() > 0 {
+			t.Errorf("%s: conditional: unexpected body", tc.name)
+		}
+
+		w = get("/traces/assets/"+tc.name, nil)
+		if want, have := http.StatusOK, w.Code; want != have {
+			t.Errorf("%s: by path: code: want %d, have %d", tc.name, want, have)
+		}
+		if want, have := "no-cache", w.Header().Get("cache-control"); want != have {
+			t.Errorf("%s: by path: cache-control: want %q, have %q", tc.name, want, have)
+		}
+	}
+
+	if want, have := http.StatusNotFound, get("/?asset=traces.html", nil).Code; want != have {
+		t.Errorf("traces.html: code: want %d, have %d", want, have)
+	}
+
+	// Standalone HTML still inlines the assets.
+	standalone, err := RenderSearchHTML(context.Background(), SearchData{Response: trc.SearchResponse{Stats: trc.NewSearchStats(nil)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{":root", "function toggleStacksFor"} {
+		if !strings.Contains(string(standalone), want) {
+			t.Errorf("standalone HTML doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(string(standalone), "?asset=") {
+		t.Errorf("standalone HTML links to assets")
+	}
+}
+
+func TestSearchDownload(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	for _, category := range []string{"foo", "bar"} {
+		_, tr := collector.NewTrace(context.Background(), category)
+		tr.Tracef("event in %s", category)
+		tr.Finish()
+	}
+	server := NewTraceServer(collector)
+
+	r := httptest.NewRequest("GET", "/?download&category=foo", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("code: want %d, have %d", want, have)
+	}
+	if want, have := "attachment; filename=", w.Header().Get("content-disposition"); !strings.HasPrefix(have, want) {
+		t.Errorf("content-disposition: want prefix %q, have %q", want, have)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"event in foo", "Exported ", ":root", "function toggleStacksFor"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("download doesn't contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{"event in bar", "?asset=", `id="search-form"`, `onclick="compareTrace(`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("download contains %q", unwanted)
+		}
+	}
+}
+
+func TestAssetScripts(t *testing.T) {
+	t.Parallel()
+
+	script, err := fs.ReadFile(assets.FS, "traces.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every template loads traces.js, which applies the selected theme, so
+	// that there's only one copy of that code. Every function called by an
+	// event handler attribute in a template must be defined, either in
+	// traces.js, or in an inline script of the template.
+	var (
+		handlerRe  = regexp.MustCompile(`\bon[a-z]+="\s*(?:return\s+)?([A-Za-z_$][A-Za-z0-9_$]*)\(`)
+		functionRe = regexp.MustCompile(`\bfunction\s+([A-Za-z_$][A-Za-z0-9_$]*)\s*\(`)
+		defined    = map[string]bool{}
+	)
+	for _, m := range functionRe.FindAllStringSubmatch(string(script), -1) {
+		defined[m[1]] = true
+	}
+
+	templates, err := fs.Glob(assets.FS, "*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range templates {
+		body, err := fs.ReadFile(assets.FS, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), `{{ template "traces.js" $ }}`) {
+			t.Errorf("%s: doesn't load traces.js", name)
+		}
+		if strings.Contains(string(body), "dataset.theme") {
+			t.Errorf("%s: applies the theme, rather than traces.js", name)
+		}
+		inline := map[string]bool{}
+		for _, m := range functionRe.FindAllStringSubmatch(string(body), -1) {
+			inline[m[1]] = true
+		}
+		for _, m := range handlerRe.FindAllStringSubmatch(string(body), -1) {
+			if fn := m[1]; !defined[fn] && !inline[fn] {
+				t.Errorf("%s: handler %s isn't defined", name, fn)
+			}
+		}
+	}
+
+	// The script must parse. There's no JavaScript parser in the standard
+	// library, so this uses node, if it's available.
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skipf("node not found, skipping syntax check of traces.js")
+	}
+	filename := filepath.Join(t.TempDir(), "traces.js")
+	if err := os.WriteFile(filename, script, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(node, "--check", filename).CombinedOutput(); err != nil {
+		t.Errorf("traces.js: %v\n%s", err, output)
+	}
+}
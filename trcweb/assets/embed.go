@@ -5,5 +5,5 @@ import "embed"
 
 // FS is the embedded FS of web assets.
 //
-//go:embed *.css *.html
+//go:embed *.css *.html *.js
 var FS embed.FS
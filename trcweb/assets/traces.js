@@ -0,0 +1,297 @@
+// Apply the selected theme, if any, before the page is rendered. Every page
+// loads this script in its head, so that it runs early enough.
+if (localStorage.getItem("theme")) {
+	document.documentElement.dataset.theme = localStorage.getItem("theme");
+}
+
+function toggleStacksFor(id) {
+	var anyOpen = false;
+	document.querySelectorAll(`div#trace-${id} .stack-details`).forEach(elem => {
+		anyOpen = anyOpen || elem.hasAttribute("open");
+	});
+	document.querySelectorAll(`div#trace-${id} .stack-details`).forEach(elem => {
+		elem.open = !anyOpen;
+	});
+}
+
+// compareTrace marks the trace for comparison. Once two traces are marked, the
+// comparison of those traces is opened.
+function compareTrace(id) {
+	let first = sessionStorage.getItem("compare");
+	if (first && first !== id) {
+		sessionStorage.removeItem("compare");
+		let params = new URLSearchParams();
+		let tenant = new URLSearchParams(window.location.search).get("tenant");
+		if (tenant) {
+			params.set("tenant", tenant);
+		}
+		params.append("compare", first);
+		params.append("compare", id);
+		window.location.search = params.toString();
+		return;
+	}
+	sessionStorage.setItem("compare", id);
+	document.querySelectorAll(".compare-link.marked").forEach(elem => elem.classList.remove("marked"));
+	document.getElementById(id + "-compare").classList.add("marked");
+}
+
+// pinTrace pins or unpins the trace, and reloads the page. It requires pin
+// requests to be enabled in the trace server.
+function pinTrace(id, pin) {
+	let params = new URLSearchParams();
+	let tenant = new URLSearchParams(window.location.search).get("tenant");
+	if (tenant) {
+		params.set("tenant", tenant);
+	}
+	params.set(pin ? "pin" : "unpin", id);
+	fetch("?" + params.toString(), { method: "POST" }).then(res => {
+		if (!res.ok) {
+			res.text().then(text => alert(`${pin ? "pin" : "unpin"} failed: ${text}`));
+			return;
+		}
+		window.location.reload();
+	});
+}
+
+function timeSince(s) {
+	var ts  = Date.parse(s);
+	var now = new Date();
+	var ms  = now - ts;
+
+	var days    = Math.floor(ms / (86400 * 1000)); if (days    > 0) { ms -= (days    * (86400 * 1000)) };
+	var hours   = Math.floor(ms / (3600  * 1000)); if (hours   > 0) { ms -= (hours   * (3600  * 1000)) };
+	var minutes = Math.floor(ms / (60    * 1000)); if (minutes > 0) { ms -= (minutes * (60    * 1000)) };
+	var seconds = Math.floor(ms / (1     * 1000)); if (seconds > 0) { ms -= (seconds * (1     * 1000)) };
+
+	switch (true) {
+		case    days > 0: return days+"d"    + hours+"h"   ;
+		case   hours > 0: return hours+"h"   + minutes+"m" ;
+		case minutes > 0: return minutes+"m" + seconds+"s" ;
+		case seconds > 0: return seconds+"s"               ;
+		case      ms > 0: return ms+"ms"                   ;
+		default:          return "fresh";
+	}
+}
+
+function calcDates() {
+	let timeSinces = document.getElementsByClassName("time-since");
+	for (let i = 0; i < timeSinces.length; i++) {
+		timeSinces[i].textContent = timeSince(timeSinces[i].title);
+	}
+}
+
+// Sorts the category rows of the summary table by the column containing
+// the given element. The overall row always stays last. Repeated clicks on
+// the same column reverse the direction. The choice is remembered for the
+// session, so it survives refreshes and new searches.
+function sortSummary(elem, direction) {
+	let column = elem.closest("th").cellIndex;
+	let stored = JSON.parse(sessionStorage.getItem("summary-sort") || "{}");
+	if (stored.column === column) {
+		direction = (stored.direction === "asc") ? "desc" : "asc";
+	}
+	sessionStorage.setItem("summary-sort", JSON.stringify({column: column, direction: direction}));
+	applySummarySort();
+}
+
+function applySummarySort() {
+	let stored = JSON.parse(sessionStorage.getItem("summary-sort") || "{}");
+	let table = document.getElementById("summary");
+	if (table == null || stored.column === undefined) {
+		return;
+	}
+
+	let rows = Array.from(table.querySelectorAll("tr.category"));
+	if (rows.length <= 2) {
+		return;
+	}
+
+	let overall = rows.pop();
+	let value = row => {
+		let cell = row.cells[stored.column];
+		return (cell == null) ? "" : (cell.dataset.sortValue || "");
+	};
+	let sign = (stored.direction === "asc") ? 1 : -1;
+	rows.sort((a, b) => {
+		let va = value(a), vb = value(b);
+		let na = parseFloat(va), nb = parseFloat(vb);
+		if (!isNaN(na) && !isNaN(nb)) {
+			return sign * (na - nb);
+		}
+		return sign * va.localeCompare(vb);
+	});
+	rows.forEach(row => overall.before(row));
+
+	table.querySelectorAll("th .sort-toggle").forEach(toggle => {
+		toggle.classList.remove("sort-asc", "sort-desc");
+		if (toggle.closest("th").cellIndex === stored.column) {
+			toggle.classList.add("sort-" + stored.direction);
+		}
+	});
+}
+
+// Saved searches are kept in local storage, as an array of {name, query}
+// objects, where query is a URL query string, without the leading "?". The
+// same array, wrapped in {"version": 1, "searches": [...]}, is the export
+// and import format, so searches can be shared between browsers.
+const savedSearchesKey = "saved-searches";
+
+function loadSearches() {
+	try {
+		let searches = JSON.parse(localStorage.getItem(savedSearchesKey) || "[]");
+		return Array.isArray(searches) ? searches : [];
+	} catch (e) {
+		return [];
+	}
+}
+
+function storeSearches(searches) {
+	localStorage.setItem(savedSearchesKey, JSON.stringify(searches));
+	renderSearches();
+}
+
+function renderSearches() {
+	let container = document.getElementById("saved-searches");
+	container.replaceChildren();
+	for (let search of loadSearches()) {
+		let row = document.createElement("div");
+		let link = document.createElement("a");
+		link.href = "?" + search.query;
+		link.textContent = search.name;
+		let remove = document.createElement("span");
+		remove.className = "saved-search-remove";
+		remove.title = "Remove saved search";
+		remove.textContent = " \u2715";
+		remove.onclick = function() { removeSearch(search.name); };
+		row.append(link, remove);
+		container.append(row);
+	}
+}
+
+function saveSearch() {
+	let query = document.getElementById("search-permalink").getAttribute("href").replace(/^\?/, "");
+	let name = window.prompt("Name for this search:", new URLSearchParams(query).get("q") || "search");
+	if (!name) {
+		return;
+	}
+	let searches = loadSearches().filter(s => s.name != name);
+	searches.push({name: name, query: query});
+	storeSearches(searches);
+}
+
+function removeSearch(name) {
+	storeSearches(loadSearches().filter(s => s.name != name));
+}
+
+function exportSearches() {
+	let blob = new Blob([JSON.stringify({version: 1, searches: loadSearches()}, null, 2)], {type: "application/json"});
+	let link = document.createElement("a");
+	link.href = URL.createObjectURL(blob);
+	link.download = "trc-saved-searches.json";
+	link.click();
+	URL.revokeObjectURL(link.href);
+}
+
+function importSearches(input) {
+	let file = input.files[0];
+	if (!file) {
+		return;
+	}
+	file.text().then(text => {
+		let imported = JSON.parse(text).searches || [];
+		let names = new Set(imported.map(s => s.name));
+		let searches = loadSearches().filter(s => !names.has(s.name));
+		for (let search of imported) {
+			if (typeof search.name == "string" && typeof search.query == "string") {
+				searches.push({name: search.name, query: search.query});
+			}
+		}
+		storeSearches(searches);
+	}).catch(err => {
+		window.alert("Import failed: " + err);
+	}).finally(() => {
+		input.value = "";
+	});
+}
+
+function hoverEvent(traceID, eventIndex) {
+	document.querySelectorAll(`
+		div#trace-${traceID} .event-timeline,
+		div#trace-${traceID} .event-${eventIndex},
+		div#trace-${traceID} .event-${eventIndex} .delta .progress-bar
+	`).forEach(elem => {
+		elem.classList.toggle("hover");
+	});
+}
+
+function loadTraces() {
+	let params = new URLSearchParams(window.location.search);
+	params.delete("stats_only");
+	window.location.search = params.toString();
+}
+
+function updateDebugInfo() {
+	let debugElem = document.getElementById("debug-info");
+	if (sessionStorage.getItem("debug-info")) {
+		debugElem.style.visibility = "inherit";
+	} else {
+		debugElem.style.visibility = "hidden";
+	}
+}
+
+function toggleDebug() {
+	if (sessionStorage.getItem("debug-info")) {
+		sessionStorage.removeItem("debug-info");
+	} else {
+		sessionStorage.setItem("debug-info", "true");
+	}
+	updateDebugInfo();
+}
+
+function toggleStacks() {
+	let selected = document.querySelector("div#traces .trace.selected");
+	if (selected != null) {
+		toggleStacksFor(selected.id.replace(/^trace-/, ""));
+		return;
+	}
+	var anyOpen = false;
+	document.querySelectorAll(`.stack-details`).forEach(elem => {
+		anyOpen = anyOpen || elem.hasAttribute("open");
+	})
+	document.querySelectorAll(`.stack-details`).forEach(elem => {
+		elem.open = !anyOpen;
+	})
+}
+
+function toggleTheme() {
+	let current = document.documentElement.dataset.theme;
+	if (!current) {
+		current = window.matchMedia("(prefers-color-scheme: dark)").matches ? "dark" : "light";
+	}
+	let next = (current === "dark") ? "light" : "dark";
+	document.documentElement.dataset.theme = next;
+	localStorage.setItem("theme", next);
+}
+
+// selectTrace moves the selection by delta traces, wrapping around.
+function selectTrace(delta) {
+	let traces = Array.from(document.querySelectorAll("div#traces .trace"));
+	if (traces.length <= 0) {
+		return;
+	}
+	let index = traces.findIndex(elem => elem.classList.contains("selected"));
+	if (index >= 0) {
+		traces[index].classList.remove("selected");
+		index = (index + delta + traces.length) % traces.length;
+	} else {
+		index = (delta > 0) ? 0 : traces.length - 1;
+	}
+	traces[index].classList.add("selected");
+	traces[index].scrollIntoView({block: "nearest"});
+}
+
+function clearSelectedTrace() {
+	document.querySelectorAll("div#traces .trace.selected").forEach(elem => {
+		elem.classList.remove("selected");
+	});
+}
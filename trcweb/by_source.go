@@ -0,0 +1,244 @@
+package trcweb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// SourceStats is the stats of the traces from a single source. Searches with
+// the by_source parameter include the stats of each source separately, in
+// addition to the merged stats of every source, so that they can be compared
+// side by side. Per-instance skew, e.g. a single instance with a high error
+// rate, is often hidden by merged totals. If a single searcher has more than
+// one source, e.g. a client of another trace server which aggregates several
+// instances, its sources are joined by commas.
+type SourceStats struct {
+	Source   string           `json:"source"`
+	Stats    *trc.SearchStats `json:"stats,omitempty"`
+	Problems []string         `json:"problems,omitempty"`
+}
+
+// SourceRow is a single category in the side-by-side layout, with a cell for
+// each source, in the same order as the sources in the search data.
+type SourceRow struct {
+	Category string       `json:"category"`
+	Cells    []SourceCell `json:"cells"`
+}
+
+// SourceCell is the stats of a category from a single source. Errored is the
+// percentage of finished traces which errored. A cell is skewed if its error
+// percentage differs from the median of all sources by at least 10 points, or
+// if its P99 duration is at least twice the median.
+type SourceCell struct {
+	Source      string        `json:"source"`
+	TotalCount  int           `json:"total_count"`
+	Errored     float64       `json:"errored"`
+	P99         time.Duration `json:"p99"`
+	HasP99      bool          `json:"has_p99"`
+	Rate        float64       `json:"rate"`
+	ErroredSkew bool          `json:"errored_skew,omitempty"`
+	P99Skew     bool          `json:"p99_skew,omitempty"`
+}
+
+const (
+	sourceStatsMax          = 16
+	sourceSkewErroredPoints = 10.0
+	sourceSkewP99Multiplier = 2.0
+)
+
+// searchBySource executes a stats-only search, with the bucketing of the given
+// request, against each of the searchers which make up the searcher of the
+// trace server, concurrently, so that their stats can be compared. The trace
+// server searcher is typically a [trc.MultiSearcher] of collectors, or of
+// clients of other trace servers, one per instance. Stats always cover every
+// trace, so the only filter condition which applies is the sources, which
+// select the searchers to compare. Fewer than two searchers can't be compared,
+// and produce no stats.
+func (s *TraceServer) searchBySource(ctx context.Context, req trc.SearchRequest) ([]SourceStats, []error) {
+	searchers := flattenSearchers(s.Searcher)
+	if len(searchers) < 2 {
+		return nil, nil
+	}
+
+	var problems []error
+	if len(searchers) > sourceStatsMax {
+		problems = append(problems, fmt.Errorf("too many sources to compare (%d), comparing the first %d", len(searchers), sourceStatsMax))
+		searchers = searchers[:sourceStatsMax]
+	}
+
+	var (
+		results = make([]SourceStats, len(searchers))
+		sources = make([][]string, len(searchers))
+		done    = make(chan struct{}, len(searchers))
+	)
+	for i, searcher := range searchers {
+		go func(i int, searcher trc.Searcher) {
+			defer func() { done <- struct{}{} }()
+
+			req := trc.SearchRequest{Bucketing: req.Bucketing, StatsOnly: true}
+			req.Normalize()
+
+			res, err := searcher.Search(ctx, &req)
+			if err != nil {
+				results[i].Source = fmt.Sprintf("searcher %d", i+1)
+				results[i].Problems = []string{fmt.Sprintf("execute stats request: %v", err)}
+				return
+			}
+			sources[i] = res.Sources
+			results[i].Source = strings.Join(res.Sources, ",")
+			results[i].Stats = res.Stats
+			results[i].Problems = res.Problems
+		}(i, searcher)
+	}
+	for range searchers {
+		<-done
+	}
+
+	selected := results[:0]
+	for i, result := range results {
+		if isSelectedSource(req.Filter.Sources, sources[i]) {
+			selected = append(selected, result)
+		}
+	}
+	if len(selected) < 2 {
+		return nil, problems
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Source < selected[j].Source })
+	return selected, problems
+}
+
+// flattenSearchers returns the searchers which make up the searcher, which are
+// the searchers of a [trc.MultiSearcher], recursively, or the searcher itself.
+func flattenSearchers(searcher trc.Searcher) []trc.Searcher {
+	ms, ok := searcher.(trc.MultiSearcher)
+	if !ok {
+		return []trc.Searcher{searcher}
+	}
+	var searchers []trc.Searcher
+	for _, s := range ms {
+		searchers = append(searchers, flattenSearchers(s)...)
+	}
+	return searchers
+}
+
+// isSelectedSource returns true if any of the sources of a searcher are in the
+// selected sources, or if there are no selected sources, i.e. all sources. A
+// searcher whose sources are unknown, because its search failed, is always
+// selected, so that its problems are reported.
+func isSelectedSource(selected, sources []string) bool {
+	if len(selected) <= 0 || len(sources) <= 0 {
+		return true
+	}
+	for _, source := range sources {
+		for _, candidate := range selected {
+			if source == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SourceRows returns a row for every category in the merged stats of the
+// response, and the synthetic overall category, with the stats of each source
+// side by side. It returns nil if the search data has no per-source stats.
+func (d SearchData) SourceRows() []SourceRow {
+	if len(d.SourceStats) <= 0 || d.Response.Stats == nil {
+		return nil
+	}
+
+	var rows []SourceRow
+	for _, merged := range d.Response.Stats.AllCategories() {
+		row := SourceRow{Category: merged.Category}
+		for _, ss := range d.SourceStats {
+			var cs *trc.CategoryStats
+			switch {
+			case ss.Stats == nil:
+				cs = nil
+			case merged.Category == "overall":
+				cs = ss.Stats.Overall()
+			default:
+				cs = ss.Stats.Categories[merged.Category]
+			}
+			row.Cells = append(row.Cells, newSourceCell(ss, cs))
+		}
+		markSourceSkew(row.Cells)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func newSourceCell(ss SourceStats, cs *trc.CategoryStats) SourceCell {
+	cell := SourceCell{Source: ss.Source}
+	if cs == nil {
+		return cell
+	}
+
+	var success int
+	if len(cs.BucketCounts) > 0 {
+		success = cs.BucketCounts[0]
+	}
+
+	cell.TotalCount = cs.TotalCount()
+	cell.Rate = cs.TraceRate()
+	if finished := success + cs.ErroredCount; finished > 0 {
+		cell.Errored = 100 * float64(cs.ErroredCount) / float64(finished)
+	}
+	if success > 0 {
+		cell.P99 = cs.Quantile(ss.Stats.Bucketing, 0.99)
+		cell.HasP99 = true
+	}
+	return cell
+}
+
+// markSourceSkew marks the cells which differ significantly from the median of
+// the cells with traces. Cells without traces are never skewed, and don't
+// affect the median.
+func markSourceSkew(cells []SourceCell) {
+	var errored, p99s []float64
+	for _, cell := range cells {
+		if cell.TotalCount > 0 {
+			errored = append(errored, cell.Errored)
+		}
+		if cell.HasP99 {
+			p99s = append(p99s, float64(cell.P99))
+		}
+	}
+	if len(errored) < 2 {
+		return
+	}
+
+	var (
+		erroredMedian = median(errored)
+		p99Median     = median(p99s)
+	)
+	for i, cell := range cells {
+		if cell.TotalCount <= 0 {
+			continue
+		}
+		if diff := cell.Errored - erroredMedian; diff >= sourceSkewErroredPoints || -diff >= sourceSkewErroredPoints {
+			cells[i].ErroredSkew = true
+		}
+		if cell.HasP99 && len(p99s) >= 2 && p99Median > 0 && float64(cell.P99) >= sourceSkewP99Multiplier*p99Median {
+			cells[i].P99Skew = true
+		}
+	}
+}
+
+func median(vs []float64) float64 {
+	if len(vs) <= 0 {
+		return 0
+	}
+	vs = append([]float64(nil), vs...)
+	sort.Float64s(vs)
+	if n := len(vs); n%2 == 0 {
+		return (vs[n/2-1] + vs[n/2]) / 2
+	}
+	return vs[len(vs)/2]
+}
@@ -0,0 +1,83 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestBySource(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		healthy = trc.NewCollector(trc.CollectorConfig{Source: "healthy"})
+		broken  = trc.NewCollector(trc.CollectorConfig{Source: "broken"})
+		other   = trc.NewCollector(trc.CollectorConfig{Source: "other"})
+	)
+	for _, c := range []*trc.Collector{healthy, broken, other} {
+		for i := 0; i < 10; i++ {
+			_, tr := c.NewTrace(ctx, "api")
+			if c == broken {
+				tr.Errorf("failed")
+			}
+			tr.Finish()
+		}
+	}
+
+	server := &TraceServer{Searcher: trc.MultiSearcher{healthy, broken, other}}
+	get := func(query, accept string) string {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	if body := get("", "text/html"); strings.Contains(body, `<table id="by-source">`) {
+		t.Errorf("by source table without by_source")
+	}
+
+	body := get("by_source=true", "text/html")
+	for _, want := range []string{
+		`<table id="by-source">`,
+		`<input type="checkbox" name="by_source" value="true" checked />`,
+		`title="Only traces from broken">broken</a>`,
+		`<td class="errored skew" title="broken: 100.0% errored">100.0%</td>`,
+		`<td class="errored" title="healthy: 0.0% errored">0.0%</td>`,
+		`by_source=true`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+
+	var data SearchData
+	if err := json.Unmarshal([]byte(get("by_source=true&source=healthy&source=broken", "application/json")), &data); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	var sources []string
+	for _, ss := range data.SourceStats {
+		sources = append(sources, ss.Source)
+		if ss.Stats == nil || ss.Stats.Overall().TotalCount() != 10 {
+			t.Errorf("%s: want 10 traces, have %+v", ss.Source, ss.Stats)
+		}
+	}
+	if want, have := "broken healthy", strings.Join(sources, " "); want != have {
+		t.Errorf("sources: want %q, have %q", want, have)
+	}
+
+	single := NewTraceServer(healthy)
+	r := httptest.NewRequest("GET", "/?by_source=true", nil)
+	r.Header.Set("accept", "text/html")
+	w := httptest.NewRecorder()
+	single.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), `<table id="by-source">`) {
+		t.Errorf("by source table with a single source")
+	}
+
+}
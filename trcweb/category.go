@@ -0,0 +1,72 @@
+package trcweb
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+
+	"github.com/peterbourgon/trc"
+)
+
+// CategoryDisplay customizes how a category is shown in the web interface. See
+// [TraceServer.Categories].
+type CategoryDisplay struct {
+	// Label is shown in place of the category name, which is still used for
+	// links and searches. Optional.
+	Label string
+
+	// Color is a CSS color, either a hex code like #c0ffee, or a name like
+	// teal, which marks the category in the summary table and trace list.
+	// Other forms, e.g. rgb(...), are ignored. Optional.
+	Color string
+}
+
+// CategoryLabel returns the display label of the category, or the category
+// itself if there's no label.
+func (d SearchData) CategoryLabel(category string) string {
+	if cd, ok := d.Categories[category]; ok && cd.Label != "" {
+		return cd.Label
+	}
+	return category
+}
+
+// CategoryColor returns the display color of the category, or an empty string
+// if there's no valid color.
+func (d SearchData) CategoryColor(category string) template.CSS {
+	if cd, ok := d.Categories[category]; ok && categoryColorRegexp.MatchString(cd.Color) {
+		return template.CSS(cd.Color)
+	}
+	return ""
+}
+
+var categoryColorRegexp = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// CategoriesData is returned by categories requests, i.e. requests with a
+// categories query parameter. It lists the categories in the collector, which
+// is much cheaper than a search, and is meant for e.g. autocompletion.
+type CategoriesData struct {
+	Tenant     string             `json:"tenant,omitempty"`
+	Categories []trc.CategoryInfo `json:"categories"`
+}
+
+func (s *TraceServer) handleCategories(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		tr  = trc.Get(ctx)
+	)
+
+	if s.CategoryLister == nil {
+		tr.Errorf("categories: not supported")
+		http.Error(w, "categories not supported", http.StatusNotImplemented)
+		return
+	}
+
+	data := CategoriesData{
+		Tenant:     s.tenant,
+		Categories: s.CategoryLister.Categories(),
+	}
+
+	tr.LazyTracef("categories: %d", len(data.Categories))
+
+	renderJSON(ctx, w, r, data)
+}
@@ -0,0 +1,103 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestCategoryDisplay(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	for _, category := range []string{"api", "db", "cache"} {
+		_, tr := collector.NewTrace(context.Background(), category)
+		tr.Finish()
+	}
+
+	server := NewTraceServer(collector)
+	server.Categories = map[string]CategoryDisplay{
+		"api":   {Label: "Public API", Color: "#c0ffee"},
+		"db":    {Color: "teal"},
+		"cache": {Label: "Cache", Color: "red; background: url(evil)"},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("accept", "text/html")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`title="api">Public API</a>`,
+		`border-left: 4px solid #c0ffee;`,
+		`border-left: 4px solid teal;`,
+		`title="cache">Cache</a>`,
+		`category=db">db</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+
+	for _, notWant := range []string{"evil", "ZgotmplZ"} {
+		if strings.Contains(body, notWant) {
+			t.Errorf("response contains %q", notWant)
+		}
+	}
+}
+
+func TestCategories(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	for _, category := range []string{"api", "db", "api"} {
+		_, tr := collector.NewTrace(context.Background(), category)
+		tr.Finish()
+	}
+
+	server := NewTraceServer(collector)
+	server.Categories = map[string]CategoryDisplay{"api": {Label: "Public API"}}
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	var data CategoriesData
+	if err := json.NewDecoder(get("categories", "application/json").Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	var have []string
+	for _, info := range data.Categories {
+		have = append(have, fmt.Sprintf("%s=%d", info.Category, info.Count))
+	}
+	if want, have := "api=2 db=1", strings.Join(have, " "); want != have {
+		t.Errorf("categories: want %q, have %q", want, have)
+	}
+
+	body := get("category=db", "text/html").Body.String()
+	for _, want := range []string{
+		`<option value="api" >Public API</option>`,
+		`<option value="db" selected>db</option>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+
+	searchOnly := &TraceServer{Searcher: collector}
+	w := httptest.NewRecorder()
+	searchOnly.ServeHTTP(w, httptest.NewRequest("GET", "/?categories", nil))
+	if want, have := http.StatusNotImplemented, w.Code; want != have {
+		t.Errorf("without a collector: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/peterbourgon/trc/trcweb/chitrc
+
+go 1.21
+
+require (
+	github.com/go-chi/chi/v5 v5.2.1
+	github.com/peterbourgon/trc v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/bernerdschaefer/eventsource v0.0.0-20130606115634-220e99a79763 // indirect
+	github.com/oklog/ulid/v2 v2.1.0 // indirect
+)
+
+replace github.com/peterbourgon/trc => ../..
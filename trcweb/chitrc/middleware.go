@@ -0,0 +1,48 @@
+// Package chitrc provides an HTTP middleware for chi routers which creates a
+// trace for each request, categorized by route pattern.
+//
+// It's a separate module, so that the trc module doesn't depend on chi.
+package chitrc
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+// Middleware returns a chi middleware which creates a trace for each request
+// served by the router, as configured, see [trcweb.NewMiddleware]. The
+// response code is always recorded in the trace.
+//
+// If cfg doesn't provide Categorize or CategorizeTags, the trace category is
+// the method and route pattern of the request, see [Categorize]. The
+// middleware should be installed on the router it's given, typically via
+// [chi.Router.Use].
+func Middleware(router chi.Routes, cfg trcweb.MiddlewareConfig) func(http.Handler) http.Handler {
+	if cfg.Categorize == nil && cfg.CategorizeTags == nil {
+		cfg.Categorize = Categorize(router)
+	}
+	return trcweb.NewMiddleware(cfg)
+}
+
+// Categorize returns a categorize function which returns the method and the
+// route pattern matching the request in the router, e.g. "GET /users/{id}",
+// or "unmatched" if no route matches.
+//
+// Middlewares installed via [chi.Router.Use] are called before the request
+// is routed, so the pattern is found by matching the request against the
+// router directly.
+func Categorize(router chi.Routes) func(*http.Request) string {
+	return func(r *http.Request) string {
+		path := r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+		pattern := router.Find(chi.NewRouteContext(), r.Method, path)
+		if pattern == "" {
+			return "unmatched"
+		}
+		return r.Method + " " + pattern
+	}
+}
@@ -0,0 +1,86 @@
+package chitrc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+	"github.com/peterbourgon/trc/trcweb/chitrc"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	router := chi.NewRouter()
+	router.Use(chitrc.Middleware(router, trcweb.MiddlewareConfig{Constructor: collector.NewTrace}))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	router.Route("/api", func(r chi.Router) {
+		r.Post("/items/{item}/tags", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "conflict", http.StatusConflict)
+		})
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		category     string
+		code         string
+	}{
+		{"GET", "/users/123", "GET /users/{id}", "HTTP 202"},
+		{"POST", "/api/items/abc/tags", "POST /api/items/{item}/tags", "HTTP 409"},
+		{"GET", "/nope", "unmatched", "HTTP 404"},
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+
+		res, err := collector.Search(context.Background(), &trc.SearchRequest{
+			Filter: trc.Filter{Category: tc.category},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s %s: category %q: want %d trace(s), have %d", tc.method, tc.path, tc.category, want, have)
+		}
+
+		var whats []string
+		for _, ev := range res.Traces[0].Events() {
+			whats = append(whats, ev.What)
+		}
+		if all := strings.Join(whats, "\n"); !strings.Contains(all, tc.code) {
+			t.Errorf("%s %s: missing %q:\n%s", tc.method, tc.path, tc.code, all)
+		}
+	}
+}
+
+func TestMiddlewareCategorize(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	router := chi.NewRouter()
+	router.Use(chitrc.Middleware(router, trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(*http.Request) string { return "custom" },
+	}))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{
+		Filter: trc.Filter{Category: "custom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("want %d trace(s), have %d", want, have)
+	}
+}
@@ -0,0 +1,248 @@
+package trcweb
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// CompareData is returned by compare requests, i.e. requests with exactly two
+// compare query parameters, each identifying a trace. The events of the two
+// traces are aligned by their text, so that e.g. a slow request can be compared
+// against a fast one, step by step.
+type CompareData struct {
+	Tenant    string           `json:"tenant,omitempty"`
+	IDs       []string         `json:"ids"`
+	A         *trc.StaticTrace `json:"a,omitempty"`
+	B         *trc.StaticTrace `json:"b,omitempty"`
+	Rows      []CompareRow     `json:"rows,omitempty"`
+	Truncated bool             `json:"truncated,omitempty"` // see compareEventsMax
+	Problems  []error          `json:"-"`                   // for rendering, not transmitting
+}
+
+// CompareRow is a single row of a trace comparison. Events with the same text
+// in both traces are aligned into the same row. Events which exist in only one
+// of the traces have a nil event for the other trace.
+type CompareRow struct {
+	What string        `json:"what"`
+	A    *CompareEvent `json:"a,omitempty"`
+	B    *CompareEvent `json:"b,omitempty"`
+
+	// Slower is "a" or "b" if both traces have the event, and the time taken
+	// to reach it from the previous event differs significantly between them.
+	Slower string `json:"slower,omitempty"`
+}
+
+// OffsetDelta returns how much later the event occurred in trace B than in trace
+// A, relative to the start of each trace, or zero if either event is missing.
+func (row CompareRow) OffsetDelta() time.Duration {
+	if row.A == nil || row.B == nil {
+		return 0
+	}
+	return row.B.Offset - row.A.Offset
+}
+
+// CompareEvent is the timing of an event in one of the compared traces.
+type CompareEvent struct {
+	Offset  time.Duration `json:"offset"` // since the start of the trace
+	Delta   time.Duration `json:"delta"`  // since the previous event
+	IsError bool          `json:"is_error,omitempty"`
+}
+
+const (
+	// compareSlowerFactor is how many times longer one delta must be than the
+	// other for the row to be flagged as slower.
+	compareSlowerFactor = 2
+
+	// compareSlowerMin is the minimum absolute difference between deltas for
+	// the row to be flagged as slower, to avoid flagging noise.
+	compareSlowerMin = time.Millisecond
+
+	// compareEventsMax is the max number of events of each trace which are
+	// aligned. Alignment takes time and space proportional to the product of
+	// the number of events in each trace, so later events are dropped.
+	compareEventsMax = 500
+)
+
+func (s *TraceServer) handleCompare(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx  = r.Context()
+		tr   = trc.Get(ctx)
+		data = CompareData{Tenant: s.tenant, IDs: r.URL.Query()["compare"]}
+	)
+
+	if len(data.IDs) != 2 || data.IDs[0] == "" || data.IDs[1] == "" {
+		http.Error(w, "bad request: compare requires exactly two trace IDs", http.StatusBadRequest)
+		return
+	}
+
+	tr.LazyTracef("compare %s vs. %s", data.IDs[0], data.IDs[1])
+
+	req := &trc.SearchRequest{
+		Filter:     trc.Filter{IDs: data.IDs},
+		Limit:      trc.SearchLimitMax,
+		StackDepth: -1,
+	}
+	res, err := s.Searcher.Search(ctx, req)
+	if err != nil {
+		data.Problems = append(data.Problems, fmt.Errorf("execute search: %w", err))
+	} else {
+		data.A = findCompareTrace(res.Traces, data.IDs[0])
+		data.B = findCompareTrace(res.Traces, data.IDs[1])
+	}
+
+	for i, st := range []*trc.StaticTrace{data.A, data.B} {
+		if st == nil {
+			data.Problems = append(data.Problems, fmt.Errorf("trace %s not found", data.IDs[i]))
+		}
+	}
+
+	if data.A != nil && data.B != nil {
+		data.Rows, data.Truncated = compareTraces(data.A, data.B)
+		if data.Truncated {
+			data.Problems = append(data.Problems, fmt.Errorf("only the first %d events of each trace are compared", compareEventsMax))
+		}
+	}
+
+	renderResponse(ctx, w, r, assetsFS(s.Assets), "compare.html", s.assetFuncs(ctx), data)
+}
+
+// compareLabel pairs a trace with a label, and the tenant query parameters, if
+// any, for the compare-trace template.
+func compareLabel(label string, tr trc.Trace, tenantParams template.URL) any {
+	return struct {
+		Label        string
+		Trace        trc.Trace
+		TenantParams template.URL
+	}{label, tr, tenantParams}
+}
+
+func signedDuration(d time.Duration) string {
+	if d < 0 {
+		return "-" + trcutil.HumanizeDuration(-d)
+	}
+	return "+" + trcutil.HumanizeDuration(d)
+}
+
+// findCompareTrace returns the trace with the given ID, or, if there's no exact
+// match, the first trace matching the ID, e.g. as a display ID.
+func findCompareTrace(traces []*trc.StaticTrace, id string) *trc.StaticTrace {
+	for _, st := range traces {
+		if st.ID() == id {
+			return st
+		}
+	}
+	f := trc.Filter{IDs: []string{id}}
+	for _, st := range traces {
+		if f.Allow(st) {
+			return st
+		}
+	}
+	return nil
+}
+
+// compareTraces aligns the events of the two traces by their text, via the
+// longest common subsequence, and returns one row per aligned pair of events,
+// or per event that exists in only one trace. Only the first compareEventsMax
+// events of each trace are aligned, and truncated is true if any were dropped.
+func compareTraces(a, b trc.Trace) (rows []CompareRow, truncated bool) {
+	var (
+		eva = compareEvents(a)
+		evb = compareEvents(b)
+	)
+	if len(eva) > compareEventsMax {
+		eva, truncated = eva[:compareEventsMax], true
+	}
+	if len(evb) > compareEventsMax {
+		evb, truncated = evb[:compareEventsMax], true
+	}
+
+	// The table of LCS lengths is stored in a single slice, where lcs(i, j) is
+	// the length for eva[i:] and evb[j:]. Lengths are at most compareEventsMax,
+	// so they fit in a uint16.
+	var (
+		na    = len(eva)
+		nb    = len(evb)
+		table = make([]uint16, (na+1)*(nb+1))
+		lcs   = func(i, j int) uint16 { return table[i*(nb+1)+j] }
+	)
+	for i := na - 1; i >= 0; i-- {
+		for j := nb - 1; j >= 0; j-- {
+			var n uint16
+			switch {
+			case eva[i].what == evb[j].what:
+				n = lcs(i+1, j+1) + 1
+			case lcs(i+1, j) >= lcs(i, j+1):
+				n = lcs(i+1, j)
+			default:
+				n = lcs(i, j+1)
+			}
+			table[i*(nb+1)+j] = n
+		}
+	}
+
+	rows = make([]CompareRow, 0, na+nb)
+	for i, j := 0, 0; i < na || j < nb; {
+		switch {
+		case i < na && j < nb && eva[i].what == evb[j].what:
+			rows = append(rows, CompareRow{
+				What:   eva[i].what,
+				A:      &eva[i].CompareEvent,
+				B:      &evb[j].CompareEvent,
+				Slower: compareSlower(eva[i].Delta, evb[j].Delta),
+			})
+			i++
+			j++
+		case j >= nb || (i < na && lcs(i+1, j) >= lcs(i, j+1)):
+			rows = append(rows, CompareRow{What: eva[i].what, A: &eva[i].CompareEvent})
+			i++
+		default:
+			rows = append(rows, CompareRow{What: evb[j].what, B: &evb[j].CompareEvent})
+			j++
+		}
+	}
+	return rows, truncated
+}
+
+type compareEvent struct {
+	CompareEvent
+	what string
+}
+
+func compareEvents(tr trc.Trace) []compareEvent {
+	var (
+		events = tr.Events()
+		result = make([]compareEvent, 0, len(events))
+		start  = tr.Started()
+		prev   time.Duration
+	)
+	for _, ev := range events {
+		offset := ev.OffsetFrom(start)
+		result = append(result, compareEvent{
+			CompareEvent: CompareEvent{
+				Offset:  offset,
+				Delta:   offset - prev,
+				IsError: ev.IsError,
+			},
+			what: strings.TrimSuffix(ev.What, "\n"),
+		})
+		prev = offset
+	}
+	return result
+}
+
+func compareSlower(a, b time.Duration) string {
+	switch {
+	case a-b >= compareSlowerMin && a >= compareSlowerFactor*b:
+		return "a"
+	case b-a >= compareSlowerMin && b >= compareSlowerFactor*a:
+		return "b"
+	default:
+		return ""
+	}
+}
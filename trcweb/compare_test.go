@@ -0,0 +1,158 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestCompareTraces(t *testing.T) {
+	t.Parallel()
+
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		ms    = func(n int) time.Time { return start.Add(time.Duration(n) * time.Millisecond) }
+		a     = &trc.StaticTrace{TraceStarted: start, TraceEvents: []trc.Event{
+			{What: "parse", When: ms(1)},
+			{What: "query", When: ms(3)},
+			{What: "cache hit", When: ms(4)},
+			{What: "render", When: ms(5)},
+		}}
+		b = &trc.StaticTrace{TraceStarted: start, TraceEvents: []trc.Event{
+			{What: "parse", When: ms(1)},
+			{What: "query", When: ms(50)},
+			{What: "cache miss", When: ms(51)},
+			{What: "render", When: ms(52)},
+		}}
+	)
+
+	var (
+		rows, _    = compareTraces(a, b)
+		haveWhat   []string
+		haveSlower []string
+	)
+	for _, row := range rows {
+		side := "ab"
+		switch {
+		case row.A == nil:
+			side = "b"
+		case row.B == nil:
+			side = "a"
+		}
+		haveWhat = append(haveWhat, side+":"+row.What)
+		haveSlower = append(haveSlower, row.Slower)
+	}
+
+	if want := []string{"ab:parse", "ab:query", "a:cache hit", "b:cache miss", "ab:render"}; !reflect.DeepEqual(want, haveWhat) {
+		t.Errorf("rows: want %v, have %v", want, haveWhat)
+	}
+	if want := []string{"", "b", "", "", ""}; !reflect.DeepEqual(want, haveSlower) {
+		t.Errorf("slower: want %q, have %q", want, haveSlower)
+	}
+	if want, have := 47*time.Millisecond, rows[len(rows)-1].OffsetDelta(); want != have {
+		t.Errorf("render offset delta: want %s, have %s", want, have)
+	}
+}
+
+func TestCompareTracesLarge(t *testing.T) {
+	t.Parallel()
+
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		n     = 10000
+		a     = &trc.StaticTrace{TraceStarted: start, TraceEvents: make([]trc.Event, n)}
+		b     = &trc.StaticTrace{TraceStarted: start, TraceEvents: make([]trc.Event, n)}
+	)
+	for i := 0; i < n; i++ {
+		a.TraceEvents[i] = trc.Event{What: fmt.Sprintf("event %d", i), When: start.Add(time.Duration(i) * time.Millisecond)}
+		b.TraceEvents[i] = trc.Event{What: fmt.Sprintf("event %d", n-i), When: start.Add(time.Duration(i) * time.Millisecond)}
+	}
+
+	rows, truncated := compareTraces(a, b)
+	if !truncated {
+		t.Errorf("truncated: want true, have false")
+	}
+
+	// No events are common to the first compareEventsMax of each trace, so
+	// every one of them is a row, and no later event is.
+	if want, have := 2*compareEventsMax, len(rows); want != have {
+		t.Errorf("rows: want %d, have %d", want, have)
+	}
+	for _, row := range rows {
+		var index int
+		fmt.Sscanf(row.What, "event %d", &index)
+		if row.A != nil && index >= compareEventsMax || row.B != nil && index <= n-compareEventsMax {
+			t.Fatalf("row %q: beyond the first %d events", row.What, compareEventsMax)
+		}
+	}
+
+	// Identical traces are still aligned completely, up to the max.
+	rows, _ = compareTraces(a, a)
+	if want, have := compareEventsMax, len(rows); want != have {
+		t.Errorf("identical rows: want %d, have %d", want, have)
+	}
+	for _, row := range rows {
+		if row.A == nil || row.B == nil {
+			t.Fatalf("identical row %q: not aligned", row.What)
+		}
+	}
+}
+
+func TestCompareHandler(t *testing.T) {
+	t.Parallel()
+
+	var (
+		collector = trc.NewDefaultCollector()
+		server    = NewTraceServer(collector)
+		_, tr1    = collector.NewTrace(context.Background(), "foo")
+		_, tr2    = collector.NewTrace(context.Background(), "foo")
+	)
+	tr1.Tracef("common")
+	tr1.Finish()
+	tr2.Tracef("common")
+	tr2.Errorf("only in tr2")
+	tr2.Finish()
+
+	get := func(query string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if want, have := http.StatusBadRequest, get("compare="+tr1.ID(), "application/json").Code; want != have {
+		t.Errorf("one ID: want %d, have %d", want, have)
+	}
+
+	w := get("compare="+tr1.ID()+"&compare="+trc.DisplayID(tr2.ID()), "application/json")
+	var data CompareData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if data.A == nil || data.B == nil {
+		t.Fatalf("want both traces, have A=%v B=%v", data.A, data.B)
+	}
+	if want, have := tr2.ID(), data.B.ID(); want != have {
+		t.Errorf("B: want %s, have %s", want, have)
+	}
+	if want, have := 2, len(data.Rows); want != have {
+		t.Errorf("rows: want %d, have %d", want, have)
+	}
+
+	w = get("compare="+tr1.ID()+"&compare="+tr2.ID(), "text/html")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("HTML: want %d, have %d", want, have)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "B only:") || !strings.Contains(body, "only in tr2") {
+		t.Errorf("HTML: missing B-only event in %s", body)
+	}
+}
@@ -0,0 +1,100 @@
+package trcweb
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// NewDebugMux returns an HTTP handler which serves the full suite of
+// diagnostics for the collector, as well as the Go runtime, with the following
+// routes.
+//
+//	/traces         trace server, see [TraceServer]
+//	/stream         trace server, always streaming, see [TraceServer]
+//	/debug/pprof/   runtime profiles, see [net/http/pprof]
+//	/debug/trc      package trc pool counters, and collector stats and counters, as text
+//	/healthz        collector health, as JSON, see [HealthHandler]
+//
+// The health check reports the collector as unhealthy if any stream subscriber
+// is saturated, or if the most recent search took longer than 5 seconds.
+//
+// The handler is typically mounted on an internal or debug HTTP server.
+func NewDebugMux(c *trc.Collector) *http.ServeMux {
+	traceServer := NewTraceServer(c)
+
+	mux := http.NewServeMux()
+	mux.Handle("/traces", traceServer)
+	mux.Handle("/stream", streamHandler(traceServer))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/trc", debugHandler(c))
+	mux.Handle("/healthz", HealthHandler(c, HealthThresholds{MaxSubscriberSaturation: 1, MaxSearchDuration: 5 * time.Second}))
+	return mux
+}
+
+// streamHandler serves every request as a stream request, even if the client
+// doesn't explicitly accept text/event-stream, e.g. curl.
+func streamHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requestExplicitlyAccepts(r, "text/event-stream") {
+			r = r.Clone(r.Context())
+			r.Header.Set("accept", "text/event-stream")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// debugHandler serves the package trc debug info, followed by stats for each
+// category in the collector, and cumulative collector counters.
+func debugHandler(c *trc.Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bytes.Buffer{}
+		buf.WriteString(debugInfo())
+
+		res, err := c.Search(r.Context(), &trc.SearchRequest{StatsOnly: true})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(buf, "\nsources: %v\n\n", res.Sources)
+
+		tw := tabwriter.NewWriter(buf, 0, 2, 2, ' ', 0)
+		fmt.Fprintf(tw, "CATEGORY\tACTIVE\tSUCCESS\tERRORED\tTOTAL\tRATE\n")
+		for _, cs := range res.Stats.AllCategories() {
+			var success int
+			if len(cs.BucketCounts) > 0 {
+				success = cs.BucketCounts[0]
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s/s\n", cs.Category, cs.ActiveCount, success, cs.ErroredCount, cs.TotalCount(), trcutil.HumanizeFloat(cs.TraceRate()))
+		}
+		tw.Flush()
+
+		cstats := c.Stats()
+		since := time.Since(cstats.Since)
+		fmt.Fprintf(buf, "\ncollector counters, since %s (%s ago)\n\n", cstats.Since.Format(time.RFC3339), trcutil.HumanizeDuration(since))
+
+		tw = tabwriter.NewWriter(buf, 0, 2, 2, ' ', 0)
+		fmt.Fprintf(tw, "CATEGORY\tCREATED\tFINISHED\tSAMPLED\tEVICTED\tEVICTIONS\tRETAINED\tCAPACITY\n")
+		for _, cs := range cstats.Categories {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s/s\t%d\t%d\n", cs.Category, cs.Created, cs.Finished, cs.Sampled, cs.Evicted, trcutil.HumanizeFloat(cs.EvictionRate(since)), cs.Retained, cs.Capacity)
+		}
+		tw.Flush()
+
+		fmt.Fprintf(buf, "\nstream subscribers: %d (%d saturated, %d evicted)\n", cstats.Streams.Subscribers, cstats.Streams.Saturated, cstats.Streams.Evictions)
+		fmt.Fprintf(buf, "last search duration: %s\n", trcutil.HumanizeDuration(cstats.LastSearchDuration))
+
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+		buf.WriteTo(w)
+	})
+}
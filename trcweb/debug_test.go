@@ -0,0 +1,50 @@
+package trcweb_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestDebugMux(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	_, tr := collector.NewTrace(context.Background(), "my-category")
+	tr.Tracef("hello")
+	tr.Finish()
+
+	server := httptest.NewServer(trcweb.NewDebugMux(collector))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"/traces?n=1", "my-category"},
+		{"/debug/pprof/", "goroutine"},
+		{"/debug/trc", "my-category"},
+		{"/debug/trc", "coreTrace"},
+		{"/healthz", `"healthy": true`},
+	} {
+		res, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if want, have := http.StatusOK, res.StatusCode; want != have {
+			t.Errorf("%s: status: want %d, have %d", tc.path, want, have)
+		}
+		if !strings.Contains(string(body), tc.want) {
+			t.Errorf("%s: body doesn't contain %q", tc.path, tc.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package trcweb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// Discover returns the URIs of the trace servers identified by the given spec,
+// which can take one of the following forms.
+//
+//	dns:name  SRV records for the name, e.g. trc._tcp.service.consul
+//	file:path a file with a JSON array of URIs, or one URI per line
+//
+// URIs discovered via DNS are of the form http://target:port, without a path.
+// Lines in files which are empty, or which begin with #, are ignored. The
+// returned URIs are sorted and de-duplicated.
+func Discover(ctx context.Context, spec string) ([]string, error) {
+	return discover(ctx, spec, net.DefaultResolver.LookupSRV, os.ReadFile)
+}
+
+type (
+	lookupSRVFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	readFileFunc  func(name string) ([]byte, error)
+)
+
+func discover(ctx context.Context, spec string, lookupSRV lookupSRVFunc, readFile readFileFunc) ([]string, error) {
+	var (
+		uris []string
+		err  error
+	)
+	switch {
+	case strings.HasPrefix(spec, "dns:"):
+		uris, err = discoverDNS(ctx, strings.TrimPrefix(spec, "dns:"), lookupSRV)
+	case strings.HasPrefix(spec, "file:"):
+		uris, err = discoverFile(strings.TrimPrefix(spec, "file:"), readFile)
+	default:
+		return nil, fmt.Errorf("%s: unsupported discovery spec, must be dns:name or file:path", spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", spec, err)
+	}
+
+	sort.Strings(uris)
+	uniq := uris[:0]
+	for i, uri := range uris {
+		if i > 0 && uri == uris[i-1] {
+			continue
+		}
+		uniq = append(uniq, uri)
+	}
+	return uniq, nil
+}
+
+func discoverDNS(ctx context.Context, name string, lookupSRV lookupSRVFunc) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("DNS name required")
+	}
+
+	_, records, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup SRV: %w", err)
+	}
+
+	uris := make([]string, 0, len(records))
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		uris = append(uris, "http://"+net.JoinHostPort(host, strconv.Itoa(int(rec.Port))))
+	}
+	return uris, nil
+}
+
+func discoverFile(path string, readFile readFileFunc) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path required")
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var uris []string
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &uris); err != nil {
+			return nil, fmt.Errorf("parse JSON: %w", err)
+		}
+	} else {
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			uris = append(uris, s.Text())
+		}
+		if err := s.Err(); err != nil {
+			return nil, fmt.Errorf("read lines: %w", err)
+		}
+	}
+
+	res := uris[:0]
+	for _, uri := range uris {
+		uri = strings.TrimSpace(uri)
+		if uri == "" || strings.HasPrefix(uri, "#") {
+			continue
+		}
+		res = append(res, uri)
+	}
+	return res, nil
+}
+
+//
+//
+//
+
+// DiscoverySearcher implements [trc.Searcher] by searching every trace server
+// identified by a discovery spec, see [Discover], like a [trc.MultiSearcher].
+// The set of trace servers is refreshed lazily, by searches which occur at
+// least RefreshInterval after the previous refresh, so that it tracks e.g.
+// autoscaling groups without a background goroutine.
+//
+// If a refresh fails, the previous set of trace servers continues to be used,
+// and the error is reported as a problem in the search response.
+type DiscoverySearcher struct {
+	// Spec identifies the trace servers to search, see [Discover]. Required.
+	Spec string
+
+	// HTTPClient is used to query the trace servers. If not provided, the
+	// [http.DefaultClient] is used.
+	HTTPClient HTTPClient
+
+	// Path, if provided, replaces the path of every discovered URI, which is
+	// typically necessary for URIs discovered via DNS.
+	Path string
+
+	// RefreshInterval is how often the set of trace servers is discovered.
+	// If not provided, a default of 30 seconds is used.
+	RefreshInterval time.Duration
+
+	mtx       sync.Mutex
+	uris      []string
+	searcher  trc.MultiSearcher
+	refreshed time.Time
+	discover  func(ctx context.Context, spec string) ([]string, error) // for tests
+}
+
+var _ trc.Searcher = (*DiscoverySearcher)(nil)
+
+const defaultDiscoveryRefreshInterval = 30 * time.Second
+
+// Search implements [trc.Searcher].
+func (s *DiscoverySearcher) Search(ctx context.Context, req *trc.SearchRequest) (*trc.SearchResponse, error) {
+	searcher, err := s.refresh(ctx)
+	if err != nil && len(searcher) <= 0 {
+		return nil, fmt.Errorf("discover: %w", err)
+	}
+
+	res, searchErr := searcher.Search(ctx, req)
+	if res != nil && err != nil {
+		res.Problems = append(res.Problems, fmt.Sprintf("discover: %v (using previous set of %d URIs)", err, len(searcher)))
+	}
+	return res, searchErr
+}
+
+// URIs returns the URIs of the trace servers that were most recently
+// discovered.
+func (s *DiscoverySearcher) URIs() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]string(nil), s.uris...)
+}
+
+// refresh discovers the set of trace servers, if the refresh interval has
+// elapsed, and returns the current searcher, as well as any discovery error.
+func (s *DiscoverySearcher) refresh(ctx context.Context) (trc.MultiSearcher, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	interval := iff(s.RefreshInterval > 0, s.RefreshInterval, defaultDiscoveryRefreshInterval)
+	if !s.refreshed.IsZero() && time.Since(s.refreshed) < interval {
+		return s.searcher, nil
+	}
+
+	tr := trc.Get(ctx)
+
+	discoverFunc := iff(s.discover != nil, s.discover, Discover)
+	uris, err := discoverFunc(ctx, s.Spec)
+	if err != nil {
+		tr.Errorf("discover: %v", err)
+		return s.searcher, err
+	}
+
+	if s.Path != "" {
+		for i, uri := range uris {
+			if !strings.HasPrefix(uri, "http") {
+				uri = "http://" + uri
+			}
+			if u, err := url.Parse(uri); err == nil {
+				u.Path = s.Path
+				uris[i] = u.String()
+			}
+		}
+	}
+
+	client := iff[HTTPClient](s.HTTPClient != nil, s.HTTPClient, http.DefaultClient)
+	searcher := make(trc.MultiSearcher, len(uris))
+	for i, uri := range uris {
+		searcher[i] = NewSearchClient(client, uri)
+	}
+
+	tr.LazyTracef("discovered URI count %d", len(uris))
+	s.uris, s.searcher, s.refreshed = uris, searcher, time.Now()
+	return s.searcher, nil
+}
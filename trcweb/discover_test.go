@@ -0,0 +1,127 @@
+package trcweb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestDiscover(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	lookupSRV := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "trc._tcp.service.consul" {
+			return "", nil, errors.New("no such host")
+		}
+		return "", []*net.SRV{
+			{Target: "b.node.consul.", Port: 8080},
+			{Target: "a.node.consul.", Port: 8080},
+			{Target: "b.node.consul.", Port: 8080},
+		}, nil
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"instances.json": `["http://b:8080/traces", "http://a:8080/traces", ""]`,
+		"instances.txt":  "# instances\nhttp://a:8080/traces\n\n  http://b:8080/traces  \n",
+		"invalid.json":   `["http://a:8080/traces"`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{"dns:trc._tcp.service.consul", []string{"http://a.node.consul:8080", "http://b.node.consul:8080"}, ""},
+		{"dns:other.service.consul", nil, "no such host"},
+		{"dns:", nil, "DNS name required"},
+		{"file:" + filepath.Join(dir, "instances.json"), []string{"http://a:8080/traces", "http://b:8080/traces"}, ""},
+		{"file:" + filepath.Join(dir, "instances.txt"), []string{"http://a:8080/traces", "http://b:8080/traces"}, ""},
+		{"file:" + filepath.Join(dir, "invalid.json"), nil, "parse JSON"},
+		{"file:" + filepath.Join(dir, "missing.json"), nil, "no such file"},
+		{"consul:trc", nil, "unsupported discovery spec"},
+	} {
+		have, err := discover(ctx, tc.spec, lookupSRV, os.ReadFile)
+		switch {
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: want error containing %q, have %v", tc.spec, tc.wantErr, err)
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: error: %v", tc.spec, err)
+		case tc.wantErr == "" && !reflect.DeepEqual(tc.want, have):
+			t.Errorf("%s: want %v, have %v", tc.spec, tc.want, have)
+		}
+	}
+}
+
+func TestDiscoverySearcher(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	newServer := func(source string) *httptest.Server {
+		collector := trc.NewCollector(trc.CollectorConfig{Source: source})
+		_, tr := collector.NewTrace(ctx, "cat")
+		tr.Finish()
+		s := httptest.NewServer(NewTraceServer(collector))
+		t.Cleanup(s.Close)
+		return s
+	}
+
+	var (
+		s1, s2    = newServer("s1"), newServer("s2")
+		available = []string{s1.URL}
+		discErr   error
+	)
+	searcher := &DiscoverySearcher{
+		Spec:            "test",
+		RefreshInterval: time.Nanosecond,
+		discover: func(ctx context.Context, spec string) ([]string, error) {
+			return append([]string(nil), available...), discErr
+		},
+	}
+
+	search := func() *trc.SearchResponse {
+		t.Helper()
+		res, err := searcher.Search(ctx, &trc.SearchRequest{})
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		return res
+	}
+
+	if want, have := []string{"s1"}, search().Sources; !reflect.DeepEqual(want, have) {
+		t.Errorf("sources: want %v, have %v", want, have)
+	}
+
+	available = []string{s1.URL, s2.URL}
+	if want, have := 2, search().TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+	if want, have := available, searcher.URIs(); !reflect.DeepEqual(want, have) {
+		t.Errorf("URIs: want %v, have %v", want, have)
+	}
+
+	discErr = errors.New("lookup failed")
+	res := search()
+	if want, have := 2, res.TotalCount; want != have {
+		t.Errorf("total count after failed refresh: want %d, have %d", want, have)
+	}
+	if want, have := "lookup failed", strings.Join(res.Problems, "; "); !strings.Contains(have, want) {
+		t.Errorf("problems: want %q, have %q", want, have)
+	}
+}
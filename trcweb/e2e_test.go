@@ -2,9 +2,13 @@ package trcweb_test
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -21,6 +25,8 @@ func TestE2E(t *testing.T) {
 	httpServer := httptest.NewServer(collectorServer)
 	defer httpServer.Close()
 	traceClient := trcweb.NewSearchClient(http.DefaultClient, httpServer.URL)
+	protoClient := trcweb.NewSearchClient(http.DefaultClient, httpServer.URL)
+	protoClient.Protobuf = true
 
 	for _, tuple := range []struct {
 		category string
@@ -70,6 +76,18 @@ func TestE2E(t *testing.T) {
 		if !cmp.Equal(res1, res2, opts...) {
 			t.Fatal(cmp.Diff(res1, res2, opts...))
 		}
+
+		res3, err3 := protoClient.Search(ctx, req)
+		if err3 != nil {
+			t.Fatal(err3)
+		}
+
+		t.Logf("protobuf: total %d, matched %d, selected %d, err %v", res3.TotalCount, res3.MatchCount, len(res3.Traces), err3)
+
+		opts = append(opts, cmpopts.EquateEmpty())
+		if !cmp.Equal(res1, res3, opts...) {
+			t.Fatal(cmp.Diff(res1, res3, opts...))
+		}
 	}
 
 	t.Run("default", func(t *testing.T) { testSelect(t, &trc.SearchRequest{}) })
@@ -79,4 +97,192 @@ func TestE2E(t *testing.T) {
 	t.Run("Query=doesnotexist", func(t *testing.T) { testSelect(t, &trc.SearchRequest{Filter: trc.Filter{Query: "doesnotexist"}}) })
 	t.Run("Query=1 Limit=2", func(t *testing.T) { testSelect(t, &trc.SearchRequest{Filter: trc.Filter{Query: "1"}, Limit: 2}) })
 	t.Run("(B|Z)", func(t *testing.T) { testSelect(t, &trc.SearchRequest{Filter: trc.Filter{Query: "(B|Z)"}}) })
+	t.Run("StatsOnly", func(t *testing.T) { testSelect(t, &trc.SearchRequest{StatsOnly: true}) })
+}
+
+func TestStatsOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var searcher trc.MultiSearcher
+	for _, source := range []string{"a", "b"} {
+		collector := trc.NewCollector(trc.CollectorConfig{Source: source})
+		for i := 0; i < 3; i++ {
+			_, tr := collector.NewTrace(ctx, "category")
+			tr.Finish()
+		}
+		httpServer := httptest.NewServer(trcweb.NewTraceServer(collector))
+		defer httpServer.Close()
+		searcher = append(searcher, trcweb.NewSearchClient(http.DefaultClient, httpServer.URL))
+	}
+
+	res, err := searcher.Search(ctx, &trc.SearchRequest{StatsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 0, len(res.Traces); want != have {
+		t.Errorf("traces: want %d, have %d", want, have)
+	}
+	if want, have := 6, res.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+	if want, have := 6, res.Stats.Categories["category"].BucketCounts[0]; want != have {
+		t.Errorf("category count: want %d, have %d", want, have)
+	}
+	if want, have := "a b", strings.Join(res.Sources, " "); want != have {
+		t.Errorf("sources: want %q, have %q", want, have)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+	for i := 0; i < 10; i++ {
+		_, tr := collector.NewTrace(ctx, "foo")
+		tr.Tracef("event %d", i)
+		tr.Finish()
+	}
+
+	server := trcweb.NewTraceServer(collector)
+
+	for _, acceptEncoding := range []string{"", "gzip", "deflate, gzip;q=1.0", "gzip;q=0"} {
+		r := httptest.NewRequest("GET", "/?n=10", nil)
+		r.Header.Set("accept", "application/json")
+		if acceptEncoding != "" {
+			r.Header.Set("accept-encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		want := strings.Contains(acceptEncoding, "gzip") && !strings.Contains(acceptEncoding, "q=0")
+		have := w.Header().Get("content-encoding") == "gzip"
+		if want != have {
+			t.Errorf("Accept-Encoding %q: want gzip %v, have %v", acceptEncoding, want, have)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector()
+	for i := 0; i < 250; i++ {
+		_, tr := collector.NewTrace(ctx, iff(i%2 == 0, "even", "odd"))
+		tr.Tracef("event %d", i)
+		tr.Finish()
+	}
+
+	server := trcweb.NewTraceServer(collector)
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"format=ndjson&all=true", 250},
+		{"format=ndjson&all=true&category=odd", 125},
+		{"format=ndjson&all=true&q=event+1", 111}, // 1, 10-19, 100-199
+	} {
+		r := httptest.NewRequest("GET", "/?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+
+		if want, have := "application/x-ndjson; charset=utf-8", w.Header().Get("content-type"); want != have {
+			t.Fatalf("%s: content-type: want %q, have %q", tc.query, want, have)
+		}
+
+		var have int
+		dec := json.NewDecoder(w.Body)
+		for {
+			var st trc.StaticTrace
+			if err := dec.Decode(&st); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: decode: %v", tc.query, err)
+			}
+			have++
+		}
+		if want := tc.want; want != have {
+			t.Errorf("%s: want %d, have %d", tc.query, want, have)
+		}
+	}
+}
+
+func iff[T any](cond bool, yes, no T) T {
+	if cond {
+		return yes
+	}
+	return no
+}
+
+func TestStreamProtobuf(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		collector   = trc.NewDefaultCollector()
+		httpServer  = httptest.NewServer(trcweb.NewTraceServer(collector))
+		initc       = make(chan struct{}, 1)
+		eventTypes  = make(chan string, 100)
+		tracec      = make(chan trc.Trace, 10)
+		errc        = make(chan error, 1)
+	)
+	defer cancel()
+	defer httpServer.Close()
+
+	client := &trcweb.StreamClient{
+		URI:      httpServer.URL,
+		Protobuf: true,
+		OnRead: func(ctx context.Context, eventType string, eventData []byte) {
+			if eventType == "init" {
+				select {
+				case initc <- struct{}{}:
+				default:
+				}
+			}
+			eventTypes <- eventType
+		},
+	}
+	go func() { errc <- client.Stream(ctx, trc.Filter{IsFinished: true}, tracec) }()
+
+	select {
+	case <-initc:
+	case err := <-errc:
+		t.Fatalf("stream: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for stream init")
+	}
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Tracef("hello")
+	tr.Finish()
+
+	select {
+	case recv := <-tracec:
+		if want, have := tr.ID(), recv.ID(); want != have {
+			t.Errorf("ID: want %s, have %s", want, have)
+		}
+		if want, have := "hello", recv.Events()[0].What; want != have {
+			t.Errorf("event: want %q, have %q", want, have)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for trace")
+	}
+
+	cancel()
+	<-errc
+	close(eventTypes)
+
+	var types []string
+	for eventType := range eventTypes {
+		if eventType != "stats" {
+			types = append(types, eventType)
+		}
+	}
+	if want, have := "init trace.pb", strings.Join(types, " "); want != have {
+		t.Errorf("event types: want %q, have %q", want, have)
+	}
 }
@@ -0,0 +1,66 @@
+// Package echotrc provides a middleware for echo servers which creates a trace
+// for each request, categorized by route pattern.
+//
+// It's a separate module, so that the trc module doesn't depend on echo.
+package echotrc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+// Middleware returns an echo middleware which creates a trace for each request,
+// as configured, see [trcweb.NewMiddleware]. The response code is always
+// recorded in the trace.
+//
+// If cfg doesn't provide Categorize or CategorizeTags, the trace category is
+// the method and route pattern of the request, e.g. "GET /users/:id", or
+// "unmatched" if no route matches. The middleware should be installed via
+// [echo.Echo.Use], so the request is routed before the middleware is called.
+//
+// Errors returned by the handler are recorded in the trace, and passed to
+// [echo.Context.Error] within the trace, so the response they produce is
+// recorded as well. They're returned to the caller as usual.
+func Middleware(cfg trcweb.MiddlewareConfig) echo.MiddlewareFunc {
+	if cfg.Categorize == nil && cfg.CategorizeTags == nil {
+		cfg.Categorize = categorize
+	}
+
+	middleware := trcweb.NewMiddleware(cfg)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			res := c.Response()
+			orig := res.Writer
+			defer func() { res.Writer = orig }()
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				res.Writer = w
+				if err = next(c); err != nil {
+					trc.Get(r.Context()).Errorf("%v", err)
+					c.Error(err)
+				}
+			})
+
+			req := c.Request()
+			ctx := context.WithValue(req.Context(), pathKey{}, c.Path())
+			middleware(handler).ServeHTTP(orig, req.WithContext(ctx))
+			return err
+		}
+	}
+}
+
+type pathKey struct{}
+
+func categorize(r *http.Request) string {
+	path, _ := r.Context().Value(pathKey{}).(string)
+	if path == "" {
+		return "unmatched"
+	}
+	return r.Method + " " + path
+}
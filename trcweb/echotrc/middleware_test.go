@@ -0,0 +1,92 @@
+package echotrc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+	"github.com/peterbourgon/trc/trcweb/echotrc"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	e := echo.New()
+	e.Use(echotrc.Middleware(trcweb.MiddlewareConfig{Constructor: collector.NewTrace}))
+	e.GET("/users/:id", func(c echo.Context) error {
+		if _, ok := trc.MaybeGet(c.Request().Context()); !ok {
+			t.Errorf("no trace in request context")
+		}
+		return c.String(http.StatusAccepted, "user "+c.Param("id"))
+	})
+	api := e.Group("/api")
+	api.POST("/items/:item/tags", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusConflict, "already tagged")
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		category     string
+		results      []string
+	}{
+		{"GET", "/users/123", "GET /users/:id", []string{"HTTP 202, 8.0B"}},
+		{"POST", "/api/items/abc/tags", "POST /api/items/:item/tags", []string{"already tagged", "HTTP 409"}},
+		{"GET", "/nope", "unmatched", []string{"HTTP 404"}},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+		res, err := collector.Search(context.Background(), &trc.SearchRequest{
+			Filter: trc.Filter{Category: tc.category},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s %s: category %q: want %d trace(s), have %d", tc.method, tc.path, tc.category, want, have)
+		}
+
+		var whats []string
+		for _, ev := range res.Traces[0].Events() {
+			whats = append(whats, ev.What)
+		}
+		all := strings.Join(whats, "\n")
+		for _, want := range tc.results {
+			if !strings.Contains(all, want) {
+				t.Errorf("%s %s: missing %q:\n%s", tc.method, tc.path, want, all)
+			}
+		}
+	}
+}
+
+func TestMiddlewareCategorize(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	e := echo.New()
+	e.Use(echotrc.Middleware(trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(*http.Request) string { return "custom" },
+	}))
+	e.GET("/users/:id", func(c echo.Context) error { return nil })
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{
+		Filter: trc.Filter{Category: "custom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("want %d trace(s), have %d", want, have)
+	}
+}
@@ -0,0 +1,46 @@
+package trcweb
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestErrorTypes(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	for _, err := range []error{io.EOF, io.EOF, context.Canceled} {
+		_, tr := collector.NewTrace(context.Background(), "api")
+		tr.Errorf("failed: %v", err)
+		tr.Finish()
+	}
+
+	server := NewTraceServer(collector)
+	get := func(query string) string {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", "text/html")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	body := get("")
+	for _, want := range []string{
+		`category=api&error_type=io.EOF" title="2 traces with error type io.EOF">io.EOF &times;2</a>`,
+		`category=api&error_type=context.Canceled"`,
+		`<span class="error-type" title="error type">io.EOF</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+
+	if body := get("error_type=context.Canceled"); strings.Contains(body, `<span class="error-type" title="error type">io.EOF</span>`) {
+		t.Errorf("filtered response contains io.EOF events")
+	}
+}
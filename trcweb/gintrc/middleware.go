@@ -0,0 +1,72 @@
+// Package gintrc provides a middleware for gin engines which creates a trace
+// for each request, categorized by route pattern.
+//
+// It's a separate module, so that the trc module doesn't depend on gin.
+package gintrc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+// Middleware returns a gin middleware which creates a trace for each request,
+// as configured, see [trcweb.NewMiddleware]. The response code is always
+// recorded in the trace.
+//
+// If cfg doesn't provide Categorize or CategorizeTags, the trace category is
+// the method and route pattern of the request, e.g. "GET /users/:id", or
+// "unmatched" if no route matches. The middleware should be installed via
+// [gin.Engine.Use], so the request is routed before the middleware is called.
+func Middleware(cfg trcweb.MiddlewareConfig) gin.HandlerFunc {
+	if cfg.Categorize == nil && cfg.CategorizeTags == nil {
+		cfg.Categorize = categorize
+	}
+
+	middleware := trcweb.NewMiddleware(cfg)
+
+	return func(c *gin.Context) {
+		orig := c.Writer
+		defer func() { c.Writer = orig }()
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Request = r
+			c.Writer = &responseWriter{ResponseWriter: orig, w: w}
+			c.Next()
+
+			// Gin sets the status code lazily, and sometimes directly, e.g.
+			// for unmatched routes, so it's reported to the middleware here.
+			w.WriteHeader(orig.Status())
+		})
+
+		ctx := context.WithValue(c.Request.Context(), fullPathKey{}, c.FullPath())
+		middleware(next).ServeHTTP(orig, c.Request.WithContext(ctx))
+	}
+}
+
+type fullPathKey struct{}
+
+func categorize(r *http.Request) string {
+	fullPath, _ := r.Context().Value(fullPathKey{}).(string)
+	if fullPath == "" {
+		return "unmatched"
+	}
+	return r.Method + " " + fullPath
+}
+
+// responseWriter writes the response body via the middleware, so the number
+// of bytes written is recorded in the trace.
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	return rw.w.Write(p)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	return rw.w.Write([]byte(s))
+}
@@ -0,0 +1,92 @@
+package gintrc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+	"github.com/peterbourgon/trc/trcweb/gintrc"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	engine := gin.New()
+	engine.Use(gintrc.Middleware(trcweb.MiddlewareConfig{Constructor: collector.NewTrace}))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		if _, ok := trc.MaybeGet(c.Request.Context()); !ok {
+			t.Errorf("no trace in request context")
+		}
+		c.String(http.StatusAccepted, "user %s", c.Param("id"))
+	})
+	api := engine.Group("/api")
+	api.POST("/items/:item/tags", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusConflict)
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		category     string
+		result       string
+	}{
+		{"GET", "/users/123", "GET /users/:id", "HTTP 202, 8.0B"},
+		{"POST", "/api/items/abc/tags", "POST /api/items/:item/tags", "HTTP 409"},
+		{"GET", "/nope", "unmatched", "HTTP 404"},
+	} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+
+		res, err := collector.Search(context.Background(), &trc.SearchRequest{
+			Filter: trc.Filter{Category: tc.category},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s %s: category %q: want %d trace(s), have %d", tc.method, tc.path, tc.category, want, have)
+		}
+
+		var whats []string
+		for _, ev := range res.Traces[0].Events() {
+			whats = append(whats, ev.What)
+		}
+		if all := strings.Join(whats, "\n"); !strings.Contains(all, tc.result) {
+			t.Errorf("%s %s: missing %q:\n%s", tc.method, tc.path, tc.result, all)
+		}
+	}
+}
+
+func TestMiddlewareCategorize(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+
+	engine := gin.New()
+	engine.Use(gintrc.Middleware(trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(*http.Request) string { return "custom" },
+	}))
+	engine.GET("/users/:id", func(c *gin.Context) {})
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{
+		Filter: trc.Filter{Category: "custom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("want %d trace(s), have %d", want, have)
+	}
+}
@@ -0,0 +1,115 @@
+package trcweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcdebug"
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// HealthThresholds determine when a [HealthHandler] reports the collector as
+// unhealthy. Zero values mean the corresponding check is disabled.
+type HealthThresholds struct {
+	// MaxOccupancy is the max fraction of capacity, from 0 to 1, which may be
+	// used by any category. Note that busy categories are normally full, as
+	// new traces evict old ones, so this is mostly useful for collectors with
+	// a MaxAge, where full categories indicate an unexpected volume of traces.
+	MaxOccupancy float64
+
+	// MaxSubscriberSaturation is the max fraction of capacity, from 0 to 1,
+	// which may be used by the channel of any stream subscriber. Saturated
+	// subscribers drop traces, or block publishers, depending on their send
+	// policy.
+	MaxSubscriberSaturation float64
+
+	// MaxLostTraces is the max number of core traces which were free'd while
+	// still active, and so couldn't be reused, since the program started. Lost
+	// traces usually indicate a bug, e.g. traces which are never finished.
+	MaxLostTraces uint64
+
+	// MaxSearchDuration is the max duration of the most recent search.
+	MaxSearchDuration time.Duration
+}
+
+// HealthReport is the JSON response body of a [HealthHandler].
+type HealthReport struct {
+	Healthy    bool             `json:"healthy"`
+	Problems   []string         `json:"problems,omitempty"`
+	Source     string           `json:"source"`
+	Categories []HealthCategory `json:"categories"`
+	Streams    trc.BrokerStats  `json:"streams"`
+	LostTraces uint64           `json:"lost_traces"`
+	LastSearch time.Duration    `json:"last_search,omitempty"`
+}
+
+// HealthCategory is the occupancy of a single category in a [HealthReport].
+type HealthCategory struct {
+	Category  string  `json:"category"`
+	Retained  int     `json:"retained"`
+	Capacity  int     `json:"capacity"`
+	Occupancy float64 `json:"occupancy"`
+}
+
+// HealthHandler returns an HTTP handler which reports the health of the
+// collector itself, i.e. the tracing subsystem, rather than the traced program,
+// as a [HealthReport] in JSON. If any of the thresholds are exceeded, the
+// report includes a problem for each, and the response is HTTP 503, so that
+// e.g. monitoring systems can notice when tracing is degraded.
+func HealthHandler(c *trc.Collector, thresholds HealthThresholds) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := newHealthReport(c.Stats(), trcdebug.CoreTraceLostCount.Load(), thresholds)
+
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		w.Header().Set("cache-control", "no-store")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		enc.Encode(report)
+	})
+}
+
+func newHealthReport(stats trc.CollectorStats, lost uint64, thresholds HealthThresholds) HealthReport {
+	report := HealthReport{
+		Source:     stats.Source,
+		Categories: make([]HealthCategory, 0, len(stats.Categories)),
+		Streams:    stats.Streams,
+		LostTraces: lost,
+		LastSearch: stats.LastSearchDuration,
+	}
+
+	for _, cs := range stats.Categories {
+		hc := HealthCategory{
+			Category: cs.Category,
+			Retained: cs.Retained,
+			Capacity: cs.Capacity,
+		}
+		if cs.Capacity > 0 {
+			hc.Occupancy = float64(cs.Retained) / float64(cs.Capacity)
+		}
+		if thresholds.MaxOccupancy > 0 && hc.Occupancy > thresholds.MaxOccupancy {
+			report.Problems = append(report.Problems, fmt.Sprintf("category %s: occupancy %s%% exceeds %s%%", cs.Category, trcutil.HumanizeFloat(100*hc.Occupancy), trcutil.HumanizeFloat(100*thresholds.MaxOccupancy)))
+		}
+		report.Categories = append(report.Categories, hc)
+	}
+
+	if max := thresholds.MaxSubscriberSaturation; max > 0 && stats.Streams.MaxSaturation >= max {
+		report.Problems = append(report.Problems, fmt.Sprintf("stream subscriber saturation %s%% reached %s%% (%d saturated)", trcutil.HumanizeFloat(100*stats.Streams.MaxSaturation), trcutil.HumanizeFloat(100*max), stats.Streams.Saturated))
+	}
+
+	if max := thresholds.MaxLostTraces; max > 0 && lost > max {
+		report.Problems = append(report.Problems, fmt.Sprintf("lost trace count %d exceeds %d", lost, max))
+	}
+
+	if max := thresholds.MaxSearchDuration; max > 0 && stats.LastSearchDuration > max {
+		report.Problems = append(report.Problems, fmt.Sprintf("last search duration %s exceeds %s", trcutil.HumanizeDuration(stats.LastSearchDuration), trcutil.HumanizeDuration(max)))
+	}
+
+	report.Healthy = len(report.Problems) <= 0
+	return report
+}
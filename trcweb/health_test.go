@@ -0,0 +1,88 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestHealthHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	collector := trc.NewDefaultCollector().SetCategorySize(2)
+	for i := 0; i < 3; i++ {
+		_, tr := collector.NewTrace(ctx, "full")
+		tr.Finish()
+	}
+	_, tr := collector.NewTrace(ctx, "half")
+	tr.Finish()
+
+	get := func(thresholds HealthThresholds) (int, HealthReport) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		HealthHandler(collector, thresholds).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		var report HealthReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("decode report: %v", err)
+		}
+		return rec.Code, report
+	}
+
+	code, report := get(HealthThresholds{})
+	if want, have := http.StatusOK, code; want != have {
+		t.Errorf("status: want %d, have %d", want, have)
+	}
+	if !report.Healthy {
+		t.Errorf("want healthy, have problems %v", report.Problems)
+	}
+	if want, have := 2, len(report.Categories); want != have {
+		t.Fatalf("categories: want %d, have %d", want, have)
+	}
+	if want, have := (HealthCategory{Category: "full", Retained: 2, Capacity: 2, Occupancy: 1}), report.Categories[0]; want != have {
+		t.Errorf("category: want %+v, have %+v", want, have)
+	}
+
+	code, report = get(HealthThresholds{MaxOccupancy: 0.9, MaxSearchDuration: time.Hour})
+	if want, have := http.StatusServiceUnavailable, code; want != have {
+		t.Errorf("status: want %d, have %d", want, have)
+	}
+	if report.Healthy {
+		t.Errorf("want unhealthy")
+	}
+	if want, have := "category full: occupancy 100% exceeds 90%", strings.Join(report.Problems, "; "); want != have {
+		t.Errorf("problems: want %q, have %q", want, have)
+	}
+}
+
+func TestHealthReportThresholds(t *testing.T) {
+	t.Parallel()
+
+	stats := trc.CollectorStats{
+		LastSearchDuration: 2 * time.Second,
+		Streams:            trc.BrokerStats{Subscribers: 3, Saturated: 1, MaxSaturation: 1},
+	}
+	report := newHealthReport(stats, 5, HealthThresholds{
+		MaxSubscriberSaturation: 1,
+		MaxLostTraces:           4,
+		MaxSearchDuration:       time.Second,
+	})
+	if want, have := 3, len(report.Problems); want != have {
+		t.Errorf("problems: want %d, have %d (%v)", want, have, report.Problems)
+	}
+
+	report = newHealthReport(stats, 5, HealthThresholds{
+		MaxSubscriberSaturation: 1.5,
+		MaxLostTraces:           5,
+		MaxSearchDuration:       2 * time.Second,
+	})
+	if !report.Healthy {
+		t.Errorf("want healthy, have problems %v", report.Problems)
+	}
+}
@@ -0,0 +1,124 @@
+package trcweb
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// HeatmapData is returned by heatmap requests, i.e. requests with a heatmap
+// query parameter, and an optional category parameter. Rows are ordered from
+// slowest to fastest, followed by a row of errored traces, which is the layout
+// used by the web interface.
+type HeatmapData struct {
+	Tenant  string         `json:"tenant,omitempty"`
+	Heatmap trc.Heatmap    `json:"heatmap"`
+	Rows    []HeatmapRow   `json:"-"` // for rendering, not transmitting
+	Labels  []HeatmapLabel `json:"-"` // for rendering, not transmitting
+	Max     int            `json:"max"`
+}
+
+// HeatmapRow is a single row of a rendered heatmap, i.e. one duration bucket,
+// or the errored traces.
+type HeatmapRow struct {
+	Bucket  time.Duration
+	Errored bool
+	Cells   []HeatmapCell
+}
+
+// HeatmapCell is a single cell of a rendered heatmap.
+type HeatmapCell struct {
+	Start time.Time
+	Count int
+
+	// Intensity is the count relative to the largest count in the heatmap,
+	// from 0 to 1, and determines the color of the cell.
+	Intensity float64
+}
+
+// HeatmapLabel is a label on the time axis of a rendered heatmap, spanning one
+// or more columns.
+type HeatmapLabel struct {
+	Text string
+	Span int
+}
+
+// heatmapLabelEvery is the number of columns spanned by each time axis label.
+const heatmapLabelEvery = 15
+
+func (s *TraceServer) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx      = r.Context()
+		tr       = trc.Get(ctx)
+		category = r.URL.Query().Get("category")
+	)
+
+	if s.Heatmapper == nil {
+		tr.Errorf("heatmap: not supported")
+		http.Error(w, "heatmap not supported", http.StatusNotImplemented)
+		return
+	}
+
+	h := s.Heatmapper.Heatmap(category)
+	data := HeatmapData{
+		Tenant:  s.tenant,
+		Heatmap: h,
+		Rows:    heatmapRows(h),
+		Labels:  heatmapLabels(h),
+		Max:     h.Max(),
+	}
+
+	tr.LazyTracef("heatmap category %q, %d column(s), max %d", category, len(h.Columns), data.Max)
+
+	renderResponse(ctx, w, r, assetsFS(s.Assets), "heatmap.html", s.assetFuncs(ctx), data)
+}
+
+// heatmapRows transposes the columns of the heatmap into rows, slowest first,
+// followed by errored traces.
+func heatmapRows(h trc.Heatmap) []HeatmapRow {
+	var (
+		max  = h.Max()
+		rows = make([]HeatmapRow, 0, len(h.Bucketing)+1)
+		cell = func(start time.Time, count int) HeatmapCell {
+			return HeatmapCell{
+				Start:     start,
+				Count:     count,
+				Intensity: iff(max > 0, float64(count)/float64(max), 0),
+			}
+		}
+	)
+
+	for i := len(h.Bucketing) - 1; i >= 0; i-- {
+		row := HeatmapRow{Bucket: h.Bucketing[i], Cells: make([]HeatmapCell, 0, len(h.Columns))}
+		for _, col := range h.Columns {
+			var count int
+			if i < len(col.Counts) {
+				count = col.Counts[i]
+			}
+			row.Cells = append(row.Cells, cell(col.Start, count))
+		}
+		rows = append(rows, row)
+	}
+
+	errored := HeatmapRow{Errored: true, Cells: make([]HeatmapCell, 0, len(h.Columns))}
+	for _, col := range h.Columns {
+		errored.Cells = append(errored.Cells, cell(col.Start, col.Errored))
+	}
+	rows = append(rows, errored)
+
+	return rows
+}
+
+// heatmapLabels returns labels for the time axis of the heatmap, each spanning
+// up to heatmapLabelEvery columns, with the start time of the first column.
+func heatmapLabels(h trc.Heatmap) []HeatmapLabel {
+	var labels []HeatmapLabel
+	for i := 0; i < len(h.Columns); i += heatmapLabelEvery {
+		labels = append(labels, HeatmapLabel{
+			Text: h.Columns[i].Start.Format("15:04"),
+			Span: min(heatmapLabelEvery, len(h.Columns)-i),
+		})
+	}
+	return labels
+}
@@ -0,0 +1,109 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestHeatmapHandler(t *testing.T) {
+	t.Parallel()
+
+	// A fixed clock puts both foo traces in the same cell.
+	var (
+		now       = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		clock     = trc.ClockFunc(func() time.Time { return now })
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+		server    = NewTraceServer(collector)
+	)
+	for _, category := range []string{"foo", "foo", "bar"} {
+		_, tr := collector.NewTrace(context.Background(), category)
+		tr.Finish()
+	}
+
+	get := func(query string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("heatmap&category=foo", "application/json")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("JSON: want %d, have %d", want, have)
+	}
+	var data HeatmapData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want, have := "foo", data.Heatmap.Category; want != have {
+		t.Errorf("category: want %q, have %q", want, have)
+	}
+	if want, have := 2, data.Max; want != have {
+		t.Errorf("max: want %d, have %d", want, have)
+	}
+	var total int
+	for _, col := range data.Heatmap.Columns {
+		total += col.Total()
+	}
+	if want, have := 2, total; want != have {
+		t.Errorf("total: want %d, have %d", want, have)
+	}
+
+	w = get("heatmap", "text/html")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("HTML: want %d, have %d", want, have)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `id="heatmap"`) || !strings.Contains(body, "all categories") {
+		t.Errorf("HTML: missing heatmap in %s", body)
+	}
+
+	unsupported := &TraceServer{Searcher: collector, Streamer: collector}
+	r := httptest.NewRequest("GET", "/?heatmap", nil)
+	rec := httptest.NewRecorder()
+	unsupported.ServeHTTP(rec, r)
+	if want, have := http.StatusNotImplemented, rec.Code; want != have {
+		t.Errorf("without heatmapper: want %d, have %d", want, have)
+	}
+}
+
+func TestHeatmapRows(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := trc.Heatmap{
+		Interval:  time.Minute,
+		Bucketing: []time.Duration{0, time.Millisecond},
+		Columns: []trc.HeatmapColumn{
+			{Start: start, Counts: []int{4, 0}, Errored: 1},
+			{Start: start.Add(time.Minute), Counts: []int{0, 2}},
+		},
+	}
+
+	rows := heatmapRows(h)
+	if want, have := 3, len(rows); want != have {
+		t.Fatalf("rows: want %d, have %d", want, have)
+	}
+	if want, have := time.Millisecond, rows[0].Bucket; want != have {
+		t.Errorf("first row: want %s, have %s", want, have)
+	}
+	if want, have := 0.5, rows[0].Cells[1].Intensity; want != have {
+		t.Errorf("intensity: want %v, have %v", want, have)
+	}
+	if want, have := 1.0, rows[1].Cells[0].Intensity; want != have {
+		t.Errorf("max intensity: want %v, have %v", want, have)
+	}
+	if want, have := true, rows[2].Errored; want != have {
+		t.Errorf("last row errored: want %v, have %v", want, have)
+	}
+	if want, have := 1, rows[2].Cells[0].Count; want != have {
+		t.Errorf("errored count: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,182 @@
+package trcweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// IngestResponse is returned by ingest requests. Ingested is the number of
+// traces which were added to the collector.
+type IngestResponse struct {
+	Ingested int `json:"ingested"`
+}
+
+// DefaultIngestSource is the source of ingested traces which don't specify a
+// source, and which aren't assigned one by the source query parameter.
+const DefaultIngestSource = "ingest"
+
+func isIngestRequest(r *http.Request) bool {
+	return r.URL.Query().Has("ingest") || path.Base(r.URL.Path) == "ingest"
+}
+
+// handleIngest adds the traces in the request body to the collector. The body
+// is a JSON array of traces, or a sequence of traces, e.g. newline-delimited
+// JSON, where each trace is a [trc.StaticTrace]. This allows processes which
+// don't use Go, e.g. scripts or batch jobs, to report traces which are shown
+// alongside the traces of Go services.
+func (s *TraceServer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx    = r.Context()
+		tr     = trc.Get(ctx)
+		source = r.URL.Query().Get("source")
+	)
+
+	if !s.authorizeMutation(w, r, "ingest", s.Mutations.Ingest) {
+		return
+	}
+
+	if s.Ingester == nil {
+		tr.Errorf("ingest: no ingester")
+		http.Error(w, "ingest not supported", http.StatusNotImplemented)
+		return
+	}
+
+	traces, err := decodeIngestTraces(http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes))
+	if err != nil {
+		tr.Errorf("ingest: %v", err)
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	added := make([]trc.Trace, len(traces))
+	for i, st := range traces {
+		if err := normalizeIngestTrace(st, source); err != nil {
+			tr.Errorf("ingest: trace %d: %v", i+1, err)
+			http.Error(w, fmt.Sprintf("bad request: trace %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		added[i] = st
+	}
+
+	s.Ingester.Add(added...)
+
+	tr.LazyTracef("ingested %d trace(s)", len(added))
+
+	renderJSON(ctx, w, r, IngestResponse{Ingested: len(added)})
+}
+
+// decodeIngestTraces decodes the traces in the body of an ingest request.
+func decodeIngestTraces(body io.Reader) ([]*trc.StaticTrace, error) {
+	var (
+		dec    = json.NewDecoder(body)
+		traces []*trc.StaticTrace
+	)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode body: %w", err)
+		}
+
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+			var a []*trc.StaticTrace
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return nil, fmt.Errorf("decode traces: %w", err)
+			}
+			traces = append(traces, a...)
+			continue
+		}
+
+		var st trc.StaticTrace
+		if err := json.Unmarshal(raw, &st); err != nil {
+			return nil, fmt.Errorf("decode trace: %w", err)
+		}
+		traces = append(traces, &st)
+	}
+
+	if len(traces) <= 0 {
+		return nil, fmt.Errorf("no traces")
+	}
+
+	return traces, nil
+}
+
+// normalizeIngestTrace validates the ingested trace, and fills in defaults.
+// Ingested traces are static, and can't be updated, so they're always
+// finished. The source parameter, if given, takes precedence over the source
+// of the trace. A trace without an ID is assigned a new one, see
+// [trc.NewTraceID]. A trace without a duration, but with duration_sec, which
+// is easier to produce in many languages, has the duration derived from it. A
+// trace with an error event is errored. Events beyond [trc.TraceMaxEvents] are
+// truncated.
+func normalizeIngestTrace(st *trc.StaticTrace, source string) error {
+	if st == nil {
+		return fmt.Errorf("null trace")
+	}
+
+	if st.TraceCategory == "" {
+		return fmt.Errorf("category is required")
+	}
+
+	switch {
+	case source != "":
+		st.TraceSource = source
+	case st.TraceSource == "":
+		st.TraceSource = DefaultIngestSource
+	}
+
+	if st.TraceStarted.IsZero() {
+		if len(st.TraceEvents) > 0 {
+			st.TraceStarted = st.TraceEvents[0].When
+		} else {
+			st.TraceStarted = time.Now()
+		}
+	}
+
+	if st.TraceID == "" {
+		id, err := trc.NewTraceID(st.TraceStarted)
+		if err != nil {
+			return fmt.Errorf("generate ID: %w", err)
+		}
+		st.TraceID = id
+	}
+
+	if st.TraceDuration == 0 && st.TraceDurationSec > 0 {
+		st.TraceDuration = time.Duration(st.TraceDurationSec * float64(time.Second))
+	}
+	if st.TraceDuration < 0 {
+		return fmt.Errorf("negative duration")
+	}
+
+	for _, ev := range st.TraceEvents {
+		if ev.IsError {
+			st.TraceErrored = true
+		}
+	}
+
+	// Like core traces, events beyond the max are replaced by a final event
+	// with the number of truncated events, so the total is at most the max.
+	if max := trc.TraceMaxEvents(); len(st.TraceEvents) > max {
+		last := st.TraceEvents[len(st.TraceEvents)-1]
+		st.TraceEvents = append(st.TraceEvents[:max-1:max-1], trc.Event{
+			When:   last.When,
+			Offset: last.Offset,
+			What:   fmt.Sprintf("(truncated event count %d)", len(st.TraceEvents)-(max-1)),
+		})
+	}
+
+	st.TraceFinished = true
+
+	return nil
+}
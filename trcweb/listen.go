@@ -0,0 +1,109 @@
+package trcweb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listen returns a listener for the given address, which is typically served
+// by an HTTP server wrapping a [TraceServer]. The address can take one of the
+// following forms.
+//
+//	host:port            TCP, as with net.Listen("tcp", addr)
+//	unix:///path/to.sock Unix domain socket at the given path
+//	unix:path/to.sock    Unix domain socket at the given relative path
+//	systemd              the single socket passed via systemd socket activation
+//	systemd:name         the socket with the given FileDescriptorName
+//
+// Unix domain sockets are useful when e.g. trace servers run as sidecars, where
+// TCP ports are contended. If a socket file already exists at the path, but
+// nothing is listening on it, e.g. because of an unclean shutdown, it's removed
+// before listening.
+//
+// Systemd socket activation follows the sd_listen_fds protocol, via the
+// LISTEN_PID, LISTEN_FDS, and LISTEN_FDNAMES environment variables.
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//"))
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		return listenSystemd(os.Getenv, strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path required")
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: socket is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// listenFDsStart is the first file descriptor passed via socket activation.
+const listenFDsStart = 3
+
+func listenSystemd(getenv func(string) string, name string) (net.Listener, error) {
+	pid, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("systemd: no sockets passed to this process (LISTEN_PID)")
+	}
+
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("systemd: no sockets passed to this process (LISTEN_FDS)")
+	}
+
+	var names []string
+	if s := getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+
+	var index int
+	switch {
+	case name == "" && n == 1:
+		index = 0
+	case name == "":
+		return nil, fmt.Errorf("systemd: %d sockets passed, name required", n)
+	default:
+		index = -1
+		for i := 0; i < n && i < len(names); i++ {
+			if names[i] == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("systemd: no socket named %q (LISTEN_FDNAMES)", name)
+		}
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart+index), "systemd:"+name)
+	if f == nil {
+		return nil, fmt.Errorf("systemd: invalid file descriptor %d", listenFDsStart+index)
+	}
+	defer f.Close() // FileListener dups the descriptor
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd: %w", err)
+	}
+
+	return ln, nil
+}
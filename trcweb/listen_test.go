@@ -0,0 +1,57 @@
+package trcweb
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "trc.sock")
+
+	ln, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	if _, err := Listen("unix://" + path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second listen: want in use error, have %v", err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(ln)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+	server.Close()
+}
+
+func TestListenSystemdErrors(t *testing.T) {
+	t.Parallel()
+
+	pid := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		env  map[string]string
+		name string
+		want string
+	}{
+		{map[string]string{}, "", "LISTEN_PID"},
+		{map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, "", "LISTEN_PID"},
+		{map[string]string{"LISTEN_PID": pid}, "", "LISTEN_FDS"},
+		{map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2"}, "", "name required"},
+		{map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2", "LISTEN_FDNAMES": "a:b"}, "c", `no socket named "c"`},
+	} {
+		_, err := listenSystemd(func(k string) string { return tc.env[k] }, tc.name)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v %q: want error containing %q, have %v", tc.env, tc.name, tc.want, err)
+		}
+	}
+}
@@ -1,8 +1,18 @@
 package trcweb
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/peterbourgon/trc"
@@ -12,27 +22,217 @@ import (
 // Middleware decorates an HTTP handler by creating a trace for each request via
 // the constructor function. The trace category is determined by the categorize
 // function. Basic metadata, such as method, path, duration, and response code,
-// is recorded in the trace.
+// is recorded in the trace. If the request context is done by the time the
+// handler returns, e.g. because the client disconnected, the status of the
+// trace is set accordingly, see [trc.ContextStatus], unless the handler already
+// set a status.
 //
 // This is meant as a convenience for simple use cases. Users who want different
-// or more sophisticated behavior should implement their own middlewares.
+// or more sophisticated behavior should use [NewMiddleware], or implement their
+// own middlewares.
 func Middleware(
 	constructor func(context.Context, string) (context.Context, trc.Trace),
 	categorize func(*http.Request) string,
 ) func(http.Handler) http.Handler {
+	return NewMiddleware(MiddlewareConfig{
+		Constructor: constructor,
+		Categorize:  categorize,
+	})
+}
+
+// MiddlewareConfig configures a middleware created via [NewMiddleware].
+type MiddlewareConfig struct {
+	// Constructor is used to create a trace for each request. Required.
+	Constructor func(context.Context, string) (context.Context, trc.Trace)
+
+	// Categorize determines the category of the trace for each request. If not
+	// provided, [Categorize] is used.
+	Categorize func(*http.Request) string
+
+	// CategorizeTags determines the category of the trace for each request, as
+	// well as tags which are assigned to the trace via [trc.WithTags], e.g. the
+	// route pattern or the tenant. Tags can be used to filter traces, similar
+	// to categories, but a trace can have many of them. If provided, it takes
+	// precedence over Categorize. Optional.
+	CategorizeTags func(*http.Request) (string, map[string]string)
+
+	// RequestHeaders are the request headers which are recorded in the trace,
+	// if present. If nil, User-Agent, Accept, and Content-Type are recorded.
+	// To record no request headers, use an empty, non-nil slice.
+	RequestHeaders []string
+
+	// ResponseHeaders are the response headers which are recorded in the
+	// trace, if present, after the request has been served. Optional.
+	ResponseHeaders []string
+
+	// QueryParams, if true, means every URL query parameter is recorded in the
+	// trace, as an individual event.
+	QueryParams bool
+
+	// ClientIP, if true, means the IP of the client, taken from the remote
+	// address of the request, is recorded in the trace. If the request has an
+	// X-Forwarded-For header, its value is recorded alongside, as reported,
+	// since it can't be verified here.
+	ClientIP bool
+
+	// Deadline, if true, means the deadline of the request context is recorded
+	// in the trace, as the time remaining when the request is received, or the
+	// absence of a deadline. Deadlines are set by upstream middlewares, e.g.
+	// [http.TimeoutHandler], not by the HTTP server.
+	Deadline bool
+
+	// Protocol, if true, means the protocol of the request, e.g. HTTP/1.1 or
+	// HTTP/2.0, is recorded in the trace.
+	Protocol bool
+
+	// TLS, if true, means the TLS connection state of the request, including
+	// the version, cipher suite, server name, and negotiated protocol, is
+	// recorded in the trace, or the absence of TLS.
+	TLS bool
+
+	// CorrelationHeader is a request header containing a correlation ID, e.g.
+	// X-Request-ID, which is assigned to the trace via trc.WithCorrelationID.
+	// The trace ID in a valid W3C traceparent header is always used as the
+	// correlation ID, if present, and takes precedence. Optional.
+	CorrelationHeader string
+
+	// TraceIDHeader is a response header, e.g. X-Trc-ID, which is set to the
+	// ID of the trace before the wrapped handler is called. Clients, and
+	// support staff, can quote the ID, and operators can paste it directly
+	// into the ID filter of the web interface. Optional.
+	TraceIDHeader string
+
+	// Redact is applied to the value of every recorded header and query
+	// parameter, as well as the query parameters in the recorded URL, and
+	// returns the value to record. It can be used to remove sensitive data,
+	// like credentials or tokens. Optional.
+	Redact func(key, value string) string
+
+	// RecoverPanics, if true, means panics in the wrapped handler are
+	// recovered, and recorded in the trace as an error event with the panic
+	// value and the full stack. If the response hasn't been written, the client
+	// receives HTTP 500. Panics with [http.ErrAbortHandler] are re-panicked
+	// after they're recorded, so the server can abort the response.
+	RecoverPanics bool
+
+	// BodyCaptureBytes, if greater than zero, means up to that many bytes of
+	// the body of each request with one of the BodyContentTypes are recorded
+	// in the trace, as a single event, before the wrapped handler is called.
+	// The captured bytes are replayed to the handler, which reads the complete
+	// body as usual. Optional.
+	BodyCaptureBytes int
+
+	// BodyContentTypes are the lower-case media types of request bodies which
+	// are captured, if BodyCaptureBytes is greater than zero, e.g.
+	// application/x-www-form-urlencoded. If nil, only application/json bodies
+	// are captured.
+	BodyContentTypes []string
+
+	// RedactBody is applied to every captured request body, which may be
+	// truncated, and returns the body to record. It can be used to remove
+	// sensitive data, like passwords. It doesn't affect the body read by the
+	// handler. Optional.
+	RedactBody func(contentType string, body []byte) []byte
+}
+
+var (
+	defaultMiddlewareRequestHeaders   = []string{"User-Agent", "Accept", "Content-Type"}
+	defaultMiddlewareBodyContentTypes = []string{"application/json"}
+)
+
+// NewMiddleware returns a middleware which decorates an HTTP handler by creating
+// a trace for each request, as configured. Basic metadata, such as method,
+// path, duration, and response code, is always recorded in the trace.
+func NewMiddleware(cfg MiddlewareConfig) func(http.Handler) http.Handler {
+	if cfg.Categorize == nil {
+		cfg.Categorize = Categorize
+	}
+	if cfg.CategorizeTags == nil {
+		categorize := cfg.Categorize
+		cfg.CategorizeTags = func(r *http.Request) (string, map[string]string) { return categorize(r), nil }
+	}
+	if cfg.RequestHeaders == nil {
+		cfg.RequestHeaders = defaultMiddlewareRequestHeaders
+	}
+	if cfg.BodyContentTypes == nil {
+		cfg.BodyContentTypes = defaultMiddlewareBodyContentTypes
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx, tr := constructor(r.Context(), categorize(r))
+			ctx := r.Context()
+			correlationID := cfg.correlationID(r)
+			if correlationID != "" {
+				ctx = trc.WithCorrelationID(ctx, correlationID)
+			}
+
+			category, tags := cfg.CategorizeTags(r)
+			if len(tags) > 0 {
+				ctx = trc.WithTags(ctx, tags)
+				tags = trc.TagsFromContext(ctx) // copy, immutable
+			}
+
+			ctx, tr := cfg.Constructor(ctx, category)
 			defer tr.Finish()
 
-			tr.LazyTracef("%s %s %s", r.RemoteAddr, r.Method, r.URL.String())
+			if cfg.TraceIDHeader != "" {
+				w.Header().Set(cfg.TraceIDHeader, tr.ID())
+			}
+
+			tr.LazyTracef("%s %s %s", r.RemoteAddr, r.Method, cfg.redactURL(r.URL))
+
+			if correlationID != "" {
+				tr.LazyTracef("correlation ID %s", correlationID)
+			}
+
+			if len(tags) > 0 {
+				tr.LazyTracef("tags %s", trc.Tags(tags))
+			}
+
+			if cfg.ClientIP {
+				cfg.traceClientIP(tr, r)
+			}
+
+			if cfg.Deadline {
+				if deadline, ok := ctx.Deadline(); ok {
+					tr.LazyTracef("deadline in %s", trcutil.HumanizeDuration(time.Until(deadline)))
+				} else {
+					tr.LazyTracef("no deadline")
+				}
+			}
+
+			if cfg.Protocol {
+				tr.LazyTracef("protocol %s", r.Proto)
+			}
 
-			for _, header := range []string{"User-Agent", "Accept", "Content-Type"} {
+			if cfg.TLS {
+				tr.LazyTracef("%s", describeTLS(r.TLS))
+			}
+
+			for _, header := range cfg.RequestHeaders {
 				if val := r.Header.Get(header); val != "" {
-					tr.LazyTracef("%s: %s", header, val)
+					tr.LazyTracef("%s: %s", header, cfg.redact(header, val))
 				}
 			}
 
+			if cfg.QueryParams {
+				urlquery := r.URL.Query()
+				keys := make([]string, 0, len(urlquery))
+				for key := range urlquery {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					for _, val := range urlquery[key] {
+						tr.LazyTracef("query %s=%s", key, cfg.redact(key, val))
+					}
+				}
+			}
+
+			if cfg.BodyCaptureBytes > 0 {
+				r = cfg.captureBody(tr, r)
+			}
+
 			iw := newInterceptor(w)
 
 			defer func(b time.Time) {
@@ -40,8 +240,30 @@ func Middleware(
 				sent := trcutil.HumanizeBytes(iw.Written())
 				took := trcutil.HumanizeDuration(time.Since(b))
 				tr.LazyTracef("HTTP %d, %s, %s", code, sent, took)
+				if status := trc.ContextStatus(ctx.Err()); status != "" && trc.Status(tr) == "" {
+					tr.LazyTracef("request context: %v", ctx.Err())
+					trc.SetStatus(tr, status)
+				}
 			}(time.Now())
 
+			if len(cfg.ResponseHeaders) > 0 {
+				defer func() {
+					for _, header := range cfg.ResponseHeaders {
+						if val := iw.Header().Get(header); val != "" {
+							tr.LazyTracef("response %s: %s", header, cfg.redact(header, val))
+						}
+					}
+				}()
+			}
+
+			if cfg.RecoverPanics {
+				defer func() {
+					if x := recover(); x != nil {
+						recoverPanic(tr, iw, x)
+					}
+				}()
+			}
+
 			w = iw
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
@@ -49,6 +271,142 @@ func Middleware(
 	}
 }
 
+// recoverPanic records the recovered panic value x in the trace, and responds
+// with HTTP 500, if possible.
+func recoverPanic(tr trc.Trace, iw *interceptor, x any) {
+	tr.Errorf("panic: %v\n%s", x, debug.Stack())
+
+	if x == http.ErrAbortHandler {
+		panic(x)
+	}
+
+	if iw.code == 0 && iw.n == 0 {
+		http.Error(iw, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// captureBody records up to BodyCaptureBytes of the request body in the trace,
+// if it has one of the BodyContentTypes, and returns a shallow copy of the
+// request whose body replays the captured bytes, followed by the rest of the
+// original body.
+func (cfg *MiddlewareConfig) captureBody(tr trc.Trace, r *http.Request) *http.Request {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+	if !contains(cfg.BodyContentTypes, contentType) {
+		return r
+	}
+
+	// Read one more byte than we capture, to detect truncation.
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, int64(cfg.BodyCaptureBytes)+1))
+
+	captured := prefix[:min(len(prefix), cfg.BodyCaptureBytes)]
+	if cfg.RedactBody != nil {
+		captured = cfg.RedactBody(contentType, bytes.Clone(captured))
+	}
+
+	var truncated string
+	if len(prefix) > cfg.BodyCaptureBytes {
+		truncated = " (truncated)"
+	}
+	tr.LazyTracef("request body%s: %s", truncated, string(captured))
+
+	rest := io.Reader(r.Body)
+	if err != nil {
+		tr.LazyTracef("read request body: %v", err)
+		rest = errorReader{err} // the handler should see the same error
+	}
+
+	replay := *r
+	replay.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(prefix), rest), Closer: r.Body}
+	return &replay
+}
+
+// replayBody is a request body which replays bytes that were read from the
+// original body, before reading the rest of it.
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// traceClientIP records the client IP of the request in the trace, and the
+// X-Forwarded-For header, if present.
+func (cfg *MiddlewareConfig) traceClientIP(tr trc.Trace, r *http.Request) {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		tr.LazyTracef("client IP %s, forwarded for %s", ip, cfg.redact("X-Forwarded-For", strings.Join(forwarded, ", ")))
+	} else {
+		tr.LazyTracef("client IP %s", ip)
+	}
+}
+
+// describeTLS returns a summary of the TLS connection state of a request.
+func describeTLS(cs *tls.ConnectionState) string {
+	if cs == nil {
+		return "no TLS"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s", tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite))
+	if cs.ServerName != "" {
+		fmt.Fprintf(&sb, ", server name %s", cs.ServerName)
+	}
+	if cs.NegotiatedProtocol != "" {
+		fmt.Fprintf(&sb, ", ALPN %s", cs.NegotiatedProtocol)
+	}
+	if cs.DidResume {
+		sb.WriteString(", resumed")
+	}
+	if len(cs.PeerCertificates) > 0 {
+		fmt.Fprintf(&sb, ", client certificate %s", cs.PeerCertificates[0].Subject)
+	}
+	return sb.String()
+}
+
+func (cfg *MiddlewareConfig) correlationID(r *http.Request) string {
+	if traceID, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		return traceID
+	}
+	if cfg.CorrelationHeader != "" {
+		return r.Header.Get(cfg.CorrelationHeader)
+	}
+	return ""
+}
+
+func (cfg *MiddlewareConfig) redact(key, value string) string {
+	if cfg.Redact == nil {
+		return value
+	}
+	return cfg.Redact(key, value)
+}
+
+func (cfg *MiddlewareConfig) redactURL(u *url.URL) string {
+	if cfg.Redact == nil || u.RawQuery == "" {
+		return u.String()
+	}
+
+	urlquery := u.Query()
+	for key, vals := range urlquery {
+		for i := range vals {
+			vals[i] = cfg.Redact(key, vals[i])
+		}
+	}
+
+	redacted := *u
+	redacted.RawQuery = urlquery.Encode()
+	return redacted.String()
+}
+
 //
 //
 //
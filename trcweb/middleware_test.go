@@ -0,0 +1,386 @@
+package trcweb_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestMiddlewareConfig(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "abc123")
+		w.WriteHeader(http.StatusTeapot)
+	})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor:     collector.NewTrace,
+		Categorize:      func(*http.Request) string { return "api" },
+		RequestHeaders:  []string{"Trace-ID", "Authorization"},
+		ResponseHeaders: []string{"X-Request-ID"},
+		QueryParams:     true,
+		Redact: func(key, value string) string {
+			if key == "Authorization" || key == "token" {
+				return "REDACTED"
+			}
+			return value
+		},
+	})
+
+	r := httptest.NewRequest("GET", "/foo?token=secret&b=2&a=1", nil)
+	r.Header.Set("Trace-ID", "xyz")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("User-Agent", "test")
+	middleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("traces: want %d, have %d", want, have)
+	}
+
+	var whats []string
+	for _, ev := range res.Traces[0].Events() {
+		whats = append(whats, ev.What)
+	}
+	all := strings.Join(whats, "\n")
+
+	if strings.Contains(all, "secret") {
+		t.Errorf("secret not redacted:\n%s", all)
+	}
+	if strings.Contains(all, "User-Agent") {
+		t.Errorf("unexpected User-Agent:\n%s", all)
+	}
+
+	for _, want := range []string{
+		"/foo?a=1&b=2&token=REDACTED",
+		"Trace-ID: xyz",
+		"Authorization: REDACTED",
+		"query a=1",
+		"query b=2",
+		"query token=REDACTED",
+		"response X-Request-ID: abc123",
+		"HTTP 418",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("missing %q:\n%s", want, all)
+		}
+	}
+}
+
+func TestMiddlewareTraceIDHeader(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor:   collector.NewTrace,
+		TraceIDHeader: "X-Trc-ID",
+	})
+
+	w := httptest.NewRecorder()
+	middleware(handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	id := w.Header().Get("X-Trc-ID")
+	if id == "" {
+		t.Fatalf("missing X-Trc-ID header")
+	}
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{Filter: trc.Filter{IDs: []string{id}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("traces with ID %s: want %d, have %d", id, want, have)
+	}
+}
+
+func TestMiddlewareRecoverPanics(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("kaboom")
+	})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor:   collector.NewTrace,
+		RecoverPanics: true,
+	})
+
+	w := httptest.NewRecorder()
+	middleware(handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if want, have := http.StatusInternalServerError, w.Code; want != have {
+		t.Errorf("code: want %d, have %d", want, have)
+	}
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("traces: want %d, have %d", want, have)
+	}
+
+	tr := res.Traces[0]
+	if !tr.Finished() || !tr.Errored() {
+		t.Errorf("trace: want finished and errored, have finished %v, errored %v", tr.Finished(), tr.Errored())
+	}
+
+	var whats []string
+	for _, ev := range tr.Events() {
+		whats = append(whats, ev.What)
+	}
+	all := strings.Join(whats, "\n")
+	for _, want := range []string{"panic: kaboom", "TestMiddlewareRecoverPanics", "HTTP 500"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("missing %q:\n%s", want, all)
+		}
+	}
+}
+
+func TestMiddlewareStatus(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/explicit":
+			trc.SetStatus(trc.Get(r.Context()), "custom")
+		case "/timeout":
+			ctx, cancel := context.WithTimeout(r.Context(), 0)
+			defer cancel()
+			<-ctx.Done()
+			trc.SetStatus(trc.Get(ctx), trc.ContextStatus(ctx.Err()))
+		}
+	})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(r *http.Request) string { return r.URL.Path },
+	})
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for path, ctx := range map[string]context.Context{
+		"/ok":       context.Background(),
+		"/canceled": canceled,
+		"/explicit": canceled,
+		"/timeout":  context.Background(),
+	} {
+		r := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		middleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	have := map[string]string{}
+	for _, tr := range res.Traces {
+		have[tr.Category()] = tr.Status()
+	}
+	want := map[string]string{
+		"/ok":       "",
+		"/canceled": trc.StatusCanceled,
+		"/explicit": "custom",
+		"/timeout":  trc.StatusTimeout,
+	}
+	for category, status := range want {
+		if status != have[category] {
+			t.Errorf("%s: want status %q, have %q", category, status, have[category])
+		}
+	}
+}
+
+func TestMiddlewareCategorizeTags(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(r *http.Request) string { t.Errorf("Categorize called"); return "" },
+		CategorizeTags: func(r *http.Request) (string, map[string]string) {
+			return "api", map[string]string{"path": r.URL.Path, "tenant": r.Header.Get("X-Tenant")}
+		},
+	})
+
+	for path, tenant := range map[string]string{"/a": "foo", "/b": "foo", "/c": "bar"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("X-Tenant", tenant)
+		middleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{Filter: trc.Filter{Tags: []string{"tenant=foo"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, res.MatchCount; want != have {
+		t.Errorf("match count: want %d, have %d", want, have)
+	}
+	for _, tr := range res.Traces {
+		if want, have := "api", tr.Category(); want != have {
+			t.Errorf("category: want %q, have %q", want, have)
+		}
+		if want, have := "path="+tr.Tags()["path"]+" tenant=foo", tr.Tags().String(); want != have {
+			t.Errorf("tags: want %q, have %q", want, have)
+		}
+	}
+}
+
+func TestMiddlewareBodyCapture(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		w.Write(body) // echo
+	})
+	middleware := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor:      collector.NewTrace,
+		Categorize:       func(r *http.Request) string { return r.URL.Path },
+		BodyCaptureBytes: 32,
+		RedactBody: func(contentType string, body []byte) []byte {
+			return bytes.ReplaceAll(body, []byte("hunter2"), []byte("REDACTED"))
+		},
+	})
+
+	for _, tc := range []struct {
+		path        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"/small", "application/json", `{"password":"hunter2"}`, `request body: {"password":"REDACTED"}`},
+		{"/large", "application/json; charset=utf-8", `{"values":[1,2,3,4,5,6,7,8,9,10,11,12]}`, `request body (truncated): {"values":[1,2,3,4,5,6,7,8,9,10,`},
+		{"/text", "text/plain", `hello`, ``},
+	} {
+		r := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+		r.Header.Set("content-type", tc.contentType)
+		w := httptest.NewRecorder()
+		middleware(handler).ServeHTTP(w, r)
+
+		if want, have := tc.body, w.Body.String(); want != have {
+			t.Errorf("%s: handler body: want %q, have %q", tc.path, want, have)
+		}
+
+		res, err := collector.Search(context.Background(), &trc.SearchRequest{Filter: trc.Filter{Category: tc.path}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s: traces: want %d, have %d", tc.path, want, have)
+		}
+
+		var captured string
+		for _, ev := range res.Traces[0].Events() {
+			if strings.HasPrefix(ev.What, "request body") {
+				captured = ev.What
+			}
+		}
+		if want, have := tc.want, captured; want != have {
+			t.Errorf("%s: captured: want %q, have %q", tc.path, want, have)
+		}
+	}
+}
+
+func TestMiddlewarePeerInfo(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	handler := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor: collector.NewTrace,
+		Categorize:  func(r *http.Request) string { return r.URL.Path },
+		ClientIP:    true,
+		Deadline:    true,
+		Protocol:    true,
+		TLS:         true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deadline" {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	for _, path := range []string{"/tls", "/deadline"} {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	events := func(category string) string {
+		res, err := collector.Search(context.Background(), &trc.SearchRequest{Filter: trc.Filter{Category: category}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s: traces: want %d, have %d", category, want, have)
+		}
+		var whats []string
+		for _, ev := range res.Traces[0].Events() {
+			whats = append(whats, ev.What)
+		}
+		return strings.Join(whats, "\n")
+	}
+
+	all := events("/tls")
+	for _, want := range []string{
+		"client IP 127.0.0.1, forwarded for 203.0.113.1",
+		"no deadline",
+		"protocol HTTP/2.0",
+		"TLS 1.3 TLS_",
+		", ALPN h2",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("/tls: missing %q:\n%s", want, all)
+		}
+	}
+
+	if all := events("/deadline"); !strings.Contains(all, "deadline in ") {
+		t.Errorf("/deadline: missing deadline:\n%s", all)
+	}
+
+	// Peer info is opt-in with NewMiddleware, and off with Middleware.
+	plain := trcweb.Middleware(collector.NewTrace, func(*http.Request) string { return "plain" })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	plain(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	all = events("plain")
+	for _, unwanted := range []string{"client IP", "deadline", "protocol", "TLS"} {
+		if strings.Contains(all, unwanted) {
+			t.Errorf("plain: unexpected %q:\n%s", unwanted, all)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package trcweb_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestCategorize(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		method string
+		target string
+		accept string
+		want   string
+	}{
+		{"GET", "/", "", "traces"},
+		{"HEAD", "/", "", "traces"},
+		{"GET", "/", "text/event-stream", "stream"},
+		{"GET", "/?format=ndjson&all=true", "", "export"},
+		{"GET", "/?compare=a&compare=b", "", "compare"},
+		{"GET", "/traces/stats", "", "stats"},
+		{"GET", "/?stats&category=foo", "", "stats"},
+		{"GET", "/?heatmap&category=foo", "", "heatmap"},
+		{"GET", "/?categories", "", "categories"},
+		{"GET", "/?streams", "", "streams"},
+		{"GET", "/?download&category=foo", "", "download"},
+		{"POST", "/", "application/json", "traces"},
+		{"POST", "/?annotate", "", "annotate"},
+		{"DELETE", "/?category=foo", "", "clear"},
+		{"POST", "/?resize=10&category=foo", "", "resize"},
+		{"POST", "/?pin=abc", "", "pin"},
+		{"POST", "/?unpin=abc", "", "pin"},
+		{"POST", "/?ingest", "", "ingest"},
+		{"POST", "/traces/ingest", "", "ingest"},
+		{"GET", "/traces/ingest", "", "traces"},
+		{"PUT", "/", "", "other"},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, nil)
+		r.Header.Set("accept", tc.accept)
+		if want, have := tc.want, trcweb.Categorize(r); want != have {
+			t.Errorf("%s %s: want %q, have %q", tc.method, tc.target, want, have)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		server    = trcweb.NewTraceServer(collector)
+	)
+	for _, category := range []string{"foo", "foo", "bar"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Finish()
+	}
+	_, active := collector.NewTrace(ctx, "foo")
+	defer active.Finish()
+
+	clear := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("DELETE", target, nil)
+		for k, vs := range header {
+			r.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if want, have := http.StatusForbidden, clear("/?category=foo", nil).Code; want != have {
+		t.Errorf("not enabled: want %d, have %d", want, have)
+	}
+
+	server.Mutations = trcweb.MutationOptions{
+		Clear:     true,
+		Authorize: func(*http.Request) error { return nil },
+	}
+
+	crossOrigin := http.Header{"Origin": []string{"https://evil.example"}}
+	if want, have := http.StatusForbidden, clear("/?category=foo", crossOrigin).Code; want != have {
+		t.Errorf("cross-origin: want %d, have %d", want, have)
+	}
+
+	w := clear("/?category=foo", nil)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("clear: want %d, have %d (%s)", want, have, strings.TrimSpace(w.Body.String()))
+	}
+	var res trcweb.ClearResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want, have := 2, res.Cleared; want != have {
+		t.Errorf("cleared: want %d, have %d", want, have)
+	}
+
+	// The active trace in foo, and the trace in bar, remain.
+	sres, err := collector.Search(ctx, &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, sres.TotalCount; want != have {
+		t.Errorf("remaining: want %d, have %d", want, have)
+	}
+
+	r := httptest.NewRequest("PUT", "/", nil)
+	rw := httptest.NewRecorder()
+	server.ServeHTTP(rw, r)
+	if want, have := http.StatusMethodNotAllowed, rw.Code; want != have {
+		t.Errorf("PUT: want %d, have %d", want, have)
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		server    = trcweb.NewTraceServer(collector)
+	)
+	for i := 0; i < 5; i++ {
+		_, tr := collector.NewTrace(ctx, "foo")
+		tr.Finish()
+	}
+
+	resize := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if want, have := http.StatusForbidden, resize("/?resize=2&category=foo").Code; want != have {
+		t.Errorf("not enabled: want %d, have %d", want, have)
+	}
+
+	server.Mutations = trcweb.MutationOptions{
+		Resize:    true,
+		Authorize: func(*http.Request) error { return nil },
+	}
+
+	for _, target := range []string{"/?resize=abc", "/?resize=0", "/?resize=-1"} {
+		if want, have := http.StatusBadRequest, resize(target).Code; want != have {
+			t.Errorf("%s: want %d, have %d", target, want, have)
+		}
+	}
+
+	w := resize("/?resize=2&category=foo")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("resize: want %d, have %d (%s)", want, have, strings.TrimSpace(w.Body.String()))
+	}
+	var res trcweb.ResizeResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want, have := (trcweb.ResizeResponse{Category: "foo", Size: 2, Evicted: 3}), res; want != have {
+		t.Errorf("response: want %+v, have %+v", want, have)
+	}
+
+	sres, err := collector.Search(ctx, &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, sres.TotalCount; want != have {
+		t.Errorf("remaining: want %d, have %d", want, have)
+	}
+}
+
+func TestPin(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector().SetCategorySize(1)
+		server    = trcweb.NewTraceServer(collector)
+	)
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+	id := tr.ID()
+
+	pin := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if want, have := http.StatusForbidden, pin("/?pin="+id).Code; want != have {
+		t.Errorf("not enabled: want %d, have %d", want, have)
+	}
+
+	server.Mutations = trcweb.MutationOptions{
+		Pin:       true,
+		Authorize: func(*http.Request) error { return nil },
+	}
+
+	for target, want := range map[string]int{
+		"/?pin=":                       http.StatusBadRequest,
+		"/?pin=" + id + "&unpin=" + id: http.StatusBadRequest,
+		"/?pin=unknown":                http.StatusNotFound,
+		"/?unpin=" + id:                http.StatusNotFound,
+	} {
+		if have := pin(target).Code; want != have {
+			t.Errorf("%s: want %d, have %d", target, want, have)
+		}
+	}
+
+	w := pin("/?pin=" + id)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("pin: want %d, have %d (%s)", want, have, strings.TrimSpace(w.Body.String()))
+	}
+	var res trcweb.PinResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want, have := id, strings.Join(res.Pinned, " "); want != have {
+		t.Errorf("pinned: want %q, have %q", want, have)
+	}
+
+	_, other := collector.NewTrace(ctx, "foo") // would evict the pinned trace
+	other.Finish()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("accept", "text/html")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<summary>pinned=1</summary>`,
+		`<span class="pinned" title="Pinned, so it won't be evicted">pinned</span>`,
+		`id="` + id + `-pin" class="pin-link pinned"`,
+		`id="` + other.ID() + `-pin" class="pin-link"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML doesn't contain %s", want)
+		}
+	}
+
+	if want, have := http.StatusOK, pin("/?unpin="+id).Code; want != have {
+		t.Errorf("unpin: want %d, have %d", want, have)
+	}
+	if want, have := 0, len(collector.Pinned()); want != have {
+		t.Errorf("pinned after unpin: want %d, have %d", want, have)
+	}
+}
+
+func TestIngest(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		server    = trcweb.NewTraceServer(collector)
+	)
+
+	ingest := func(target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	if want, have := http.StatusForbidden, ingest("/traces/ingest", `{"category":"foo"}`).Code; want != have {
+		t.Errorf("not enabled: want %d, have %d", want, have)
+	}
+
+	server.Mutations = trcweb.MutationOptions{
+		Ingest:    true,
+		Authorize: func(*http.Request) error { return nil },
+	}
+
+	for body, want := range map[string]int{
+		``:                    http.StatusBadRequest,
+		`{"category":`:        http.StatusBadRequest,
+		`{"id":"abc"}`:        http.StatusBadRequest,
+		`[{"category":"a"},]`: http.StatusBadRequest,
+		`null`:                http.StatusBadRequest,
+		`{"category":"a","started":"1969-12-31T00:00:00Z"}`: http.StatusBadRequest,
+	} {
+		if have := ingest("/traces/ingest", body).Code; want != have {
+			t.Errorf("%q: want %d, have %d", body, want, have)
+		}
+	}
+
+	body := strings.Join([]string{
+		`{"id":"one","category":"script","duration_sec":1.5}`,
+		`[{"id":"two","category":"script","source":"cron","events":[{"what":"failed","is_error":true}]}]`,
+	}, "\n")
+	w := ingest("/traces/ingest", body)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("ingest: want %d, have %d (%s)", want, have, strings.TrimSpace(w.Body.String()))
+	}
+	var res trcweb.IngestResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want, have := 2, res.Ingested; want != have {
+		t.Errorf("ingested: want %d, have %d", want, have)
+	}
+
+	if want, have := http.StatusOK, ingest("/?ingest&source=sidecar", `{"category":"script"}`).Code; want != have {
+		t.Errorf("ingest with source: want %d, have %d", want, have)
+	}
+
+	search, err := collector.Search(ctx, &trc.SearchRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	sources := map[string]string{}
+	for _, tr := range search.Traces {
+		if !tr.Finished() {
+			t.Errorf("%s: not finished", tr.ID())
+		}
+		switch tr.ID() {
+		case "one":
+			if want, have := 1500*time.Millisecond, tr.Duration(); want != have {
+				t.Errorf("%s: duration: want %s, have %s", tr.ID(), want, have)
+			}
+		case "two":
+			if !tr.Errored() {
+				t.Errorf("%s: not errored", tr.ID())
+			}
+		}
+		sources[tr.ID()] = tr.Source()
+	}
+	if want, have := 3, len(sources); want != have {
+		t.Fatalf("traces: want %d, have %d (%v)", want, have, sources)
+	}
+	if want, have := trcweb.DefaultIngestSource, sources["one"]; want != have {
+		t.Errorf("one: source: want %q, have %q", want, have)
+	}
+	if want, have := "cron", sources["two"]; want != have {
+		t.Errorf("two: source: want %q, have %q", want, have)
+	}
+	for id, source := range sources {
+		if id != "one" && id != "two" && source != "sidecar" {
+			t.Errorf("%s: source: want %q, have %q", id, "sidecar", source)
+		}
+	}
+
+	// Events beyond the max are truncated, like core traces.
+	var (
+		max    = trc.TraceMaxEvents()
+		events = make([]string, max+5)
+	)
+	for i := range events {
+		events[i] = fmt.Sprintf(`{"what":"event %d"}`, i+1)
+	}
+	if want, have := http.StatusOK, ingest("/traces/ingest", `{"id":"long","category":"long","events":[`+strings.Join(events, ",")+`]}`).Code; want != have {
+		t.Fatalf("ingest long trace: want %d, have %d", want, have)
+	}
+	search, err = collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{IDs: []string{"long"}}})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if want, have := 1, len(search.Traces); want != have {
+		t.Fatalf("long trace: want %d, have %d", want, have)
+	}
+	long := search.Traces[0].Events()
+	if want, have := max, len(long); want != have {
+		t.Errorf("long trace: events: want %d, have %d", want, have)
+	}
+	if want, have := "(truncated event count 6)", long[len(long)-1].What; want != have {
+		t.Errorf("long trace: last event: want %q, have %q", want, have)
+	}
+}
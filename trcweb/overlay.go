@@ -0,0 +1,114 @@
+package trcweb
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"sync"
+
+	"github.com/peterbourgon/trc/trcweb/assets"
+)
+
+// RegisterAssets adds an overlay for the assets used to render the web
+// interface, for every trace server in the process. Files in the root of the
+// overlay replace embedded assets with the same name, e.g. traces.css, and
+// other files are parsed as additional templates, which replacement assets can
+// reference. Overlays registered later take precedence over those registered
+// earlier.
+//
+// Templates are parsed together, so replacement templates must define the same
+// named templates as the originals they replace, and can use the same template
+// functions. Customizing only traces.css is usually the simplest way to brand
+// the web interface, e.g.
+//
+//	//go:embed custom/traces.css
+//	var custom embed.FS
+//
+//	func init() {
+//	    sub, _ := fs.Sub(custom, "custom")
+//	    trcweb.RegisterAssets(sub)
+//	}
+//
+// To customize the assets of a single trace server, see [TraceServer.Assets].
+func RegisterAssets(fsys fs.FS) {
+	registeredAssetsMtx.Lock()
+	defer registeredAssetsMtx.Unlock()
+	registeredAssets = append(registeredAssets, fsys)
+}
+
+var (
+	registeredAssetsMtx sync.Mutex
+	registeredAssets    []fs.FS
+)
+
+// assetsFS returns the embedded assets, overlaid with the assets registered
+// via RegisterAssets, and then the given overlays, if any, in that order.
+func assetsFS(overlays ...fs.FS) fs.FS {
+	registeredAssetsMtx.Lock()
+	layers := append([]fs.FS{assets.FS}, registeredAssets...)
+	registeredAssetsMtx.Unlock()
+
+	for _, overlay := range overlays {
+		if overlay != nil {
+			layers = append(layers, overlay)
+		}
+	}
+	if len(layers) == 1 {
+		return assets.FS
+	}
+	return overlayFS(layers)
+}
+
+// overlayFS merges multiple file systems, with files in later layers replacing
+// files with the same name in earlier layers.
+type overlayFS []fs.FS
+
+var _ fs.ReadDirFS = overlayFS(nil)
+
+// Open implements fs.FS.
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for i := len(o) - 1; i >= 0; i-- {
+		f, err := o[i].Open(name)
+		switch {
+		case err == nil:
+			return f, nil
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		default:
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS, merging the entries of every layer.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		found   bool
+		entries = map[string]fs.DirEntry{}
+	)
+	for _, layer := range o {
+		des, err := fs.ReadDir(layer, name)
+		switch {
+		case err == nil:
+			found = true
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		default:
+			return nil, err
+		}
+		for _, de := range des {
+			entries[de.Name()] = de
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, de := range entries {
+		result = append(result, de)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
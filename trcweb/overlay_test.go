@@ -0,0 +1,82 @@
+package trcweb
+
+import (
+	"context"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestOverlayFS(t *testing.T) {
+	t.Parallel()
+
+	var (
+		base  = fstest.MapFS{"a.html": {Data: []byte("base a")}, "b.css": {Data: []byte("base b")}}
+		top   = fstest.MapFS{"b.css": {Data: []byte("top b")}, "c.html": {Data: []byte("top c")}}
+		layer = overlayFS{base, top}
+	)
+
+	for name, want := range map[string]string{"a.html": "base a", "b.css": "top b", "c.html": "top c"} {
+		have, err := fs.ReadFile(layer, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if want != string(have) {
+			t.Errorf("%s: want %q, have %q", name, want, have)
+		}
+	}
+
+	if _, err := layer.Open("d.html"); err == nil {
+		t.Errorf("d.html: want error, have none")
+	}
+
+	names, err := fs.Glob(layer, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "a.html b.css c.html", strings.Join(names, " "); want != have {
+		t.Errorf("Glob: want %q, have %q", want, have)
+	}
+}
+
+func TestTraceServerAssets(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	_, tr := collector.NewTrace(context.Background(), "foo")
+	tr.Finish()
+
+	server := NewTraceServer(collector)
+	server.Assets = fstest.MapFS{
+		"traces.css": {Data: []byte(`.custom-brand { color: {{ template "brand.html" }}; }`)},
+		"brand.html": {Data: []byte(`purple`)},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("accept", "text/html")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if body := w.Body.String(); !strings.Contains(body, tr.ID()) {
+		t.Errorf("response doesn't contain %q", tr.ID())
+	}
+
+	// The CSS is served as an asset, which is rendered with the overlay.
+	r = httptest.NewRequest("GET", "/?asset=traces.css", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	if want, body := ".custom-brand { color: purple; }", w.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("asset doesn't contain %q", want)
+	}
+
+	// Other servers use the default assets.
+	w = httptest.NewRecorder()
+	NewTraceServer(collector).ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "custom-brand") {
+		t.Errorf("default server uses custom assets")
+	}
+}
@@ -0,0 +1,58 @@
+package trcweb
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/peterbourgon/trc"
+)
+
+// PinResponse is returned by pin requests. Pinned contains the IDs of every
+// pinned trace, after the request was applied.
+type PinResponse struct {
+	Pinned []string `json:"pinned"`
+}
+
+func (s *TraceServer) handlePin(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx      = r.Context()
+		tr       = trc.Get(ctx)
+		urlquery = r.URL.Query()
+	)
+
+	if !s.authorizeMutation(w, r, "pin", s.Mutations.Pin) {
+		return
+	}
+
+	if s.Pinner == nil {
+		tr.Errorf("pin: no pinner")
+		http.Error(w, "pin not supported", http.StatusNotImplemented)
+		return
+	}
+
+	var (
+		pin, unpin = urlquery.Get("pin"), urlquery.Get("unpin")
+		err        error
+	)
+	switch {
+	case pin != "" && unpin == "":
+		tr.LazyTracef("pin %s", pin)
+		err = s.Pinner.Pin(pin)
+	case unpin != "" && pin == "":
+		tr.LazyTracef("unpin %s", unpin)
+		err = s.Pinner.Unpin(unpin)
+	default:
+		http.Error(w, "bad request: pin requires exactly one of pin=ID or unpin=ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case err == nil:
+		renderJSON(ctx, w, r, PinResponse{Pinned: s.Pinner.Pinned()})
+	case errors.Is(err, trc.ErrTraceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		tr.Errorf("pin: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
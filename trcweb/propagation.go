@@ -0,0 +1,123 @@
+package trcweb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/peterbourgon/trc"
+)
+
+// Propagator is an HTTP round tripper which injects the trace in the context of
+// each outbound request into that request, so that traces can be correlated
+// across services. Requests without a trace in their context are unmodified.
+//
+// The trace is injected as a W3C traceparent header. If the trace has a
+// correlation ID which is a W3C trace ID, e.g. because it was created by a
+// [Middleware] serving a request with a traceparent header, then that trace ID
+// is propagated. Otherwise, the trc trace ID is used as the W3C trace ID, or,
+// if it isn't a ULID, e.g. because of [trc.SetTraceIDGenerator], a hash of the
+// trc trace ID. An existing traceparent header in the request is preserved.
+type Propagator struct {
+	// Next is used to execute the requests. If not provided, the
+	// http.DefaultTransport is used.
+	Next http.RoundTripper
+
+	// Header, if provided, is an additional request header which is set to the
+	// correlation ID of the trace, if it has one, or to the trc trace ID
+	// otherwise, e.g. X-Request-ID. Optional.
+	Header string
+}
+
+var _ http.RoundTripper = (*Propagator)(nil)
+
+// NewPropagator returns a propagator which executes requests via next.
+func NewPropagator(next http.RoundTripper) *Propagator {
+	return &Propagator{Next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *Propagator) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := p.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	tr, ok := trc.MaybeGet(req.Context())
+	if !ok {
+		return next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context()) // round trippers must not modify the request
+
+	if req.Header.Get(traceparentHeader) == "" {
+		if traceparent, ok := newTraceparent(tr); ok {
+			req.Header.Set(traceparentHeader, traceparent)
+			tr.LazyTracef("propagate %s: %s", traceparentHeader, traceparent)
+		}
+	}
+
+	if p.Header != "" && req.Header.Get(p.Header) == "" {
+		id := trc.CorrelationID(tr)
+		if id == "" {
+			id = tr.ID()
+		}
+		req.Header.Set(p.Header, id)
+	}
+
+	return next.RoundTrip(req)
+}
+
+//
+//
+//
+
+const traceparentHeader = "traceparent"
+
+var traceparentRegexp = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// parseTraceparent returns the trace ID from a W3C traceparent header value.
+func parseTraceparent(s string) (traceID string, ok bool) {
+	m := traceparentRegexp.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return "", false
+	case m[1] == "ff": // invalid version
+		return "", false
+	case m[1] == "00" && m[5] != "": // version 00 has exactly 4 fields
+		return "", false
+	case m[2] == "00000000000000000000000000000000": // invalid trace ID
+		return "", false
+	case m[3] == "0000000000000000": // invalid parent ID
+		return "", false
+	}
+	return m[2], true
+}
+
+// newTraceparent returns a W3C traceparent header value for an outbound request
+// made within the given trace, with a new random parent ID.
+func newTraceparent(tr trc.Trace) (string, bool) {
+	traceID := trc.CorrelationID(tr)
+	if !isW3CTraceID(traceID) {
+		if id, err := ulid.Parse(tr.ID()); err == nil {
+			traceID = hex.EncodeToString(id[:])
+		} else {
+			traceID = sha256hex(tr.ID())[:32]
+		}
+	}
+
+	var parentID [8]byte
+	if _, err := rand.Read(parentID[:]); err != nil {
+		return "", false
+	}
+
+	return "00-" + traceID + "-" + hex.EncodeToString(parentID[:]) + "-01", true
+}
+
+var w3cTraceIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func isW3CTraceID(s string) bool {
+	return w3cTraceIDRegexp.MatchString(s) && s != "00000000000000000000000000000000"
+}
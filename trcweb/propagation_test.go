@@ -0,0 +1,93 @@
+package trcweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestPropagation(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		upstream   = trc.NewDefaultCollector()
+		downstream = trc.NewDefaultCollector()
+		traceID    = "4bf92f3577b34da6a3ce929d0e0e4736"
+		received   = make(chan http.Header, 1)
+	)
+
+	downstreamServer := httptest.NewServer(trcweb.Middleware(downstream.NewTrace, trcweb.Categorize)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	})))
+	defer downstreamServer.Close()
+
+	client := &http.Client{Transport: &trcweb.Propagator{Header: "X-Request-ID"}}
+
+	upstreamHandler := trcweb.NewMiddleware(trcweb.MiddlewareConfig{
+		Constructor: upstream.NewTrace,
+		Categorize:  func(*http.Request) string { return "api" },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", downstreamServer.URL, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("downstream request: %v", err)
+			return
+		}
+		res.Body.Close()
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	upstreamHandler.ServeHTTP(httptest.NewRecorder(), r)
+
+	headers := <-received
+	if want, have := `^00-`+traceID+`-[0-9a-f]{16}-01$`, headers.Get("traceparent"); !regexp.MustCompile(want).MatchString(have) {
+		t.Errorf("traceparent: want %s, have %s", want, have)
+	}
+	if want, have := traceID, headers.Get("X-Request-ID"); want != have {
+		t.Errorf("X-Request-ID: want %s, have %s", want, have)
+	}
+
+	for name, collector := range map[string]*trc.Collector{"upstream": upstream, "downstream": downstream} {
+		res, err := collector.Search(ctx, &trc.SearchRequest{Filter: trc.Filter{IDs: []string{traceID}}})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want, have := 1, len(res.Traces); want != have {
+			t.Fatalf("%s: want %d trace(s), have %d", name, want, have)
+		}
+		if want, have := traceID, res.Traces[0].CorrelationID(); want != have {
+			t.Errorf("%s: correlation ID: want %s, have %s", name, want, have)
+		}
+	}
+}
+
+func TestPropagatorWithoutCorrelation(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer server.Close()
+
+	ctx, tr := trc.New(context.Background(), "src", "cat")
+	defer tr.Finish()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	res, err := (&http.Client{Transport: trcweb.NewPropagator(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if want, have := `^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`, (<-received).Get("traceparent"); !regexp.MustCompile(want).MatchString(have) {
+		t.Errorf("traceparent: want %s, have %s", want, have)
+	}
+}
@@ -0,0 +1,111 @@
+package trcweb
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the rate of requests from individual clients, using a
+// token bucket per client. Clients are identified by a key, which by default
+// is the host part of the request's remote address.
+type RateLimiter struct {
+	// Rate is the number of requests per second allowed for each client, on
+	// average. Required.
+	Rate float64
+
+	// Burst is the maximum number of requests allowed for a client in a
+	// single burst. The default is 1, or Rate rounded up, whichever is greater.
+	Burst int
+
+	// Key returns the key identifying the client which made the request, e.g.
+	// an authenticated principal. Optional.
+	Key func(*http.Request) string
+
+	mtx     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter returns a rate limiter allowing the given number of requests
+// per second from each client, keyed by remote address.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		Rate:  rate,
+		Burst: burst,
+	}
+}
+
+// Allow returns true if the request is within the rate limit for its client,
+// consuming a token from that client's bucket, and false otherwise.
+func (rl *RateLimiter) Allow(r *http.Request) bool {
+	return rl.allowAt(rl.key(r), time.Now())
+}
+
+func (rl *RateLimiter) key(r *http.Request) string {
+	if rl.Key != nil {
+		return rl.Key(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (rl *RateLimiter) allowAt(key string, now time.Time) bool {
+	if rl.Rate <= 0 {
+		return true
+	}
+
+	burst := float64(rl.Burst)
+	if min := math.Max(1, math.Ceil(rl.Rate)); burst < min {
+		burst = min
+	}
+
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	if rl.buckets == nil {
+		rl.buckets = map[string]*tokenBucket{}
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		rl.prune(now, burst)
+		b = &tokenBucket{tokens: burst, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// prune removes buckets which would be full by now, as they're equivalent to
+// new buckets. It's only done when the number of buckets grows large, so that
+// the cost is amortized over many requests.
+func (rl *RateLimiter) prune(now time.Time, burst float64) {
+	if len(rl.buckets) < rateLimiterPruneThreshold {
+		return
+	}
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.Rate >= burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+const rateLimiterPruneThreshold = 1024
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
@@ -0,0 +1,51 @@
+package trcweb_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	rl := trcweb.NewRateLimiter(0.001, 2)
+
+	newRequest := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	for i, want := range []bool{true, true, false, false} {
+		if have := rl.Allow(newRequest("1.2.3.4:5678")); want != have {
+			t.Errorf("request %d: want %v, have %v", i+1, want, have)
+		}
+	}
+
+	if !rl.Allow(newRequest("5.6.7.8:1234")) {
+		t.Errorf("distinct client was rate limited")
+	}
+
+	if rl.Allow(newRequest("1.2.3.4:1111")) {
+		t.Errorf("same host with a different port wasn't rate limited")
+	}
+}
+
+func TestTraceServerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server := trcweb.NewTraceServer(trc.NewDefaultCollector())
+	server.RateLimiter = trcweb.NewRateLimiter(0.001, 1)
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if have := w.Code; want != have {
+			t.Errorf("request %d: want HTTP %d, have HTTP %d", i+1, want, have)
+		}
+	}
+}
@@ -2,12 +2,15 @@ package trcweb
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"mime"
 	"net/http"
@@ -22,6 +25,7 @@ import (
 	"github.com/peterbourgon/trc"
 	"github.com/peterbourgon/trc/internal/trcdebug"
 	"github.com/peterbourgon/trc/internal/trcutil"
+	"github.com/peterbourgon/trc/trcproto"
 )
 
 func renderResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, fs fs.FS, templateName string, funcs template.FuncMap, data any) {
@@ -34,52 +38,185 @@ func renderResponse(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	)
 	switch {
 	case useHTML:
-		renderHTML(ctx, w, fs, templateName, funcs, data)
+		renderHTML(ctx, w, r, fs, templateName, funcs, data)
 	case useJSON:
-		renderJSON(ctx, w, data)
+		renderJSON(ctx, w, r, data)
 	default:
-		renderJSON(ctx, w, data)
+		renderJSON(ctx, w, r, data)
 	}
 }
 
-func renderHTML(ctx context.Context, w http.ResponseWriter, fs fs.FS, templateName string, funcs template.FuncMap, data any) {
+func renderHTML(ctx context.Context, w http.ResponseWriter, r *http.Request, fs fs.FS, templateName string, funcs template.FuncMap, data any) {
 	tr := trc.Get(ctx)
 
 	code := http.StatusOK
 	body, err := renderTemplate(ctx, fs, templateName, funcs, data)
 	if err != nil {
 		tr.LazyErrorf("render template: %v", err)
-		code = http.StatusInternalServerError
+		code = iff(errors.Is(err, errRenderTimeout), http.StatusServiceUnavailable, http.StatusInternalServerError)
 		body = []byte(fmt.Sprintf(`<html><body><h1>Error</h1><p>%v</p>`, err))
 	}
 
 	w.Header().Set("content-type", "text/html; charset=utf-8")
-	w.WriteHeader(code)
-	w.Write(body)
+	writeBody(ctx, w, r, code, body)
 }
 
-func renderJSON(ctx context.Context, w http.ResponseWriter, data any) {
+func renderJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, data any) {
 	tr := trc.Get(ctx)
 
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetIndent("", "    ")
+	begin := time.Now()
+	body, err := renderBounded(ctx, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(data)
+	})
 
 	code := http.StatusOK
-	if err := enc.Encode(data); err != nil {
+	switch {
+	case errors.Is(err, errRenderTimeout):
+		code = http.StatusServiceUnavailable
+		tr.LazyErrorf("marshal JSON: %v", err)
+		body = []byte(`{"error":"render timeout"}`)
+	case err != nil:
 		code = http.StatusInternalServerError
 		tr.LazyErrorf("marshal JSON: %v", err)
-		buf.Reset()
-		buf.WriteString(`{"error":"failed to marshal response"}`)
-	} else {
-		tr.LazyTracef("marshaled JSON response (%s)", trcutil.HumanizeBytes(buf.Len()))
+		body = []byte(`{"error":"failed to marshal response"}`)
+	default:
+		tr.LazyTracef("marshaled JSON response (%s) in %s", trcutil.HumanizeBytes(len(body)), trcutil.HumanizeDuration(time.Since(begin)))
 	}
 
 	w.Header().Set("content-type", "application/json; charset=utf-8")
+	writeBody(ctx, w, r, code, body)
+}
+
+type renderTimeoutContextKey struct{}
+
+// withRenderTimeout returns a context which bounds the rendering of the
+// response to the request, see [TraceServer.RenderTimeout].
+func withRenderTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, renderTimeoutContextKey{}, timeout)
+}
+
+var errRenderTimeout = errors.New("render timeout")
+
+// renderBounded calls render with a writer to a buffer, and returns the bytes
+// written to the buffer. If the context has a render timeout, and render
+// doesn't return in time, renderBounded returns errRenderTimeout without
+// waiting for it, and subsequent writes by render fail, so that e.g. template
+// execution stops as soon as possible.
+func renderBounded(ctx context.Context, render func(io.Writer) error) ([]byte, error) {
+	timeout, ok := ctx.Value(renderTimeoutContextKey{}).(time.Duration)
+	if !ok {
+		var buf bytes.Buffer
+		err := render(&buf)
+		return buf.Bytes(), err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		cw   = &contextWriter{ctx: ctx}
+		errc = make(chan error, 1)
+	)
+	go func() {
+		defer func() {
+			if x := recover(); x != nil {
+				errc <- fmt.Errorf("PANIC: %v", x)
+			}
+		}()
+		errc <- render(cw)
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", errRenderTimeout, timeout) // failed write
+		}
+		return cw.buf.Bytes(), err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s", errRenderTimeout, timeout)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// contextWriter writes to a buffer until the context is done, after which
+// writes fail with the context error.
+type contextWriter struct {
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.buf.Write(p)
+}
+
+func renderProtobuf(ctx context.Context, w http.ResponseWriter, r *http.Request, body []byte) {
+	trc.Get(ctx).LazyTracef("marshaled protobuf response (%s)", trcutil.HumanizeBytes(len(body)))
+	w.Header().Set("content-type", trcproto.ContentType)
+	writeBody(ctx, w, r, http.StatusOK, body)
+}
+
+// writeBody writes the response code and body to the response writer. If the
+// request accepts gzip encoding, and the body is large enough to benefit, the
+// body is compressed.
+func writeBody(ctx context.Context, w http.ResponseWriter, r *http.Request, code int, body []byte) {
+	tr := trc.Get(ctx)
+
+	w.Header().Add("vary", "accept-encoding")
+
+	if len(body) < gzipMinSizeBytes || !requestAcceptsEncoding(r, "gzip") {
+		w.WriteHeader(code)
+		w.Write(body)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		tr.LazyErrorf("gzip response: %v", err)
+		w.WriteHeader(code)
+		w.Write(body)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		tr.LazyErrorf("gzip response: %v", err)
+		w.WriteHeader(code)
+		w.Write(body)
+		return
+	}
+
+	tr.LazyTracef("gzip response (%s -> %s)", trcutil.HumanizeBytes(len(body)), trcutil.HumanizeBytes(buf.Len()))
+
+	w.Header().Set("content-encoding", "gzip")
 	w.WriteHeader(code)
 	buf.WriteTo(w)
 }
 
+const gzipMinSizeBytes = 1024
+
+func requestAcceptsEncoding(r *http.Request, encoding string) bool {
+	for _, a := range strings.Split(r.Header.Get("accept-encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(a), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		if q := strings.ReplaceAll(strings.TrimSpace(params), " ", ""); q == "q=0" || q == "q=0.0" {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
 func requestExplicitlyAccepts(r *http.Request, acceptable ...string) bool {
 	accept := parseAcceptMediaTypes(r)
 	for _, want := range acceptable {
@@ -104,7 +241,10 @@ func parseAcceptMediaTypes(r *http.Request) map[string]map[string]string {
 
 // AssetsDirEnvKey can be set to a local path for the assets directory, in which
 // case those files will be used when rendering assets, instead of the embedded
-// assets. This is especially useful when developing.
+// assets. This is especially useful when developing. Local files take
+// precedence over overlays provided via [RegisterAssets] or
+// [TraceServer.Assets], which are the preferred way to customize assets in
+// production.
 const AssetsDirEnvKey = "TRC_ASSETS_DIR"
 
 func renderTemplate(ctx context.Context, fs fs.FS, templateName string, userFuncs template.FuncMap, data any) (_ []byte, err error) {
@@ -157,14 +297,15 @@ func renderTemplate(ctx context.Context, fs fs.FS, templateName string, userFunc
 
 	tr.LazyTracef("template.Lookup(%s) OK", templateName)
 
-	var templateBuf bytes.Buffer
-	if err := templateFile.Execute(&templateBuf, data); err != nil {
+	begin := time.Now()
+	body, err := renderBounded(ctx, func(w io.Writer) error { return templateFile.Execute(w, data) })
+	if err != nil {
 		return nil, fmt.Errorf("execute template: %w", err)
 	}
 
-	tr.LazyTracef("template.Execute OK, %s", trcutil.HumanizeBytes(templateBuf.Len()))
+	tr.LazyTracef("template.Execute OK, %s in %s", trcutil.HumanizeBytes(len(body)), trcutil.HumanizeDuration(time.Since(begin)))
 
-	return templateBuf.Bytes(), nil
+	return body, nil
 }
 
 //
@@ -203,6 +344,7 @@ var templateFuncs = template.FuncMap{
 	"SourceLink":           func(fileline string) template.URL { return sourceLinkFunc.Get()(fileline) },
 	"AddInt":               func(i, j int) int { return i + j },
 	"AddFloat":             func(i, j float64) float64 { return i + j },
+	"MulFloat":             func(i, j float64) float64 { return i * j },
 	"PercentInt":           func(n, d int) int { return int(100 * float64(n) / float64(d)) },
 	"PercentUint64":        func(n, d uint64) int { return int(100 * float64(n) / float64(d)) },
 	"PercentDuration":      func(n, d time.Duration) int { return int(100 * float64(n) / float64(d)) },
@@ -220,6 +362,9 @@ var templateFuncs = template.FuncMap{
 	"URLEncode":            func(s string) template.URL { return template.URL(url.QueryEscape(s)) },
 	"SafeURL":              func(s string) template.URL { return template.URL(s) },
 	"DefaultBucketing":     func() []time.Duration { return trc.DefaultBucketing },
+	"SearchSorts":          func() []trc.SearchSort { return trc.SearchSorts },
+	"Levels":               func() []trc.Level { return trc.Levels },
+	"Version":              trc.Version,
 	"StringsJoinNewline":   func(a []string) string { return strings.Join(a, string([]byte{0xa})) },
 	"ReflectDeepEqual":     func(a, b any) bool { return reflect.DeepEqual(a, b) },
 	"PositiveDuration":     func(d time.Duration) time.Duration { return iff(d > 0, d, 0) },
@@ -231,10 +376,18 @@ var templateFuncs = template.FuncMap{
 	"HumanizeBytes":        trcutil.HumanizeBytes[int],
 	"HumanizeFunction":     humanizeFunction,
 	"CategoryClass":        categoryClass,
+	"DisplayID":            trc.DisplayID,
 	"HighlightClasses":     highlightClasses,
 	"DebugInfo":            debugInfo,
 	"FlexGrowPercent":      flexGrowPercent,
 	"RenderEvents":         renderEvents,
+	"QueryRegexp":          queryRegexp,
+	"BucketHistogram":      bucketHistogram,
+	"CompareLabel":         compareLabel,
+	"SignedDuration":       signedDuration,
+	"SearchPermalink":      searchPermalink,
+	"SearchFormHidden":     searchFormHidden,
+	"AssetURL":             func(string) template.URL { return "" }, // assets are inlined, unless served, see TraceServer.assetFuncs
 }
 
 func humanizeFunction(s string) string {
@@ -268,6 +421,50 @@ func highlightClasses(f trc.Filter) []string {
 	return classes
 }
 
+// histogramBar is a single bar in an inline histogram of bucket counts.
+type histogramBar struct {
+	Min     time.Duration
+	Count   int
+	Percent int // of the largest bar
+}
+
+// bucketHistogram converts cumulative bucket counts to the number of traces
+// within each individual bucket, for rendering as an inline histogram.
+func bucketHistogram(bucketing []time.Duration, counts []int) []histogramBar {
+	if len(bucketing) != len(counts) {
+		return nil
+	}
+
+	bars := make([]histogramBar, len(counts))
+	var max int
+	for i := range counts {
+		n := counts[i]
+		if i < len(counts)-1 {
+			n -= counts[i+1]
+		}
+		bars[i] = histogramBar{Min: bucketing[i], Count: n}
+		if n > max {
+			max = n
+		}
+	}
+	for i := range bars {
+		if max > 0 {
+			bars[i].Percent = int(100 * float64(bars[i].Count) / float64(max))
+		}
+	}
+	return bars
+}
+
+// queryRegexp returns the regexp part of a filter query, excluding conditions
+// like category:foo, for highlighting matches.
+func queryRegexp(query string) string {
+	f, err := trc.ParseFilterQuery(query)
+	if err != nil {
+		return ""
+	}
+	return f.Query
+}
+
 func debugInfo() string {
 	var (
 		tn = trcdebug.CoreTraceNewCount.Load()
@@ -279,22 +476,17 @@ func debugInfo() string {
 		en = trcdebug.CoreEventNewCount.Load()
 		ea = trcdebug.CoreEventAllocCount.Load()
 		ef = trcdebug.CoreEventFreeCount.Load()
-		el = trcdebug.CoreEventLostCount.Load()
 		er = 100 * float64(ef) / float64(en)
 
-		sn = trcdebug.StringerNewCount.Load()
-		sa = trcdebug.StringerAllocCount.Load()
-		sf = trcdebug.StringerFreeCount.Load()
-		sl = trcdebug.StringerLostCount.Load()
-		sr = 100 * float64(sf) / float64(sn)
+		fp = trcdebug.FormatPanicCount.Load()
 	)
 	buf := &bytes.Buffer{}
 	tw := tabwriter.NewWriter(buf, 0, 2, 2, ' ', 0)
 	fmt.Fprintf(tw, "KIND\tNEW\tALLOC\tFREE\tLOST\tREUSE\n")
 	fmt.Fprintf(tw, "coreTrace\t%d\t%d\t%d\t%d\t%.2f%%\n", tn, ta, tf, tl, tr)
-	fmt.Fprintf(tw, "coreEvent\t%d\t%d\t%d\t%d\t%.2f%%\n", en, ea, ef, el, er)
-	fmt.Fprintf(tw, "stringer\t%d\t%d\t%d\t%d\t%.2f%%\n", sn, sa, sf, sl, sr)
+	fmt.Fprintf(tw, "coreEvent\t%d\t%d\t%d\t-\t%.2f%%\n", en, ea, ef, er)
 	tw.Flush()
+	fmt.Fprintf(buf, "\nformatting panics: %d\n", fp)
 	return buf.String()
 }
 
@@ -325,26 +517,31 @@ func renderEvents(st *trc.StaticTrace) []renderEvent {
 		What:    "start",
 	})
 
-	// Actual trace events.
-	prev := st.TraceStarted
+	// Actual trace events. Deltas are computed from offsets, rather than wall
+	// clock timestamps, which can go backwards.
+	var prev time.Duration
 	for i, ev := range st.TraceEvents {
-		delta := ev.When.Sub(prev)
+		offset := ev.OffsetFrom(st.TraceStarted)
+		delta := offset - prev
 		events = append(events, renderEvent{
 			Index:        i,
 			When:         ev.When,
 			Delta:        delta,
 			DeltaPercent: 100 * float64(delta) / float64(st.TraceDuration),
-			Cumulative:   ev.When.Sub(st.TraceStarted),
+			Cumulative:   offset,
 			What:         ev.What,
 			IsError:      ev.IsError,
+			ErrorType:    ev.ErrorType,
+			Level:        ev.Level,
+			Repeat:       ev.Repeat,
 			Stack:        ev.Stack,
 		})
-		prev = ev.When
+		prev = offset
 	}
 
 	// Synthetic "end" event.
 	when := st.TraceStarted.Add(st.TraceDuration)
-	delta := when.Sub(prev)
+	delta := st.TraceDuration - prev
 	what := iff(st.TraceFinished, "finished", "active...")
 	events = append(events, renderEvent{
 		IsEnd:        true,
@@ -368,5 +565,8 @@ type renderEvent struct {
 	Cumulative     time.Duration
 	What           string
 	IsError        bool
+	ErrorType      string
+	Level          trc.Level
+	Repeat         int
 	Stack          []trc.Frame
 }
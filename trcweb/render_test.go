@@ -0,0 +1,172 @@
+package trcweb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestRenderQuantiles(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		now       = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		clock     = trc.ClockFunc(func() time.Time { return now })
+		collector = trc.NewCollector(trc.CollectorConfig{Clock: clock})
+		server    = NewTraceServer(collector)
+	)
+	for i := 0; i < 100; i++ {
+		_, tr := collector.NewTrace(ctx, "api")
+		now = now.Add(time.Duration(i) * time.Millisecond)
+		tr.Finish()
+	}
+
+	res, err := collector.Search(ctx, &trc.SearchRequest{StatsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := res.Stats.Categories["api"]
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("accept", "text/html")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	body := w.Body.String()
+
+	for _, q := range []time.Duration{cs.P50, cs.P90, cs.P99} {
+		if q <= 0 {
+			t.Fatalf("quantiles: %v %v %v", cs.P50, cs.P90, cs.P99)
+		}
+		if want := fmt.Sprintf(`data-sort-value="%d" title="%s"`, q.Nanoseconds(), q); !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+}
+
+func TestBucketHistogram(t *testing.T) {
+	t.Parallel()
+
+	var (
+		bucketing = []time.Duration{0, 10 * time.Millisecond, 100 * time.Millisecond}
+		counts    = []int{10, 8, 2}
+		bars      = bucketHistogram(bucketing, counts)
+	)
+
+	if want, have := 3, len(bars); want != have {
+		t.Fatalf("len: want %d, have %d", want, have)
+	}
+
+	for i, want := range []histogramBar{
+		{Min: 0, Count: 2, Percent: 33},
+		{Min: 10 * time.Millisecond, Count: 6, Percent: 100},
+		{Min: 100 * time.Millisecond, Count: 2, Percent: 33},
+	} {
+		if have := bars[i]; want != have {
+			t.Errorf("bar %d: want %+v, have %+v", i, want, have)
+		}
+	}
+}
+
+func TestRenderEventsOffsets(t *testing.T) {
+	t.Parallel()
+
+	// The wall clock steps backwards between the events, but the offsets,
+	// which are monotonic, don't.
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		st    = &trc.StaticTrace{
+			TraceStarted:  start,
+			TraceDuration: 10 * time.Millisecond,
+			TraceFinished: true,
+			TraceEvents: []trc.Event{
+				{When: start.Add(2 * time.Millisecond), Offset: 2 * time.Millisecond, What: "a"},
+				{When: start.Add(-time.Second), Offset: 5 * time.Millisecond, What: "b"},
+			},
+		}
+		events = renderEvents(st)
+	)
+
+	var deltas []time.Duration
+	for _, ev := range events {
+		deltas = append(deltas, ev.Delta)
+	}
+	if want, have := []time.Duration{0, 2 * time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond}, deltas; !reflect.DeepEqual(want, have) {
+		t.Errorf("deltas: want %v, have %v", want, have)
+	}
+	if want, have := 5*time.Millisecond, events[2].Cumulative; want != have {
+		t.Errorf("cumulative: want %v, have %v", want, have)
+	}
+}
+
+func TestRenderTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := withRenderTimeout(context.Background(), 10*time.Millisecond)
+
+	t.Run("fast", func(t *testing.T) {
+		body, err := renderBounded(ctx, func(w io.Writer) error {
+			_, err := io.WriteString(w, "hello")
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "hello", string(body); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	})
+
+	t.Run("slow template", func(t *testing.T) {
+		var writeErr error
+		donec := make(chan struct{})
+		begin := time.Now()
+		_, err := renderBounded(ctx, func(w io.Writer) error {
+			defer close(donec)
+			for writeErr == nil && time.Since(begin) < 10*time.Second {
+				_, writeErr = io.WriteString(w, "x")
+				time.Sleep(time.Millisecond)
+			}
+			return writeErr
+		})
+		if !errors.Is(err, errRenderTimeout) {
+			t.Errorf("want %v, have %v", errRenderTimeout, err)
+		}
+		<-donec
+		if writeErr == nil {
+			t.Errorf("writes didn't fail after timeout")
+		}
+	})
+
+	t.Run("slow JSON", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		begin := time.Now()
+		renderJSON(ctx, w, r, slowMarshaler(time.Second))
+		if took := time.Since(begin); took >= time.Second {
+			t.Errorf("render took %s", took)
+		}
+		if want, have := http.StatusServiceUnavailable, w.Code; want != have {
+			t.Errorf("code: want %d, have %d", want, have)
+		}
+		if want, have := "render timeout", w.Body.String(); !strings.Contains(have, want) {
+			t.Errorf("body: want %q, have %q", want, have)
+		}
+	})
+}
+
+type slowMarshaler time.Duration
+
+func (m slowMarshaler) MarshalJSON() ([]byte, error) {
+	time.Sleep(time.Duration(m))
+	return []byte(`{}`), nil
+}
@@ -0,0 +1,128 @@
+package trcweb
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/internal/trcutil"
+)
+
+// RoundTripper is an HTTP round tripper which records outbound requests in the
+// trace found in each request's context. For each request, it records the
+// method and URL, connection details like DNS, connect, and TLS timings, the
+// time to first response byte, and the response status code and duration.
+// Transport errors, and responses with 5xx status codes, are recorded as
+// errors. Requests without a trace in their context are unmodified.
+//
+// The recorded duration is the time until the response headers are received,
+// and doesn't include reading the response body.
+type RoundTripper struct {
+	// Next is used to execute the requests. If not provided, the
+	// http.DefaultTransport is used.
+	Next http.RoundTripper
+}
+
+var _ http.RoundTripper = (*RoundTripper)(nil)
+
+// NewRoundTripper returns a round tripper which executes requests via next.
+func NewRoundTripper(next http.RoundTripper) *RoundTripper {
+	return &RoundTripper{Next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := rt.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	tr, ok := trc.MaybeGet(req.Context())
+	if !ok {
+		return next.RoundTrip(req)
+	}
+
+	var (
+		begin        = time.Now()
+		since        = func() string { return trcutil.HumanizeDuration(time.Since(begin)) }
+		mtx          sync.Mutex // callbacks may be concurrent, e.g. dialing multiple addresses
+		dnsBegin     time.Time
+		connectBegin = map[string]time.Time{}
+		tlsBegin     time.Time
+	)
+
+	tr.LazyTracef("→ %s %s", req.Method, req.URL.Redacted())
+
+	clientTrace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			dnsBegin = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			mtx.Lock()
+			took := trcutil.HumanizeDuration(time.Since(dnsBegin))
+			mtx.Unlock()
+			if info.Err != nil {
+				tr.LazyErrorf("DNS error after %s: %v", took, info.Err)
+				return
+			}
+			tr.LazyTracef("DNS %d address(es) in %s", len(info.Addrs), took)
+		},
+		ConnectStart: func(network, addr string) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			connectBegin[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mtx.Lock()
+			took := trcutil.HumanizeDuration(time.Since(connectBegin[network+" "+addr]))
+			mtx.Unlock()
+			if err != nil {
+				tr.LazyErrorf("connect %s %s error after %s: %v", network, addr, took, err)
+				return
+			}
+			tr.LazyTracef("connect %s %s in %s", network, addr, took)
+		},
+		TLSHandshakeStart: func() {
+			mtx.Lock()
+			defer mtx.Unlock()
+			tlsBegin = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			mtx.Lock()
+			took := trcutil.HumanizeDuration(time.Since(tlsBegin))
+			mtx.Unlock()
+			if err != nil {
+				tr.LazyErrorf("TLS handshake error after %s: %v", took, err)
+				return
+			}
+			tr.LazyTracef("TLS handshake in %s", took)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				tr.LazyTracef("reused connection to %s (idle %s)", info.Conn.RemoteAddr(), trcutil.HumanizeDuration(info.IdleTime))
+			}
+		},
+		GotFirstResponseByte: func() {
+			tr.LazyTracef("first response byte after %s", since())
+		},
+	}
+
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace))
+
+	res, err := next.RoundTrip(req)
+	switch {
+	case err != nil:
+		tr.LazyErrorf("← error after %s: %v", since(), err)
+	case res.StatusCode >= 500:
+		tr.LazyErrorf("← HTTP %d after %s", res.StatusCode, since())
+	default:
+		tr.LazyTracef("← HTTP %d after %s", res.StatusCode, since())
+	}
+
+	return res, err
+}
@@ -0,0 +1,61 @@
+package trcweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: trcweb.NewRoundTripper(nil)}
+
+	do := func(path string) trc.Trace {
+		ctx, tr := trc.New(context.Background(), "src", "cat")
+		defer tr.Finish()
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+path, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		res.Body.Close()
+		return tr
+	}
+
+	{
+		tr := do("/ok")
+		var whats []string
+		for _, ev := range tr.Events() {
+			whats = append(whats, ev.What)
+		}
+		all := strings.Join(whats, "\n")
+		for _, want := range []string{"→ GET " + server.URL + "/ok", "connect tcp", "first response byte", "← HTTP 200"} {
+			if !strings.Contains(all, want) {
+				t.Errorf("missing %q:\n%s", want, all)
+			}
+		}
+		if tr.Errored() {
+			t.Errorf("/ok: unexpectedly errored")
+		}
+	}
+
+	{
+		tr := do("/fail")
+		if !tr.Errored() {
+			t.Errorf("/fail: expected errored trace")
+		}
+	}
+}
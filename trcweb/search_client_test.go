@@ -0,0 +1,167 @@
+package trcweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestSearchClientRetries(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx         = context.Background()
+		collector   = trc.NewDefaultCollector()
+		traceServer = trcweb.NewTraceServer(collector)
+		requests    atomic.Int64
+		httpServer  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch requests.Add(1) {
+			case 1:
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			case 2:
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+			default:
+				traceServer.ServeHTTP(w, r)
+			}
+		}))
+	)
+	defer httpServer.Close()
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+
+	var (
+		mtx    sync.Mutex
+		errors int
+		client = trcweb.NewSearchClient(http.DefaultClient, httpServer.URL)
+	)
+	client.Retries = 2
+	client.RetryInterval = time.Millisecond
+	client.OnRequest = func(ctx context.Context, uri string, took time.Duration, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err != nil {
+			errors++
+		}
+	}
+
+	res, err := client.Search(ctx, &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, res.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+	if want, have := int64(3), requests.Load(); want != have {
+		t.Errorf("requests: want %d, have %d", want, have)
+	}
+	mtx.Lock()
+	if want, have := 2, errors; want != have {
+		t.Errorf("OnRequest errors: want %d, have %d", want, have)
+	}
+	mtx.Unlock()
+
+	// Client errors aren't retried.
+	requests.Store(100)
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer badRequest.Close()
+
+	client = trcweb.NewSearchClient(http.DefaultClient, badRequest.URL)
+	client.Retries = 2
+	client.RetryInterval = time.Millisecond
+	if _, err := client.Search(ctx, &trc.SearchRequest{}); err == nil {
+		t.Errorf("want error, have none")
+	}
+	if want, have := int64(101), requests.Load(); want != have {
+		t.Errorf("requests: want %d, have %d", want, have)
+	}
+}
+
+func TestSearchClientHedging(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		release   = make(chan struct{})
+		slow      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			http.Error(w, "too slow", http.StatusServiceUnavailable)
+		}))
+		fast = httptest.NewServer(trcweb.NewTraceServer(collector))
+	)
+	defer fast.Close()
+	defer slow.Close()
+	defer close(release)
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+
+	var (
+		mtx    sync.Mutex
+		uris   []string
+		client = trcweb.NewSearchClient(http.DefaultClient, slow.URL)
+	)
+	client.Replicas = []string{fast.URL}
+	client.HedgeDelay = 10 * time.Millisecond
+	client.OnRequest = func(ctx context.Context, uri string, took time.Duration, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		uris = append(uris, uri)
+	}
+
+	res, err := client.Search(ctx, &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, res.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(uris) <= 0 || uris[0] != fast.URL {
+		t.Errorf("want first completed request to be %s, have %v", fast.URL, uris)
+	}
+}
+
+func TestSearchClientTimeout(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx     = context.Background()
+		release = make(chan struct{})
+		server  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+	)
+	defer server.Close()
+	defer close(release)
+
+	client := trcweb.NewSearchClient(http.DefaultClient, server.URL)
+	client.Timeout = 10 * time.Millisecond
+
+	begin := time.Now()
+	if _, err := client.Search(ctx, &trc.SearchRequest{}); err == nil {
+		t.Errorf("want error, have none")
+	}
+	if took := time.Since(begin); took > 5*time.Second {
+		t.Errorf("search took %s, longer than the timeout", took)
+	}
+}
@@ -0,0 +1,80 @@
+package trcweb_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestTraceServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	var (
+		collector   = trc.NewDefaultCollector()
+		traceServer = trcweb.NewTraceServer(collector)
+		httpServer  = httptest.NewServer(traceServer)
+	)
+	defer httpServer.Close()
+
+	stream := func() *http.Response {
+		req, err := http.NewRequest("GET", httpServer.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("accept", "text/event-stream")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := stream()
+	defer res.Body.Close()
+
+	var (
+		scanner = bufio.NewScanner(res.Body)
+		events  []string
+		closed  string
+	)
+	for scanner.Scan() && len(events) < 1 {
+		if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	if want, have := []string{"init"}, events; len(have) != 1 || want[0] != have[0] {
+		t.Fatalf("events: want %v, have %v", want, have)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- traceServer.Shutdown(ctx) }()
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "event: close" && scanner.Scan() {
+			closed = scanner.Text()
+		}
+	}
+	if !strings.Contains(closed, "server closing") {
+		t.Errorf("close event: want server closing, have %q", closed)
+	}
+
+	if err := <-errc; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+
+	res2 := stream()
+	res2.Body.Close()
+	if want, have := http.StatusServiceUnavailable, res2.StatusCode; want != have {
+		t.Errorf("stream after shutdown: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,66 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestSourceFilter(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewCollector(trc.CollectorConfig{Source: "local"})
+	_, tr := collector.NewTrace(context.Background(), "cat")
+	tr.Finish()
+	collector.Add(
+		&trc.StaticTrace{TraceSource: "remote-a", TraceID: "a", TraceCategory: "cat", TraceStarted: time.Now(), TraceFinished: true},
+		&trc.StaticTrace{TraceSource: "remote-b", TraceID: "b", TraceCategory: "cat", TraceStarted: time.Now(), TraceFinished: true},
+	)
+	server := NewTraceServer(collector)
+
+	get := func(query, accept string) string {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	for _, tc := range []struct {
+		query     string
+		wantMatch int
+	}{
+		{"", 3},
+		{"source=", 3},
+		{"source=remote-a", 1},
+		{"source=remote-a&source=local", 2},
+		{"exclude_source=local&exclude_source=", 2},
+		{"source=nope", 0},
+	} {
+		var data SearchData
+		if err := json.Unmarshal([]byte(get(tc.query, "application/json")), &data); err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		if want, have := tc.wantMatch, data.Response.MatchCount; want != have {
+			t.Errorf("%q: match count: want %d, have %d", tc.query, want, have)
+		}
+	}
+
+	body := get("source=remote-a", "text/html")
+	for _, want := range []string{
+		`<summary title="Select one or more sources">remote-a &#9662;</summary>`,
+		`<input type="checkbox" name="source" value="local"  /> local</label>`,
+		`<input type="checkbox" name="source" value="remote-a" checked /> remote-a</label>`,
+		`<input type="checkbox" name="source" value="remote-b"  /> remote-b</label>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+// StatsData is the response to a stats request, which is a lightweight
+// alternative to a search request, intended for e.g. dashboards which poll for
+// rates and errors every few seconds. It contains only stats, like the stats
+// of a search response, which cover every trace from the selected sources,
+// regardless of other filter conditions, and never any traces.
+type StatsData struct {
+	Sources    []string         `json:"sources"`
+	TotalCount int              `json:"total_count"`
+	Stats      *trc.SearchStats `json:"stats"`
+	Problems   []string         `json:"problems,omitempty"`
+}
+
+const (
+	statsWaitMax      = time.Minute
+	statsPollInterval = 250 * time.Millisecond
+)
+
+// isStatsRequest returns true if the request has a stats query parameter, or a
+// path ending in /stats, e.g. /traces/stats.
+func isStatsRequest(r *http.Request) bool {
+	return r.URL.Query().Has("stats") || path.Base(r.URL.Path) == "stats"
+}
+
+// handleStats serves the stats of the traces from the sources in the query
+// parameters, as JSON, with an ETag of their contents. Requests with a matching
+// If-None-Match header receive HTTP 304. If the request also has a wait
+// parameter, e.g. wait=30s, the request is held until the stats change, or the
+// wait elapses, whichever comes first, i.e. a long poll. A search slot, see
+// MaxConcurrentSearches, is only held while the stats are searched, and not
+// while the long poll waits between searches.
+func (s *TraceServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx      = r.Context()
+		tr       = trc.Get(ctx)
+		urlquery = r.URL.Query()
+		req      = parseSearchRequest(r)
+		wait     = parseRange(urlquery.Get("wait"), time.ParseDuration, 0, 0, statsWaitMax)
+		deadline = time.Now().Add(wait)
+	)
+
+	release, err := s.acquireSearch()
+	if err != nil {
+		tr.Errorf("%v", err)
+		w.Header().Set("retry-after", "1")
+		http.Error(w, "too many concurrent searches", http.StatusTooManyRequests)
+		return
+	}
+
+	req.StatsOnly = true
+	problems := req.Normalize()
+
+	tr.LazyTracef("stats request %s, wait %s", req, wait)
+
+	body := s.searchStats(ctx, &req, problems)
+	release()
+
+	var (
+		etag  = statsETag(body)
+		polls int
+	)
+	for matchesETag(r, etag) && time.Now().Before(deadline) {
+		polls++
+		select {
+		case <-ctx.Done():
+			tr.LazyTracef("canceled after %d poll(s)", polls)
+			return
+		case <-time.After(statsPollInterval):
+		}
+
+		release, err := s.acquireSearch()
+		if err != nil {
+			tr.LazyTracef("poll %d skipped: %v", polls, err)
+			continue
+		}
+		body = s.searchStats(ctx, &req, problems)
+		release()
+		etag = statsETag(body)
+	}
+
+	tr.LazyTracef("etag %s, %d poll(s)", etag, polls)
+
+	w.Header().Set("etag", etag)
+	w.Header().Set("cache-control", "no-cache")
+
+	if matchesETag(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+	writeBody(ctx, w, r, http.StatusOK, body)
+}
+
+// searchStats executes the stats-only search request, and returns the JSON
+// encoded stats data. Errors are reported as problems in the data.
+func (s *TraceServer) searchStats(ctx context.Context, req *trc.SearchRequest, problems []error) []byte {
+	var data StatsData
+	for _, problem := range problems {
+		data.Problems = append(data.Problems, problem.Error())
+	}
+
+	res, err := s.Searcher.Search(ctx, req)
+	if err != nil {
+		data.Problems = append(data.Problems, fmt.Sprintf("execute stats request: %v", err))
+	} else {
+		data.Sources = res.Sources
+		data.TotalCount = res.TotalCount
+		data.Stats = res.Stats
+		data.Problems = append(data.Problems, res.Problems...)
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		trc.Get(ctx).LazyErrorf("marshal JSON: %v", err)
+		return []byte(`{"problems":["failed to marshal response"]}`)
+	}
+	return body
+}
+
+// statsETag returns the ETag of the JSON encoded stats data.
+func statsETag(body []byte) string {
+	return `"` + sha256hex(string(body))[:16] + `"`
+}
+
+// matchesETag returns true if the If-None-Match header of the request matches
+// the given ETag.
+func matchesETag(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("if-none-match"), ",") {
+		if c := strings.TrimSpace(candidate); c == etag || c == "*" {
+			return true
+		}
+	}
+	return false
+}
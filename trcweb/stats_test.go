@@ -0,0 +1,136 @@
+package trcweb_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestTraceServerStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		server    = trcweb.NewTraceServer(collector)
+	)
+
+	for _, category := range []string{"foo", "foo", "bar"} {
+		_, tr := collector.NewTrace(ctx, category)
+		tr.Tracef("hello")
+		tr.Finish()
+	}
+
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if etag != "" {
+			r.Header.Set("if-none-match", etag)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/traces/stats?source=default", "")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("code: want %d, have %d", want, have)
+	}
+	if strings.Contains(w.Body.String(), "hello") {
+		t.Errorf("stats response contains trace events")
+	}
+
+	var data trcweb.StatsData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 3, data.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+	if data.Stats == nil || data.Stats.Categories["foo"] == nil {
+		t.Fatalf("stats: missing category foo: %+v", data.Stats)
+	}
+	if want, have := 2, data.Stats.Categories["foo"].BucketCounts[0]; want != have {
+		t.Errorf("foo count: want %d, have %d", want, have)
+	}
+
+	// The same stats have the same ETag.
+	etag := w.Header().Get("etag")
+	if w := get("/traces/stats?source=default", etag); w.Code != http.StatusNotModified {
+		t.Errorf("code: want %d, have %d", http.StatusNotModified, w.Code)
+	}
+
+	// A long poll returns once the stats change.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, tr := collector.NewTrace(ctx, "foo")
+		tr.Finish()
+	}()
+	begin := time.Now()
+	w = get("/traces/stats?source=default&wait=10s", etag)
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("code: want %d, have %d", want, have)
+	}
+	if took := time.Since(begin); took < 100*time.Millisecond || took > 5*time.Second {
+		t.Errorf("long poll took %s", took)
+	}
+	if w.Header().Get("etag") == etag {
+		t.Errorf("ETag didn't change")
+	}
+
+	// A long poll without changes returns HTTP 304 when the wait elapses.
+	etag = w.Header().Get("etag")
+	if w := get("/?stats&source=default&wait=300ms", etag); w.Code != http.StatusNotModified {
+		t.Errorf("code: want %d, have %d", http.StatusNotModified, w.Code)
+	}
+}
+
+func TestTraceServerStatsLongPollSearchLimit(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		server    = trcweb.NewTraceServer(collector)
+	)
+	server.MaxConcurrentSearches = 1
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("accept", "application/json")
+		if etag != "" {
+			r.Header.Set("if-none-match", etag)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	etag := get("/traces/stats", "").Header().Get("etag")
+
+	// A long poll without changes doesn't hold the only search slot while it
+	// waits, so other searches are served concurrently.
+	done := make(chan int)
+	go func() { done <- get("/traces/stats?wait=1s", etag).Code }()
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if want, have := http.StatusOK, get("/traces", "").Code; want != have {
+			t.Errorf("search %d during long poll: want %d, have %d", i+1, want, have)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if want, have := http.StatusNotModified, <-done; want != have {
+		t.Errorf("long poll: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,185 @@
+package trcweb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		attempt int
+		rnd     float64
+		want    time.Duration
+	}{
+		{1, 0, 1 * time.Second},
+		{2, 0, 2 * time.Second},
+		{3, 0, 4 * time.Second},
+		{4, 0, 8 * time.Second},
+		{5, 0, 10 * time.Second},
+		{100, 0, 10 * time.Second},
+		{1, 0.5, 750 * time.Millisecond},
+		{3, 0.999, 2*time.Second + 2*time.Millisecond},
+		{100, 0.5, 7500 * time.Millisecond},
+	} {
+		if want, have := tc.want, retryDelay(time.Second, 10*time.Second, tc.attempt, tc.rnd); want != have {
+			t.Errorf("attempt %d, rnd %v: want %s, have %s", tc.attempt, tc.rnd, want, have)
+		}
+	}
+}
+
+func TestStreamClientHooks(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		collector   = trc.NewDefaultCollector()
+		traceServer = NewTraceServer(collector)
+		requests    atomic.Int64
+		httpServer  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			traceServer.ServeHTTP(w, r)
+		}))
+		connectc = make(chan struct{}, 1)
+		tracec   = make(chan trc.Trace, 10)
+		errc     = make(chan error, 1)
+		mtx      sync.Mutex
+		log      []string
+	)
+	defer cancel()
+	defer httpServer.Close()
+
+	record := func(s string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		log = append(log, s)
+	}
+
+	client := &StreamClient{
+		URI:           httpServer.URL,
+		RetryInterval: time.Second,
+		OnConnect: func(ctx context.Context) {
+			record("connect")
+			connectc <- struct{}{}
+		},
+		OnDisconnect: func(ctx context.Context, err error) {
+			record("disconnect: " + err.Error())
+		},
+		OnRetry: func(ctx context.Context, attempt int, delay time.Duration) {
+			if delay < 500*time.Millisecond || delay > time.Second {
+				t.Errorf("retry %d: delay %s out of range", attempt, delay)
+			}
+			record("retry " + strings.Repeat("+", attempt))
+		},
+	}
+	go func() { errc <- client.Stream(ctx, trc.Filter{}, tracec) }()
+
+	select {
+	case <-connectc:
+	case err := <-errc:
+		t.Fatalf("stream: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for connect")
+	}
+
+	_, tr := collector.NewTrace(ctx, "foo")
+	tr.Finish()
+
+	select {
+	case recv := <-tracec:
+		if want, have := tr.ID(), recv.ID(); want != have {
+			t.Errorf("ID: want %s, have %s", want, have)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for trace")
+	}
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("stream: %v", err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if want, have := "disconnect: connect: server returned 503 Service Unavailable; retry +; connect", strings.Join(log, "; "); want != have {
+		t.Errorf("hooks: want %q, have %q", want, have)
+	}
+}
+
+func TestStreamClientTerminalError(t *testing.T) {
+	t.Parallel()
+
+	httpServer := httptest.NewServer(http.NotFoundHandler())
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var retries int
+	client := &StreamClient{
+		URI:     httpServer.URL,
+		OnRetry: func(ctx context.Context, attempt int, delay time.Duration) { retries++ },
+	}
+	err := client.Stream(ctx, trc.Filter{}, make(chan trc.Trace))
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("want 404 error, have %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("want no retries, have %d", retries)
+	}
+}
+
+type evictingStreamer struct{}
+
+func (evictingStreamer) Stream(ctx context.Context, f trc.Filter, ch chan<- trc.Trace) (trc.StreamStats, error) {
+	<-ctx.Done()
+	return trc.StreamStats{}, ctx.Err()
+}
+
+func (evictingStreamer) StreamWithOptions(ctx context.Context, f trc.Filter, ch chan<- trc.Trace, opts trc.StreamOptions) (trc.StreamStats, error) {
+	if opts.MaxDrops <= 0 {
+		return evictingStreamer{}.Stream(ctx, f, ch)
+	}
+	time.Sleep(10 * time.Millisecond)
+	return trc.StreamStats{Drops: opts.MaxDrops, ConsecutiveDrops: opts.MaxDrops}, trc.ErrSlowSubscriber
+}
+
+func (evictingStreamer) StreamStats(ctx context.Context, ch chan<- trc.Trace) (trc.StreamStats, error) {
+	return trc.StreamStats{}, nil
+}
+
+func TestStreamClientEvicted(t *testing.T) {
+	t.Parallel()
+
+	httpServer := httptest.NewServer(&TraceServer{Streamer: evictingStreamer{}})
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var retries int
+	client := &StreamClient{
+		URI:         httpServer.URL,
+		SendOptions: trc.StreamOptions{MaxDrops: 3},
+		OnRetry:     func(ctx context.Context, attempt int, delay time.Duration) { retries++ },
+	}
+	err := client.Stream(ctx, trc.Filter{}, make(chan trc.Trace))
+	if err == nil || !strings.Contains(err.Error(), trc.ErrSlowSubscriber.Error()) {
+		t.Errorf("want eviction error, have %v", err)
+	}
+	if retries != 0 {
+		t.Errorf("want no retries, have %d", retries)
+	}
+}
@@ -0,0 +1,112 @@
+package trcweb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/peterbourgon/trc"
+)
+
+// StreamEventVersion is the version of the schema of the JSON payloads of the
+// server-sent events produced by a trace server stream. It's incremented only
+// for incompatible changes, like removing or renaming a field, or changing its
+// meaning. Adding fields is a compatible change, so consumers must ignore
+// fields they don't know.
+//
+// Stream clients, including [StreamClient], should skip events with a version
+// greater than the version they understand, rather than failing.
+const StreamEventVersion = 1
+
+// Stream event types, which are used both as the type of the server-sent
+// event, and as the type field in its JSON payload. Traces encoded as protobuf,
+// see [StreamClient.Protobuf], have the SSE type "trace.pb", and a base64
+// payload rather than JSON, so they carry no header.
+const (
+	StreamEventInit  = "init"
+	StreamEventStats = "stats"
+	StreamEventTrace = "trace"
+	StreamEventClose = "close"
+	StreamEventError = "error"
+)
+
+// StreamEventHeader is included in the JSON payload of every stream event, so
+// that consumers can identify the payload without relying on the type of the
+// server-sent event, and detect incompatible changes via the version.
+type StreamEventHeader struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+func newStreamEventHeader(eventType string) StreamEventHeader {
+	return StreamEventHeader{Type: eventType, Version: StreamEventVersion}
+}
+
+// check returns an error if the header describes a payload which can't be
+// decoded as the given SSE event type. Headers without a version come from
+// servers which predate versioning, and are accepted.
+func (h StreamEventHeader) check(eventType string) error {
+	switch {
+	case h.Version > StreamEventVersion:
+		return fmt.Errorf("unsupported %s event version %d (max %d)", eventType, h.Version, StreamEventVersion)
+	case h.Type != "" && h.Type != eventType:
+		return fmt.Errorf("%s event has payload type %q", eventType, h.Type)
+	default:
+		return nil
+	}
+}
+
+// StreamInitEvent is the payload of the first event of every stream, which
+// describes the parameters of the stream, as understood by the server.
+type StreamInitEvent struct {
+	StreamEventHeader
+	Filter     trc.Filter        `json:"filter"`
+	SendBuffer int               `json:"sendbuf"`
+	Options    trc.StreamOptions `json:"options"`
+}
+
+// StreamStatsEvent is the payload of the periodic stats events of a stream.
+// The fields of the stats are inlined.
+type StreamStatsEvent struct {
+	StreamEventHeader
+	trc.StreamStats
+}
+
+// StreamTraceEvent is the payload of every JSON-encoded trace in a stream. The
+// fields of the trace are inlined, so consumers which predate versioning can
+// decode the payload as a [trc.StaticTrace] directly.
+type StreamTraceEvent struct {
+	StreamEventHeader
+	*trc.StaticTrace
+}
+
+// StreamEndEvent is the payload of the final event of a stream, if the server
+// ends it. Close events mean the stream ended normally, e.g. because the
+// server is shutting down, and clients should reconnect. Error events mean the
+// stream ended due to an error, e.g. because the client was too slow, and
+// clients shouldn't reconnect.
+type StreamEndEvent struct {
+	StreamEventHeader
+	Reason string `json:"reason"`
+}
+
+var errUnsupportedStreamEvent = errors.New("unsupported stream event")
+
+// decodeStreamEvent unmarshals the JSON payload of the stream event into v,
+// which must be a pointer to one of the stream event types. It returns an
+// error satisfying errors.Is(err, errUnsupportedStreamEvent) if the header of
+// the payload is incompatible with the event type, in which case v is not
+// populated.
+func decodeStreamEvent(eventType string, data []byte, v any) error {
+	var h StreamEventHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return fmt.Errorf("decode %s event: %w", eventType, err)
+	}
+	if err := h.check(eventType); err != nil {
+		return fmt.Errorf("%w: %v", errUnsupportedStreamEvent, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s event: %w", eventType, err)
+	}
+	return nil
+}
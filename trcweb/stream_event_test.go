@@ -0,0 +1,112 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestStreamEventVersions(t *testing.T) {
+	t.Parallel()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		for _, ev := range []struct{ typ, data string }{
+			{"init", `{"type":"init","version":1,"filter":{}}`},
+			{"trace", `{"type":"trace","version":2,"id":"from-the-future"}`},
+			{"trace", `{"type":"stats","version":1,"id":"mismatched-type"}`},
+			{"trace", `{"id":"legacy"}`},
+			{"trace", `{"type":"trace","version":1,"id":"current","category":"foo"}`},
+			{"stats", `{"type":"stats","version":2,"sends":"not a number"}`},
+			{"error", `{"type":"error","version":1,"reason":"done"}`},
+		} {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.typ, ev.data)
+		}
+	}))
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tracec := make(chan trc.Trace, 10)
+	err := (&StreamClient{URI: httpServer.URL}).Stream(ctx, trc.Filter{}, tracec)
+	if want, have := "server: done", fmt.Sprint(err); want != have {
+		t.Errorf("error: want %q, have %q", want, have)
+	}
+
+	close(tracec)
+	var ids []string
+	for tr := range tracec {
+		ids = append(ids, tr.ID()+"/"+tr.Category())
+	}
+	if want, have := "legacy/ current/foo", strings.Join(ids, " "); want != have {
+		t.Errorf("traces: want %q, have %q", want, have)
+	}
+}
+
+func TestStreamEventHeaders(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		collector   = trc.NewDefaultCollector()
+		httpServer  = httptest.NewServer(NewTraceServer(collector))
+		eventc      = make(chan StreamEventHeader, 10)
+		tracec      = make(chan trc.Trace, 10)
+		errc        = make(chan error, 1)
+	)
+	defer cancel()
+	defer httpServer.Close()
+
+	client := &StreamClient{
+		URI:           httpServer.URL,
+		StatsInterval: time.Second,
+		OnRead: func(ctx context.Context, eventType string, eventData []byte) {
+			var h StreamEventHeader
+			if err := json.Unmarshal(eventData, &h); err != nil {
+				t.Errorf("%s: %v", eventType, err)
+			}
+			if h.Type != eventType {
+				t.Errorf("%s: payload has type %q", eventType, h.Type)
+			}
+			select {
+			case eventc <- h:
+			default:
+			}
+		},
+	}
+	go func() { errc <- client.Stream(ctx, trc.Filter{}, tracec) }()
+
+	seen := map[string]bool{}
+	for !seen[StreamEventInit] || !seen[StreamEventTrace] || !seen[StreamEventStats] {
+		select {
+		case h := <-eventc:
+			if want, have := StreamEventVersion, h.Version; want != have {
+				t.Errorf("%s: want version %d, have %d", h.Type, want, have)
+			}
+			if h.Type == StreamEventInit {
+				_, tr := collector.NewTrace(ctx, "foo")
+				tr.Finish()
+			}
+			seen[h.Type] = true
+		case err := <-errc:
+			t.Fatalf("stream: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timeout, seen %v", seen)
+		}
+	}
+
+	if recv := <-tracec; recv.Category() != "foo" {
+		t.Errorf("trace: want category foo, have %q", recv.Category())
+	}
+
+	cancel()
+	<-errc
+}
@@ -0,0 +1,64 @@
+package trcweb
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/peterbourgon/trc"
+)
+
+// StreamsData is returned by streams requests, i.e. requests with a streams
+// query parameter. It describes the active stream subscriptions, including
+// their filters, and how many traces they've been sent, and dropped.
+type StreamsData struct {
+	Tenant        string                 `json:"tenant,omitempty"`
+	Subscriptions []trc.SubscriptionInfo `json:"subscriptions"`
+}
+
+// Sends returns the total number of traces sent to every subscription.
+func (d StreamsData) Sends() (n int) {
+	for _, sub := range d.Subscriptions {
+		n += sub.Stats.Sends
+	}
+	return n
+}
+
+// Drops returns the total number of traces dropped by every subscription.
+func (d StreamsData) Drops() (n int) {
+	for _, sub := range d.Subscriptions {
+		n += sub.Stats.Drops
+	}
+	return n
+}
+
+func (s *TraceServer) handleStreams(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		tr  = trc.Get(ctx)
+	)
+
+	if s.SubscriptionLister == nil {
+		tr.Errorf("streams: not supported")
+		http.Error(w, "streams not supported", http.StatusNotImplemented)
+		return
+	}
+
+	data := StreamsData{
+		Tenant:        s.tenant,
+		Subscriptions: s.SubscriptionLister.Subscriptions(),
+	}
+
+	tr.LazyTracef("streams: %d subscription(s)", len(data.Subscriptions))
+
+	renderResponse(ctx, w, r, assetsFS(s.Assets), "streams.html", s.assetFuncs(ctx), data)
+}
+
+// describeSubscriber returns a description of the client making the stream
+// request, which is shown by streams requests.
+func describeSubscriber(r *http.Request) string {
+	subscriber := r.RemoteAddr
+	if ua := strings.TrimSpace(r.UserAgent()); ua != "" {
+		subscriber += " (" + ua + ")"
+	}
+	return subscriber
+}
@@ -0,0 +1,89 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestStreamsHandler(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		collector   = trc.NewDefaultCollector()
+		server      = NewTraceServer(collector)
+		httpServer  = httptest.NewServer(server)
+		tracec      = make(chan trc.Trace, 10)
+		errc        = make(chan error, 1)
+	)
+	defer cancel()
+	defer httpServer.Close()
+
+	get := func(query string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("streams", "text/html")
+	if want, have := http.StatusOK, w.Code; want != have {
+		t.Fatalf("HTML: want %d, have %d", want, have)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "No active streams") {
+		t.Errorf("HTML: missing empty message in %s", body)
+	}
+
+	client := &StreamClient{URI: httpServer.URL}
+	go func() { errc <- client.Stream(ctx, trc.Filter{Category: "foo"}, tracec) }()
+
+	var data StreamsData
+	for len(data.Subscriptions) <= 0 {
+		select {
+		case err := <-errc:
+			t.Fatalf("stream: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for subscription")
+		case <-time.After(10 * time.Millisecond):
+		}
+		if err := json.NewDecoder(get("streams", "application/json").Body).Decode(&data); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+
+	sub := data.Subscriptions[0]
+	if want, have := "foo", sub.Filter.Category; want != have {
+		t.Errorf("filter category: want %q, have %q", want, have)
+	}
+	if want, have := "127.0.0.1", sub.Subscriber; !strings.HasPrefix(have, want) {
+		t.Errorf("subscriber: want prefix %q, have %q", want, have)
+	}
+
+	w = get("streams", "text/html")
+	if body := w.Body.String(); !strings.Contains(body, `id="streams"`) || !strings.Contains(body, sub.ID) {
+		t.Errorf("HTML: missing subscription in %s", body)
+	}
+
+	if body := get("", "text/html").Body.String(); !strings.Contains(body, "streams=1") {
+		t.Errorf("traces HTML: missing streams link")
+	}
+
+	cancel()
+	<-errc
+
+	unsupported := &TraceServer{Searcher: collector, Streamer: collector}
+	r := httptest.NewRequest("GET", "/?streams", nil)
+	rec := httptest.NewRecorder()
+	unsupported.ServeHTTP(rec, r)
+	if want, have := http.StatusNotImplemented, rec.Code; want != have {
+		t.Errorf("without subscription lister: want %d, have %d", want, have)
+	}
+}
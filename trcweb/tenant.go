@@ -0,0 +1,99 @@
+package trcweb
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/peterbourgon/trc"
+)
+
+// TenantServer serves a [trc.CollectorGroup] over HTTP, routing each request
+// to a trace server for a single tenant, identified by the tenant query
+// parameter. Each tenant's trace server only has access to that tenant's
+// collector, so searches and streams can't cross tenants.
+//
+// Requests without a tenant receive HTTP 400, requests for tenants which don't
+// exist in the group receive HTTP 404, and requests which aren't authorized
+// for the tenant receive HTTP 403. The web interface preserves the tenant
+// parameter in its links and forms.
+type TenantServer struct {
+	// Group of collectors to serve, one per tenant. Required.
+	Group *trc.CollectorGroup
+
+	// Authorize is called for every request, and must return true if the
+	// request is allowed to access the given tenant, e.g. based on a session
+	// cookie or client certificate. Required: if not provided, every request is
+	// rejected.
+	Authorize func(r *http.Request, tenant string) bool
+
+	// Configure, if provided, is called once for each tenant's trace server,
+	// when it's created, e.g. to set mutation options or a rate limiter.
+	// Optional.
+	Configure func(tenant string, s *TraceServer)
+
+	mtx     sync.Mutex
+	servers map[string]*TraceServer
+}
+
+// TenantParam is the query parameter which identifies the tenant of a request
+// to a [TenantServer].
+const TenantParam = "tenant"
+
+// ServeHTTP implements http.Handler.
+func (s *TenantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr := trc.Get(r.Context())
+
+	tenant := r.URL.Query().Get(TenantParam)
+	if tenant == "" {
+		http.Error(w, "bad request: tenant is required", http.StatusBadRequest)
+		return
+	}
+
+	if s.Authorize == nil || !s.Authorize(r, tenant) {
+		tr.Errorf("tenant %q: unauthorized", tenant)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	server, ok := s.getServer(tenant)
+	if !ok {
+		tr.Errorf("tenant %q: not found", tenant)
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	tr.LazyTracef("tenant %s", tenant)
+	server.ServeHTTP(w, r)
+}
+
+// getServer returns the trace server for the tenant, creating it if necessary,
+// as long as the tenant exists in the group.
+func (s *TenantServer) getServer(tenant string) (*TraceServer, bool) {
+	if s.Group == nil {
+		return nil, false
+	}
+
+	collector, ok := s.Group.Lookup(tenant)
+	if !ok {
+		return nil, false
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if server, ok := s.servers[tenant]; ok {
+		return server, true
+	}
+
+	server := NewTraceServer(collector)
+	if s.Configure != nil {
+		s.Configure(tenant, server)
+	}
+	server.tenant = tenant
+
+	if s.servers == nil {
+		s.servers = map[string]*TraceServer{}
+	}
+	s.servers[tenant] = server
+	return server, true
+}
@@ -0,0 +1,86 @@
+package trcweb_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcweb"
+)
+
+func TestTenantServer(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		group  = trc.NewCollectorGroup(trc.CollectorConfig{})
+		server = &trcweb.TenantServer{
+			Group: group,
+			Authorize: func(r *http.Request, tenant string) bool {
+				return r.Header.Get("x-tenant") == tenant
+			},
+		}
+	)
+
+	for _, tenant := range []string{"alpha", "beta"} {
+		_, tr := group.NewTrace(ctx, tenant, "request")
+		tr.Tracef("%s event", tenant)
+		tr.Finish()
+	}
+
+	get := func(query, tenant, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("x-tenant", tenant)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		query  string
+		tenant string
+		want   int
+	}{
+		{"", "alpha", http.StatusBadRequest},
+		{"tenant=alpha", "beta", http.StatusForbidden},
+		{"tenant=gamma", "gamma", http.StatusNotFound},
+		{"tenant=alpha", "alpha", http.StatusOK},
+	} {
+		if want, have := tc.want, get(tc.query, tc.tenant, "application/json").Code; want != have {
+			t.Errorf("%q as %q: want %d, have %d", tc.query, tc.tenant, want, have)
+		}
+	}
+
+	var data trcweb.SearchData
+	if err := json.NewDecoder(get("tenant=beta", "beta", "application/json").Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "beta", data.Tenant; want != have {
+		t.Errorf("tenant: want %q, have %q", want, have)
+	}
+	if want, have := 1, len(data.Response.Traces); want != have {
+		t.Fatalf("traces: want %d, have %d", want, have)
+	}
+	if want, have := "beta event", data.Response.Traces[0].Events()[0].What; want != have {
+		t.Errorf("event: want %q, have %q", want, have)
+	}
+
+	// The web interface keeps the tenant in its links and forms.
+	body := get("tenant=beta", "beta", "text/html").Body.String()
+	for _, want := range []string{
+		`href="?tenant=beta&amp;n=10&errored"`,
+		`<input type="hidden" name="tenant" value="beta" />`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML doesn't contain %s", want)
+		}
+	}
+	if strings.Contains(body, "alpha event") {
+		t.Errorf("HTML contains trace from another tenant")
+	}
+}
@@ -2,21 +2,30 @@ package trcweb
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"io/fs"
+	"math/rand"
+	"mime"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bernerdschaefer/eventsource"
 	"github.com/peterbourgon/trc"
 	"github.com/peterbourgon/trc/internal/trcutil"
-	"github.com/peterbourgon/trc/trcweb/assets"
+	"github.com/peterbourgon/trc/trcproto"
 )
 
 // HTTPClient models an http.Client.
@@ -35,6 +44,55 @@ type Streamer interface {
 	StreamStats(ctx context.Context, ch chan<- trc.Trace) (trc.StreamStats, error)
 }
 
+// Exporter models the export method of a trc.Collector.
+type Exporter interface {
+	Export(ctx context.Context, f trc.Filter, fn func(*trc.StaticTrace) error) error
+}
+
+// Annotator models the annotate method of a trc.Collector.
+type Annotator interface {
+	Annotate(id string, format string, args ...any) error
+}
+
+// Clearer models the clear method of a trc.Collector.
+type Clearer interface {
+	Clear(ctx context.Context, f trc.Filter) (int, error)
+}
+
+// Resizer models the resize methods of a trc.Collector.
+type Resizer interface {
+	Resize(cap int) int
+	ResizeCategory(category string, cap int) int
+	CategorySize(category string) int
+}
+
+// Heatmapper models the heatmap method of a trc.Collector.
+type Heatmapper interface {
+	Heatmap(category string) trc.Heatmap
+}
+
+// CategoryLister models the categories method of a trc.Collector.
+type CategoryLister interface {
+	Categories() []trc.CategoryInfo
+}
+
+// Pinner models the pin methods of a trc.Collector.
+type Pinner interface {
+	Pin(id string) error
+	Unpin(id string) error
+	Pinned() []string
+}
+
+// Ingester models the add method of a trc.Collector.
+type Ingester interface {
+	Add(traces ...trc.Trace)
+}
+
+// SubscriptionLister models the subscriptions method of a trc.Collector.
+type SubscriptionLister interface {
+	Subscriptions() []trc.SubscriptionInfo
+}
+
 //
 //
 //
@@ -44,13 +102,93 @@ type TraceServer struct {
 	// Collector is the default implementation for Searcher and Streamer.
 	Collector *trc.Collector
 
-	// Searcher is used to serve requests which Accept: text/html and/or
-	// application/json. If not provided, the Collector will be used.
+	// Searcher is used to serve requests which Accept: text/html,
+	// application/json, and/or application/x-protobuf. If not provided, the
+	// Collector will be used.
 	Searcher Searcher
 
 	// Streamer is used to serve requests which Accept: text/event-stream. If
 	// not provided, the Collector will be used.
 	Streamer Streamer
+
+	// Exporter is used to serve export requests, i.e. requests with query
+	// parameters format=ndjson and all=true. If not provided, the Collector
+	// will be used.
+	Exporter Exporter
+
+	// Annotator is used to serve annotate requests. If not provided, the
+	// Collector will be used.
+	Annotator Annotator
+
+	// Clearer is used to serve clear requests. If not provided, the Collector
+	// will be used.
+	Clearer Clearer
+
+	// Resizer is used to serve resize requests. If not provided, the Collector
+	// will be used.
+	Resizer Resizer
+
+	// Heatmapper is used to serve heatmap requests. If not provided, the
+	// Collector will be used.
+	Heatmapper Heatmapper
+
+	// CategoryLister is used to serve categories requests. If not provided,
+	// the Collector will be used.
+	CategoryLister CategoryLister
+
+	// Pinner is used to serve pin requests, and to list pinned traces in the
+	// web interface. If not provided, the Collector will be used.
+	Pinner Pinner
+
+	// Ingester is used to serve ingest requests. If not provided, the
+	// Collector will be used.
+	Ingester Ingester
+
+	// SubscriptionLister is used to serve streams requests, which describe
+	// the active stream subscriptions. If not provided, the Collector will be
+	// used.
+	SubscriptionLister SubscriptionLister
+
+	// Mutations enables and authorizes routes which modify the collector, like
+	// annotate and clear. By default, all such routes are disabled.
+	Mutations MutationOptions
+
+	// RateLimiter, if provided, is applied to every search and stream request.
+	// Requests which exceed the rate limit receive HTTP 429.
+	RateLimiter *RateLimiter
+
+	// RenderTimeout, if greater than zero, bounds the time spent rendering each
+	// response, i.e. executing templates or encoding JSON. Responses which
+	// take longer are abandoned, and the client receives HTTP 503 with an
+	// error message, rather than waiting for a pathologically large response.
+	RenderTimeout time.Duration
+
+	// MaxConcurrentSearches, if greater than zero, limits the number of search
+	// requests which can be served concurrently. Search requests which exceed
+	// this limit receive HTTP 429.
+	MaxConcurrentSearches int
+
+	// Assets, if provided, is an overlay for the assets used to render the web
+	// interface of this server, which takes precedence over the embedded
+	// assets, and any assets registered via [RegisterAssets]. Optional.
+	Assets fs.FS
+
+	// Categories maps category names to display options, e.g. human-friendly
+	// labels and stable colors, which are used by the web interface. Optional.
+	Categories map[string]CategoryDisplay
+
+	tenant string // set by TenantServer
+
+	activeSearches atomic.Int64
+
+	assetsMtx   sync.Mutex
+	assetsCache map[string]*renderedAsset
+
+	streamsMtx     sync.Mutex
+	streamsActive  int
+	streamsClosed  bool
+	streamsClosing chan struct{} // closed by Shutdown
+	streamsIdle    chan struct{} // closed when closed and there are no active streams
 }
 
 // NewTraceServer returns a standard trace server wrapping the collector.
@@ -69,26 +207,318 @@ func (s *TraceServer) initialize() {
 	if s.Streamer == nil {
 		s.Streamer = s.Collector
 	}
+	if s.Exporter == nil && s.Collector != nil {
+		s.Exporter = s.Collector
+	}
+	if s.Annotator == nil && s.Collector != nil {
+		s.Annotator = s.Collector
+	}
+	if s.Clearer == nil && s.Collector != nil {
+		s.Clearer = s.Collector
+	}
+	if s.Resizer == nil && s.Collector != nil {
+		s.Resizer = s.Collector
+	}
+	if s.Heatmapper == nil && s.Collector != nil {
+		s.Heatmapper = s.Collector
+	}
+	if s.CategoryLister == nil && s.Collector != nil {
+		s.CategoryLister = s.Collector
+	}
+	if s.Pinner == nil && s.Collector != nil {
+		s.Pinner = s.Collector
+	}
+	if s.Ingester == nil && s.Collector != nil {
+		s.Ingester = s.Collector
+	}
+	if s.SubscriptionLister == nil && s.Collector != nil {
+		s.SubscriptionLister = s.Collector
+	}
+}
+
+// MutationOptions enable and authorize the routes of a trace server which
+// modify the collector. Every such route requires a non-safe HTTP method, so it
+// can't be triggered by a link or a simple cross-site form, and requests from
+// browsers are additionally rejected unless they're same-origin.
+type MutationOptions struct {
+	// Annotate enables annotate requests, i.e. POST requests with the annotate
+	// query parameter, which add an event to an active trace. The body of the
+	// request is an [AnnotateRequest], as JSON.
+	Annotate bool
+
+	// Clear enables clear requests, i.e. DELETE requests, which remove every
+	// finished trace matching the filter in the query parameters, or every
+	// finished trace if there's no filter, from the collector.
+	Clear bool
+
+	// Resize enables resize requests, i.e. POST requests with the resize query
+	// parameter, which set the max number of traces in each category of the
+	// collector, or, with a category parameter, in a specific category. For
+	// example, resize=500&category=api. See [trc.Collector.Resize].
+	Resize bool
+
+	// Pin enables pin requests, i.e. POST requests with a pin=ID or unpin=ID
+	// query parameter, which pin or unpin the trace with that ID, so that it
+	// isn't evicted from the collector. See [trc.Collector.Pin].
+	Pin bool
+
+	// Ingest enables ingest requests, i.e. POST requests with the ingest query
+	// parameter, or a path ending in /ingest, e.g. /traces/ingest, whose body
+	// contains traces as JSON, see [trc.StaticTrace], which are added to the
+	// collector. This allows processes which don't use Go, e.g. scripts, to
+	// report traces. The source query parameter, if given, is assigned to
+	// every ingested trace. See [trc.Collector.Add].
+	Ingest bool
+
+	// Authorize is called for every mutating request, and should return a
+	// non-nil error if the request isn't authorized, e.g. because it doesn't
+	// carry a valid token. Required: if not provided, all mutating requests
+	// are rejected with HTTP 403, even if they're enabled.
+	Authorize func(*http.Request) error
 }
 
 // ServeHTTP implements http.Handler.
 func (s *TraceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.initialize()
 
-	switch Categorize(r) {
+	category := Categorize(r)
+
+	if s.RenderTimeout > 0 {
+		r = r.WithContext(withRenderTimeout(r.Context(), s.RenderTimeout))
+	}
+
+	if s.RateLimiter != nil && category != "asset" && !s.RateLimiter.Allow(r) {
+		trc.Get(r.Context()).Errorf("rate limit exceeded for %s", r.RemoteAddr)
+		w.Header().Set("retry-after", "1")
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	switch category {
+	case "asset":
+		s.handleAsset(w, r)
 	case "stream":
 		s.handleStream(w, r)
-	default:
+	case "download":
 		s.handleSearch(w, r)
+	case "export":
+		s.handleExport(w, r)
+	case "compare":
+		s.handleCompare(w, r)
+	case "stats":
+		s.handleStats(w, r)
+	case "heatmap":
+		s.handleHeatmap(w, r)
+	case "categories":
+		s.handleCategories(w, r)
+	case "streams":
+		s.handleStreams(w, r)
+	case "annotate":
+		s.handleAnnotate(w, r)
+	case "clear":
+		s.handleClear(w, r)
+	case "resize":
+		s.handleResize(w, r)
+	case "pin":
+		s.handlePin(w, r)
+	case "ingest":
+		s.handleIngest(w, r)
+	case "traces":
+		s.handleSearch(w, r)
+	default:
+		w.Header().Set("allow", "GET, HEAD, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// Categorize the request for a [Middleware].
+// Categorize the request for a [Middleware], by its method and parameters.
+//
+//	GET with asset=NAME                    asset (or by path, see AssetParam)
+//	GET with Accept: text/event-stream     stream
+//	GET with format=ndjson and all=true    export
+//	GET with compare=ID1&compare=ID2       compare
+//	GET with stats, or path ending /stats  stats (see StatsData)
+//	GET with heatmap                       heatmap (see HeatmapData)
+//	GET with categories                    categories (see CategoriesData)
+//	GET with streams                       streams (see StreamsData)
+//	GET with download                      download (standalone HTML file)
+//	GET otherwise, optional JSON body      traces (or protobuf, see trcproto)
+//	POST with annotate                     annotate
+//	POST with resize=N                     resize
+//	POST with pin=ID or unpin=ID           pin
+//	POST with ingest, or path /ingest      ingest (see IngestResponse)
+//	POST otherwise, with JSON body         traces
+//	DELETE                                 clear
+//
+// HEAD is treated like GET. Other methods are categorized as "other", and are
+// rejected by the trace server.
 func Categorize(r *http.Request) string {
-	if requestExplicitlyAccepts(r, "text/event-stream") {
-		return "stream"
+	urlquery := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, "":
+		_, isAsset := assetName(r)
+		switch {
+		case isAsset:
+			return "asset"
+		case requestExplicitlyAccepts(r, "text/event-stream"):
+			return "stream"
+		case urlquery.Get("format") == "ndjson" && urlquery.Get("all") == "true":
+			return "export"
+		case urlquery.Has("compare"):
+			return "compare"
+		case isStatsRequest(r):
+			return "stats"
+		case urlquery.Has("heatmap"):
+			return "heatmap"
+		case urlquery.Has("categories"):
+			return "categories"
+		case urlquery.Has("streams"):
+			return "streams"
+		case urlquery.Has("download"):
+			return "download"
+		default:
+			return "traces"
+		}
+	case http.MethodPost:
+		if urlquery.Has("annotate") {
+			return "annotate"
+		}
+		if urlquery.Has("resize") {
+			return "resize"
+		}
+		if urlquery.Has("pin") || urlquery.Has("unpin") {
+			return "pin"
+		}
+		if isIngestRequest(r) {
+			return "ingest"
+		}
+		return "traces"
+	case http.MethodDelete:
+		return "clear"
+	default:
+		return "other"
+	}
+}
+
+// authorizeMutation returns true if the mutating request is enabled, and
+// authorized. Otherwise, it writes an error response, and returns false.
+func (s *TraceServer) authorizeMutation(w http.ResponseWriter, r *http.Request, name string, enabled bool) bool {
+	tr := trc.Get(r.Context())
+
+	if !enabled || s.Mutations.Authorize == nil {
+		tr.Errorf("%s: not enabled", name)
+		http.Error(w, name+" not enabled", http.StatusForbidden)
+		return false
+	}
+
+	if !isSameOrigin(r) {
+		tr.Errorf("%s: cross-origin request rejected", name)
+		http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+		return false
+	}
+
+	if err := s.Mutations.Authorize(r); err != nil {
+		tr.Errorf("%s: unauthorized: %v", name, err)
+		http.Error(w, "unauthorized", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// isSameOrigin returns false if the request was made by a browser on behalf of
+// a different origin. Requests from non-browser clients, which don't set the
+// relevant headers, are assumed to be same-origin.
+func isSameOrigin(r *http.Request) bool {
+	switch r.Header.Get("sec-fetch-site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+
+	if origin := r.Header.Get("origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+//
+//
+
+// Shutdown gracefully ends all active stream requests, and causes new stream
+// requests to be rejected with HTTP 503. Each active stream receives a terminal
+// "close" event before its response is completed. Shutdown waits until every
+// stream request has been completed, or until the context is canceled, in which
+// case it returns the context error. Search requests are unaffected.
+//
+// Stream requests are long-lived, so they prevent [http.Server.Shutdown] from
+// completing, unless Shutdown is also called, e.g. via
+// [http.Server.RegisterOnShutdown].
+//
+//	httpServer.RegisterOnShutdown(func() {
+//		traceServer.Shutdown(context.Background())
+//	})
+func (s *TraceServer) Shutdown(ctx context.Context) error {
+	idle := func() chan struct{} {
+		s.streamsMtx.Lock()
+		defer s.streamsMtx.Unlock()
+
+		s.initializeStreams()
+		if !s.streamsClosed {
+			s.streamsClosed = true
+			close(s.streamsClosing)
+			if s.streamsActive <= 0 {
+				close(s.streamsIdle)
+			}
+		}
+		return s.streamsIdle
+	}()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// initializeStreams is called with the streams mutex held.
+func (s *TraceServer) initializeStreams() {
+	if s.streamsClosing == nil {
+		s.streamsClosing = make(chan struct{})
+		s.streamsIdle = make(chan struct{})
+	}
+}
+
+// beginStream registers a new stream request, and returns a channel which is
+// closed when the server is shut down. It returns false if the server has
+// already been shut down, in which case the request should be rejected.
+func (s *TraceServer) beginStream() (<-chan struct{}, bool) {
+	s.streamsMtx.Lock()
+	defer s.streamsMtx.Unlock()
+
+	s.initializeStreams()
+	if s.streamsClosed {
+		return nil, false
+	}
+	s.streamsActive++
+	return s.streamsClosing, true
+}
+
+// endStream unregisters a stream request which was registered by beginStream.
+func (s *TraceServer) endStream() {
+	s.streamsMtx.Lock()
+	defer s.streamsMtx.Unlock()
+
+	s.streamsActive--
+	if s.streamsClosed && s.streamsActive <= 0 {
+		close(s.streamsIdle)
 	}
-	return "traces"
 }
 
 //
@@ -97,19 +527,146 @@ func Categorize(r *http.Request) string {
 
 // SearchData is returned by normal trace search requests.
 type SearchData struct {
-	Request  trc.SearchRequest  `json:"request"`
-	Response trc.SearchResponse `json:"response"`
-	Problems []error            `json:"-"` // for rendering, not transmitting
+	Tenant     string                     `json:"tenant,omitempty"`
+	Request    trc.SearchRequest          `json:"request"`
+	Response   trc.SearchResponse         `json:"response"`
+	Pinned     []string                   `json:"pinned,omitempty"`
+	Problems   []error                    `json:"-"` // for rendering, not transmitting
+	Categories map[string]CategoryDisplay `json:"-"` // for rendering, not transmitting
+	CanPin     bool                       `json:"-"` // for rendering, not transmitting
+	Streams    int                        `json:"-"` // for rendering, not transmitting
+	HasStreams bool                       `json:"-"` // for rendering, not transmitting
+	Exported   time.Time                  `json:"-"` // for rendering offline, see RenderSearchHTML
+
+	// BySource is true if the request has the by_source parameter, in which
+	// case SourceStats has the stats of each source separately, see
+	// SourceRows, if there are at least two sources to compare.
+	BySource    bool          `json:"-"`
+	SourceStats []SourceStats `json:"source_stats,omitempty"`
+}
+
+// SeenSources returns every source in the response, and in the returned traces,
+// as well as any sources in the filter, so that they can still be selected when
+// they don't match anything. The sources are sorted.
+func (d SearchData) SeenSources() []string {
+	index := map[string]bool{}
+	for _, source := range d.Response.Sources {
+		index[source] = true
+	}
+	for _, tr := range d.Response.Traces {
+		index[tr.Source()] = true
+	}
+	for _, source := range d.Request.Filter.Sources {
+		index[source] = true
+	}
+	delete(index, "")
+
+	sources := make([]string, 0, len(index))
+	for source := range index {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// HasSource returns true if the source is selected by the filter of the
+// request.
+func (d SearchData) HasSource(source string) bool {
+	for _, candidate := range d.Request.Filter.Sources {
+		if candidate == source {
+			return true
+		}
+	}
+	return false
+}
+
+// SeenCategories returns every category in the stats of the response, and in
+// the returned traces, as well as the category in the filter, so that it can
+// still be selected when it doesn't match anything. The categories are sorted.
+func (d SearchData) SeenCategories() []string {
+	index := map[string]bool{}
+	if d.Response.Stats != nil {
+		for category := range d.Response.Stats.Categories {
+			index[category] = true
+		}
+	}
+	for _, tr := range d.Response.Traces {
+		index[tr.Category()] = true
+	}
+	index[d.Request.Filter.Category] = true
+	delete(index, "")
+	delete(index, "overall")
+
+	categories := make([]string, 0, len(index))
+	for category := range index {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// DrillDown returns the URL query for the traces counted by a cell of the stats
+// table, i.e. those in the category, or every category if it's "overall", with
+// at least the min duration, unless it's negative, and errored, if errored is
+// true. Every other parameter of the current search request, including the
+// current min duration and errored state if they aren't overridden, is kept, so
+// that following the link narrows the current search, rather than replacing
+// it. The tenant, if any, isn't included.
+func (d SearchData) DrillDown(category string, min time.Duration, errored bool) template.URL {
+	q := searchRequestValues(d.Request)
+	for _, key := range []string{"id", "active", "stats_only"} {
+		q.Del(key) // incompatible with, or irrelevant to, drilling down
+	}
+	if category != "overall" {
+		q.Set("category", category)
+	}
+	if min >= 0 {
+		q.Set("min", min.String())
+	}
+	if errored {
+		q.Set("errored", "true")
+		q.Del("success")
+	}
+	if d.BySource {
+		q.Set("by_source", "true")
+	}
+	return template.URL(q.Encode())
+}
+
+// acquireSearch reserves one of the MaxConcurrentSearches, if there's a limit,
+// and returns a function which releases it. If the limit is reached, it
+// returns an error instead.
+func (s *TraceServer) acquireSearch() (release func(), err error) {
+	max := int64(s.MaxConcurrentSearches)
+	if max <= 0 {
+		return func() {}, nil
+	}
+	if n := s.activeSearches.Add(1); n > max {
+		s.activeSearches.Add(-1)
+		return nil, fmt.Errorf("too many concurrent searches (%d > %d)", n, max)
+	}
+	return func() { s.activeSearches.Add(-1) }, nil
 }
 
 func (s *TraceServer) handleSearch(w http.ResponseWriter, r *http.Request) {
 	var (
 		ctx    = r.Context()
 		tr     = trc.Get(ctx)
-		isJSON = strings.Contains(r.Header.Get("content-type"), "application/json")
-		data   = SearchData{}
+		ctype  = r.Header.Get("content-type")
+		isJSON = strings.Contains(ctype, "application/json")
+		isPB   = strings.Contains(ctype, trcproto.ContentType)
+		data   = SearchData{Tenant: s.tenant, Categories: s.Categories}
 	)
 
+	release, err := s.acquireSearch()
+	if err != nil {
+		tr.Errorf("%v", err)
+		w.Header().Set("retry-after", "1")
+		http.Error(w, "too many concurrent searches", http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
 	switch {
 	case isJSON:
 		body := http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
@@ -123,14 +680,23 @@ func (s *TraceServer) handleSearch(w http.ResponseWriter, r *http.Request) {
 		}
 		data.Request = req
 
-	default:
-		urlquery := r.URL.Query()
-		data.Request = trc.SearchRequest{
-			Bucketing:  parseBucketing(urlquery["b"]), // nil is OK
-			Filter:     parseFilter(r),
-			Limit:      parseRange(urlquery.Get("n"), strconv.Atoi, trc.SearchLimitMin, trc.SearchLimitDefault, trc.SearchLimitMax),
-			StackDepth: parseDefault(urlquery.Get("stack"), strconv.Atoi, 0),
+	case isPB:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes))
+		if err != nil {
+			tr.Errorf("read protobuf request failed (%v) -- returning error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		req, err := trcproto.UnmarshalSearchRequest(body)
+		if err != nil {
+			tr.Errorf("decode protobuf request failed (%v) -- returning error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data.Request = *req
+
+	default:
+		data.Request = parseSearchRequest(r)
 	}
 
 	data.Problems = append(data.Problems, data.Request.Normalize()...)
@@ -152,15 +718,132 @@ func (s *TraceServer) handleSearch(w http.ResponseWriter, r *http.Request) {
 		data.Problems = append(data.Problems, fmt.Errorf("way too many categories (%d)", n))
 	}
 
-	renderResponse(ctx, w, r, assets.FS, "traces.html", nil, data)
+	data.BySource = r.URL.Query().Has("by_source")
+	if data.BySource {
+		sourceStats, problems := s.searchBySource(ctx, data.Request)
+		data.Problems = append(data.Problems, problems...)
+		for _, ss := range sourceStats {
+			for _, problem := range ss.Problems {
+				data.Problems = append(data.Problems, fmt.Errorf("%s: %s", ss.Source, problem))
+			}
+		}
+		data.SourceStats = sourceStats
+		tr.LazyTracef("compared %d source(s)", len(sourceStats))
+	}
+
+	if s.Pinner != nil {
+		data.Pinned = s.Pinner.Pinned()
+		data.CanPin = s.Mutations.Pin && s.Mutations.Authorize != nil
+	}
+
+	if s.SubscriptionLister != nil {
+		data.Streams = len(s.SubscriptionLister.Subscriptions())
+		data.HasStreams = true
+	}
+
+	if requestExplicitlyAccepts(r, trcproto.ContentType) {
+		renderProtobuf(ctx, w, r, trcproto.MarshalSearchResponse(&data.Response))
+		return
+	}
+
+	if Categorize(r) == "download" {
+		s.renderDownload(ctx, w, r, data)
+		return
+	}
+
+	renderResponse(ctx, w, r, assetsFS(s.Assets), "traces.html", s.assetFuncs(ctx), data)
+}
+
+// renderDownload writes the search data as a standalone HTML document, see
+// RenderSearchHTML, which the browser saves as a file.
+func (s *TraceServer) renderDownload(ctx context.Context, w http.ResponseWriter, r *http.Request, data SearchData) {
+	tr := trc.Get(ctx)
+
+	data.CanPin = false
+	data.HasStreams = false
+	data.Exported = time.Now().UTC()
+
+	body, err := renderTemplate(ctx, assetsFS(s.Assets), "traces.html", nil, data)
+	if err != nil {
+		tr.Errorf("render download: %v", err)
+		http.Error(w, err.Error(), iff(errors.Is(err, errRenderTimeout), http.StatusServiceUnavailable, http.StatusInternalServerError))
+		return
+	}
+
+	tr.LazyTracef("download %d trace(s), %s", len(data.Response.Traces), trcutil.HumanizeBytes(len(body)))
+
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Header().Set("content-disposition", fmt.Sprintf(`attachment; filename="trc-%s.html"`, data.Exported.Format("20060102-150405")))
+	writeBody(ctx, w, r, http.StatusOK, body)
+}
+
+// RenderSearchHTML renders the search data as a standalone HTML document, using
+// the same template as the web interface. Assets are inlined, and controls
+// which only work against a live trace server, like the search form, are
+// omitted, so the document can be viewed offline, e.g. attached to an incident
+// ticket. It's intended for producing static reports, e.g. via the trc CLI. If
+// data.Exported is zero, it's set to the current time.
+func RenderSearchHTML(ctx context.Context, data SearchData) ([]byte, error) {
+	if data.Exported.IsZero() {
+		data.Exported = time.Now().UTC()
+	}
+	return renderTemplate(ctx, assetsFS(), "traces.html", nil, data)
 }
 
 //
 
 // SearchClient implements [trc.Searcher] by querying a search server.
+//
+// The client uses the given HTTP client for every request, so connections are
+// pooled by its transport. Clients which make many concurrent requests to the
+// same servers should use an [http.Client] with a [http.Transport] which keeps
+// enough idle connections per host, e.g. via MaxIdleConnsPerHost.
 type SearchClient struct {
 	client HTTPClient
 	uri    string
+
+	// Protobuf, if true, encodes requests and responses as protobuf rather than
+	// JSON, which is much cheaper for both client and server, especially for
+	// large traces. The search server must support protobuf.
+	Protobuf bool
+
+	// Timeout, if greater than zero, is the timeout of each individual HTTP
+	// request, including retries and hedged requests. The overall search is
+	// still bounded by the context. Optional.
+	Timeout time.Duration
+
+	// Retries is the number of times a failed search is retried, if the
+	// failure is temporary, i.e. a transport error, a timeout, or a server
+	// error like HTTP 503. Client errors, like HTTP 400, aren't retried.
+	// Optional.
+	Retries int
+
+	// RetryInterval is the initial delay before a retry, which is doubled
+	// after each consecutive failure, up to 10 times the initial delay, with
+	// random jitter of up to half of the delay. Default 100ms, max 10s.
+	RetryInterval time.Duration
+
+	// Replicas are the URIs of other search servers which serve the same
+	// traces as the primary URI, e.g. replicas of the same instance. They're
+	// used for hedged requests, see HedgeDelay, and by retries, which try each
+	// replica in turn. Optional.
+	Replicas []string
+
+	// HedgeDelay, if greater than zero, and if there are replicas, is how long
+	// to wait for a response before sending the same request to the next
+	// replica, or immediately, if a request fails. The first successful
+	// response is used, and other requests are canceled. This reduces tail
+	// latency, at the cost of additional requests. Optional.
+	HedgeDelay time.Duration
+
+	// OnRequest, if provided, is called after every HTTP request, including
+	// retries and hedged requests, with the URI, the duration of the request,
+	// and the error, if any. Hedged requests which are canceled, because
+	// another request succeeded, report a [context.Canceled] error. It's
+	// intended for metrics, e.g. a histogram of request durations, and is
+	// called concurrently, so implementations must be safe for concurrent use,
+	// and must not block. Optional.
+	OnRequest func(ctx context.Context, uri string, took time.Duration, err error)
 }
 
 var _ trc.Searcher = (*SearchClient)(nil)
@@ -168,15 +851,19 @@ var _ trc.Searcher = (*SearchClient)(nil)
 // NewSearchClient returns a search client using the given HTTP client to query
 // the given search server URI.
 func NewSearchClient(client HTTPClient, uri string) *SearchClient {
-	if !strings.HasPrefix(uri, "http") {
-		uri = "http://" + uri
-	}
 	return &SearchClient{
 		client: client,
-		uri:    uri,
+		uri:    searchClientURI(uri),
 	}
 }
 
+func searchClientURI(uri string) string {
+	if !strings.HasPrefix(uri, "http") {
+		uri = "http://" + uri
+	}
+	return uri
+}
+
 // Search implements [trc.Searcher].
 func (c *SearchClient) Search(ctx context.Context, req *trc.SearchRequest) (_ *trc.SearchResponse, err error) {
 	tr := trc.Get(ctx)
@@ -187,22 +874,146 @@ func (c *SearchClient) Search(ctx context.Context, req *trc.SearchRequest) (_ *t
 		}
 	}()
 
-	body, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("encode search request: %w", err)
+	var (
+		body        []byte
+		contentType string
+		accept      string
+	)
+	switch {
+	case c.Protobuf:
+		body = trcproto.MarshalSearchRequest(req)
+		contentType, accept = trcproto.ContentType, trcproto.ContentType
+	default:
+		if body, err = json.Marshal(req); err != nil {
+			return nil, fmt.Errorf("encode search request: %w", err)
+		}
+		contentType, accept = "application/json; charset=utf-8", "application/json"
+	}
+
+	uris := []string{c.uri}
+	for _, replica := range c.Replicas {
+		uris = append(uris, searchClientURI(replica))
+	}
+
+	interval := c.RetryInterval
+	if def, max := 100*time.Millisecond, 10*time.Second; interval <= 0 {
+		interval = def
+	} else if interval > max {
+		interval = max
+	}
+
+	for attempt := 1; ; attempt++ {
+		// Each retry starts with the next replica, if there are any.
+		offset := (attempt - 1) % len(uris)
+		ordered := append(append([]string{}, uris[offset:]...), uris[:offset]...)
+
+		res, err := c.searchHedged(ctx, ordered, body, contentType, accept)
+		switch {
+		case err == nil:
+			return res, nil
+		case attempt > c.Retries, !isTemporarySearchError(err), ctx.Err() != nil:
+			return nil, err
+		}
+
+		delay := retryDelay(interval, 10*interval, attempt, rand.Float64())
+		tr.LazyTracef("attempt %d failed, retrying in %s: %v", attempt, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// searchHedged sends the search request to the first URI, and then to each
+// subsequent URI, if HedgeDelay elapses without a response, or if the previous
+// request fails. It returns the first successful response, or the last error,
+// if every request fails, or the first permanent error.
+func (c *SearchClient) searchHedged(ctx context.Context, uris []string, body []byte, contentType, accept string) (*trc.SearchResponse, error) {
+	if c.HedgeDelay <= 0 || len(uris) <= 1 {
+		return c.searchOnce(ctx, uris[0], body, contentType, accept)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels any outstanding requests
+
+	type result struct {
+		res *trc.SearchResponse
+		err error
+	}
+
+	var (
+		results  = make(chan result, len(uris)) // buffered, so canceled requests don't block
+		launched int
+		pending  int
+		launch   = func() {
+			uri := uris[launched]
+			launched++
+			pending++
+			go func() {
+				res, err := c.searchOnce(ctx, uri, body, contentType, accept)
+				results <- result{res, err}
+			}()
+		}
+		hedge = time.NewTimer(c.HedgeDelay)
+	)
+	defer hedge.Stop()
+
+	launch()
+
+	for {
+		select {
+		case <-hedge.C:
+			if launched < len(uris) {
+				trc.Get(ctx).LazyTracef("no response after %s, hedging with %s", c.HedgeDelay, uris[launched])
+				launch()
+				hedge.Reset(c.HedgeDelay)
+			}
+
+		case r := <-results:
+			pending--
+			switch {
+			case r.err == nil:
+				return r.res, nil
+			case !isTemporarySearchError(r.err):
+				return nil, r.err
+			case launched < len(uris):
+				launch()
+				hedge.Reset(c.HedgeDelay)
+			case pending <= 0:
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// searchOnce sends the search request to the URI.
+func (c *SearchClient) searchOnce(ctx context.Context, uri string, body []byte, contentType, accept string) (_ *trc.SearchResponse, err error) {
+	tr := trc.Get(ctx)
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.uri, bytes.NewReader(body))
+	if c.OnRequest != nil {
+		defer func(begin time.Time) { c.OnRequest(ctx, uri, time.Since(begin), err) }(time.Now())
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", uri, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("create HTTP request: %w", err)
 	}
 
-	httpReq.Header.Set("content-type", "application/json; charset=utf-8")
-	httpReq.Header.Set("accept", "application/json")
+	httpReq.Header.Set("content-type", contentType)
+	httpReq.Header.Set("accept", accept)
+	httpReq.Header.Set("accept-encoding", "gzip")
 
 	httpRes, err := c.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("execute HTTP request: %w", err)
+		return nil, temporarySearchError{fmt.Errorf("execute HTTP request: %w", err)}
 	}
 	defer func() {
 		io.Copy(io.Discard, httpRes.Body)
@@ -210,17 +1021,264 @@ func (c *SearchClient) Search(ctx context.Context, req *trc.SearchRequest) (_ *t
 	}()
 
 	if httpRes.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("read HTTP response: server gave HTTP %d (%s)", httpRes.StatusCode, http.StatusText(httpRes.StatusCode))
+		err := fmt.Errorf("read HTTP response: server gave HTTP %d (%s)", httpRes.StatusCode, http.StatusText(httpRes.StatusCode))
+		if httpRes.StatusCode >= 500 || httpRes.StatusCode == http.StatusTooManyRequests {
+			err = temporarySearchError{err}
+		}
+		return nil, err
+	}
+
+	var resBody io.Reader = httpRes.Body
+	if strings.EqualFold(httpRes.Header.Get("content-encoding"), "gzip") {
+		zr, err := gzip.NewReader(httpRes.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read gzip response: %w", err)
+		}
+		defer zr.Close()
+		resBody = zr
+	}
+
+	var res *trc.SearchResponse
+	switch {
+	case c.Protobuf:
+		if !strings.Contains(httpRes.Header.Get("content-type"), trcproto.ContentType) {
+			return nil, fmt.Errorf("read HTTP response: server doesn't support protobuf (content-type %q)", httpRes.Header.Get("content-type"))
+		}
+		b, err := io.ReadAll(resBody)
+		if err != nil {
+			return nil, temporarySearchError{fmt.Errorf("read search response: %w", err)}
+		}
+		if res, err = trcproto.UnmarshalSearchResponse(b); err != nil {
+			return nil, fmt.Errorf("decode search response: %w", err)
+		}
+	default:
+		var data SearchData
+		if err := json.NewDecoder(resBody).Decode(&data); err != nil {
+			return nil, fmt.Errorf("decode search response: %w", err)
+		}
+		res = &data.Response
+	}
+
+	tr.LazyTracef("%s -> total %d, matched %d, returned %d", uri, res.TotalCount, res.MatchCount, len(res.Traces))
+
+	return res, nil
+}
+
+// temporarySearchError is a search error which may succeed if retried, e.g. a
+// transport error, or a server error.
+type temporarySearchError struct{ error }
+
+func (e temporarySearchError) Unwrap() error { return e.error }
+
+func isTemporarySearchError(err error) bool {
+	var tmp temporarySearchError
+	return errors.As(err, &tmp)
+}
+
+//
+//
+//
+
+func (s *TraceServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		tr  = trc.Get(ctx)
+		f   = parseFilter(r)
+	)
+
+	if s.Exporter == nil {
+		tr.Errorf("no exporter")
+		http.Error(w, "export not supported", http.StatusNotImplemented)
+		return
 	}
 
-	var res SearchData
-	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
-		return nil, fmt.Errorf("decode search response: %w", err)
+	if normalizeErrs := f.Normalize(); len(normalizeErrs) > 0 {
+		err := fmt.Errorf("bad request: %s", strings.Join(trcutil.FlattenErrors(normalizeErrs...), "; "))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	tr.LazyTracef("%s -> total %d, matched %d, returned %d", c.uri, res.Response.TotalCount, res.Response.MatchCount, len(res.Response.Traces))
+	tr.LazyTracef("export filter %s", f)
 
-	return &res.Response, nil
+	w.Header().Set("content-type", "application/x-ndjson; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	var (
+		flusher, _ = w.(http.Flusher)
+		enc        = json.NewEncoder(w)
+		count      int
+	)
+	err := s.Exporter.Export(ctx, f, func(st *trc.StaticTrace) error {
+		if err := enc.Encode(st); err != nil {
+			return err
+		}
+		if count++; flusher != nil && count%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	switch {
+	case err != nil:
+		tr.Errorf("export failed after %d trace(s): %v", count, err)
+	default:
+		tr.LazyTracef("exported %d trace(s)", count)
+	}
+}
+
+const exportFlushEvery = 100
+
+//
+//
+//
+
+// AnnotateRequest is the JSON body of an annotate request, which adds an event
+// with the given text to the active trace with the given ID.
+type AnnotateRequest struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+func (s *TraceServer) handleAnnotate(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		tr  = trc.Get(ctx)
+	)
+
+	if !s.authorizeMutation(w, r, "annotate", s.Mutations.Annotate) {
+		return
+	}
+
+	if s.Annotator == nil {
+		tr.Errorf("annotate: no annotator")
+		http.Error(w, "annotate not supported", http.StatusNotImplemented)
+		return
+	}
+
+	var req AnnotateRequest
+	body := http.MaxBytesReader(w, r.Body, maxRequestBodySizeBytes)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode annotate request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" || req.Text == "" {
+		http.Error(w, "id and text are required", http.StatusBadRequest)
+		return
+	}
+
+	tr.LazyTracef("annotate %s: %q", req.ID, req.Text)
+
+	switch err := s.Annotator.Annotate(req.ID, "%s", req.Text); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, trc.ErrTraceNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, trc.ErrTraceFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		tr.Errorf("annotate: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+//
+//
+//
+
+// ClearResponse is returned by clear requests.
+type ClearResponse struct {
+	Filter  trc.Filter `json:"filter"`
+	Cleared int        `json:"cleared"`
+}
+
+func (s *TraceServer) handleClear(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx = r.Context()
+		tr  = trc.Get(ctx)
+		f   = parseFilter(r)
+	)
+
+	if !s.authorizeMutation(w, r, "clear", s.Mutations.Clear) {
+		return
+	}
+
+	if s.Clearer == nil {
+		tr.Errorf("clear: no clearer")
+		http.Error(w, "clear not supported", http.StatusNotImplemented)
+		return
+	}
+
+	if normalizeErrs := f.Normalize(); len(normalizeErrs) > 0 {
+		err := fmt.Errorf("bad request: %s", strings.Join(trcutil.FlattenErrors(normalizeErrs...), "; "))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tr.LazyTracef("clear filter %s", f)
+
+	n, err := s.Clearer.Clear(ctx, f)
+	if err != nil {
+		tr.Errorf("clear: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, r, ClearResponse{Filter: f, Cleared: n})
+}
+
+//
+//
+//
+
+// ResizeResponse is returned by resize requests. Category is empty if the
+// default size of every category was set.
+type ResizeResponse struct {
+	Category string `json:"category,omitempty"`
+	Size     int    `json:"size"`
+	Evicted  int    `json:"evicted"`
+}
+
+func (s *TraceServer) handleResize(w http.ResponseWriter, r *http.Request) {
+	var (
+		ctx      = r.Context()
+		tr       = trc.Get(ctx)
+		urlquery = r.URL.Query()
+		category = urlquery.Get("category")
+	)
+
+	if !s.authorizeMutation(w, r, "resize", s.Mutations.Resize) {
+		return
+	}
+
+	if s.Resizer == nil {
+		tr.Errorf("resize: no resizer")
+		http.Error(w, "resize not supported", http.StatusNotImplemented)
+		return
+	}
+
+	size, err := strconv.Atoi(urlquery.Get("resize"))
+	if err != nil || (size <= 0 && category == "") {
+		http.Error(w, "bad request: resize requires a positive size, or zero with a category", http.StatusBadRequest)
+		return
+	}
+
+	var evicted int
+	if category == "" {
+		evicted = s.Resizer.Resize(size)
+	} else {
+		evicted = s.Resizer.ResizeCategory(category, size)
+	}
+
+	tr.LazyTracef("resize category %q to %d, evicted %d", category, size, evicted)
+
+	renderJSON(ctx, w, r, ResizeResponse{
+		Category: category,
+		Size:     s.Resizer.CategorySize(category),
+		Evicted:  evicted,
+	})
 }
 
 //
@@ -233,6 +1291,14 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 		tr  = trc.Get(ctx)
 	)
 
+	closing, ok := s.beginStream()
+	if !ok {
+		tr.Errorf("server shutting down")
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.endStream()
+
 	var f trc.Filter
 	switch {
 	case strings.Contains(r.Header.Get("content-type"), "application/json"):
@@ -261,19 +1327,50 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 	var (
 		stats   = parseDefault(r.URL.Query().Get("stats"), time.ParseDuration, 10*time.Second)
 		sendbuf = parseRange(r.URL.Query().Get("sendbuf"), strconv.Atoi, 0, 100, 100000)
-		tracec  = make(chan trc.Trace, sendbuf)
-		donec   = make(chan struct{})
+		opts    = trc.StreamOptions{
+			SendPolicy:  trc.SendPolicy(r.URL.Query().Get("send_policy")),
+			SendTimeout: parseDefault(r.URL.Query().Get("send_timeout"), time.ParseDuration, 0),
+			MaxDrops:    parseDefault(r.URL.Query().Get("max_drops"), strconv.Atoi, 0),
+		}
+		tracec = make(chan trc.Trace, sendbuf)
+		donec  = make(chan struct{})
+		usePB  = requestExplicitlyAccepts(r, trcproto.ContentType)
 	)
 
 	tr.LazyTracef("stats interval %s", stats)
 	tr.LazyTracef("send buffer %d", sendbuf)
 
-	ctx, cancel := context.WithCancel(ctx)
+	// Send options are only supported by streamers like trc.Collector, which
+	// implement the optional StreamWithOptions method.
+	stream := s.Streamer.Stream
+	if opts != (trc.StreamOptions{}) {
+		if normalizeErrs := opts.Normalize(); len(normalizeErrs) > 0 {
+			err := fmt.Errorf("bad request: %s", strings.Join(trcutil.FlattenErrors(normalizeErrs...), "; "))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sws, ok := s.Streamer.(interface {
+			StreamWithOptions(context.Context, trc.Filter, chan<- trc.Trace, trc.StreamOptions) (trc.StreamStats, error)
+		})
+		if !ok {
+			http.Error(w, "send options not supported", http.StatusNotImplemented)
+			return
+		}
+		tr.LazyTracef("send policy %s, timeout %s, max drops %d", opts.SendPolicy, opts.SendTimeout, opts.MaxDrops)
+		stream = func(ctx context.Context, f trc.Filter, ch chan<- trc.Trace) (trc.StreamStats, error) {
+			return sws.StreamWithOptions(ctx, f, ch, opts)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(trc.WithSubscriber(ctx, describeSubscriber(r)))
 	defer cancel()
 
+	var streamErr error
 	go func() {
-		stats, err := s.Streamer.Stream(ctx, f, tracec)
+		stats, err := stream(ctx, f, tracec)
 		tr.LazyTracef("%s (error: %v)", stats, err)
+		streamErr = err
 		close(donec)
 	}()
 	defer func() {
@@ -292,9 +1389,11 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 		for {
 			select {
 			case <-initc:
-				data, err := json.Marshal(map[string]any{
-					"filter":  f,
-					"sendbuf": cap(tracec),
+				data, err := json.Marshal(StreamInitEvent{
+					StreamEventHeader: newStreamEventHeader(StreamEventInit),
+					Filter:            f,
+					SendBuffer:        cap(tracec),
+					Options:           opts,
 				})
 				if err != nil {
 					tr.Errorf("JSON marshal init: %v", err)
@@ -302,7 +1401,7 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 				}
 
 				if err := encoder.Encode(eventsource.Event{
-					Type: "init",
+					Type: StreamEventInit,
 					Data: data,
 				}); err != nil {
 					tr.Errorf("encode init: %v", err)
@@ -316,14 +1415,17 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 					continue
 				}
 
-				data, err := json.Marshal(stats)
+				data, err := json.Marshal(StreamStatsEvent{
+					StreamEventHeader: newStreamEventHeader(StreamEventStats),
+					StreamStats:       stats,
+				})
 				if err != nil {
 					tr.Errorf("JSON marshal stats: %v", err)
 					continue
 				}
 
 				if err := encoder.Encode(eventsource.Event{
-					Type: "stats",
+					Type: StreamEventStats,
 					Data: data,
 				}); err != nil {
 					tr.Errorf("encode stats: %v", err)
@@ -335,20 +1437,54 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 					continue // don't publish our own trace events
 				}
 
-				data, err := json.Marshal(recv)
-				if err != nil {
-					tr.Errorf("JSON marshal trace: %v", err)
-					continue
+				st, ok := recv.(*trc.StaticTrace)
+				if !ok {
+					st = trc.NewStreamTrace(recv)
 				}
 
-				if err := encoder.Encode(eventsource.Event{
-					Type: "trace",
-					Data: data,
-				}); err != nil {
+				var ev eventsource.Event
+				switch {
+				case usePB:
+					ev.Type = "trace.pb"
+					ev.Data = []byte(base64.StdEncoding.EncodeToString(trcproto.MarshalStaticTrace(st)))
+				default:
+					data, err := json.Marshal(StreamTraceEvent{
+						StreamEventHeader: newStreamEventHeader(StreamEventTrace),
+						StaticTrace:       st,
+					})
+					if err != nil {
+						tr.Errorf("JSON marshal trace: %v", err)
+						continue
+					}
+					ev.Type = StreamEventTrace
+					ev.Data = data
+				}
+
+				if err := encoder.Encode(ev); err != nil {
 					tr.Errorf("encode trace: %v", err)
 					continue
 				}
 
+			case <-closing:
+				tr.LazyTracef("stopping: server shutting down")
+				encodeCloseEvent(encoder, "server closing")
+				cancel()
+				return
+
+			case <-donec:
+				if ctx.Err() != nil {
+					tr.LazyTracef("stopping: context done (%v)", ctx.Err())
+					return
+				}
+				if errors.Is(streamErr, trc.ErrSlowSubscriber) {
+					tr.Errorf("stopping: %v", streamErr)
+					encodeErrorEvent(encoder, streamErr.Error())
+					return
+				}
+				tr.LazyTracef("stopping: stream ended (%v)", streamErr)
+				encodeCloseEvent(encoder, fmt.Sprintf("stream ended: %v", streamErr))
+				return
+
 			case <-ctx.Done():
 				tr.LazyTracef("stopping: context done (%v)", ctx.Err())
 				return
@@ -362,6 +1498,33 @@ func (s *TraceServer) handleStream(w http.ResponseWriter, r *http.Request) {
 	}).ServeHTTP(w, r)
 }
 
+// encodeCloseEvent sends a terminal event to a stream client, telling it that
+// the stream is ending normally, and why.
+func encodeCloseEvent(encoder *eventsource.Encoder, reason string) {
+	encodeEndEvent(encoder, StreamEventClose, reason)
+}
+
+// encodeErrorEvent sends a terminal event to a stream client, telling it that
+// the stream is ending due to an error, e.g. because the client was evicted for
+// being too slow, and that it shouldn't reconnect.
+func encodeErrorEvent(encoder *eventsource.Encoder, reason string) {
+	encodeEndEvent(encoder, StreamEventError, reason)
+}
+
+func encodeEndEvent(encoder *eventsource.Encoder, eventType string, reason string) {
+	data, err := json.Marshal(StreamEndEvent{
+		StreamEventHeader: newStreamEventHeader(eventType),
+		Reason:            reason,
+	})
+	if err != nil {
+		return
+	}
+	encoder.Encode(eventsource.Event{
+		Type: eventType,
+		Data: data,
+	})
+}
+
 //
 
 // StreamClient streams trace data from a server.
@@ -375,15 +1538,45 @@ type StreamClient struct {
 	// SendBuffer used by the remote stream server. Min 0, max 100k.
 	SendBuffer int
 
+	// SendOptions used by the remote stream server, e.g. to determine what
+	// happens when the send buffer is full. Optional.
+	SendOptions trc.StreamOptions
+
 	// OnRead is called for every stream event received by the client.
 	// Implementations must not block.
 	OnRead func(ctx context.Context, eventType string, eventData []byte)
 
-	// RetryInterval between reconnect attempts. Default 3s, min 1s, max 60s.
+	// OnConnect is called whenever a connection to the remote stream server is
+	// established. Implementations must not block.
+	OnConnect func(ctx context.Context)
+
+	// OnDisconnect is called whenever a connection ends, or a connection
+	// attempt fails, with the reason. Implementations must not block.
+	OnDisconnect func(ctx context.Context, err error)
+
+	// OnRetry is called before each reconnect attempt, with the number of
+	// consecutive failed attempts, starting at 1, and the delay before the
+	// reconnect. Implementations must not block.
+	OnRetry func(ctx context.Context, attempt int, delay time.Duration)
+
+	// RetryInterval is the initial delay between reconnect attempts, which is
+	// doubled after each consecutive failed attempt, up to MaxRetryInterval,
+	// with random jitter of up to half of the delay, so that clients of a
+	// flapping server back off, and don't reconnect in lockstep. The delay is
+	// reset once a connection receives data. Default 3s, min 1s, max 60s.
 	RetryInterval time.Duration
 
+	// MaxRetryInterval is the max delay between reconnect attempts. Default
+	// 60s, min RetryInterval, max 10m.
+	MaxRetryInterval time.Duration
+
 	// StatsInterval for stream stats updates. Default 10s, min 1s, max 60s.
 	StatsInterval time.Duration
+
+	// Protobuf, if true, asks the remote stream server to encode traces as
+	// protobuf rather than JSON. Servers which don't support protobuf send JSON
+	// regardless, which the client also accepts. Optional.
+	Protobuf bool
 }
 
 func (c *StreamClient) initialize() {
@@ -405,6 +1598,18 @@ func (c *StreamClient) initialize() {
 		c.OnRead = func(ctx context.Context, eventType string, eventData []byte) {}
 	}
 
+	if c.OnConnect == nil {
+		c.OnConnect = func(ctx context.Context) {}
+	}
+
+	if c.OnDisconnect == nil {
+		c.OnDisconnect = func(ctx context.Context, err error) {}
+	}
+
+	if c.OnRetry == nil {
+		c.OnRetry = func(ctx context.Context, attempt int, delay time.Duration) {}
+	}
+
 	if def, min, max := 3*time.Second, 1*time.Second, 60*time.Second; c.RetryInterval == 0 {
 		c.RetryInterval = def
 	} else if c.RetryInterval < min {
@@ -413,6 +1618,14 @@ func (c *StreamClient) initialize() {
 		c.RetryInterval = max
 	}
 
+	if def, min, max := 60*time.Second, c.RetryInterval, 10*time.Minute; c.MaxRetryInterval == 0 {
+		c.MaxRetryInterval = iff(def > min, def, min)
+	} else if c.MaxRetryInterval < min {
+		c.MaxRetryInterval = min
+	} else if c.MaxRetryInterval > max {
+		c.MaxRetryInterval = max
+	}
+
 	if def, min, max := 10*time.Second, 1*time.Second, 60*time.Second; c.StatsInterval == 0 {
 		c.StatsInterval = def
 	} else if c.StatsInterval < min {
@@ -432,8 +1645,10 @@ func NewStreamClient(uri string) *StreamClient {
 }
 
 // Stream trace data from the remote server, filtered by the provided filter, to
-// the provided channel. The stream stops when the context is canceled, or a
-// non-recoverable error occurs.
+// the provided channel. The client reconnects whenever the connection ends, or
+// a connection attempt fails, with exponential backoff, see RetryInterval. The
+// stream stops when the context is canceled, or a non-recoverable error occurs,
+// e.g. the server responds with a client error, or sends an invalid trace.
 func (c *StreamClient) Stream(ctx context.Context, f trc.Filter, ch chan<- trc.Trace) (err error) {
 	c.initialize()
 
@@ -445,12 +1660,9 @@ func (c *StreamClient) Stream(ctx context.Context, f trc.Filter, ch chan<- trc.T
 		}
 	}()
 
-	// Explicitly don't provide the context to the request, because EventSource
-	// (incorrectly) treats context cancelation as a recoverable error, in which
-	// case Read can block for a single retry duration before returning.
-	//
-	// Also, EventSource directly re-uses this request over reconnect attempts,
-	// which prevents the use of a body, and means we have to encode the filter
+	// The request doesn't set an explicit Accept-Encoding header, so that the
+	// HTTP transport can negotiate gzip and decompress transparently. The same
+	// request is used for every connection attempt, so the filter is encoded
 	// in the URL.
 	var req *http.Request
 	{
@@ -466,55 +1678,175 @@ func (c *StreamClient) Stream(ctx context.Context, f trc.Filter, ch chan<- trc.T
 		if c.StatsInterval > 0 {
 			query.Set("stats", c.StatsInterval.String())
 		}
+		if c.SendOptions.SendPolicy != "" {
+			query.Set("send_policy", string(c.SendOptions.SendPolicy))
+		}
+		if c.SendOptions.SendTimeout > 0 {
+			query.Set("send_timeout", c.SendOptions.SendTimeout.String())
+		}
+		if c.SendOptions.MaxDrops > 0 {
+			query.Set("max_drops", strconv.Itoa(c.SendOptions.MaxDrops))
+		}
 		uri.RawQuery = query.Encode()
 
-		r, err := http.NewRequest("GET", uri.String(), nil)
+		r, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
 		if err != nil {
 			return err
 		}
 
 		encodeFilter(f, r)
 
+		r.Header.Set("accept", "text/event-stream")
+		if c.Protobuf {
+			r.Header.Set("accept", "text/event-stream, "+trcproto.ContentType)
+		}
+		r.Header.Set("cache-control", "no-cache")
+
 		req = r
 	}
 
-	es := eventsource.New(req, c.RetryInterval)
-	go func() {
-		<-ctx.Done()
-		es.Close()
-	}()
+	for attempt := 1; ; attempt++ {
+		received, err := c.streamOnce(ctx, req, ch)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, errStreamClosed):
+			return nil
+		case errors.As(err, &streamTerminalError{}):
+			return err
+		}
 
-	for {
-		ev, err := es.Read()
-		if errors.Is(err, eventsource.ErrClosed) {
+		c.OnDisconnect(ctx, err)
+		tr.LazyTracef("disconnected: %v", err)
+
+		if received {
+			attempt = 1 // reset the backoff
+		}
+
+		delay := retryDelay(c.RetryInterval, c.MaxRetryInterval, attempt, rand.Float64())
+		c.OnRetry(ctx, attempt, delay)
+		tr.LazyTracef("retry %d in %s", attempt, delay)
+
+		select {
+		case <-ctx.Done():
 			return nil
+		case <-time.After(delay):
 		}
-		if err != nil {
-			return fmt.Errorf("read server-sent event: %w", err)
+	}
+}
+
+var errStreamClosed = errors.New("stream closed by server")
+
+// streamTerminalError wraps errors which stop the stream, rather than causing
+// a reconnect.
+type streamTerminalError struct{ error }
+
+func (e streamTerminalError) Unwrap() error { return e.error }
+
+// streamOnce connects to the remote stream server, and reads events from the
+// connection until it ends, forwarding traces to the channel. It returns true
+// if any data was received, other than the initial event, and the reason the
+// connection ended, which is never nil.
+func (c *StreamClient) streamOnce(ctx context.Context, req *http.Request, ch chan<- trc.Trace) (received bool, err error) {
+	tr := trc.Get(ctx)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("connect: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return false, errStreamClosed
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return false, fmt.Errorf("connect: server returned %s", resp.Status) // assumed to be temporary
+	case resp.StatusCode != http.StatusOK:
+		return false, streamTerminalError{fmt.Errorf("connect: server returned unrecoverable status %s", resp.Status)}
+	}
+
+	if mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("content-type")); mediatype != "text/event-stream" {
+		return false, streamTerminalError{fmt.Errorf("connect: invalid content type %q", resp.Header.Get("content-type"))}
+	}
+
+	c.OnConnect(ctx)
+	tr.LazyTracef("connected")
+
+	dec := eventsource.NewDecoder(resp.Body)
+	for {
+		var ev eventsource.Event
+		switch err := dec.Decode(&ev); {
+		case errors.Is(err, eventsource.ErrInvalidEncoding):
+			continue
+		case errors.Is(err, io.EOF):
+			return received, fmt.Errorf("connection closed")
+		case err != nil:
+			return received, fmt.Errorf("read server-sent event: %w", err)
+		case len(ev.Data) <= 0:
+			continue
 		}
 
 		c.OnRead(ctx, ev.Type, ev.Data)
 
 		switch ev.Type {
-		case "init":
+		case StreamEventInit:
 			tr.LazyTracef("init: %s", string(ev.Data))
 
-		case "trace":
-			var str trc.StaticTrace
-			if err := json.Unmarshal(ev.Data, &str); err != nil {
-				return fmt.Errorf("decode trace event: %w", err)
+		case StreamEventTrace:
+			received = true
+			var event StreamTraceEvent
+			switch err := decodeStreamEvent(ev.Type, ev.Data, &event); {
+			case errors.Is(err, errUnsupportedStreamEvent):
+				tr.LazyTracef("skipping event: %v", err)
+				continue
+			case err != nil:
+				return received, streamTerminalError{err}
+			case event.StaticTrace == nil:
+				event.StaticTrace = &trc.StaticTrace{} // no trace fields in the payload
+			}
+			select {
+			case <-ctx.Done():
+			case ch <- event.StaticTrace:
+			}
+
+		case "trace.pb":
+			received = true
+			data, err := base64.StdEncoding.DecodeString(string(ev.Data))
+			if err != nil {
+				return received, streamTerminalError{fmt.Errorf("decode trace event: %w", err)}
+			}
+			str, err := trcproto.UnmarshalStaticTrace(data)
+			if err != nil {
+				return received, streamTerminalError{fmt.Errorf("decode trace event: %w", err)}
 			}
 			select {
 			case <-ctx.Done():
-			case ch <- &str:
+			case ch <- str:
 			}
 
-		case "stats":
-			var stats trc.StreamStats
-			if err := json.Unmarshal(ev.Data, &stats); err == nil {
-				tr.LazyTracef("%s", stats)
-			} else {
-				return fmt.Errorf("invalid stats event: %w", err)
+		case StreamEventClose:
+			// The server ended the stream normally, e.g. because it's shutting
+			// down. The connection will end, and we'll reconnect.
+			tr.LazyTracef("close: %s", string(ev.Data))
+
+		case StreamEventError:
+			// The server ended the stream due to an error, e.g. because we
+			// were evicted for being too slow. Reconnecting won't help. The
+			// stream ends even if the payload can't be decoded.
+			var event StreamEndEvent
+			decodeStreamEvent(ev.Type, ev.Data, &event)
+			return received, streamTerminalError{fmt.Errorf("server: %s", event.Reason)}
+
+		case StreamEventStats:
+			received = true
+			var event StreamStatsEvent
+			switch err := decodeStreamEvent(ev.Type, ev.Data, &event); {
+			case errors.Is(err, errUnsupportedStreamEvent):
+				tr.LazyTracef("skipping event: %v", err)
+			case err != nil:
+				return received, streamTerminalError{fmt.Errorf("invalid stats event: %w", err)}
+			default:
+				tr.LazyTracef("%s", event.StreamStats)
 			}
 
 		default:
@@ -522,3 +1854,17 @@ func (c *StreamClient) Stream(ctx context.Context, f trc.Filter, ch chan<- trc.T
 		}
 	}
 }
+
+// retryDelay returns the delay before the given reconnect attempt, which is
+// the base delay doubled for every previous attempt, up to max, less a jitter
+// of up to half of that delay, determined by rnd in [0, 1).
+func retryDelay(base, max time.Duration, attempt int, rnd float64) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay - time.Duration(rnd*float64(delay/2))
+}
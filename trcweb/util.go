@@ -1,8 +1,12 @@
 package trcweb
 
 import (
+	"html/template"
 	"net/http"
+	"net/url"
+	"reflect"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/peterbourgon/trc"
@@ -12,9 +16,19 @@ const maxRequestBodySizeBytes = 1 * 1024 * 1024 // 1MB
 
 func encodeFilter(f trc.Filter, r *http.Request) {
 	q := r.URL.Query()
+	addFilterValues(q, f)
+	r.URL.RawQuery = q.Encode()
+}
+
+// addFilterValues adds the filter to the URL query, in the form parsed by
+// parseFilter.
+func addFilterValues(q url.Values, f trc.Filter) {
 	for _, source := range f.Sources {
 		q.Add("source", source)
 	}
+	for _, source := range f.ExcludeSources {
+		q.Add("exclude_source", source)
+	}
 	for _, id := range f.IDs {
 		q.Add("id", id)
 	}
@@ -36,24 +50,125 @@ func encodeFilter(f trc.Filter, r *http.Request) {
 	if f.IsErrored {
 		q.Set("errored", "true")
 	}
+	for _, status := range f.Statuses {
+		q.Add("status", status)
+	}
+	for _, status := range f.ExcludeStatuses {
+		q.Add("exclude_status", status)
+	}
+	for _, tag := range f.Tags {
+		q.Add("tag", tag)
+	}
+	for _, typ := range f.ErrorTypes {
+		q.Add("error_type", typ)
+	}
+	if f.MinEvents != nil {
+		q.Set("min_events", strconv.Itoa(*f.MinEvents))
+	}
+	if f.MaxEvents != nil {
+		q.Set("max_events", strconv.Itoa(*f.MaxEvents))
+	}
+	if f.HasErrorEvents {
+		q.Set("error_events", "true")
+	}
 	if f.Query != "" {
 		q.Set("q", f.Query)
 	}
-	r.URL.RawQuery = q.Encode()
+	if f.MatchEvents {
+		q.Set("match_events", "true")
+	}
+}
+
+// searchRequestValues returns the URL query which, when parsed by the trace
+// server, produces the given search request. Parameters with default values are
+// omitted. It's the basis for permalinks to a given search.
+func searchRequestValues(req trc.SearchRequest) url.Values {
+	q := url.Values{}
+	addFilterValues(q, req.Filter)
+	if req.Limit != 0 && req.Limit != trc.SearchLimitDefault {
+		q.Set("n", strconv.Itoa(req.Limit))
+	}
+	if len(req.Bucketing) > 0 && !reflect.DeepEqual(req.Bucketing, trc.DefaultBucketing) {
+		for _, b := range req.Bucketing {
+			q.Add("b", b.String())
+		}
+	}
+	if req.StackDepth != 0 {
+		q.Set("stack", strconv.Itoa(req.StackDepth))
+	}
+	if req.StatsOnly {
+		q.Set("stats_only", "true")
+	}
+	if req.MaxScan > 0 {
+		q.Set("max_scan", strconv.Itoa(req.MaxScan))
+	}
+	if req.MaxDuration > 0 {
+		q.Set("max_duration", req.MaxDuration.String())
+	}
+	if req.Sort != "" && req.Sort != trc.SearchSortNewest {
+		q.Set("sort", string(req.Sort))
+	}
+	if req.MinEventLevel != "" {
+		q.Set("level", string(req.MinEventLevel))
+	}
+	return q
+}
+
+// searchPermalink returns the encoded URL query for the search request, see
+// searchRequestValues.
+func searchPermalink(req trc.SearchRequest) template.URL {
+	return template.URL(searchRequestValues(req).Encode())
+}
+
+// searchFormHidden returns the parameters of the search request which aren't
+// represented by a visible control in the search form, so they can be included
+// as hidden inputs, and preserved when the form is submitted.
+func searchFormHidden(req trc.SearchRequest) url.Values {
+	q := searchRequestValues(req)
+	for _, visible := range []string{"q", "n", "sort", "level", "source", "category"} {
+		q.Del(visible)
+	}
+	return q
+}
+
+// parseSearchRequest parses a search request from the URL query. It's the
+// inverse of searchRequestValues.
+func parseSearchRequest(r *http.Request) trc.SearchRequest {
+	urlquery := r.URL.Query()
+	return trc.SearchRequest{
+		Bucketing:     parseBucketing(urlquery["b"]), // nil is OK
+		Filter:        parseFilter(r),
+		Limit:         parseRange(urlquery.Get("n"), strconv.Atoi, trc.SearchLimitMin, trc.SearchLimitDefault, trc.SearchLimitMax),
+		StackDepth:    parseDefault(urlquery.Get("stack"), strconv.Atoi, 0),
+		StatsOnly:     urlquery.Has("stats_only"),
+		MaxScan:       parseDefault(urlquery.Get("max_scan"), strconv.Atoi, 0),
+		MaxDuration:   parseDefault(urlquery.Get("max_duration"), time.ParseDuration, 0),
+		Sort:          trc.SearchSort(urlquery.Get("sort")),
+		MinEventLevel: trc.Level(urlquery.Get("level")),
+	}
 }
 
 func parseFilter(r *http.Request) trc.Filter {
 	urlquery := r.URL.Query()
 	return trc.Filter{
-		Sources:     urlquery["source"],
-		IDs:         urlquery["id"],
-		Category:    urlquery.Get("category"),
-		IsActive:    urlquery.Has("active"),
-		IsFinished:  urlquery.Has("finished"),
-		MinDuration: parseDefault(urlquery.Get("min"), parseDurationPointer, nil),
-		IsSuccess:   urlquery.Has("success"),
-		IsErrored:   urlquery.Has("errored"),
-		Query:       urlquery.Get("q"),
+		Sources:         urlquery["source"],
+		ExcludeSources:  urlquery["exclude_source"],
+		IDs:             urlquery["id"],
+		Category:        urlquery.Get("category"),
+		IsActive:        urlquery.Has("active"),
+		IsFinished:      urlquery.Has("finished"),
+		MinDuration:     parseDefault(urlquery.Get("min"), parseDurationPointer, nil),
+		IsSuccess:       urlquery.Has("success"),
+		IsErrored:       urlquery.Has("errored"),
+		Statuses:        urlquery["status"],
+		ExcludeStatuses: urlquery["exclude_status"],
+		Tags:            urlquery["tag"],
+		ErrorTypes:      urlquery["error_type"],
+		MinEvents:       parseDefault(urlquery.Get("min_events"), parseIntPointer, nil),
+		MaxEvents:       parseDefault(urlquery.Get("max_events"), parseIntPointer, nil),
+		HasErrorEvents:  urlquery.Has("error_events"),
+		Query:           urlquery.Get("q"),
+		MatchEvents:     urlquery.Has("match_events"),
 	}
 }
 
@@ -86,6 +201,14 @@ func parseDurationPointer(s string) (*time.Duration, error) {
 	return &d, nil
 }
 
+func parseIntPointer(s string) (*int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
 func parseBucketing(bs []string) []time.Duration {
 	if len(bs) <= 0 {
 		return nil
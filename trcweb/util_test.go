@@ -0,0 +1,99 @@
+package trcweb
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/trc"
+)
+
+func TestSearchRequestValuesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	min := 50 * time.Millisecond
+	minEvents, maxEvents := 0, 10
+	for _, req := range []trc.SearchRequest{
+		{Limit: trc.SearchLimitDefault},
+		{
+			Bucketing: []time.Duration{0, time.Millisecond, time.Second},
+			Filter: trc.Filter{
+				Sources:        []string{"a", "b"},
+				ExcludeSources: []string{"c"},
+				IDs:            []string{"id1"},
+				Category:       "api & co",
+				IsActive:       true,
+				IsFinished:     true,
+				MinDuration:    &min,
+				IsSuccess:      true,
+				IsErrored:      true,
+				MinEvents:      &minEvents,
+				MaxEvents:      &maxEvents,
+				HasErrorEvents: true,
+				Query:          `cat:api err:true (foo|bar)&baz`,
+				MatchEvents:    true,
+			},
+			Limit:         25,
+			StackDepth:    -1,
+			StatsOnly:     true,
+			MaxScan:       1000,
+			MaxDuration:   time.Second,
+			Sort:          trc.SearchSortSlowest,
+			MinEventLevel: trc.LevelWarn,
+		},
+	} {
+		r := httptest.NewRequest("GET", "/?"+searchRequestValues(req).Encode(), nil)
+		if want, have := req, parseSearchRequest(r); !reflect.DeepEqual(want, have) {
+			t.Errorf("round trip: want %+v, have %+v", want, have)
+		}
+	}
+}
+
+func TestSearchDataDrillDown(t *testing.T) {
+	t.Parallel()
+
+	min := time.Second
+	data := SearchData{Request: trc.SearchRequest{
+		Filter: trc.Filter{
+			Sources:     []string{"a"},
+			IDs:         []string{"id1"},
+			Category:    "api*",
+			IsActive:    true,
+			MinDuration: &min,
+			IsSuccess:   true,
+			Query:       "foo",
+		},
+		Limit: 25,
+	}}
+
+	for _, tc := range []struct {
+		category string
+		min      time.Duration
+		errored  bool
+		want     trc.Filter
+	}{
+		{
+			category: "api/users",
+			min:      time.Millisecond,
+			want:     trc.Filter{Sources: []string{"a"}, Category: "api/users", MinDuration: ptr(time.Millisecond), IsSuccess: true, Query: "foo"},
+		},
+		{
+			category: "overall",
+			min:      -1,
+			errored:  true,
+			want:     trc.Filter{Sources: []string{"a"}, Category: "api*", MinDuration: &min, IsErrored: true, Query: "foo"},
+		},
+	} {
+		r := httptest.NewRequest("GET", "/?"+string(data.DrillDown(tc.category, tc.min, tc.errored)), nil)
+		have := parseSearchRequest(r)
+		if want := tc.want; !reflect.DeepEqual(want, have.Filter) {
+			t.Errorf("%s: want %+v, have %+v", tc.category, want, have.Filter)
+		}
+		if want, have := 25, have.Limit; want != have {
+			t.Errorf("%s: limit: want %d, have %d", tc.category, want, have)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
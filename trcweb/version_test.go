@@ -0,0 +1,69 @@
+package trcweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+)
+
+type versionedSearcher struct {
+	trc.Searcher
+	version string
+}
+
+func (s versionedSearcher) Search(ctx context.Context, req *trc.SearchRequest) (*trc.SearchResponse, error) {
+	res, err := s.Searcher.Search(ctx, req)
+	if res != nil {
+		for source := range res.Versions {
+			res.Versions[source] = s.version
+		}
+	}
+	return res, err
+}
+
+func TestVersions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		c1     = trc.NewCollector(trc.CollectorConfig{Source: "c1"})
+		c2     = trc.NewCollector(trc.CollectorConfig{Source: "c2"})
+		server = NewTraceServer(c1)
+	)
+	server.Searcher = trc.MultiSearcher{
+		versionedSearcher{c1, "v1.0.0"},
+		versionedSearcher{c2, "v1.1.0"},
+	}
+
+	get := func(accept string) string {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("accept", accept)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	var data SearchData
+	if err := json.Unmarshal([]byte(get("application/json")), &data); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (trc.Versions{"c1": "v1.0.0", "c2": "v1.1.0"}), data.Response.Versions; len(want) != len(have) || want["c1"] != have["c1"] || want["c2"] != have["c2"] {
+		t.Errorf("versions: want %v, have %v", want, have)
+	}
+
+	body := get("text/html")
+	for _, want := range []string{
+		"trc " + trc.Version(),
+		"sources running 2 versions",
+		`c1 <span class="source-version">v1.0.0</span>`,
+		`c2 <span class="source-version">v1.1.0</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package trcxnet provides a compatibility layer for code instrumented with
+// golang.org/x/net/trace, so that it can be migrated to trc incrementally.
+//
+// The package mirrors the x/net/trace API: [New] and [NewEventLog] return
+// values which implement the x/net/trace Trace and EventLog interfaces, and
+// [NewContext] and [FromContext] carry those values in a context. Existing
+// call sites can switch their import path to this package, and their traces
+// and event logs become ordinary trc traces, maintained in a [trc.Collector],
+// and visible in the trc web interface alongside native traces.
+//
+// The family of a trace or event log is used as its category, and the title is
+// recorded as its first event. Traces created by this package are also injected
+// into the context by [NewContext], so code which has already been migrated
+// can use [trc.Get] to add events to the same trace.
+//
+// This package doesn't depend on golang.org/x/net directly.
+package trcxnet
@@ -0,0 +1,219 @@
+package trcxnet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/peterbourgon/trc"
+)
+
+// Trace mirrors the Trace interface from golang.org/x/net/trace. Values of
+// this type returned by this package also satisfy that interface.
+type Trace interface {
+	// LazyLog adds x to the event log. The sensitive flag is ignored, as trc
+	// doesn't restrict access to events by remote address.
+	LazyLog(x fmt.Stringer, sensitive bool)
+
+	// LazyPrintf evaluates its arguments with fmt.Sprintf each time the event
+	// is rendered, like [trc.Trace.LazyTracef].
+	LazyPrintf(format string, a ...any)
+
+	// SetError marks the trace as errored.
+	SetError()
+
+	// SetRecycler sets a function which would be called with each value passed
+	// to LazyLog when the value is discarded. It's accepted for compatibility,
+	// but never called: discarded values are garbage collected as normal.
+	SetRecycler(f func(any))
+
+	// SetTraceInfo records the trace and span ID of the trace as an event.
+	SetTraceInfo(traceID, spanID uint64)
+
+	// SetMaxEvents sets the maximum number of events retained by the trace,
+	// via [trc.SetMaxEvents].
+	SetMaxEvents(m int)
+
+	// Finish marks the trace as finished.
+	Finish()
+}
+
+// EventLog mirrors the EventLog interface from golang.org/x/net/trace. Values
+// of this type returned by this package also satisfy that interface.
+type EventLog interface {
+	// Printf adds a normal event to the log.
+	Printf(format string, a ...any)
+
+	// Errorf adds an error event to the log, which marks it as errored.
+	Errorf(format string, a ...any)
+
+	// Finish marks the log as finished.
+	Finish()
+}
+
+//
+//
+//
+
+// Tracer creates traces and event logs in a specific collector.
+type Tracer struct {
+	collector *trc.Collector
+}
+
+// NewTracer returns a tracer which creates traces and event logs in the given
+// collector.
+func NewTracer(collector *trc.Collector) *Tracer {
+	return &Tracer{collector: collector}
+}
+
+// New returns a new trace in the collector, with the family as its category,
+// and the title as its first event.
+func (t *Tracer) New(family, title string) Trace {
+	_, tr := t.collector.NewTrace(context.Background(), family)
+	tr.LazyTracef("%s", title)
+	return &xtrace{tr: tr}
+}
+
+// NewEventLog returns a new event log in the collector, with the family as its
+// category, and the title as its first event. Event logs are typically long
+// lived, and are represented as a trace which remains active until Finish is
+// called, so only their most recent events are retained.
+func (t *Tracer) NewEventLog(family, title string) EventLog {
+	_, tr := t.collector.NewTrace(context.Background(), family)
+	tr.LazyTracef("%s", title)
+	return &xeventLog{tr: tr}
+}
+
+//
+//
+//
+
+var defaultTracer atomic.Pointer[Tracer]
+
+func init() {
+	defaultTracer.Store(NewTracer(trc.NewDefaultCollector()))
+}
+
+// SetCollector sets the collector used by the package-level [New] and
+// [NewEventLog] functions. By default, a collector is created via
+// [trc.NewDefaultCollector], which isn't served anywhere, so most programs
+// should call SetCollector with the collector they serve via e.g. trcweb,
+// before any traces are created.
+func SetCollector(collector *trc.Collector) {
+	defaultTracer.Store(NewTracer(collector))
+}
+
+// Collector returns the collector used by the package-level [New] and
+// [NewEventLog] functions.
+func Collector() *trc.Collector {
+	return defaultTracer.Load().collector
+}
+
+// New is like [Tracer.New], using the collector set via [SetCollector].
+func New(family, title string) Trace {
+	return defaultTracer.Load().New(family, title)
+}
+
+// NewEventLog is like [Tracer.NewEventLog], using the collector set via
+// [SetCollector].
+func NewEventLog(family, title string) EventLog {
+	return defaultTracer.Load().NewEventLog(family, title)
+}
+
+//
+//
+//
+
+type contextKey struct{}
+
+// NewContext returns a copy of the parent context containing the trace. If the
+// trace was created by this package, the underlying trc trace is also injected
+// into the context, so that [trc.Get] returns it.
+func NewContext(ctx context.Context, tr Trace) context.Context {
+	if t, ok := Unwrap(tr); ok {
+		ctx, _ = trc.Put(ctx, t)
+	}
+	return context.WithValue(ctx, contextKey{}, tr)
+}
+
+// FromContext returns the trace in the context, if any.
+func FromContext(ctx context.Context) (tr Trace, ok bool) {
+	tr, ok = ctx.Value(contextKey{}).(Trace)
+	return tr, ok
+}
+
+// Unwrap returns the trc trace underlying a trace or event log created by this
+// package. It returns nil and false for other values.
+func Unwrap(x any) (trc.Trace, bool) {
+	u, ok := x.(interface{ Unwrap() trc.Trace })
+	if !ok {
+		return nil, false
+	}
+	return u.Unwrap(), true
+}
+
+//
+//
+//
+
+type xtrace struct {
+	tr      trc.Trace
+	errOnce sync.Once
+}
+
+var _ Trace = (*xtrace)(nil)
+
+func (x *xtrace) LazyLog(s fmt.Stringer, sensitive bool) {
+	x.tr.LazyTracef("%s", s)
+}
+
+func (x *xtrace) LazyPrintf(format string, a ...any) {
+	x.tr.LazyTracef(format, a...)
+}
+
+// SetError adds an error event, as trc traces are only marked as errored by
+// error events. Repeated calls add a single event.
+func (x *xtrace) SetError() {
+	x.errOnce.Do(func() { x.tr.Errorf("error") })
+}
+
+func (x *xtrace) SetRecycler(f func(any)) {}
+
+func (x *xtrace) SetTraceInfo(traceID, spanID uint64) {
+	x.tr.Tracef("trace ID %016x, span ID %016x", traceID, spanID)
+}
+
+func (x *xtrace) SetMaxEvents(m int) {
+	trc.SetMaxEvents(x.tr, m)
+}
+
+func (x *xtrace) Finish() {
+	x.tr.Finish()
+}
+
+func (x *xtrace) Unwrap() trc.Trace {
+	return x.tr
+}
+
+type xeventLog struct {
+	tr trc.Trace
+}
+
+var _ EventLog = (*xeventLog)(nil)
+
+func (x *xeventLog) Printf(format string, a ...any) {
+	x.tr.Tracef(format, a...)
+}
+
+func (x *xeventLog) Errorf(format string, a ...any) {
+	x.tr.Errorf(format, a...)
+}
+
+func (x *xeventLog) Finish() {
+	x.tr.Finish()
+}
+
+func (x *xeventLog) Unwrap() trc.Trace {
+	return x.tr
+}
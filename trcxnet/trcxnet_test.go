@@ -0,0 +1,117 @@
+package trcxnet_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/trc"
+	"github.com/peterbourgon/trc/trcxnet"
+)
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		collector = trc.NewDefaultCollector()
+		tracer    = trcxnet.NewTracer(collector)
+	)
+
+	xtr := tracer.New("grpc.Sent.svc", "/svc/Method")
+	xtr.LazyPrintf("hello %d", 1)
+	xtr.LazyLog(stringer("payload"), true)
+	xtr.SetTraceInfo(1, 2)
+	xtr.SetError()
+	xtr.SetError()
+
+	ctx = trcxnet.NewContext(ctx, xtr)
+	if have, ok := trcxnet.FromContext(ctx); !ok || have != xtr {
+		t.Errorf("FromContext: want %v, have %v (%v)", xtr, have, ok)
+	}
+	trc.Get(ctx).Tracef("native")
+	xtr.Finish()
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(res.Traces); want != have {
+		t.Fatalf("trace count: want %d, have %d", want, have)
+	}
+
+	tr := res.Traces[0]
+	if want, have := "grpc.Sent.svc", tr.Category(); want != have {
+		t.Errorf("category: want %q, have %q", want, have)
+	}
+	if !tr.Finished() || !tr.Errored() {
+		t.Errorf("want finished and errored, have %v and %v", tr.Finished(), tr.Errored())
+	}
+
+	var have []string
+	for _, ev := range tr.Events() {
+		have = append(have, ev.What)
+	}
+	want := []string{"/svc/Method", "hello 1", "payload", "trace ID 0000000000000001, span ID 0000000000000002", "error", "native"}
+	if fmt.Sprint(want) != fmt.Sprint(have) {
+		t.Errorf("events: want %q, have %q", want, have)
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	t.Parallel()
+
+	var (
+		collector = trc.NewDefaultCollector()
+		tracer    = trcxnet.NewTracer(collector)
+	)
+
+	log := tracer.NewEventLog("pool", "db")
+	log.Printf("conn %d opened", 1)
+	log.Errorf("conn %d failed", 2)
+
+	tr, ok := trcxnet.Unwrap(log)
+	if !ok {
+		t.Fatalf("Unwrap failed")
+	}
+	if tr.Finished() {
+		t.Errorf("event log finished before Finish")
+	}
+	log.Finish()
+
+	if !tr.Finished() || !tr.Errored() {
+		t.Errorf("want finished and errored, have %v and %v", tr.Finished(), tr.Errored())
+	}
+	var have []string
+	for _, ev := range tr.Events() {
+		have = append(have, ev.What)
+	}
+	if want := "db|conn 1 opened|conn 2 failed"; want != strings.Join(have, "|") {
+		t.Errorf("events: want %q, have %q", want, strings.Join(have, "|"))
+	}
+}
+
+func TestSetCollector(t *testing.T) {
+	t.Parallel()
+
+	collector := trc.NewDefaultCollector()
+	trcxnet.SetCollector(collector)
+	if trcxnet.Collector() != collector {
+		t.Fatalf("Collector doesn't return the collector set via SetCollector")
+	}
+
+	trcxnet.New("foo", "bar").Finish()
+
+	res, err := collector.Search(context.Background(), &trc.SearchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, res.TotalCount; want != have {
+		t.Errorf("total count: want %d, have %d", want, have)
+	}
+}
@@ -0,0 +1,87 @@
+package trc
+
+import (
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+const modulePath = "github.com/peterbourgon/trc"
+
+// Version returns the version of this module in the running program, as
+// reported by its build info, e.g. "v0.1.2". If the module is the main module,
+// e.g. in tests, the version is typically "(devel)", followed by the VCS
+// revision, if it's known. If the program has no build info, the version is
+// "unknown".
+//
+// Each collector reports its version in search responses, so that problems
+// with aggregating results from sources running different versions are easier
+// to diagnose. See [SearchResponse.Versions].
+func Version() string {
+	return getVersion()
+}
+
+var getVersion = sync.OnceValue(func() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	return moduleVersion(bi)
+})
+
+func moduleVersion(bi *debug.BuildInfo) string {
+	for _, dep := range bi.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+
+	if bi.Main.Path != modulePath {
+		return "unknown"
+	}
+
+	version := iff(bi.Main.Version != "", bi.Main.Version, "(devel)")
+	for _, setting := range bi.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			version += " " + setting.Value[:min(12, len(setting.Value))]
+		}
+	}
+	return version
+}
+
+// Versions maps sources to the version of this module they're running, see
+// [Version]. Sources which don't report a version, e.g. older servers, are
+// missing.
+type Versions map[string]string
+
+// Distinct returns the distinct versions, sorted. More than one distinct
+// version means the sources are running different versions of this module.
+func (v Versions) Distinct() []string {
+	index := map[string]bool{}
+	for _, version := range v {
+		index[version] = true
+	}
+	distinct := make([]string, 0, len(index))
+	for version := range index {
+		distinct = append(distinct, version)
+	}
+	sort.Strings(distinct)
+	return distinct
+}
+
+func (v Versions) merge(other Versions) Versions {
+	if len(other) <= 0 {
+		return v
+	}
+	if v == nil {
+		v = make(Versions, len(other))
+	}
+	for source, version := range other {
+		v[source] = version
+	}
+	return v
+}